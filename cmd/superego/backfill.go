@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"cloud.google.com/go/datastore"
+	"github.com/benkim0414/superego/pkg/profile"
+)
+
+// runBackfillEmails implements the backfill-emails subcommand, which reserves
+// the email addresses of the profiles of the datastore store written before
+// addresses were reserved, and prints the addresses used by more than one
+// profile, one per line.
+//
+//	superego backfill-emails
+func runBackfillEmails(args []string) error {
+	fs := flag.NewFlagSet("backfill-emails", flag.ExitOnError)
+	fs.Parse(args)
+
+	ctx := context.Background()
+	client, err := datastore.NewClient(ctx, os.Getenv("GCP_PROJECT_ID"))
+	if err != nil {
+		return fmt.Errorf("datastore: could not connect: %v", err)
+	}
+	defer client.Close()
+
+	reserved, conflicts, err := profile.BackfillEmailReservations(ctx, client)
+	fmt.Printf("reserved %d email addresses\n", reserved)
+	if len(conflicts) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "EMAIL\tRESERVED BY\tALSO USED BY")
+		for _, c := range conflicts {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", c.Email, c.ReservedBy, strings.Join(c.ProfileIDs, ", "))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/benkim0414/superego/pkg/dedupe"
+	"github.com/benkim0414/superego/pkg/profile"
+)
+
+// runDuplicates implements the duplicates subcommand, which runs the
+// duplicate detection job over the profiles of the store and prints the
+// likely duplicates, one pair per line, ordered by descending score.
+//
+//	superego duplicates [-store datastore] [-store.path superego.log] [-store.dsn dsn] [-score 0.7]
+func runDuplicates(args []string) error {
+	fs := flag.NewFlagSet("duplicates", flag.ExitOnError)
+	store := fs.String("store", "datastore", "Storage backend of the profiles: "+strings.Join(profile.Backends(), ", "))
+	storePath := fs.String("store.path", "superego.log", "Path of the log of the disk store")
+	storeDSN := fs.String("store.dsn", "", "Data source name of the database of the postgres store")
+	minScore := fs.Float64("score", dedupe.DefaultMinScore, "Minimum score of the printed pairs, from 0 to 1")
+	fs.Parse(args)
+
+	ctx := context.Background()
+	profiles, closeProfiles, err := profile.Open(ctx, *store, profile.Config{
+		ProjectID: os.Getenv("GCP_PROJECT_ID"),
+		Path:      *storePath,
+		DSN:       *storeDSN,
+	})
+	if err != nil {
+		return err
+	}
+	defer closeProfiles()
+
+	pairs, err := dedupe.Find(ctx, profiles, *minScore)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SCORE\tPROFILE\tDUPLICATE\tREASONS")
+	for _, pair := range pairs {
+		fmt.Fprintf(w, "%.2f\t%s\t%s\t%s\n", pair.Score, pair.ProfileIDs[0], pair.ProfileIDs[1], strings.Join(pair.Reasons, ", "))
+	}
+	return w.Flush()
+}
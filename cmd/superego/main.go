@@ -4,30 +4,69 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
-	"cloud.google.com/go/datastore"
-
 	"github.com/benkim0414/superego/pkg/endpoint"
 	"github.com/benkim0414/superego/pkg/graphql"
+	"github.com/benkim0414/superego/pkg/pb"
+	"github.com/benkim0414/superego/pkg/profile"
 	"github.com/benkim0414/superego/pkg/service"
 	"github.com/benkim0414/superego/pkg/transport"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/metrics"
 	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
-	"github.com/graphql-go/handler"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "duplicates" {
+		if err := runDuplicates(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "superego duplicates: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "backfill-emails" {
+		if err := runBackfillEmails(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "superego backfill-emails: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "superego migrate: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var (
-		promAddr = flag.String("prom.addr", ":8079", "Prometheus listen address")
-		httpAddr = flag.String("http.addr", ":8080", "HTTP listen address")
-		gqlAddr  = flag.String("graphql.addr", ":8081", "GraphQL listen address")
+		promAddr  = flag.String("prom.addr", ":8079", "Prometheus listen address")
+		httpAddr  = flag.String("http.addr", ":8080", "HTTP listen address")
+		gqlAddr   = flag.String("graphql.addr", ":8081", "GraphQL listen address")
+		grpcAddr  = flag.String("grpc.addr", ":8082", "gRPC listen address")
+		adminAddr = flag.String("admin.addr", "localhost:8083", "Admin HTTP listen address, which must not be exposed")
+		store     = flag.String("store", "datastore", "Storage backend of the profiles: "+strings.Join(profile.Backends(), ", "))
+
+		storePath         = flag.String("store.path", "superego.log", "Path of the log of the disk store")
+		storeSyncInterval = flag.Duration("store.sync-interval", 0, "Interval at which the disk store syncs its log, 0 to sync every write, negative to leave it to the OS")
+		storeDSN          = flag.String("store.dsn", "", "Data source name of the database of the postgres store, empty to use the PG* environment variables")
+
+		gqlMaxDepth      = flag.Int("graphql.max-depth", graphql.DefaultLimits.MaxDepth, "Maximum depth of GraphQL queries, 0 for no limit")
+		gqlMaxComplexity = flag.Int("graphql.max-complexity", graphql.DefaultLimits.MaxComplexity, "Maximum complexity of GraphQL queries, 0 for no limit")
+		gqlMaxAliases    = flag.Int("graphql.max-aliases", graphql.DefaultLimits.MaxAliases, "Maximum number of aliases of GraphQL queries, 0 for no limit")
+
+		gqlPersistedQueries = flag.Bool("graphql.persisted-queries", true, "Enable automatic persisted GraphQL queries")
+		gqlAllowList        = flag.String("graphql.allow-list", "", "Path of a manifest of the only GraphQL queries allowed to run")
+		gqlAllowedOrigins   = flag.String("graphql.allowed-origins", "", "Comma-separated origins of the pages allowed to open GraphQL subscriptions, * for any, empty for the GraphQL address only")
 	)
 	flag.Parse()
 
@@ -58,20 +97,53 @@ func main() {
 		Name:      "request_duration_seconds",
 		Help:      "Request duration in seconds.",
 	}, []string{"method", "success"})
+	var transactionRetries metrics.Histogram
+	transactionRetries = kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+		Namespace: "superego",
+		Subsystem: "datastore",
+		Name:      "transaction_retries",
+		Help:      "Number of times a transaction was retried due to contention.",
+		Buckets:   []float64{0, 1, 2, 3, 4},
+	}, []string{"method"})
+	var batchSizes metrics.Histogram
+	batchSizes = kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+		Namespace: "superego",
+		Subsystem: "graphql",
+		Name:      "batch_size",
+		Help:      "Number of profiles loaded by each batch of node lookups.",
+		Buckets:   []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000},
+	}, []string{})
+	var rejectedQueries metrics.Counter
+	rejectedQueries = kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "superego",
+		Subsystem: "graphql",
+		Name:      "rejected_queries_total",
+		Help:      "Number of GraphQL queries rejected for exceeding a limit.",
+	}, []string{"limit"})
 	http.DefaultServeMux.Handle("/metrics", promhttp.Handler())
 
 	ctx := context.Background()
-	projectID := os.Getenv("GCP_PROJECT_ID")
-	client, err := datastore.NewClient(ctx, projectID)
+	profiles, closeProfiles, err := profile.Open(ctx, *store, profile.Config{
+		ProjectID: os.Getenv("GCP_PROJECT_ID"),
+		Options:   []profile.Option{profile.TransactionRetries(transactionRetries)},
+		Path:      *storePath,
+		DiskOptions: []profile.DiskOption{
+			profile.SyncInterval(*storeSyncInterval),
+		},
+		DSN: *storeDSN,
+	})
 	if err != nil {
-		logger.Log("datastore: could not connect: %v", err)
+		logger.Log("store", *store, "err", err)
+		os.Exit(1)
 	}
-	defer client.Close()
+	defer closeProfiles()
 
+	bus := service.NewEventBus()
 	var (
-		service     = service.New(client, logger, requestCount, requestLatency)
-		endpoints   = endpoint.New(service, logger, duration)
-		httpHandler = transport.NewHTTPHandler(endpoints, logger)
+		service      = service.NewPublishingMiddleware(bus)(service.New(profiles, logger, requestCount, requestLatency))
+		endpoints    = endpoint.New(service, logger, duration)
+		httpHandler  = transport.NewHTTPHandler(endpoints, logger)
+		adminHandler = transport.NewAdminHTTPHandler(endpoints, logger)
 	)
 
 	schema, err := graphql.NewSchema(service)
@@ -79,15 +151,33 @@ func main() {
 		logger.Log("graphql: could not create new schema: %v", err)
 	}
 
-	var gqlHandler = handler.New(&handler.Config{
-		Schema:   &schema,
-		Pretty:   true,
-		GraphiQL: true,
-	})
+	limits := graphql.DefaultLimits
+	limits.MaxDepth, limits.MaxComplexity, limits.MaxAliases = *gqlMaxDepth, *gqlMaxComplexity, *gqlMaxAliases
+	gqlOptions := []graphql.Option{
+		graphql.BatchSizes(batchSizes),
+		graphql.QueryLimits(limits),
+		graphql.RejectedQueries(rejectedQueries),
+		graphql.Subscriptions(bus),
+	}
+	if *gqlPersistedQueries {
+		gqlOptions = append(gqlOptions, graphql.PersistedQueries(graphql.NewQueryStore(graphql.DefaultQueryStoreSize)))
+	}
+	if *gqlAllowList != "" {
+		manifest, err := loadManifest(*gqlAllowList)
+		if err != nil {
+			logger.Log("graphql", "could not load the allow-list", "err", err)
+			os.Exit(1)
+		}
+		gqlOptions = append(gqlOptions, graphql.AllowList(manifest))
+	}
+	if *gqlAllowedOrigins != "" {
+		gqlOptions = append(gqlOptions, graphql.AllowedOrigins(strings.Split(*gqlAllowedOrigins, ",")...))
+	}
+	var gqlHandler = graphql.NewHandler(&schema, gqlOptions...)
 
 	errs := make(chan error)
 	go func() {
-		c := make(chan os.Signal)
+		c := make(chan os.Signal, 1)
 		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 		errs <- fmt.Errorf("%s", <-c)
 	}()
@@ -102,9 +192,36 @@ func main() {
 		errs <- http.ListenAndServe(*httpAddr, httpHandler)
 	}()
 
+	go func() {
+		logger.Log("transport", "HTTP", "addr", *adminAddr)
+		errs <- http.ListenAndServe(*adminAddr, adminHandler)
+	}()
+
 	go func() {
 		logger.Log("transport", "HTTP", "addr", *gqlAddr)
 		errs <- http.ListenAndServe(*gqlAddr, gqlHandler)
 	}()
+
+	go func() {
+		logger.Log("transport", "gRPC", "addr", *grpcAddr)
+		ln, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			errs <- err
+			return
+		}
+		grpcServer := grpc.NewServer()
+		pb.RegisterProfileServiceServer(grpcServer, transport.NewGRPCServer(endpoints, logger))
+		errs <- grpcServer.Serve(ln)
+	}()
 	logger.Log("exit", <-errs)
 }
+
+// loadManifest loads the allow-list manifest of GraphQL queries at path.
+func loadManifest(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return graphql.LoadManifest(f)
+}
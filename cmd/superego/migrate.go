@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/benkim0414/superego/pkg/profile"
+)
+
+// runMigrate implements the migrate subcommand, which applies, reverts or
+// prints the migrations of the schema of the postgres store.
+//
+//	superego migrate [-store.dsn dsn] up
+//	superego migrate [-store.dsn dsn] down [-steps 1]
+//	superego migrate [-store.dsn dsn] status
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	storeDSN := fs.String("store.dsn", "", "Data source name of the database of the postgres store, empty to use the PG* environment variables")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: superego migrate [-store.dsn dsn] up | down [-steps 1] | status")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing command")
+	}
+
+	db, err := sql.Open(profile.PostgresDriver, *storeDSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	m := profile.NewMigrator(db)
+
+	ctx := context.Background()
+	switch command := fs.Arg(0); command {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("applied %d_%s\n", migration.Version, migration.Name)
+		}
+		return err
+	case "down":
+		down := flag.NewFlagSet("migrate down", flag.ExitOnError)
+		steps := down.Int("steps", 1, "Number of migrations to revert")
+		down.Parse(fs.Args()[1:])
+		if *steps < 1 {
+			return fmt.Errorf("invalid -steps %d", *steps)
+		}
+		reverted, err := m.Down(ctx, *steps)
+		for _, migration := range reverted {
+			fmt.Printf("reverted %d_%s\n", migration.Version, migration.Name)
+		}
+		return err
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			applied := "pending"
+			if !status.AppliedAt.IsZero() {
+				applied = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, applied)
+		}
+		return w.Flush()
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
@@ -0,0 +1,4 @@
+package main
+
+// the postgres store and the migrate subcommand need a database/sql driver.
+import _ "github.com/lib/pq"
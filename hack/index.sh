@@ -0,0 +1,4 @@
+#!/bin/bash
+
+SUPEREGO_ROOT="$(dirname "${BASH_SOURCE}")/.."
+gcloud datastore indexes create $SUPEREGO_ROOT/hack/datastore/index.yaml
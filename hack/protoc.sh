@@ -0,0 +1,15 @@
+#!/bin/bash
+# Generates pkg/pb/profile.pb.go from profile.proto with the vendored
+# protoc-gen-go, which must match the vendored proto package. Requires protoc.
+
+set -e
+
+SUPEREGO_ROOT="$(cd "$(dirname "${BASH_SOURCE}")/.." && pwd)"
+BIN="$(mktemp -d)"
+trap 'rm -rf "$BIN"' EXIT
+
+(cd $SUPEREGO_ROOT && go build -o $BIN/protoc-gen-go ./vendor/github.com/golang/protobuf/protoc-gen-go)
+cd $SUPEREGO_ROOT/pkg/pb
+protoc --plugin=protoc-gen-go=$BIN/protoc-gen-go \
+  --go_out=plugins=grpc,Mgoogle/protobuf/timestamp.proto=github.com/golang/protobuf/ptypes/timestamp:. \
+  profile.proto
@@ -0,0 +1,163 @@
+package testutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// emulatorStartTimeout bounds the time the emulator takes to start serving.
+const emulatorStartTimeout = time.Minute
+
+var noEmulator = errors.New("DATASTORE_EMULATOR_HOST is not set and the gcloud CLI is not installed")
+
+var emulator struct {
+	once sync.Once
+	// host is the address of the emulator, and err why there is none.
+	host string
+	err  error
+	// cmd is the emulator started by the tests, which Main stops.
+	cmd *exec.Cmd
+}
+
+// emulatorHost returns the address of the Datastore emulator, which is
+// DATASTORE_EMULATOR_HOST if it is set, or the address of an emulator started
+// with the gcloud CLI otherwise. The emulator is started at most once per
+// test binary.
+func emulatorHost() (string, error) {
+	emulator.once.Do(func() {
+		if host := os.Getenv("DATASTORE_EMULATOR_HOST"); host != "" {
+			emulator.host = host
+			return
+		}
+		if _, err := exec.LookPath("gcloud"); err != nil {
+			emulator.err = noEmulator
+			return
+		}
+		emulator.host, emulator.cmd, emulator.err = startEmulator()
+		if emulator.err == nil {
+			// the datastore client connects to the emulator of this variable.
+			os.Setenv("DATASTORE_EMULATOR_HOST", emulator.host)
+		}
+	})
+	return emulator.host, emulator.err
+}
+
+// startEmulator starts a Datastore emulator on a free local port, which keeps
+// the entities in memory and applies every write immediately, so that
+// queries are deterministic.
+func startEmulator() (string, *exec.Cmd, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return "", nil, err
+	}
+	host := l.Addr().String()
+	l.Close()
+
+	cmd := exec.Command("gcloud", "beta", "emulators", "datastore", "start",
+		"--project="+emulatorProjectID,
+		"--host-port="+host,
+		"--no-store-on-disk",
+		"--consistency=1.0",
+		"--quiet",
+	)
+	if err := cmd.Start(); err != nil {
+		return "", nil, fmt.Errorf("could not start the Datastore emulator: %v", err)
+	}
+
+	deadline := time.Now().Add(emulatorStartTimeout)
+	for time.Now().Before(deadline) {
+		if resp, err := http.Get("http://" + host); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return host, cmd, nil
+			}
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	stopEmulator(host, cmd)
+	return "", nil, fmt.Errorf("the Datastore emulator did not start at %s within %v", host, emulatorStartTimeout)
+}
+
+// stopEmulator shuts down the emulator through its API, since the gcloud
+// CLI does not forward signals to the emulator it runs.
+func stopEmulator(host string, cmd *exec.Cmd) {
+	if resp, err := http.Post("http://"+host+"/shutdown", "text/plain", nil); err == nil {
+		resp.Body.Close()
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+}
+
+// Main runs the tests, and then stops the Datastore emulator and the
+// PostgreSQL cluster if they were started by EmulatorTestContext and
+// PostgresTestContext. Packages with such tests call it from TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(testutil.Main(m))
+//	}
+func Main(m *testing.M) int {
+	code := m.Run()
+	if emulator.cmd != nil {
+		stopEmulator(emulator.host, emulator.cmd)
+	}
+	if postgres.dir != "" {
+		stopPostgres(postgres.dir)
+	}
+	return code
+}
+
+// invalidNamespaceChars matches the characters which are not allowed in a
+// Datastore namespace.
+var invalidNamespaceChars = regexp.MustCompile(`[^0-9A-Za-z._-]`)
+
+// maxNamespaceLength is the maximum length of a Datastore namespace.
+const maxNamespaceLength = 100
+
+// newNamespace returns a namespace for the test, which is unique across
+// test runs sharing an emulator.
+func newNamespace(t *testing.T) string {
+	suffix := fmt.Sprintf("-%x", time.Now().UnixNano())
+	name := invalidNamespaceChars.ReplaceAllString(t.Name(), "_")
+	if len(name)+len(suffix) > maxNamespaceLength {
+		name = name[:maxNamespaceLength-len(suffix)]
+	}
+	return name + suffix
+}
+
+// deleteNamespace deletes all of the entities of the namespace.
+func deleteNamespace(ctx context.Context, projectID, namespace string) error {
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// a kindless query returns the entities of every kind.
+	keys, err := client.GetAll(ctx, datastore.NewQuery("").Namespace(namespace).KeysOnly(), nil)
+	if err != nil {
+		return err
+	}
+	// a single call deletes at most 500 entities.
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > 500 {
+			n = 500
+		}
+		if err := client.DeleteMulti(ctx, keys[:n]); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package testutil
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+var noPostgres = errors.New("SUPEREGO_POSTGRES_DSN is not set and the PostgreSQL server binaries are not installed")
+
+var postgres struct {
+	once sync.Once
+	// dsn is the data source name of the database, and err why there is none.
+	dsn string
+	err error
+	// dir holds the cluster started by the tests, which Main stops.
+	dir string
+}
+
+// postgresDSN returns the data source name of the test database, which is
+// SUPEREGO_POSTGRES_DSN if it is set, or the database of a cluster started
+// with initdb and pg_ctl otherwise. The cluster is started at most once per
+// test binary.
+func postgresDSN() (string, error) {
+	postgres.once.Do(func() {
+		if dsn := os.Getenv("SUPEREGO_POSTGRES_DSN"); dsn != "" {
+			postgres.dsn = dsn
+			return
+		}
+		for _, name := range []string{"initdb", "pg_ctl"} {
+			if _, err := exec.LookPath(name); err != nil {
+				postgres.err = noPostgres
+				return
+			}
+		}
+		postgres.dsn, postgres.dir, postgres.err = startPostgres()
+	})
+	return postgres.dsn, postgres.err
+}
+
+// startPostgres initializes a throwaway cluster in a temporary directory and
+// starts it on a free local port. The server only listens on a Unix socket
+// in the same directory and trusts every local connection.
+func startPostgres() (string, string, error) {
+	dir, err := ioutil.TempDir("", "superego-postgres")
+	if err != nil {
+		return "", "", err
+	}
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+
+	data := filepath.Join(dir, "data")
+	initdb := exec.Command("initdb", "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8", "--no-sync")
+	if out, err := initdb.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", "", fmt.Errorf("could not initialize a PostgreSQL cluster: %v: %s", err, out)
+	}
+	start := exec.Command("pg_ctl", "start", "-w", "-D", data, "-l", filepath.Join(dir, "server.log"),
+		"-o", fmt.Sprintf("-p %s -k %s -c listen_addresses='' -c fsync=off", port, dir))
+	if out, err := start.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", "", fmt.Errorf("could not start PostgreSQL: %v: %s", err, out)
+	}
+	dsn := fmt.Sprintf("host=%s port=%s user=postgres dbname=postgres sslmode=disable", dir, port)
+	return dsn, dir, nil
+}
+
+// stopPostgres shuts down the cluster of the directory and removes it.
+func stopPostgres(dir string) {
+	exec.Command("pg_ctl", "stop", "-w", "-m", "immediate", "-D", filepath.Join(dir, "data")).Run()
+	os.RemoveAll(dir)
+}
@@ -2,17 +2,25 @@
 package testutil
 
 import (
+	"context"
 	"errors"
 	"os"
 	"testing"
 )
 
-var (
-	noProjectID = errors.New("GCP_PROJECT_ID is not set")
-)
+var noProjectID = errors.New("GCP_PROJECT_ID is not set")
+
+// emulatorProjectID is the project ID used with the Datastore emulator when
+// GCP_PROJECT_ID is not set.
+const emulatorProjectID = "superego-test"
 
 type Context struct {
 	ProjectID string
+	// Namespace is the datastore namespace of the entities of the test.
+	Namespace string
+	// PostgresDSN is the data source name of the database of the postgres
+	// backend.
+	PostgresDSN string
 }
 
 func newContext() (Context, error) {
@@ -37,3 +45,51 @@ func SystemTestContext(t *testing.T) Context {
 	}
 	return tc
 }
+
+// EmulatorTestContext returns the test context for a local Datastore
+// emulator, which the datastore client connects to when the
+// DATASTORE_EMULATOR_HOST environment variable is set. The emulator of
+// DATASTORE_EMULATOR_HOST is attached to if it is set, and one is started
+// with the gcloud CLI otherwise, which is stopped by Main.
+// The test is skipped if there is no emulator.
+//
+// Every test gets its own namespace, whose entities are deleted once the
+// test and its subtests have finished, so that the tests are isolated from
+// each other.
+func EmulatorTestContext(t *testing.T) Context {
+	if _, err := emulatorHost(); err == noEmulator {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	tc, err := newContext()
+	if err == noProjectID {
+		tc.ProjectID = emulatorProjectID
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	tc.Namespace = newNamespace(t)
+	t.Cleanup(func() {
+		if err := deleteNamespace(context.Background(), tc.ProjectID, tc.Namespace); err != nil {
+			t.Errorf("could not delete the namespace %s: %v", tc.Namespace, err)
+		}
+	})
+	return tc
+}
+
+// PostgresTestContext returns the test context for a local PostgreSQL
+// database. The database of the SUPEREGO_POSTGRES_DSN environment variable,
+// such as "postgres://localhost/superego_test?sslmode=disable", is used if it
+// is set, and a cluster is started with initdb and pg_ctl otherwise, which is
+// stopped by Main. The test is skipped if there is no database. The tests
+// reset the schema of the database.
+func PostgresTestContext(t *testing.T) Context {
+	dsn, err := postgresDSN()
+	if err == noPostgres {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return Context{PostgresDSN: dsn}
+}
@@ -0,0 +1,340 @@
+// Package client provides a profile.Service which calls the REST API of a
+// remote superego, so that it can be used in place of a local service.
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/benkim0414/superego/pkg/endpoint"
+	"github.com/benkim0414/superego/pkg/profile"
+	kitendpoint "github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+const (
+	// DefaultMaxAttempts is the number of times an idempotent request is
+	// attempted when the remote service is unavailable.
+	DefaultMaxAttempts = 3
+	// DefaultBackoff is the delay before the first retry of a request, which
+	// is doubled for every subsequent retry.
+	DefaultBackoff = 100 * time.Millisecond
+)
+
+// Option configures the client.
+type Option func(*config)
+
+type config struct {
+	httpClient  *http.Client
+	timeout     time.Duration
+	maxAttempts int
+	backoff     time.Duration
+	before      []httptransport.RequestFunc
+}
+
+// HTTPClient sets the HTTP client which sends the requests. It defaults to
+// http.DefaultClient.
+func HTTPClient(c *http.Client) Option {
+	return func(cfg *config) {
+		cfg.httpClient = c
+	}
+}
+
+// Timeout sets the time limit of every attempt of a request, including
+// reading the response body. Zero means no timeout.
+func Timeout(timeout time.Duration) Option {
+	return func(cfg *config) {
+		cfg.timeout = timeout
+	}
+}
+
+// MaxAttempts sets the number of times an idempotent request is attempted
+// when the remote service is unavailable. It defaults to DefaultMaxAttempts,
+// and 1 disables retries.
+func MaxAttempts(attempts int) Option {
+	return func(cfg *config) {
+		if attempts > 0 {
+			cfg.maxAttempts = attempts
+		}
+	}
+}
+
+// Backoff sets the delay before the first retry of a request, which is
+// doubled for every subsequent retry. It defaults to DefaultBackoff.
+func Backoff(backoff time.Duration) Option {
+	return func(cfg *config) {
+		cfg.backoff = backoff
+	}
+}
+
+// Header sets a header of every request, such as an API key.
+func Header(key, value string) Option {
+	return func(cfg *config) {
+		cfg.before = append(cfg.before, httptransport.SetRequestHeader(key, value))
+	}
+}
+
+// BearerToken authorizes every request with the given bearer token.
+func BearerToken(token string) Option {
+	return Header("Authorization", "Bearer "+token)
+}
+
+// New returns a service which calls the REST API of the superego at the
+// given base URL, such as "http://superego:8080". Errors of the remote
+// service are returned as *profile.Error with the same code, and failed
+// requests as Unavailable errors. An expected revision of the context, see
+// profile.WithExpectedRevision, is sent as If-Match.
+func New(baseURL string, opts ...Option) (profile.Service, error) {
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "http://" + baseURL
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v1"
+
+	cfg := config{
+		httpClient:  http.DefaultClient,
+		maxAttempts: DefaultMaxAttempts,
+		backoff:     DefaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.timeout > 0 {
+		c := *cfg.httpClient
+		c.Timeout = cfg.timeout
+		cfg.httpClient = &c
+	}
+
+	// idempotent requests are retried, since repeating them has the same
+	// effect as a single successful attempt, unless they are conditional:
+	// an attempt which succeeded without a response changes the revision of
+	// the profile, so that a retry would fail its precondition.
+	makeEndpoint := func(method string, enc httptransport.EncodeRequestFunc, dec httptransport.DecodeResponseFunc, idempotent bool) kitendpoint.Endpoint {
+		e := httptransport.NewClient(method, u, enc, dec,
+			httptransport.SetClient(cfg.httpClient),
+			httptransport.ClientBefore(cfg.before...),
+		).Endpoint()
+		e = unavailable(e)
+		if idempotent {
+			e = retry(cfg.maxAttempts, cfg.backoff)(e)
+		}
+		return e
+	}
+
+	return &client{
+		postProfile:     makeEndpoint("POST", encodePostProfileRequest, decodePostProfileResponse, false),
+		getProfile:      makeEndpoint("GET", encodeGetProfileRequest, decodeGetProfileResponse, true),
+		getProfiles:     makeEndpoint("GET", encodeGetProfilesRequest, decodeGetProfilesResponse, true),
+		putProfile:      makeEndpoint("PUT", encodePutProfileRequest, decodePutProfileResponse, true),
+		patchProfile:    makeEndpoint("PATCH", encodePatchProfileRequest, decodePatchProfileResponse, false),
+		deleteProfile:   makeEndpoint("DELETE", encodeDeleteProfileRequest, decodeDeleteProfileResponse, true),
+		lookupProfile:   makeEndpoint("GET", encodeLookupProfileRequest, decodeLookupProfileResponse, true),
+		linkIdentity:    makeEndpoint("POST", encodeLinkIdentityRequest, decodeLinkIdentityResponse, false),
+		unlinkIdentity:  makeEndpoint("DELETE", encodeUnlinkIdentityRequest, decodeUnlinkIdentityResponse, false),
+		resolveIdentity: makeEndpoint("GET", encodeResolveIdentityRequest, decodeResolveIdentityResponse, true),
+		mergeProfiles:   makeEndpoint("POST", encodeMergeProfilesRequest, decodeMergeProfilesResponse, false),
+		listProfiles:    makeEndpoint("GET", encodeListProfilesRequest, decodeListProfilesResponse, true),
+		searchProfiles:  makeEndpoint("GET", encodeSearchProfilesRequest, decodeSearchProfilesResponse, true),
+	}, nil
+}
+
+// unavailable turns the errors of requests which did not get a response,
+// such as refused connections and timeouts, into Unavailable errors.
+func unavailable(next kitendpoint.Endpoint) kitendpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		response, err := next(ctx, request)
+		if _, ok := err.(*profile.Error); err != nil && !ok {
+			err = &profile.Error{Code: profile.Unavailable, Message: "superego: request failed", Err: err}
+		}
+		return response, err
+	}
+}
+
+// retry returns a middleware which attempts requests up to maxAttempts times
+// with exponential backoff while they fail with Unavailable errors. Requests
+// conditional on a revision are attempted once.
+func retry(maxAttempts int, backoff time.Duration) kitendpoint.Middleware {
+	return func(next kitendpoint.Endpoint) kitendpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if conditional(request) {
+				return next(ctx, request)
+			}
+			delay := backoff
+			for attempt := 1; ; attempt++ {
+				response, err := next(ctx, request)
+				if attempt > 1 && profile.ErrorCode(err) == profile.NotFound {
+					// an earlier attempt may have succeeded without a
+					// response, and deleted the profile.
+					if _, ok := request.(endpoint.DeleteProfileRequest); ok {
+						return endpoint.DeleteProfileResponse{}, nil
+					}
+				}
+				if err == nil || attempt >= maxAttempts || profile.ErrorCode(err) != profile.Unavailable {
+					return response, err
+				}
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return nil, err
+				}
+				delay *= 2
+			}
+		}
+	}
+}
+
+// conditional reports whether the request is conditional on a revision of
+// the profile, see setIfMatch.
+func conditional(request interface{}) bool {
+	switch r := request.(type) {
+	case endpoint.PutProfileRequest:
+		return r.Revision != 0
+	case endpoint.DeleteProfileRequest:
+		return r.Revision != 0
+	}
+	return false
+}
+
+// client implements profile.Service with an endpoint for every method.
+type client struct {
+	postProfile     kitendpoint.Endpoint
+	getProfile      kitendpoint.Endpoint
+	getProfiles     kitendpoint.Endpoint
+	putProfile      kitendpoint.Endpoint
+	patchProfile    kitendpoint.Endpoint
+	deleteProfile   kitendpoint.Endpoint
+	lookupProfile   kitendpoint.Endpoint
+	linkIdentity    kitendpoint.Endpoint
+	unlinkIdentity  kitendpoint.Endpoint
+	resolveIdentity kitendpoint.Endpoint
+	mergeProfiles   kitendpoint.Endpoint
+	listProfiles    kitendpoint.Endpoint
+	searchProfiles  kitendpoint.Endpoint
+}
+
+func (c *client) PostProfile(ctx context.Context, p *profile.Profile) (*profile.Profile, error) {
+	response, err := c.postProfile(ctx, endpoint.PostProfileRequest{Profile: p})
+	if err != nil {
+		return nil, err
+	}
+	return response.(endpoint.PostProfileResponse).Profile, nil
+}
+
+func (c *client) GetProfile(ctx context.Context, id string) (*profile.Profile, error) {
+	response, err := c.getProfile(ctx, endpoint.GetProfileRequest{ID: id})
+	if err != nil {
+		return nil, err
+	}
+	return response.(endpoint.GetProfileResponse).Profile, nil
+}
+
+func (c *client) GetProfiles(ctx context.Context, ids []string) ([]*profile.Profile, error) {
+	response, err := c.getProfiles(ctx, endpoint.GetProfilesRequest{IDs: ids})
+	if err != nil {
+		return nil, err
+	}
+	return response.(endpoint.GetProfilesResponse).Profiles, nil
+}
+
+func (c *client) PutProfile(ctx context.Context, id string, p *profile.Profile) (*profile.Profile, error) {
+	response, err := c.putProfile(ctx, endpoint.PutProfileRequest{ID: id, Profile: p, Revision: expectedRevision(ctx)})
+	if err != nil {
+		return nil, err
+	}
+	return response.(endpoint.PutProfileResponse).Profile, nil
+}
+
+// PatchProfile sends the patch with the media type of its kind. Only the
+// patches of the profile package are supported.
+func (c *client) PatchProfile(ctx context.Context, id string, patch profile.Patch) (*profile.Profile, error) {
+	response, err := c.patchProfile(ctx, endpoint.PatchProfileRequest{ID: id, Patch: patch, Revision: expectedRevision(ctx)})
+	if err != nil {
+		return nil, err
+	}
+	return response.(endpoint.PatchProfileResponse).Profile, nil
+}
+
+func (c *client) DeleteProfile(ctx context.Context, id string) error {
+	_, err := c.deleteProfile(ctx, endpoint.DeleteProfileRequest{ID: id, Revision: expectedRevision(ctx)})
+	return err
+}
+
+func (c *client) LookupProfile(ctx context.Context, email string) (*profile.Profile, error) {
+	response, err := c.lookupProfile(ctx, endpoint.LookupProfileRequest{Email: email})
+	if err != nil {
+		return nil, err
+	}
+	return response.(endpoint.LookupProfileResponse).Profile, nil
+}
+
+func (c *client) LinkIdentity(ctx context.Context, id string, identity profile.Identity) (*profile.Profile, error) {
+	response, err := c.linkIdentity(ctx, endpoint.LinkIdentityRequest{ID: id, Identity: identity, Revision: expectedRevision(ctx)})
+	if err != nil {
+		return nil, err
+	}
+	return response.(endpoint.LinkIdentityResponse).Profile, nil
+}
+
+func (c *client) UnlinkIdentity(ctx context.Context, id string, provider, subject string) (*profile.Profile, error) {
+	response, err := c.unlinkIdentity(ctx, endpoint.UnlinkIdentityRequest{
+		ID:       id,
+		Provider: provider,
+		Subject:  subject,
+		Revision: expectedRevision(ctx),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.(endpoint.UnlinkIdentityResponse).Profile, nil
+}
+
+func (c *client) ResolveIdentity(ctx context.Context, provider, subject string) (*profile.Profile, error) {
+	response, err := c.resolveIdentity(ctx, endpoint.ResolveIdentityRequest{Provider: provider, Subject: subject})
+	if err != nil {
+		return nil, err
+	}
+	return response.(endpoint.ResolveIdentityResponse).Profile, nil
+}
+
+func (c *client) MergeProfiles(ctx context.Context, survivorID, victimID string, strategy profile.MergeStrategy) (*profile.Profile, error) {
+	response, err := c.mergeProfiles(ctx, endpoint.MergeProfilesRequest{
+		SurvivorID: survivorID,
+		VictimID:   victimID,
+		Strategy:   strategy,
+		Revision:   expectedRevision(ctx),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.(endpoint.MergeProfilesResponse).Profile, nil
+}
+
+func (c *client) ListProfiles(ctx context.Context, opts profile.ListOptions) ([]*profile.Profile, string, error) {
+	response, err := c.listProfiles(ctx, endpoint.ListProfilesRequest{Options: opts})
+	if err != nil {
+		return nil, "", err
+	}
+	r := response.(endpoint.ListProfilesResponse)
+	return r.Profiles, r.NextPageToken, nil
+}
+
+func (c *client) SearchProfiles(ctx context.Context, query profile.SearchQuery, opts profile.ListOptions) ([]*profile.Profile, string, error) {
+	response, err := c.searchProfiles(ctx, endpoint.SearchProfilesRequest{Query: query, Options: opts})
+	if err != nil {
+		return nil, "", err
+	}
+	r := response.(endpoint.SearchProfilesResponse)
+	return r.Profiles, r.NextPageToken, nil
+}
+
+// expectedRevision returns the revision expected by the context, or zero.
+func expectedRevision(ctx context.Context) int64 {
+	revision, _ := profile.ExpectedRevision(ctx)
+	return revision
+}
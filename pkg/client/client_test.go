@@ -0,0 +1,309 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benkim0414/superego/pkg/endpoint"
+	"github.com/benkim0414/superego/pkg/profile"
+	"github.com/benkim0414/superego/pkg/service"
+	"github.com/benkim0414/superego/pkg/transport"
+	"github.com/benkim0414/superego/pkg/validation"
+	"github.com/go-kit/kit/log"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+var handler = func() http.Handler {
+	logger := log.NewNopLogger()
+	duration := kitprometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+		Namespace: "client_test",
+		Subsystem: "profile",
+		Name:      "request_duration_seconds",
+		Help:      "Request duration in seconds.",
+	}, []string{"method", "success"})
+	s := service.NewValidatingMiddleware()(profile.FakeService)
+	return transport.NewHTTPHandler(endpoint.New(s, logger, duration), logger)
+}()
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	s, err := New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	p, err := s.PostProfile(ctx, &profile.Profile{ID: "client", Email: "client@gunwoo.org", Name: profile.Name{GivenName: "Gunwoo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Revision != 1 || p.UpdateTime.IsZero() {
+		t.Errorf("PostProfile: got %+v", p)
+	}
+	got, err := s.GetProfile(ctx, "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, p) {
+		t.Errorf("GetProfile: got %+v, want %+v", got, p)
+	}
+
+	batch, err := s.GetProfiles(ctx, []string{"client", "unknown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 2 || !reflect.DeepEqual(batch[0], p) || batch[1] != nil {
+		t.Errorf("GetProfiles: got %v, want %v and nil", batch, p)
+	}
+
+	patches := []struct {
+		patch profile.Patch
+		want  func(p *profile.Profile)
+	}{
+		{&profile.Profile{AboutMe: "about"}, func(p *profile.Profile) { p.AboutMe = "about" }},
+		{profile.MaskedPatch{Profile: &profile.Profile{}, Mask: profile.FieldMask{"aboutMe"}}, func(p *profile.Profile) { p.AboutMe = "" }},
+		{profile.MergePatch{"displayName": "Ben"}, func(p *profile.Profile) { p.DisplayName = "Ben" }},
+		{profile.JSONPatch{{Op: "remove", Path: "/displayName"}}, func(p *profile.Profile) { p.DisplayName = "" }},
+	}
+	for _, tt := range patches {
+		want := *p
+		tt.want(&want)
+		p, err = s.PatchProfile(profile.WithExpectedRevision(ctx, p.Revision), "client", tt.patch)
+		if err != nil {
+			t.Fatalf("PatchProfile(%#v): %v", tt.patch, err)
+		}
+		want.Revision++
+		want.UpdateTime = p.UpdateTime
+		if !reflect.DeepEqual(p, &want) {
+			t.Errorf("PatchProfile(%#v): got %+v, want %+v", tt.patch, p, &want)
+		}
+	}
+
+	if p, err = s.LinkIdentity(ctx, "client", profile.Identity{Provider: "github", Subject: "42"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.ResolveIdentity(ctx, "github", "42"); err != nil || got.ID != "client" {
+		t.Errorf("ResolveIdentity: got %v, %v", got, err)
+	}
+	if p, err = s.UnlinkIdentity(ctx, "client", "github", "42"); err != nil || len(p.Identities) != 0 {
+		t.Errorf("UnlinkIdentity: got %v, %v", p, err)
+	}
+	// the path variables are escaped, so that subjects may contain "/".
+	for _, subject := range []string{"a/b", "a?b%c", "a b"} {
+		if p, err = s.LinkIdentity(ctx, "client", profile.Identity{Provider: "saml", Subject: subject}); err != nil {
+			t.Fatalf("LinkIdentity(%q): %v", subject, err)
+		}
+		if p, err = s.UnlinkIdentity(ctx, "client", "saml", subject); err != nil || len(p.Identities) != 0 {
+			t.Errorf("UnlinkIdentity(%q): got %v, %v", subject, p, err)
+		}
+	}
+	if got, err := s.LookupProfile(ctx, "CLIENT@gunwoo.org"); err != nil || got.ID != "client" {
+		t.Errorf("LookupProfile: got %v, %v", got, err)
+	}
+	profiles, _, err := s.SearchProfiles(ctx, profile.SearchQuery{Email: "client@gunwoo.org"}, profile.ListOptions{})
+	if err != nil || len(profiles) != 1 || profiles[0].ID != "client" {
+		t.Errorf("SearchProfiles: got %v, %v", profiles, err)
+	}
+	profiles, _, err = s.ListProfiles(ctx, profile.ListOptions{PageSize: profile.MaxPageSize})
+	if err != nil || len(profiles) == 0 {
+		t.Errorf("ListProfiles: got %v, %v", profiles, err)
+	}
+
+	if _, err := s.PostProfile(ctx, &profile.Profile{ID: "client-victim", Email: "victim@gunwoo.org", AboutMe: "victim"}); err != nil {
+		t.Fatal(err)
+	}
+	if p, err = s.MergeProfiles(ctx, "client", "client-victim", profile.MergeStrategy{}); err != nil || p.AboutMe != "victim" {
+		t.Errorf("MergeProfiles: got %v, %v", p, err)
+	}
+
+	if err := s.DeleteProfile(ctx, "client"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetProfile(ctx, "client"); profile.ErrorCode(err) != profile.NotFound {
+		t.Errorf("GetProfile: got %v, want %v", err, profile.NotFound)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	s, err := New(server.URL, MaxAttempts(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := s.PostProfile(ctx, &profile.Profile{ID: "client-errors", Email: "client-errors@gunwoo.org"}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.DeleteProfile(ctx, "client-errors")
+
+	_, err = s.PutProfile(profile.WithExpectedRevision(ctx, 2), "client-errors", &profile.Profile{Email: "client-errors@gunwoo.org"})
+	if code := profile.ErrorCode(err); code != profile.FailedPrecondition {
+		t.Errorf("PutProfile: got %v, want %v", code, profile.FailedPrecondition)
+	}
+
+	_, err = s.PostProfile(ctx, &profile.Profile{ID: "client-conflict", Email: "client-errors@gunwoo.org"})
+	if e, ok := err.(*profile.Error); !ok || e.Code != profile.Conflict || e.ExistingID != "client-errors" {
+		t.Errorf("PostProfile: got %#v, want a conflict with client-errors", err)
+	}
+
+	_, err = s.PostProfile(ctx, &profile.Profile{ID: "client-invalid", Email: "invalid"})
+	if e, ok := err.(*profile.Error); !ok || !validation.IsInvalid(err) || len(e.Violations) != 1 || e.Violations[0].Field != "email" {
+		t.Errorf("PostProfile: got %#v, want an invalid email", err)
+	}
+
+	_, err = s.PatchProfile(ctx, "client-errors", unsupportedPatch{})
+	if code := profile.ErrorCode(err); code != profile.InvalidArgument {
+		t.Errorf("PatchProfile: got %v, want %v", code, profile.InvalidArgument)
+	}
+}
+
+type unsupportedPatch struct{}
+
+func (unsupportedPatch) Apply(p *profile.Profile) error { return nil }
+
+func TestClientRetry(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1)%3 != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	s, err := New(server.URL, MaxAttempts(3), Backoff(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	_, err = s.GetProfile(ctx, "client-retry")
+	if code := profile.ErrorCode(err); code != profile.NotFound {
+		t.Errorf("GetProfile: got %v, want %v", err, profile.NotFound)
+	}
+	if requests != 3 {
+		t.Errorf("GetProfile: got %d requests, want 3", requests)
+	}
+
+	// a POST is not idempotent, so it is not retried.
+	requests = 0
+	_, err = s.PostProfile(ctx, &profile.Profile{ID: "client-retry", Email: "client-retry@gunwoo.org"})
+	if code := profile.ErrorCode(err); code != profile.Unavailable {
+		t.Errorf("PostProfile: got %v, want %v", err, profile.Unavailable)
+	}
+	if requests != 1 {
+		t.Errorf("PostProfile: got %d requests, want 1", requests)
+	}
+}
+
+func TestClientRetryLostResponse(t *testing.T) {
+	// the first attempt of every request is served, but its response is
+	// lost.
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	s, err := New(server.URL, MaxAttempts(3), Backoff(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	p, err := profile.FakeService.PostProfile(ctx, &profile.Profile{ID: "client-lost", Email: "client-lost@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer profile.FakeService.DeleteProfile(ctx, p.ID)
+
+	// a conditional request is not retried, since a retry would fail its
+	// precondition.
+	_, err = s.PutProfile(profile.WithExpectedRevision(ctx, p.Revision), p.ID, &profile.Profile{Email: p.Email, AboutMe: "lost"})
+	if code := profile.ErrorCode(err); code != profile.Unavailable {
+		t.Errorf("PutProfile: got %v, want %v", err, profile.Unavailable)
+	}
+	if requests != 1 {
+		t.Errorf("PutProfile: got %d requests, want 1", requests)
+	}
+
+	// linking and unlinking an identity are not idempotent.
+	requests = 0
+	_, err = s.LinkIdentity(ctx, p.ID, profile.Identity{Provider: "github", Subject: "lost"})
+	if code := profile.ErrorCode(err); code != profile.Unavailable {
+		t.Errorf("LinkIdentity: got %v, want %v", err, profile.Unavailable)
+	}
+	if requests != 1 {
+		t.Errorf("LinkIdentity: got %d requests, want 1", requests)
+	}
+	requests = 0
+	_, err = s.UnlinkIdentity(ctx, p.ID, "github", "lost")
+	if code := profile.ErrorCode(err); code != profile.Unavailable {
+		t.Errorf("UnlinkIdentity: got %v, want %v", err, profile.Unavailable)
+	}
+	if requests != 1 {
+		t.Errorf("UnlinkIdentity: got %d requests, want 1", requests)
+	}
+
+	// the profile deleted by the first attempt of a DELETE is not found by
+	// its retry.
+	requests = 0
+	if err := s.DeleteProfile(ctx, p.ID); err != nil {
+		t.Errorf("DeleteProfile: got %v, want nil", err)
+	}
+	if requests != 2 {
+		t.Errorf("DeleteProfile: got %d requests, want 2", requests)
+	}
+	if _, err := profile.FakeService.GetProfile(ctx, p.ID); profile.ErrorCode(err) != profile.NotFound {
+		t.Errorf("GetProfile: got %v, want %v", err, profile.NotFound)
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("X-Slow") != "" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	s, err := New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetProfile(ctx, "client-options"); profile.ErrorCode(err) != profile.PermissionDenied {
+		t.Errorf("GetProfile: got %v, want %v", err, profile.PermissionDenied)
+	}
+	s, err = New(server.URL, BearerToken("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetProfile(ctx, "client-options"); profile.ErrorCode(err) != profile.NotFound {
+		t.Errorf("GetProfile: got %v, want %v", err, profile.NotFound)
+	}
+	s, err = New(server.URL, BearerToken("token"), Header("X-Slow", "1"), Timeout(10*time.Millisecond), MaxAttempts(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetProfile(ctx, "client-options"); profile.ErrorCode(err) != profile.Unavailable {
+		t.Errorf("GetProfile: got %v, want %v", err, profile.Unavailable)
+	}
+}
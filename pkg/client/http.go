@@ -0,0 +1,346 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/benkim0414/superego/pkg/endpoint"
+	"github.com/benkim0414/superego/pkg/profile"
+	"github.com/benkim0414/superego/pkg/transport"
+	"github.com/benkim0414/superego/pkg/validation"
+)
+
+// The encoders append the path of their route, with its variables escaped,
+// to the base path of the request, and set the query, the headers and the
+// body which the decoders of the HTTP transport expect.
+
+func encodePostProfileRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(endpoint.PostProfileRequest)
+	r.URL.Path += "/profiles/"
+	return encodeJSONBody(r, "application/json", req.Profile)
+}
+
+func encodeGetProfileRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(endpoint.GetProfileRequest)
+	appendPath(r, "/profiles/%s", req.ID)
+	if len(req.Fields) > 0 {
+		r.URL.RawQuery = url.Values{"fields": {strings.Join(req.Fields, ",")}}.Encode()
+	}
+	return nil
+}
+
+func encodeGetProfilesRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(endpoint.GetProfilesRequest)
+	r.URL.Path += "/profiles:batchGet"
+	q := url.Values{"ids": req.IDs}
+	if len(req.Fields) > 0 {
+		q.Set("fields", strings.Join(req.Fields, ","))
+	}
+	r.URL.RawQuery = q.Encode()
+	return nil
+}
+
+func encodePutProfileRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(endpoint.PutProfileRequest)
+	appendPath(r, "/profiles/%s", req.ID)
+	setIfMatch(r, req.Revision)
+	return encodeJSONBody(r, "application/json", req.Profile)
+}
+
+// encodePatchProfileRequest encodes the patch with the media type of its
+// kind: a profile is merged, a masked patch overwrites the fields of its
+// update mask, and merge and JSON patches have their own media types.
+func encodePatchProfileRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(endpoint.PatchProfileRequest)
+	appendPath(r, "/profiles/%s", req.ID)
+	setIfMatch(r, req.Revision)
+	switch patch := req.Patch.(type) {
+	case *profile.Profile:
+		return encodeJSONBody(r, "application/json", patch)
+	case profile.MaskedPatch:
+		r.URL.RawQuery = url.Values{"updateMask": {strings.Join(patch.Mask, ",")}}.Encode()
+		return encodeJSONBody(r, "application/json", patch.Profile)
+	case profile.MergePatch:
+		return encodeJSONBody(r, "application/merge-patch+json", patch)
+	case profile.JSONPatch:
+		return encodeJSONBody(r, "application/json-patch+json", patch)
+	}
+	return profile.Errorf(profile.InvalidArgument, "unsupported patch %T", req.Patch)
+}
+
+func encodeDeleteProfileRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(endpoint.DeleteProfileRequest)
+	appendPath(r, "/profiles/%s", req.ID)
+	setIfMatch(r, req.Revision)
+	return nil
+}
+
+func encodeLookupProfileRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(endpoint.LookupProfileRequest)
+	r.URL.Path += "/profiles:lookup"
+	r.URL.RawQuery = url.Values{"email": {req.Email}}.Encode()
+	return nil
+}
+
+func encodeLinkIdentityRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(endpoint.LinkIdentityRequest)
+	appendPath(r, "/profiles/%s/identities", req.ID)
+	setIfMatch(r, req.Revision)
+	return encodeJSONBody(r, "application/json", req.Identity)
+}
+
+func encodeUnlinkIdentityRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(endpoint.UnlinkIdentityRequest)
+	appendPath(r, "/profiles/%s/identities/%s/%s", req.ID, req.Provider, req.Subject)
+	setIfMatch(r, req.Revision)
+	return nil
+}
+
+func encodeResolveIdentityRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(endpoint.ResolveIdentityRequest)
+	r.URL.Path += "/profiles:resolve"
+	r.URL.RawQuery = url.Values{"provider": {req.Provider}, "subject": {req.Subject}}.Encode()
+	return nil
+}
+
+func encodeMergeProfilesRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(endpoint.MergeProfilesRequest)
+	appendPath(r, "/profiles/%s:merge", req.SurvivorID)
+	setIfMatch(r, req.Revision)
+	return encodeJSONBody(r, "application/json", struct {
+		VictimID string                `json:"victimId"`
+		Strategy profile.MergeStrategy `json:"strategy"`
+	}{req.VictimID, req.Strategy})
+}
+
+func encodeListProfilesRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(endpoint.ListProfilesRequest)
+	r.URL.Path += "/profiles/"
+	q := listQuery(req.Options)
+	if len(req.Fields) > 0 {
+		q.Set("fields", strings.Join(req.Fields, ","))
+	}
+	r.URL.RawQuery = q.Encode()
+	return nil
+}
+
+func encodeSearchProfilesRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(endpoint.SearchProfilesRequest)
+	r.URL.Path += "/profiles:search"
+	q := listQuery(req.Options)
+	for name, value := range map[string]string{
+		"email":             req.Query.Email,
+		"emailPrefix":       req.Query.EmailPrefix,
+		"displayName":       req.Query.DisplayName,
+		"displayNamePrefix": req.Query.DisplayNamePrefix,
+		"familyName":        req.Query.FamilyName,
+		"familyNamePrefix":  req.Query.FamilyNamePrefix,
+		"givenName":         req.Query.GivenName,
+		"givenNamePrefix":   req.Query.GivenNamePrefix,
+	} {
+		if value != "" {
+			q.Set(name, value)
+		}
+	}
+	if len(req.Fields) > 0 {
+		q.Set("fields", strings.Join(req.Fields, ","))
+	}
+	r.URL.RawQuery = q.Encode()
+	return nil
+}
+
+// listQuery returns the query parameters of the list options.
+func listQuery(opts profile.ListOptions) url.Values {
+	q := url.Values{}
+	if opts.PageSize != 0 {
+		q.Set("pageSize", strconv.Itoa(opts.PageSize))
+	}
+	if opts.PageToken != "" {
+		q.Set("pageToken", opts.PageToken)
+	}
+	if opts.OrderBy != "" {
+		q.Set("orderBy", opts.OrderBy)
+	}
+	return q
+}
+
+// appendPath appends the route to the path of the request, with the given
+// path variables escaped, so that they may contain "/".
+func appendPath(r *http.Request, route string, vars ...string) {
+	raw := make([]interface{}, len(vars))
+	escaped := make([]interface{}, len(vars))
+	for i, v := range vars {
+		raw[i] = v
+		escaped[i] = url.PathEscape(v)
+	}
+	r.URL.RawPath = r.URL.EscapedPath() + fmt.Sprintf(route, escaped...)
+	r.URL.Path += fmt.Sprintf(route, raw...)
+}
+
+// setIfMatch makes the request conditional on the given revision of the
+// profile, unless it is zero.
+func setIfMatch(r *http.Request, revision int64) {
+	switch revision {
+	case 0:
+	case profile.AnyRevision:
+		r.Header.Set("If-Match", "*")
+	default:
+		r.Header.Set("If-Match", `"`+strconv.FormatInt(revision, 10)+`"`)
+	}
+}
+
+// encodeJSONBody sets the body of the request to the JSON encoding of v.
+func encodeJSONBody(r *http.Request, contentType string, v interface{}) error {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(v); err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", contentType)
+	r.Body = ioutil.NopCloser(&b)
+	r.ContentLength = int64(b.Len())
+	return nil
+}
+
+func decodePostProfileResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var response endpoint.PostProfileResponse
+	err := decodeResponse(r, &response)
+	return response, err
+}
+
+func decodeGetProfileResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var response endpoint.GetProfileResponse
+	err := decodeResponse(r, &response)
+	return response, err
+}
+
+func decodeGetProfilesResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var response endpoint.GetProfilesResponse
+	err := decodeResponse(r, &response)
+	return response, err
+}
+
+func decodePutProfileResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var response endpoint.PutProfileResponse
+	err := decodeResponse(r, &response)
+	return response, err
+}
+
+func decodePatchProfileResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var response endpoint.PatchProfileResponse
+	err := decodeResponse(r, &response)
+	return response, err
+}
+
+func decodeDeleteProfileResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var response endpoint.DeleteProfileResponse
+	err := decodeResponse(r, &response)
+	return response, err
+}
+
+func decodeLookupProfileResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var response endpoint.LookupProfileResponse
+	err := decodeResponse(r, &response)
+	return response, err
+}
+
+func decodeLinkIdentityResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var response endpoint.LinkIdentityResponse
+	err := decodeResponse(r, &response)
+	return response, err
+}
+
+func decodeUnlinkIdentityResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var response endpoint.UnlinkIdentityResponse
+	err := decodeResponse(r, &response)
+	return response, err
+}
+
+func decodeResolveIdentityResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var response endpoint.ResolveIdentityResponse
+	err := decodeResponse(r, &response)
+	return response, err
+}
+
+func decodeMergeProfilesResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var response endpoint.MergeProfilesResponse
+	err := decodeResponse(r, &response)
+	return response, err
+}
+
+func decodeListProfilesResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var response endpoint.ListProfilesResponse
+	err := decodeResponse(r, &response)
+	return response, err
+}
+
+func decodeSearchProfilesResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var response endpoint.SearchProfilesResponse
+	err := decodeResponse(r, &response)
+	return response, err
+}
+
+// decodeResponse decodes the body of a successful response into the given
+// response, or returns the error of a failed response.
+func decodeResponse(r *http.Response, response interface{}) error {
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		return decodeError(r)
+	}
+	if err := json.NewDecoder(r.Body).Decode(response); err != nil {
+		return &profile.Error{Code: profile.Unknown, Message: "superego: invalid response body", Err: err}
+	}
+	return nil
+}
+
+// decodeError returns the *profile.Error described by the problem details of
+// a failed response, or by its status code if it has none, such as the
+// responses of a proxy.
+func decodeError(r *http.Response) error {
+	e := &profile.Error{Code: codeFrom(r.StatusCode), Message: r.Status}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/problem+json" {
+		var p transport.Problem
+		if err := json.NewDecoder(r.Body).Decode(&p); err == nil {
+			if code := profile.ParseCode(p.Code); code != profile.Unknown {
+				e.Code = code
+			}
+			if p.Detail != "" {
+				e.Message = p.Detail
+			}
+			e.Violations = p.Violations
+			e.ExistingID = p.ExistingID
+		}
+	}
+	if r.StatusCode == http.StatusUnprocessableEntity {
+		// so that validation.IsInvalid tells invalid profiles apart, as it
+		// does for a local service.
+		e.Err = validation.ErrInvalid
+	}
+	return e
+}
+
+// codeFrom returns the code corresponding to the HTTP status code, as the
+// reverse of the mapping of the HTTP transport.
+func codeFrom(status int) profile.Code {
+	switch status {
+	case http.StatusNotFound:
+		return profile.NotFound
+	case http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusMethodNotAllowed, http.StatusUnsupportedMediaType:
+		return profile.InvalidArgument
+	case http.StatusConflict:
+		return profile.Conflict
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return profile.PermissionDenied
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout, http.StatusTooManyRequests:
+		return profile.Unavailable
+	case http.StatusPreconditionFailed:
+		return profile.FailedPrecondition
+	}
+	return profile.Unknown
+}
@@ -0,0 +1,355 @@
+// Package dedupe finds profiles which likely belong to the same person, so
+// that they can be reviewed and merged with MergeProfiles.
+package dedupe
+
+import (
+	"context"
+	"encoding/base64"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/benkim0414/superego/pkg/profile"
+)
+
+const (
+	// DefaultMinScore is the minimum score of the pairs reported when no
+	// minimum is given. It is met by a matching email address, or a
+	// matching, swapped or similar name alone.
+	DefaultMinScore = 0.6
+
+	// DefaultCacheTTL is how long a Cache keeps the pairs it found.
+	DefaultCacheTTL = 5 * time.Minute
+
+	// maxBlockSize is the maximum number of profiles sharing a blocking key
+	// which are compared pairwise; larger blocks, such as the profiles of a
+	// very common name, are too unspecific to be worth the quadratic cost.
+	maxBlockSize = 500
+
+	// similarThreshold is the minimum Jaro-Winkler similarity of two names
+	// which are considered similar rather than different.
+	similarThreshold = 0.9
+)
+
+// Pair is a pair of profiles which likely belong to the same person.
+type Pair struct {
+	// The ids of the two profiles, in lexical order.
+	ProfileIDs [2]string `json:"profileIds"`
+	// The likelihood that the profiles are duplicates, from 0 to 1.
+	Score float64 `json:"score"`
+	// The signals which contributed to the score, such as "email".
+	Reasons []string `json:"reasons"`
+}
+
+// Find scans all of the profiles of the service, and returns the pairs of
+// profiles with at least the given score, as Detect.
+func Find(ctx context.Context, s profile.Service, minScore float64) ([]Pair, error) {
+	var profiles []*profile.Profile
+	opts := profile.ListOptions{PageSize: profile.MaxPageSize}
+	for {
+		page, nextPageToken, err := s.ListProfiles(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, page...)
+		if nextPageToken == "" {
+			break
+		}
+		opts.PageToken = nextPageToken
+	}
+	return Detect(profiles, minScore), nil
+}
+
+// Cache runs Find at most once per minimum score within its time to live,
+// so that repeated requests, such as for the pages of the pairs, do not scan
+// all of the profiles every time. Scans run one at a time.
+type Cache struct {
+	s   profile.Service
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[float64]cacheEntry
+}
+
+type cacheEntry struct {
+	pairs   []Pair
+	expires time.Time
+}
+
+// NewCache returns a cache of the pairs of profiles of the service, which
+// are kept for the given time to live.
+func NewCache(s profile.Service, ttl time.Duration) *Cache {
+	return &Cache{s: s, ttl: ttl, now: time.Now, entries: map[float64]cacheEntry{}}
+}
+
+// Find returns the pairs of profiles with at least the given score, as Find,
+// which are found again once they have expired.
+func (c *Cache) Find(ctx context.Context, minScore float64) ([]Pair, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if e, ok := c.entries[minScore]; ok && now.Before(e.expires) {
+		return e.pairs, nil
+	}
+	pairs, err := Find(ctx, c.s, minScore)
+	if err != nil {
+		return nil, err
+	}
+	for score, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, score)
+		}
+	}
+	c.entries[minScore] = cacheEntry{pairs: pairs, expires: now.Add(c.ttl)}
+	return pairs, nil
+}
+
+// Page returns the page of the pairs at the page token, of at most the given
+// number of pairs, along with the token of the next page, as ListProfiles.
+// The pages of pairs found again in between may overlap or skip pairs.
+func Page(pairs []Pair, pageSize int, pageToken string) ([]Pair, string, error) {
+	switch {
+	case pageSize < 0:
+		return nil, "", profile.Errorf(profile.InvalidArgument, "invalid page size %d", pageSize)
+	case pageSize == 0:
+		pageSize = profile.DefaultPageSize
+	case pageSize > profile.MaxPageSize:
+		pageSize = profile.MaxPageSize
+	}
+	offset := 0
+	if pageToken != "" {
+		b, err := base64.RawURLEncoding.DecodeString(pageToken)
+		if err == nil {
+			offset, err = strconv.Atoi(string(b))
+		}
+		if err != nil || offset < 0 {
+			return nil, "", profile.Errorf(profile.InvalidArgument, "invalid page token %q", pageToken)
+		}
+	}
+	if offset > len(pairs) {
+		offset = len(pairs)
+	}
+	end := offset + pageSize
+	if end >= len(pairs) {
+		return pairs[offset:], "", nil
+	}
+	return pairs[offset:end], base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(end))), nil
+}
+
+// Detect returns the pairs of the given profiles with at least the given
+// score, ordered by descending score.
+// Only profiles sharing a normalized email address or a part of their name
+// are compared, so names which differ in both components are not detected.
+func Detect(profiles []*profile.Profile, minScore float64) []Pair {
+	entries := make([]*entry, len(profiles))
+	blocks := map[string][]int{}
+	for i, p := range profiles {
+		entries[i] = newEntry(p)
+		for _, key := range entries[i].blockingKeys() {
+			blocks[key] = append(blocks[key], i)
+		}
+	}
+
+	compared := map[[2]int]bool{}
+	pairs := []Pair{}
+	for _, block := range blocks {
+		if len(block) > maxBlockSize {
+			continue
+		}
+		for x := 0; x < len(block); x++ {
+			for y := x + 1; y < len(block); y++ {
+				i, j := block[x], block[y]
+				if compared[[2]int{i, j}] {
+					continue
+				}
+				compared[[2]int{i, j}] = true
+				score, reasons := compare(entries[i], entries[j])
+				if score < minScore {
+					continue
+				}
+				ids := [2]string{entries[i].id, entries[j].id}
+				if ids[1] < ids[0] {
+					ids[0], ids[1] = ids[1], ids[0]
+				}
+				pairs = append(pairs, Pair{ProfileIDs: ids, Score: score, Reasons: reasons})
+			}
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Score != pairs[j].Score {
+			return pairs[i].Score > pairs[j].Score
+		}
+		if pairs[i].ProfileIDs[0] != pairs[j].ProfileIDs[0] {
+			return pairs[i].ProfileIDs[0] < pairs[j].ProfileIDs[0]
+		}
+		return pairs[i].ProfileIDs[1] < pairs[j].ProfileIDs[1]
+	})
+	return pairs
+}
+
+// entry is the normalized form of a profile which is compared.
+type entry struct {
+	id          string
+	email       string
+	displayName string
+	givenName   string
+	familyName  string
+	// the full name, which falls back to the formatted name.
+	name string
+}
+
+func newEntry(p *profile.Profile) *entry {
+	e := &entry{
+		id:          p.ID,
+		email:       NormalizeEmail(p.Email),
+		displayName: FoldName(p.DisplayName),
+		givenName:   FoldName(p.Name.GivenName),
+		familyName:  FoldName(p.Name.FamilyName),
+	}
+	e.name = strings.TrimSpace(e.givenName + " " + e.familyName)
+	if e.name == "" {
+		e.name = FoldName(p.Name.Formatted)
+	}
+	return e
+}
+
+// blockingKeys returns the keys of the blocks of profiles which the entry is
+// compared with. The name components are also keyed by the initial of the
+// other component, so that a typo in one of them is still detected.
+func (e *entry) blockingKeys() []string {
+	var keys []string
+	if e.email != "" {
+		keys = append(keys, "email:"+e.email)
+	}
+	if e.displayName != "" {
+		keys = append(keys, "displayName:"+e.displayName)
+	}
+	if e.name != "" {
+		tokens := strings.Fields(e.name)
+		sort.Strings(tokens)
+		keys = append(keys, "name:"+strings.Join(tokens, " "))
+	}
+	if e.familyName != "" && e.givenName != "" {
+		keys = append(keys,
+			"familyName:"+e.familyName+":"+initial(e.givenName),
+			"givenName:"+e.givenName+":"+initial(e.familyName))
+	}
+	return keys
+}
+
+// signal is a piece of evidence that two profiles are duplicates.
+type signal struct {
+	reason string
+	weight float64
+}
+
+// compare returns the score of a pair of entries and the reasons for it.
+// The signals are combined as independent evidence, so that every signal
+// raises the score without exceeding 1.
+func compare(a, b *entry) (float64, []string) {
+	var signals []signal
+	if a.email != "" && a.email == b.email {
+		signals = append(signals, signal{"email", 0.9})
+	}
+	switch {
+	case a.name == "" || b.name == "":
+	case a.name == b.name:
+		signals = append(signals, signal{"name", 0.7})
+	case a.givenName != "" && a.givenName == b.familyName && a.familyName == b.givenName:
+		signals = append(signals, signal{"swapped name", 0.6})
+	default:
+		if similarity := jaroWinkler(a.name, b.name); similarity >= similarThreshold {
+			signals = append(signals, signal{"similar name", 0.7 * similarity})
+		}
+	}
+	switch {
+	case a.displayName == "" || b.displayName == "":
+	case a.displayName == b.displayName:
+		signals = append(signals, signal{"displayName", 0.5})
+	default:
+		if similarity := jaroWinkler(a.displayName, b.displayName); similarity >= similarThreshold {
+			signals = append(signals, signal{"similar displayName", 0.5 * similarity})
+		}
+	}
+
+	unlikely := 1.0
+	reasons := make([]string, 0, len(signals))
+	for _, s := range signals {
+		unlikely *= 1 - s.weight
+		reasons = append(reasons, s.reason)
+	}
+	return 1 - unlikely, reasons
+}
+
+// initial returns the first character of s.
+func initial(s string) string {
+	_, size := utf8.DecodeRuneInString(s)
+	return s[:size]
+}
+
+// jaroWinkler returns the Jaro-Winkler similarity of two strings, from 0 for
+// no similarity to 1 for equal strings, which favors common prefixes.
+func jaroWinkler(s, t string) float64 {
+	a, b := []rune(s), []rune(t)
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	window := max(len(a), len(b))/2 - 1
+	if window < 0 {
+		window = 0
+	}
+	matchedA, matchedB := make([]bool, len(a)), make([]bool, len(b))
+	matches := 0
+	for i := range a {
+		for j := max(0, i-window); j < min(len(b), i+window+1); j++ {
+			if !matchedB[j] && a[i] == b[j] {
+				matchedA[i], matchedB[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+	transpositions, j := 0, 0
+	for i := range a {
+		if !matchedA[i] {
+			continue
+		}
+		for !matchedB[j] {
+			j++
+		}
+		if a[i] != b[j] {
+			transpositions++
+		}
+		j++
+	}
+	m := float64(matches)
+	jaro := (m/float64(len(a)) + m/float64(len(b)) + (m-float64(transpositions)/2)/m) / 3
+
+	prefix := 0
+	for prefix < min(4, min(len(a), len(b))) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
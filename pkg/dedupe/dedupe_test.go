@@ -0,0 +1,164 @@
+package dedupe
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/benkim0414/superego/pkg/profile"
+)
+
+func TestDetect(t *testing.T) {
+	profiles := []*profile.Profile{
+		{ID: "a", Email: "gun.woo@gmail.com", DisplayName: "benkim0414"},
+		{ID: "b", Email: "gunwoo+work@gmail.com"},
+		{ID: "c", Email: "c@gunwoo.org", Name: profile.Name{GivenName: "José", FamilyName: "Álvarez"}},
+		{ID: "d", Email: "d@gunwoo.org", Name: profile.Name{GivenName: "Jose", FamilyName: "Alvarez"}, DisplayName: "Jose"},
+		{ID: "e", Email: "e@gunwoo.org", Name: profile.Name{GivenName: "Alvarez", FamilyName: "Jose"}},
+		{ID: "f", Email: "f@gunwoo.org", Name: profile.Name{GivenName: "Jonathan", FamilyName: "Smith"}},
+		{ID: "g", Email: "g@gunwoo.org", Name: profile.Name{GivenName: "Jonathon", FamilyName: "Smith"}},
+		{ID: "h", Email: "h@gunwoo.org", Name: profile.Name{GivenName: "Mary", FamilyName: "Smith"}},
+	}
+
+	got := map[[2]string][]string{}
+	for _, pair := range Detect(profiles, DefaultMinScore) {
+		got[pair.ProfileIDs] = pair.Reasons
+	}
+	want := map[[2]string][]string{
+		{"a", "b"}: {"email"},
+		{"c", "d"}: {"name"},
+		{"c", "e"}: {"swapped name"},
+		{"d", "e"}: {"swapped name"},
+		{"f", "g"}: {"similar name"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Detect: got %v, want %v", got, want)
+	}
+
+	if pairs := Detect(profiles, 0.8); len(pairs) != 1 || pairs[0].ProfileIDs != [2]string{"a", "b"} {
+		t.Errorf("Detect: got %v, want only the pair of matching emails", pairs)
+	}
+	// a minimum score of zero returns all of the compared pairs.
+	unlikely := []*profile.Profile{
+		{ID: "x", Name: profile.Name{GivenName: "Ann", FamilyName: "Lee"}},
+		{ID: "y", Name: profile.Name{GivenName: "Ann", FamilyName: "Long"}},
+	}
+	if pairs := Detect(unlikely, 0); len(pairs) != 1 || pairs[0].Score != 0 {
+		t.Errorf("Detect: got %v, want the pair of score 0", pairs)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	a := newEntry(&profile.Profile{Email: "gunwoo@gunwoo.org", DisplayName: "benkim0414", Name: profile.Name{GivenName: "Gunwoo", FamilyName: "Kim"}})
+	b := newEntry(&profile.Profile{Email: "Gunwoo+x@gunwoo.org", DisplayName: "BenKim0414", Name: profile.Name{GivenName: "Gunwoo", FamilyName: "Kim"}})
+	score, reasons := compare(a, b)
+	// 1 - (1-0.9)(1-0.7)(1-0.5)
+	if math.Abs(score-0.985) > 1e-9 {
+		t.Errorf("compare: got score %v, want %v", score, 0.985)
+	}
+	if !reflect.DeepEqual(reasons, []string{"email", "name", "displayName"}) {
+		t.Errorf("compare: got reasons %v", reasons)
+	}
+}
+
+func TestJaroWinkler(t *testing.T) {
+	tests := []struct {
+		s, t string
+		want float64
+	}{
+		{"martha", "marhta", 0.961},
+		{"dixon", "dicksonx", 0.813},
+		{"gunwoo", "gunwoo", 1},
+		{"abc", "xyz", 0},
+		{"", "gunwoo", 0},
+	}
+	for _, tt := range tests {
+		if got := jaroWinkler(tt.s, tt.t); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("jaroWinkler(%q, %q): got %.3f, want %.3f", tt.s, tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	ctx := context.Background()
+	for _, p := range []*profile.Profile{
+		{ID: "find-a", Email: "find@gmail.com"},
+		{ID: "find-b", Email: "f.ind@gmail.com"},
+	} {
+		if _, err := profile.FakeService.PostProfile(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+		defer profile.FakeService.DeleteProfile(ctx, p.ID)
+	}
+
+	pairs, err := Find(ctx, profile.FakeService, DefaultMinScore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 1 || pairs[0].ProfileIDs != [2]string{"find-a", "find-b"} {
+		t.Errorf("Find: got %v", pairs)
+	}
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	s := profile.NewFakeService()
+	for _, email := range []string{"cache@gmail.com", "c.ache@gmail.com"} {
+		if _, err := s.PostProfile(ctx, &profile.Profile{Email: email}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	c := NewCache(s, time.Minute)
+	c.now = func() time.Time { return now }
+
+	find := func(want int) {
+		t.Helper()
+		pairs, err := c.Find(ctx, DefaultMinScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pairs) != want {
+			t.Errorf("Find: got %v, want %d pairs", pairs, want)
+		}
+	}
+	find(1)
+	if _, err := s.PostProfile(ctx, &profile.Profile{Email: "cach.e@gmail.com"}); err != nil {
+		t.Fatal(err)
+	}
+	// the pairs are cached until they expire.
+	find(1)
+	now = now.Add(time.Minute)
+	find(3)
+}
+
+func TestPage(t *testing.T) {
+	pairs := make([]Pair, 5)
+	for i := range pairs {
+		pairs[i].Score = float64(i)
+	}
+	var got []Pair
+	token := ""
+	for {
+		page, next, err := Page(pairs, 2, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > 2 {
+			t.Errorf("Page: got %d pairs, want at most 2", len(page))
+		}
+		got = append(got, page...)
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	if !reflect.DeepEqual(got, pairs) {
+		t.Errorf("Page: got %v, want %v", got, pairs)
+	}
+	if _, _, err := Page(pairs, 2, "invalid"); profile.ErrorCode(err) != profile.InvalidArgument {
+		t.Errorf("Page: got %v, want an InvalidArgument error", err)
+	}
+}
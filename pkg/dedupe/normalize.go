@@ -0,0 +1,65 @@
+package dedupe
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// dotlessDomains are the email providers which ignore dots in the local
+// part of an address, mapped to their canonical domain.
+var dotlessDomains = map[string]string{
+	"gmail.com":      "gmail.com",
+	"googlemail.com": "gmail.com",
+}
+
+// NormalizeEmail returns the canonical form of an email address, under which
+// different spellings of the same mailbox are equal: it is lowercased, the
+// "+tag" of plus-addressing is removed, and so are the dots of the local part
+// for providers which ignore them, such as gmail.com.
+func NormalizeEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	local, domain := email[:at], email[at+1:]
+	if plus := strings.Index(local, "+"); plus >= 0 {
+		local = local[:plus]
+	}
+	if canonical, ok := dotlessDomains[domain]; ok {
+		local = strings.Replace(local, ".", "", -1)
+		domain = canonical
+	}
+	return local + "@" + domain
+}
+
+// FoldName returns the loose form of a name, under which names that differ
+// only in case, diacritics, compatibility forms (such as full-width
+// letters), punctuation or spacing are equal. It approximates the primary
+// strength of the Unicode collation algorithm for alphabetic scripts; the
+// tables of golang.org/x/text/collate are not vendored, so it is built on
+// the compatibility decomposition of golang.org/x/text/unicode/norm.
+func FoldName(name string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range norm.NFKD.String(name) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// combining marks, such as the accent of "é".
+		case unicode.IsSpace(r) || unicode.Is(unicode.Pd, r):
+			// "Jean-Luc" is the same as "Jean Luc".
+			space = b.Len() > 0
+		case unicode.IsPunct(r):
+			// "O'Brien" is the same as "OBrien".
+		default:
+			if space {
+				b.WriteByte(' ')
+				space = false
+			}
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return norm.NFC.String(b.String())
+}
@@ -0,0 +1,41 @@
+package dedupe
+
+import "testing"
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		email, want string
+	}{
+		{"gunwoo@gunwoo.org", "gunwoo@gunwoo.org"},
+		{" Gunwoo@Gunwoo.ORG ", "gunwoo@gunwoo.org"},
+		{"gunwoo+superego@gunwoo.org", "gunwoo@gunwoo.org"},
+		{"gun.woo@gunwoo.org", "gun.woo@gunwoo.org"},
+		{"Gun.Woo+spam@gmail.com", "gunwoo@gmail.com"},
+		{"gun.woo@googlemail.com", "gunwoo@gmail.com"},
+		{"gunwoo", "gunwoo"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeEmail(tt.email); got != tt.want {
+			t.Errorf("NormalizeEmail(%q): got %q, want %q", tt.email, got, tt.want)
+		}
+	}
+}
+
+func TestFoldName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"Gunwoo Kim", "gunwoo kim"},
+		{"  Gunwoo   KIM ", "gunwoo kim"},
+		{"José Álvarez", "jose alvarez"},
+		{"José", "jose"},
+		{"Ｇｕｎｗｏｏ", "gunwoo"},
+		{"Jean-Luc O'Brien", "jean luc obrien"},
+		{"김건우", "김건우"},
+	}
+	for _, tt := range tests {
+		if got := FoldName(tt.name); got != tt.want {
+			t.Errorf("FoldName(%q): got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package endpoint
 
 import (
 	"context"
+	"encoding/json"
 
+	"github.com/benkim0414/superego/pkg/dedupe"
 	"github.com/benkim0414/superego/pkg/profile"
 	"github.com/benkim0414/superego/pkg/service"
 	"github.com/go-kit/kit/endpoint"
@@ -14,11 +16,21 @@ import (
 // It's meant to be used as a helper struct, to collect all of the endpoints
 // into a single parameter.
 type Endpoints struct {
-	PostProfileEndpoint   endpoint.Endpoint
-	GetProfileEndpoint    endpoint.Endpoint
-	PutProfileEndpoint    endpoint.Endpoint
-	PatchProfileEndpoint  endpoint.Endpoint
-	DeleteProfileEndpoint endpoint.Endpoint
+	PostProfileEndpoint     endpoint.Endpoint
+	GetProfileEndpoint      endpoint.Endpoint
+	GetProfilesEndpoint     endpoint.Endpoint
+	PutProfileEndpoint      endpoint.Endpoint
+	PatchProfileEndpoint    endpoint.Endpoint
+	DeleteProfileEndpoint   endpoint.Endpoint
+	LookupProfileEndpoint   endpoint.Endpoint
+	ListIdentitiesEndpoint  endpoint.Endpoint
+	LinkIdentityEndpoint    endpoint.Endpoint
+	UnlinkIdentityEndpoint  endpoint.Endpoint
+	ResolveIdentityEndpoint endpoint.Endpoint
+	MergeProfilesEndpoint   endpoint.Endpoint
+	FindDuplicatesEndpoint  endpoint.Endpoint
+	ListProfilesEndpoint    endpoint.Endpoint
+	SearchProfilesEndpoint  endpoint.Endpoint
 }
 
 // New returns an Endpoints struct where each endpoint
@@ -34,6 +46,11 @@ func New(s service.Service, logger log.Logger, duration metrics.Histogram) Endpo
 	getProfileEndpoint = LoggingMiddleware(log.With(logger, "method", "GetProfile"))(getProfileEndpoint)
 	getProfileEndpoint = InstrumentingMiddleware(duration.With("method", "GetProfile"))(getProfileEndpoint)
 
+	var getProfilesEndpoint endpoint.Endpoint
+	getProfilesEndpoint = MakeGetProfilesEndpoint(s)
+	getProfilesEndpoint = LoggingMiddleware(log.With(logger, "method", "GetProfiles"))(getProfilesEndpoint)
+	getProfilesEndpoint = InstrumentingMiddleware(duration.With("method", "GetProfiles"))(getProfilesEndpoint)
+
 	var putProfileEndpoint endpoint.Endpoint
 	putProfileEndpoint = MakePutProfileEndpoint(s)
 	putProfileEndpoint = LoggingMiddleware(log.With(logger, "method", "PutProfile"))(putProfileEndpoint)
@@ -49,15 +66,77 @@ func New(s service.Service, logger log.Logger, duration metrics.Histogram) Endpo
 	deleteProfileEndpoint = LoggingMiddleware(log.With(logger, "method", "DeleteProfile"))(deleteProfileEndpoint)
 	deleteProfileEndpoint = InstrumentingMiddleware(duration.With("method", "DeleteProfile"))(deleteProfileEndpoint)
 
+	var lookupProfileEndpoint endpoint.Endpoint
+	lookupProfileEndpoint = MakeLookupProfileEndpoint(s)
+	lookupProfileEndpoint = LoggingMiddleware(log.With(logger, "method", "LookupProfile"))(lookupProfileEndpoint)
+	lookupProfileEndpoint = InstrumentingMiddleware(duration.With("method", "LookupProfile"))(lookupProfileEndpoint)
+
+	var listIdentitiesEndpoint endpoint.Endpoint
+	listIdentitiesEndpoint = MakeListIdentitiesEndpoint(s)
+	listIdentitiesEndpoint = LoggingMiddleware(log.With(logger, "method", "ListIdentities"))(listIdentitiesEndpoint)
+	listIdentitiesEndpoint = InstrumentingMiddleware(duration.With("method", "ListIdentities"))(listIdentitiesEndpoint)
+
+	var linkIdentityEndpoint endpoint.Endpoint
+	linkIdentityEndpoint = MakeLinkIdentityEndpoint(s)
+	linkIdentityEndpoint = LoggingMiddleware(log.With(logger, "method", "LinkIdentity"))(linkIdentityEndpoint)
+	linkIdentityEndpoint = InstrumentingMiddleware(duration.With("method", "LinkIdentity"))(linkIdentityEndpoint)
+
+	var unlinkIdentityEndpoint endpoint.Endpoint
+	unlinkIdentityEndpoint = MakeUnlinkIdentityEndpoint(s)
+	unlinkIdentityEndpoint = LoggingMiddleware(log.With(logger, "method", "UnlinkIdentity"))(unlinkIdentityEndpoint)
+	unlinkIdentityEndpoint = InstrumentingMiddleware(duration.With("method", "UnlinkIdentity"))(unlinkIdentityEndpoint)
+
+	var resolveIdentityEndpoint endpoint.Endpoint
+	resolveIdentityEndpoint = MakeResolveIdentityEndpoint(s)
+	resolveIdentityEndpoint = LoggingMiddleware(log.With(logger, "method", "ResolveIdentity"))(resolveIdentityEndpoint)
+	resolveIdentityEndpoint = InstrumentingMiddleware(duration.With("method", "ResolveIdentity"))(resolveIdentityEndpoint)
+
+	var mergeProfilesEndpoint endpoint.Endpoint
+	mergeProfilesEndpoint = MakeMergeProfilesEndpoint(s)
+	mergeProfilesEndpoint = LoggingMiddleware(log.With(logger, "method", "MergeProfiles"))(mergeProfilesEndpoint)
+	mergeProfilesEndpoint = InstrumentingMiddleware(duration.With("method", "MergeProfiles"))(mergeProfilesEndpoint)
+
+	var findDuplicatesEndpoint endpoint.Endpoint
+	findDuplicatesEndpoint = MakeFindDuplicatesEndpoint(s)
+	findDuplicatesEndpoint = LoggingMiddleware(log.With(logger, "method", "FindDuplicates"))(findDuplicatesEndpoint)
+	findDuplicatesEndpoint = InstrumentingMiddleware(duration.With("method", "FindDuplicates"))(findDuplicatesEndpoint)
+
+	var listProfilesEndpoint endpoint.Endpoint
+	listProfilesEndpoint = MakeListProfilesEndpoint(s)
+	listProfilesEndpoint = LoggingMiddleware(log.With(logger, "method", "ListProfiles"))(listProfilesEndpoint)
+	listProfilesEndpoint = InstrumentingMiddleware(duration.With("method", "ListProfiles"))(listProfilesEndpoint)
+
+	var searchProfilesEndpoint endpoint.Endpoint
+	searchProfilesEndpoint = MakeSearchProfilesEndpoint(s)
+	searchProfilesEndpoint = LoggingMiddleware(log.With(logger, "method", "SearchProfiles"))(searchProfilesEndpoint)
+	searchProfilesEndpoint = InstrumentingMiddleware(duration.With("method", "SearchProfiles"))(searchProfilesEndpoint)
+
 	return Endpoints{
-		PostProfileEndpoint:   postProfileEndpoint,
-		GetProfileEndpoint:    getProfileEndpoint,
-		PutProfileEndpoint:    putProfileEndpoint,
-		PatchProfileEndpoint:  patchProfileEndpoint,
-		DeleteProfileEndpoint: deleteProfileEndpoint,
+		PostProfileEndpoint:     postProfileEndpoint,
+		GetProfileEndpoint:      getProfileEndpoint,
+		GetProfilesEndpoint:     getProfilesEndpoint,
+		PutProfileEndpoint:      putProfileEndpoint,
+		PatchProfileEndpoint:    patchProfileEndpoint,
+		DeleteProfileEndpoint:   deleteProfileEndpoint,
+		LookupProfileEndpoint:   lookupProfileEndpoint,
+		ListIdentitiesEndpoint:  listIdentitiesEndpoint,
+		LinkIdentityEndpoint:    linkIdentityEndpoint,
+		UnlinkIdentityEndpoint:  unlinkIdentityEndpoint,
+		ResolveIdentityEndpoint: resolveIdentityEndpoint,
+		MergeProfilesEndpoint:   mergeProfilesEndpoint,
+		FindDuplicatesEndpoint:  findDuplicatesEndpoint,
+		ListProfilesEndpoint:    listProfilesEndpoint,
+		SearchProfilesEndpoint:  searchProfilesEndpoint,
 	}
 }
 
+// Failer is implemented by all response types that may contain an error
+// returned by the service. It allows transports to encode the error without
+// needing to trigger an endpoint (transport-level) error.
+type Failer interface {
+	Failed() error
+}
+
 // MakePostProfileEndpoint returns an endpoint via the passed service.
 func MakePostProfileEndpoint(s service.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
@@ -72,7 +151,16 @@ func MakeGetProfileEndpoint(s service.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(GetProfileRequest)
 		p, e := s.GetProfile(ctx, req.ID)
-		return GetProfileResponse{Profile: p, Err: e}, nil
+		return GetProfileResponse{Profile: p, Fields: req.Fields, Err: e}, nil
+	}
+}
+
+// MakeGetProfilesEndpoint returns an endpoint via the passed service.
+func MakeGetProfilesEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(GetProfilesRequest)
+		p, e := s.GetProfiles(ctx, req.IDs)
+		return GetProfilesResponse{Profiles: p, Fields: req.Fields, Err: e}, nil
 	}
 }
 
@@ -80,7 +168,7 @@ func MakeGetProfileEndpoint(s service.Service) endpoint.Endpoint {
 func MakePutProfileEndpoint(s service.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(PutProfileRequest)
-		p, e := s.PutProfile(ctx, req.ID, req.Profile)
+		p, e := s.PutProfile(withRevision(ctx, req.Revision), req.ID, req.Profile)
 		return PutProfileResponse{Profile: p, Err: e}, nil
 	}
 }
@@ -89,7 +177,7 @@ func MakePutProfileEndpoint(s service.Service) endpoint.Endpoint {
 func MakePatchProfileEndpoint(s service.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(PatchProfileRequest)
-		p, e := s.PatchProfile(ctx, req.ID, req.Profile)
+		p, e := s.PatchProfile(withRevision(ctx, req.Revision), req.ID, req.Patch)
 		return PatchProfileResponse{Profile: p, Err: e}, nil
 	}
 }
@@ -98,11 +186,116 @@ func MakePatchProfileEndpoint(s service.Service) endpoint.Endpoint {
 func MakeDeleteProfileEndpoint(s service.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(DeleteProfileRequest)
-		e := s.DeleteProfile(ctx, req.ID)
+		e := s.DeleteProfile(withRevision(ctx, req.Revision), req.ID)
 		return DeleteProfileResponse{Err: e}, nil
 	}
 }
 
+// MakeLookupProfileEndpoint returns an endpoint via the passed service.
+func MakeLookupProfileEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(LookupProfileRequest)
+		p, e := s.LookupProfile(ctx, req.Email)
+		return LookupProfileResponse{Profile: p, Err: e}, nil
+	}
+}
+
+// MakeListIdentitiesEndpoint returns an endpoint via the passed service.
+func MakeListIdentitiesEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ListIdentitiesRequest)
+		p, e := s.GetProfile(ctx, req.ID)
+		if e != nil {
+			return ListIdentitiesResponse{Err: e}, nil
+		}
+		identities := p.Identities
+		if identities == nil {
+			identities = []profile.Identity{}
+		}
+		return ListIdentitiesResponse{Identities: identities}, nil
+	}
+}
+
+// MakeLinkIdentityEndpoint returns an endpoint via the passed service.
+func MakeLinkIdentityEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(LinkIdentityRequest)
+		p, e := s.LinkIdentity(withRevision(ctx, req.Revision), req.ID, req.Identity)
+		return LinkIdentityResponse{Profile: p, Err: e}, nil
+	}
+}
+
+// MakeUnlinkIdentityEndpoint returns an endpoint via the passed service.
+func MakeUnlinkIdentityEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(UnlinkIdentityRequest)
+		p, e := s.UnlinkIdentity(withRevision(ctx, req.Revision), req.ID, req.Provider, req.Subject)
+		return UnlinkIdentityResponse{Profile: p, Err: e}, nil
+	}
+}
+
+// MakeResolveIdentityEndpoint returns an endpoint via the passed service.
+func MakeResolveIdentityEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ResolveIdentityRequest)
+		p, e := s.ResolveIdentity(ctx, req.Provider, req.Subject)
+		return ResolveIdentityResponse{Profile: p, Err: e}, nil
+	}
+}
+
+// MakeMergeProfilesEndpoint returns an endpoint via the passed service.
+func MakeMergeProfilesEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(MergeProfilesRequest)
+		p, e := s.MergeProfiles(withRevision(ctx, req.Revision), req.SurvivorID, req.VictimID, req.Strategy)
+		return MergeProfilesResponse{Profile: p, Err: e}, nil
+	}
+}
+
+// MakeFindDuplicatesEndpoint returns an endpoint which runs the duplicate
+// detection job over all of the profiles of the passed service, and returns
+// a page of the pairs. The pairs are cached for dedupe.DefaultCacheTTL, so
+// that the pages do not run the job again.
+func MakeFindDuplicatesEndpoint(s service.Service) endpoint.Endpoint {
+	cache := dedupe.NewCache(s, dedupe.DefaultCacheTTL)
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(FindDuplicatesRequest)
+		pairs, e := cache.Find(ctx, req.MinScore)
+		if e != nil {
+			return FindDuplicatesResponse{Err: e}, nil
+		}
+		page, token, e := dedupe.Page(pairs, req.PageSize, req.PageToken)
+		return FindDuplicatesResponse{Duplicates: page, NextPageToken: token, Err: e}, nil
+	}
+}
+
+// MakeListProfilesEndpoint returns an endpoint via the passed service.
+func MakeListProfilesEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ListProfilesRequest)
+		p, token, e := s.ListProfiles(ctx, req.Options)
+		return ListProfilesResponse{Profiles: p, NextPageToken: token, Fields: req.Fields, Err: e}, nil
+	}
+}
+
+// MakeSearchProfilesEndpoint returns an endpoint via the passed service.
+func MakeSearchProfilesEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(SearchProfilesRequest)
+		p, token, e := s.SearchProfiles(ctx, req.Query, req.Options)
+		return SearchProfilesResponse{Profiles: p, NextPageToken: token, Fields: req.Fields, Err: e}, nil
+	}
+}
+
+// withRevision returns a copy of the context which expects the given revision
+// of the profile, unless it is zero.
+func withRevision(ctx context.Context, revision int64) context.Context {
+	if revision == 0 {
+		return ctx
+	}
+	return profile.WithExpectedRevision(ctx, revision)
+}
+
 type PostProfileRequest struct {
 	Profile *profile.Profile `json:"profile"`
 }
@@ -112,22 +305,73 @@ type PostProfileResponse struct {
 	Err     error            `json:"err,omitempty"`
 }
 
-func (r PostProfileResponse) error() error { return r.Err }
+func (r PostProfileResponse) Failed() error { return r.Err }
 
 type GetProfileRequest struct {
 	ID string `json:"id"`
+	// The fields of the profile to respond with, or all of them if empty.
+	Fields profile.FieldMask `json:"fields,omitempty"`
 }
 
 type GetProfileResponse struct {
-	Profile *profile.Profile `json:"profile,omitempty"`
-	Err     error            `json:"err,omitempty"`
+	Profile *profile.Profile  `json:"profile,omitempty"`
+	Fields  profile.FieldMask `json:"-"`
+	Err     error             `json:"err,omitempty"`
+}
+
+func (r GetProfileResponse) Failed() error { return r.Err }
+
+// MarshalJSON encodes only the fields of the profile in the field mask.
+func (r GetProfileResponse) MarshalJSON() ([]byte, error) {
+	var p *profile.Partial
+	if r.Profile != nil {
+		p = &profile.Partial{Profile: r.Profile, Mask: r.Fields}
+	}
+	return json.Marshal(struct {
+		Profile *profile.Partial `json:"profile,omitempty"`
+		Err     error            `json:"err,omitempty"`
+	}{p, r.Err})
+}
+
+type GetProfilesRequest struct {
+	IDs []string `json:"ids"`
+	// The fields of the profiles to respond with, or all of them if empty.
+	Fields profile.FieldMask `json:"fields,omitempty"`
+}
+
+type GetProfilesResponse struct {
+	// The profiles in the order of the ids, which are null if they do not
+	// exist.
+	Profiles []*profile.Profile `json:"profiles"`
+	Fields   profile.FieldMask  `json:"-"`
+	Err      error              `json:"err,omitempty"`
 }
 
-func (r GetProfileResponse) error() error { return r.Err }
+func (r GetProfilesResponse) Failed() error { return r.Err }
+
+// MarshalJSON encodes only the fields of the profiles in the field mask.
+func (r GetProfilesResponse) MarshalJSON() ([]byte, error) {
+	var partials []*profile.Partial
+	if r.Profiles != nil {
+		partials = make([]*profile.Partial, len(r.Profiles))
+		for i, p := range r.Profiles {
+			if p != nil {
+				partials[i] = &profile.Partial{Profile: p, Mask: r.Fields}
+			}
+		}
+	}
+	return json.Marshal(struct {
+		Profiles []*profile.Partial `json:"profiles"`
+		Err      error              `json:"err,omitempty"`
+	}{partials, r.Err})
+}
 
 type PutProfileRequest struct {
 	ID      string           `json:"id"`
 	Profile *profile.Profile `json:"profile"`
+	// The expected revision of the profile, or zero to update it
+	// unconditionally.
+	Revision int64 `json:"revision,omitempty"`
 }
 
 type PutProfileResponse struct {
@@ -135,11 +379,14 @@ type PutProfileResponse struct {
 	Err     error            `json:"err,omitempty"`
 }
 
-func (r PutProfileResponse) error() error { return r.Err }
+func (r PutProfileResponse) Failed() error { return r.Err }
 
 type PatchProfileRequest struct {
-	ID      string           `json:"id"`
-	Profile *profile.Profile `json:"profile"`
+	ID    string        `json:"id"`
+	Patch profile.Patch `json:"patch"`
+	// The expected revision of the profile, or zero to update it
+	// unconditionally.
+	Revision int64 `json:"revision,omitempty"`
 }
 
 type PatchProfileResponse struct {
@@ -147,14 +394,171 @@ type PatchProfileResponse struct {
 	Err     error            `json:"err,omitempty"`
 }
 
-func (r PatchProfileResponse) error() error { return r.Err }
+func (r PatchProfileResponse) Failed() error { return r.Err }
 
 type DeleteProfileRequest struct {
 	ID string
+	// The expected revision of the profile, or zero to delete it
+	// unconditionally.
+	Revision int64 `json:"revision,omitempty"`
 }
 
 type DeleteProfileResponse struct {
 	Err error `json:"err,omitempty"`
 }
 
-func (r DeleteProfileResponse) error() error { return r.Err }
+func (r DeleteProfileResponse) Failed() error { return r.Err }
+
+type LookupProfileRequest struct {
+	Email string `json:"email"`
+}
+
+type LookupProfileResponse struct {
+	Profile *profile.Profile `json:"profile,omitempty"`
+	Err     error            `json:"err,omitempty"`
+}
+
+func (r LookupProfileResponse) Failed() error { return r.Err }
+
+type ListIdentitiesRequest struct {
+	ID string `json:"id"`
+}
+
+type ListIdentitiesResponse struct {
+	Identities []profile.Identity `json:"identities"`
+	Err        error              `json:"err,omitempty"`
+}
+
+func (r ListIdentitiesResponse) Failed() error { return r.Err }
+
+type LinkIdentityRequest struct {
+	ID       string           `json:"id"`
+	Identity profile.Identity `json:"identity"`
+	// The expected revision of the profile, or zero to link the identity
+	// unconditionally.
+	Revision int64 `json:"revision,omitempty"`
+}
+
+type LinkIdentityResponse struct {
+	Profile *profile.Profile `json:"profile,omitempty"`
+	Err     error            `json:"err,omitempty"`
+}
+
+func (r LinkIdentityResponse) Failed() error { return r.Err }
+
+type UnlinkIdentityRequest struct {
+	ID       string `json:"id"`
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+	// The expected revision of the profile, or zero to unlink the identity
+	// unconditionally.
+	Revision int64 `json:"revision,omitempty"`
+}
+
+type UnlinkIdentityResponse struct {
+	Profile *profile.Profile `json:"profile,omitempty"`
+	Err     error            `json:"err,omitempty"`
+}
+
+func (r UnlinkIdentityResponse) Failed() error { return r.Err }
+
+type ResolveIdentityRequest struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+}
+
+type ResolveIdentityResponse struct {
+	Profile *profile.Profile `json:"profile,omitempty"`
+	Err     error            `json:"err,omitempty"`
+}
+
+func (r ResolveIdentityResponse) Failed() error { return r.Err }
+
+type MergeProfilesRequest struct {
+	SurvivorID string                `json:"survivorId"`
+	VictimID   string                `json:"victimId"`
+	Strategy   profile.MergeStrategy `json:"strategy"`
+	// The expected revision of the surviving profile, or zero to merge
+	// unconditionally.
+	Revision int64 `json:"revision,omitempty"`
+}
+
+type MergeProfilesResponse struct {
+	Profile *profile.Profile `json:"profile,omitempty"`
+	Err     error            `json:"err,omitempty"`
+}
+
+func (r MergeProfilesResponse) Failed() error { return r.Err }
+
+type FindDuplicatesRequest struct {
+	// The minimum score of the returned pairs.
+	MinScore  float64 `json:"minScore"`
+	PageSize  int     `json:"pageSize,omitempty"`
+	PageToken string  `json:"pageToken,omitempty"`
+}
+
+type FindDuplicatesResponse struct {
+	Duplicates    []dedupe.Pair `json:"duplicates"`
+	NextPageToken string        `json:"nextPageToken,omitempty"`
+	Err           error         `json:"err,omitempty"`
+}
+
+func (r FindDuplicatesResponse) Failed() error { return r.Err }
+
+type ListProfilesRequest struct {
+	Options profile.ListOptions `json:"options"`
+	// The fields of the profiles to respond with, or all of them if empty.
+	Fields profile.FieldMask `json:"fields,omitempty"`
+}
+
+type ListProfilesResponse struct {
+	Profiles      []*profile.Profile `json:"profiles"`
+	NextPageToken string             `json:"nextPageToken,omitempty"`
+	Fields        profile.FieldMask  `json:"-"`
+	Err           error              `json:"err,omitempty"`
+}
+
+func (r ListProfilesResponse) Failed() error { return r.Err }
+
+// MarshalJSON encodes only the fields of the profiles in the field mask.
+func (r ListProfilesResponse) MarshalJSON() ([]byte, error) {
+	return marshalPage(r.Profiles, r.NextPageToken, r.Fields, r.Err)
+}
+
+type SearchProfilesRequest struct {
+	Query   profile.SearchQuery `json:"query"`
+	Options profile.ListOptions `json:"options"`
+	// The fields of the profiles to respond with, or all of them if empty.
+	Fields profile.FieldMask `json:"fields,omitempty"`
+}
+
+type SearchProfilesResponse struct {
+	Profiles      []*profile.Profile `json:"profiles"`
+	NextPageToken string             `json:"nextPageToken,omitempty"`
+	Fields        profile.FieldMask  `json:"-"`
+	Err           error              `json:"err,omitempty"`
+}
+
+func (r SearchProfilesResponse) Failed() error { return r.Err }
+
+// MarshalJSON encodes only the fields of the profiles in the field mask.
+func (r SearchProfilesResponse) MarshalJSON() ([]byte, error) {
+	return marshalPage(r.Profiles, r.NextPageToken, r.Fields, r.Err)
+}
+
+// marshalPage encodes a page of profiles of which only the fields in the
+// field mask are encoded.
+func marshalPage(profiles []*profile.Profile, nextPageToken string, fields profile.FieldMask, err error) ([]byte, error) {
+	var partials []profile.Partial
+	if profiles != nil {
+		partials = make([]profile.Partial, len(profiles))
+		for i, p := range profiles {
+			partials[i] = profile.Partial{Profile: p, Mask: fields}
+		}
+	}
+	return json.Marshal(struct {
+		Profiles      []profile.Partial `json:"profiles"`
+		NextPageToken string            `json:"nextPageToken,omitempty"`
+		Err           error             `json:"err,omitempty"`
+	}{partials, nextPageToken, err})
+}
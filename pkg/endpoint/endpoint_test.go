@@ -40,6 +40,14 @@ func TestNew(t *testing.T) {
 	deleteProfileEndpoint = LoggingMiddleware(log.With(logger, "method", "DeleteProfile"))(deleteProfileEndpoint)
 	deleteProfileEndpoint = InstrumentingMiddleware(duration.With("method", "DeleteProfile"))(deleteProfileEndpoint)
 
+	listProfilesEndpoint := MakeListProfilesEndpoint(profile.FakeService)
+	listProfilesEndpoint = LoggingMiddleware(log.With(logger, "method", "ListProfiles"))(listProfilesEndpoint)
+	listProfilesEndpoint = InstrumentingMiddleware(duration.With("method", "ListProfiles"))(listProfilesEndpoint)
+
+	searchProfilesEndpoint := MakeSearchProfilesEndpoint(profile.FakeService)
+	searchProfilesEndpoint = LoggingMiddleware(log.With(logger, "method", "SearchProfiles"))(searchProfilesEndpoint)
+	searchProfilesEndpoint = InstrumentingMiddleware(duration.With("method", "SearchProfiles"))(searchProfilesEndpoint)
+
 	endpoints := New(profile.FakeService, logger, duration)
 	ctx := context.Background()
 	var req interface{}
@@ -72,15 +80,37 @@ func TestNew(t *testing.T) {
 	}
 
 	req = PatchProfileRequest{
-		ID:      "",
-		Profile: &profile.Profile{Email: "gunwoo@gunwoo.org"},
+		ID:    "",
+		Patch: &profile.Profile{Email: "gunwoo@gunwoo.org"},
 	}
 	want, _ = patchProfileEndpoint(ctx, req)
 	got, _ = endpoints.PatchProfileEndpoint(ctx, req)
+	// every patch increments the revision and updates the update time of
+	// the profile.
+	want.(PatchProfileResponse).Profile.Revision++
+	want.(PatchProfileResponse).Profile.UpdateTime = got.(PatchProfileResponse).Profile.UpdateTime
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("Endpoints.PatchProfileEndpoint: got %v, want %v", got, want)
 	}
 
+	req = ListProfilesRequest{
+		Options: profile.ListOptions{OrderBy: "email"},
+	}
+	want, _ = listProfilesEndpoint(ctx, req)
+	got, _ = endpoints.ListProfilesEndpoint(ctx, req)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Endpoints.ListProfilesEndpoint: got %v, want %v", got, want)
+	}
+
+	req = SearchProfilesRequest{
+		Query: profile.SearchQuery{Email: "gunwoo@gunwoo.org"},
+	}
+	want, _ = searchProfilesEndpoint(ctx, req)
+	got, _ = endpoints.SearchProfilesEndpoint(ctx, req)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Endpoints.SearchProfilesEndpoint: got %v, want %v", got, want)
+	}
+
 	req = DeleteProfileRequest{
 		ID: "",
 	}
@@ -109,9 +139,9 @@ func TestMakePostProfileEndpoint(t *testing.T) {
 		t.Errorf("PostProfileEndpoint: got %v, want %v", got.Profile, req.Profile)
 	}
 
-	err = got.error()
+	err = got.Failed()
 	if err != nil {
-		t.Errorf("postProfileResponse.error(): got %v, want %v", err, nil)
+		t.Errorf("PostProfileResponse.Failed(): got %v, want %v", err, nil)
 	}
 }
 
@@ -119,7 +149,7 @@ func TestMakeGetProfileEndpoint(t *testing.T) {
 	e := MakeGetProfileEndpoint(profile.FakeService)
 
 	ctx := context.Background()
-	p := &profile.Profile{Email: "gunwoo@gunwoo.org"}
+	p := &profile.Profile{Email: "gunwoo@gunwoo.org", Revision: 1}
 	req := GetProfileRequest{
 		ID: p.ID,
 	}
@@ -129,12 +159,16 @@ func TestMakeGetProfileEndpoint(t *testing.T) {
 	}
 
 	got := resp.(GetProfileResponse)
+	if got.Profile.UpdateTime.IsZero() {
+		t.Errorf("GetProfileEndpoint: got zero UpdateTime")
+	}
+	p.UpdateTime = got.Profile.UpdateTime
 	if !reflect.DeepEqual(got.Profile, p) {
 		t.Errorf("GetProfileEndpoint: got %v, want %v", got.Profile, p)
 	}
-	err = got.error()
+	err = got.Failed()
 	if err != nil {
-		t.Errorf("getProfileResponse.error(): got %v, want %v", err, nil)
+		t.Errorf("GetProfileResponse.Failed(): got %v, want %v", err, nil)
 	}
 }
 
@@ -156,9 +190,9 @@ func TestMakePutProfileEndpoint(t *testing.T) {
 	if !reflect.DeepEqual(got.Profile, p) {
 		t.Errorf("PutProfileEndpoint: got %v, want %v", got.Profile, p)
 	}
-	err = got.error()
+	err = got.Failed()
 	if err != nil {
-		t.Errorf("putProfileResponse.error(): got %v, want %v", err, nil)
+		t.Errorf("PutProfileResponse.Failed(): got %v, want %v", err, nil)
 	}
 }
 
@@ -168,8 +202,8 @@ func TestMakePatchProfileEndpoint(t *testing.T) {
 	ctx := context.Background()
 	p := &profile.Profile{Email: "gunwoo@gunwoo.org"}
 	req := PatchProfileRequest{
-		ID:      p.ID,
-		Profile: p,
+		ID:    p.ID,
+		Patch: profile.MergePatch{"email": p.Email},
 	}
 	resp, err := e(ctx, req)
 	if err != nil {
@@ -177,12 +211,12 @@ func TestMakePatchProfileEndpoint(t *testing.T) {
 	}
 
 	got := resp.(PatchProfileResponse)
-	if !reflect.DeepEqual(got.Profile, p) {
+	if got.Profile.Email != p.Email {
 		t.Errorf("PatchProfileEndpoint: got %v, want %v", got.Profile, p)
 	}
-	err = got.error()
+	err = got.Failed()
 	if err != nil {
-		t.Errorf("patchProfileResponse.error(): got %v, want %v", err, nil)
+		t.Errorf("PatchProfileResponse.Failed(): got %v, want %v", err, nil)
 	}
 }
 
@@ -202,8 +236,94 @@ func TestDeleteProfileEndpoinit(t *testing.T) {
 	if got.Err != nil {
 		t.Errorf("DeleteProfileEndpoint: got %v, want %v", got.Err, nil)
 	}
-	err = got.error()
+	err = got.Failed()
+	if err != nil {
+		t.Errorf("DeleteProfileResponse.Failed(): got %v, want %v", err, nil)
+	}
+}
+
+func TestMakeLookupProfileEndpoint(t *testing.T) {
+	e := MakeLookupProfileEndpoint(profile.FakeService)
+
+	ctx := context.Background()
+	p := &profile.Profile{ID: "lookup", Email: "lookup@gunwoo.org"}
+	if _, err := profile.FakeService.PostProfile(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	defer profile.FakeService.DeleteProfile(ctx, p.ID)
+
+	resp, err := e(ctx, LookupProfileRequest{Email: p.Email})
 	if err != nil {
-		t.Errorf("deleteProfileResponse.error(): got %v, want %v", err, nil)
+		t.Fatal(err)
+	}
+	got := resp.(LookupProfileResponse)
+	if !reflect.DeepEqual(got.Profile, p) {
+		t.Errorf("LookupProfileEndpoint: got %v, want %v", got.Profile, p)
+	}
+	if err := got.Failed(); err != nil {
+		t.Errorf("LookupProfileResponse.Failed(): got %v, want %v", err, nil)
+	}
+}
+
+func TestMakeListProfilesEndpoint(t *testing.T) {
+	e := MakeListProfilesEndpoint(profile.FakeService)
+
+	ctx := context.Background()
+	req := ListProfilesRequest{
+		Options: profile.ListOptions{PageSize: 1},
+	}
+	resp, err := e(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := resp.(ListProfilesResponse)
+	want, token, _ := profile.FakeService.ListProfiles(ctx, req.Options)
+	if !reflect.DeepEqual(got.Profiles, want) {
+		t.Errorf("ListProfilesEndpoint: got %v, want %v", got.Profiles, want)
+	}
+	if got.NextPageToken != token {
+		t.Errorf("ListProfilesEndpoint: got %q, want %q", got.NextPageToken, token)
+	}
+	err = got.Failed()
+	if err != nil {
+		t.Errorf("ListProfilesResponse.Failed(): got %v, want %v", err, nil)
+	}
+}
+
+func TestMakeSearchProfilesEndpoint(t *testing.T) {
+	e := MakeSearchProfilesEndpoint(profile.FakeService)
+
+	ctx := context.Background()
+	req := SearchProfilesRequest{
+		Query: profile.SearchQuery{EmailPrefix: "gunwoo"},
+	}
+	resp, err := e(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := resp.(SearchProfilesResponse)
+	want, token, _ := profile.FakeService.SearchProfiles(ctx, req.Query, req.Options)
+	if !reflect.DeepEqual(got.Profiles, want) {
+		t.Errorf("SearchProfilesEndpoint: got %v, want %v", got.Profiles, want)
+	}
+	if got.NextPageToken != token {
+		t.Errorf("SearchProfilesEndpoint: got %q, want %q", got.NextPageToken, token)
+	}
+	err = got.Failed()
+	if err != nil {
+		t.Errorf("SearchProfilesResponse.Failed(): got %v, want %v", err, nil)
+	}
+}
+
+func TestWithRevision(t *testing.T) {
+	ctx := context.Background()
+	if _, ok := profile.ExpectedRevision(withRevision(ctx, 0)); ok {
+		t.Error("withRevision: should not expect a revision with zero")
+	}
+	got, ok := profile.ExpectedRevision(withRevision(ctx, 2))
+	if !ok || got != 2 {
+		t.Errorf("withRevision: got (%d, %t), want (%d, %t)", got, ok, 2, true)
 	}
 }
@@ -0,0 +1,55 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+
+	"github.com/benkim0414/superego/pkg/profile"
+)
+
+type errorsContextKey struct{}
+
+// errorCodes records the codes of the profile service errors returned by
+// resolvers during the execution of a single GraphQL request. The executor
+// only keeps the messages of errors, so the codes are looked up by message.
+type errorCodes struct {
+	mu         sync.Mutex
+	codes      map[string]profile.Code
+	violations map[string][]profile.FieldViolation
+}
+
+// withErrorCodes returns a copy of the context which records the codes of the
+// errors reported during the execution of a request.
+func withErrorCodes(ctx context.Context) (context.Context, *errorCodes) {
+	ec := &errorCodes{
+		codes:      map[string]profile.Code{},
+		violations: map[string][]profile.FieldViolation{},
+	}
+	return context.WithValue(ctx, errorsContextKey{}, ec), ec
+}
+
+// lookup returns the code and the field violations of the error with the
+// given message.
+func (ec *errorCodes) lookup(message string) (profile.Code, []profile.FieldViolation, bool) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	code, ok := ec.codes[message]
+	return code, ec.violations[message], ok
+}
+
+// report records the code of the error in the context, if any, and returns
+// the error unchanged so that it can be returned by a resolver.
+func report(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ec, ok := ctx.Value(errorsContextKey{}).(*errorCodes); ok {
+		ec.mu.Lock()
+		ec.codes[err.Error()] = profile.ErrorCode(err)
+		if e, ok := err.(*profile.Error); ok && len(e.Violations) > 0 {
+			ec.violations[err.Error()] = e.Violations
+		}
+		ec.mu.Unlock()
+	}
+	return err
+}
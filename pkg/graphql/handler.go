@@ -0,0 +1,283 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/benkim0414/superego/pkg/profile"
+	"github.com/benkim0414/superego/pkg/service"
+	"github.com/go-kit/kit/metrics"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/graphql-go/handler"
+)
+
+// NewHandler returns an http.Handler which serves GraphQL requests against
+// the schema. Errors returned by the profile service are reported with their
+// code, and the invalid fields if any, in the extensions of the GraphQL
+// errors, e.g.
+//
+//	{"message": "no such entity", "extensions": {"code": "NOT_FOUND"}}
+//
+// With the PersistedQueries or AllowList options, clients may send the hashes
+// of queries instead of their text, as with Apollo's automatic persisted
+// queries. Operations which exceed the DefaultLimits, or those of the
+// QueryLimits option, are rejected before they are executed. The profiles
+// looked up by the node fields of a request are loaded in batches. With the
+// Subscriptions option, subscriptions are served over WebSocket connections
+// to the same address, using the graphql-ws protocol.
+func NewHandler(schema *graphql.Schema, opts ...Option) http.Handler {
+	h := &requestHandler{
+		schema: schema,
+		limits: DefaultLimits,
+		next: handler.New(&handler.Config{
+			Schema:   schema,
+			Pretty:   true,
+			GraphiQL: true,
+		}),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Option configures the handler.
+type Option func(*requestHandler)
+
+// BatchSizes sets the histogram which observes the number of profiles loaded
+// by each batch of node lookups.
+func BatchSizes(h metrics.Histogram) Option {
+	return func(rh *requestHandler) {
+		rh.batchSizes = h
+	}
+}
+
+// QueryLimits sets the limits of the operations served by the handler.
+func QueryLimits(limits Limits) Option {
+	return func(rh *requestHandler) {
+		rh.limits = limits
+	}
+}
+
+// RejectedQueries sets the counter of the operations rejected for exceeding
+// a limit, labeled by "limit": depth, complexity or aliases.
+func RejectedQueries(c metrics.Counter) Option {
+	return func(rh *requestHandler) {
+		rh.rejected = c
+	}
+}
+
+// Subscriptions sets the event bus whose changes are delivered to
+// subscriptions. Subscriptions are not served without it.
+func Subscriptions(bus *service.EventBus) Option {
+	return func(rh *requestHandler) {
+		rh.bus = bus
+	}
+}
+
+// AllowedOrigins sets the origins, such as "https://gunwoo.org", of the
+// pages which may open subscription connections, or "*" to allow any. Only
+// pages of the origin of the handler are allowed without it.
+func AllowedOrigins(origins ...string) Option {
+	return func(rh *requestHandler) {
+		rh.origins = origins
+	}
+}
+
+// requestHandler checks the limits of each request and sets up its context,
+// in which profiles are loaded in batches and errors are recorded, and adds
+// the extensions of the errors reported by resolvers to the responses of the
+// next handler.
+type requestHandler struct {
+	schema     *graphql.Schema
+	limits     Limits
+	next       http.Handler
+	batchSizes metrics.Histogram
+	rejected   metrics.Counter
+	queries    QueryStore
+	allowList  map[string]string
+	bus        *service.EventBus
+	origins    []string
+}
+
+func (h *requestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.bus != nil && isWebSocketUpgrade(r) {
+		serveSubscriptions(w, r, h)
+		return
+	}
+	if err := h.checkRequest(r); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	ctx, codes := withErrorCodes(withLoader(r.Context(), h.batchSizes))
+	rw := &responseBuffer{header: w.Header(), code: http.StatusOK}
+	h.next.ServeHTTP(rw, r.WithContext(ctx))
+
+	body := rw.body.Bytes()
+	if strings.HasPrefix(rw.header.Get("Content-Type"), "application/json") {
+		if b, err := addErrorExtensions(body, codes); err == nil {
+			body = b
+		}
+	}
+	w.WriteHeader(rw.code)
+	w.Write(body)
+}
+
+// requestError is the error of a request which is rejected before it is
+// executed.
+type requestError struct {
+	Message    string                 `json:"message"`
+	Extensions map[string]interface{} `json:"extensions"`
+}
+
+// graphQLRequest is a GraphQL request, with the extensions of automatic
+// persisted queries.
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+	Extensions    struct {
+		PersistedQuery *persistedQueryExtension `json:"persistedQuery"`
+	} `json:"extensions"`
+}
+
+// readRequest returns the GraphQL request of the HTTP request, as read by
+// the next handler. The body of the request is buffered, so that the next
+// handler can read it again.
+func readRequest(r *http.Request) *graphQLRequest {
+	clone := *r
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			return &graphQLRequest{}
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	opts := handler.NewRequestOptions(&clone)
+	req := &graphQLRequest{Query: opts.Query, Variables: opts.Variables, OperationName: opts.OperationName}
+	if extensions := r.URL.Query().Get("extensions"); extensions != "" {
+		json.Unmarshal([]byte(extensions), &req.Extensions)
+	} else if len(body) > 0 {
+		var b struct {
+			Extensions json.RawMessage `json:"extensions"`
+		}
+		if json.Unmarshal(body, &b) == nil && len(b.Extensions) > 0 {
+			json.Unmarshal(b.Extensions, &req.Extensions)
+		}
+	}
+	return req
+}
+
+// checkRequest resolves the persisted query of the request, if any, and
+// checks the limits of its operation. A query resolved from its hash is set
+// in the query parameters of the request, which the next handler reads
+// first.
+func (h *requestHandler) checkRequest(r *http.Request) *requestError {
+	req := readRequest(r)
+	hasQuery := req.Query != ""
+	if err := h.resolvePersistedQuery(req); err != nil {
+		return err
+	}
+	if req.Query == "" {
+		return nil
+	}
+	if !hasQuery {
+		q := url.Values{"query": {req.Query}}
+		if req.OperationName != "" {
+			q.Set("operationName", req.OperationName)
+		}
+		if req.Variables != nil {
+			b, err := json.Marshal(req.Variables)
+			if err != nil {
+				return &requestError{err.Error(), map[string]interface{}{"code": profile.InvalidArgument.String()}}
+			}
+			q.Set("variables", string(b))
+		}
+		r.URL.RawQuery = q.Encode()
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(req.Query), Name: "GraphQL request"}),
+	})
+	if err != nil {
+		// reported by the next handler.
+		return nil
+	}
+	return h.checkLimits(doc, req.OperationName, req.Variables)
+}
+
+// checkLimits checks the limits of the operation of the document, and counts
+// it if it is rejected.
+func (h *requestHandler) checkLimits(doc *ast.Document, operationName string, variables map[string]interface{}) *requestError {
+	err := h.limits.check(h.schema, doc, operationName, variables)
+	if err == nil {
+		return nil
+	}
+	if h.rejected != nil {
+		h.rejected.With("limit", err.Limit).Add(1)
+	}
+	return &requestError{err.Message, map[string]interface{}{
+		"code":  profile.InvalidArgument.String(),
+		"limit": err.Limit,
+	}}
+}
+
+// writeRequestError writes a GraphQL result with the error of a rejected
+// request, e.g.
+//
+//	{"message": "query depth 12 exceeds the maximum of 10", "extensions": {"code": "INVALID_ARGUMENT", "limit": "depth"}}
+func writeRequestError(w http.ResponseWriter, err *requestError) {
+	b, _ := json.MarshalIndent(map[string]interface{}{"errors": []*requestError{err}}, "", "\t")
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// addErrorExtensions adds the codes of the reported errors to the errors of
+// an encoded GraphQL result.
+func addErrorExtensions(body []byte, codes *errorCodes) ([]byte, error) {
+	var result struct {
+		Data   json.RawMessage          `json:"data,omitempty"`
+		Errors []map[string]interface{} `json:"errors,omitempty"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Errors) == 0 {
+		return body, nil
+	}
+	for _, e := range result.Errors {
+		message, _ := e["message"].(string)
+		if code, violations, ok := codes.lookup(message); ok {
+			extensions := map[string]interface{}{
+				"code": code.String(),
+			}
+			if len(violations) > 0 {
+				extensions["violations"] = violations
+			}
+			e["extensions"] = extensions
+		}
+	}
+	return json.MarshalIndent(result, "", "\t")
+}
+
+// responseBuffer is an http.ResponseWriter which buffers the response, so
+// that it can be rewritten before being sent to the client.
+type responseBuffer struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (rw *responseBuffer) Header() http.Header         { return rw.header }
+func (rw *responseBuffer) Write(b []byte) (int, error) { return rw.body.Write(b) }
+func (rw *responseBuffer) WriteHeader(code int)        { rw.code = code }
@@ -0,0 +1,95 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/benkim0414/superego/pkg/profile"
+	"github.com/graphql-go/relay"
+)
+
+func TestHandlerErrorExtensions(t *testing.T) {
+	schema, err := NewSchema(profile.FakeService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(&schema)
+
+	query := `{ node(id: "` + relay.ToGlobalID("Profile", "unknown") + `") { id } }`
+	body, err := json.Marshal(map[string]interface{}{"query": query})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	var result struct {
+		Errors []struct {
+			Message    string `json:"message"`
+			Extensions struct {
+				Code string `json:"code"`
+			} `json:"extensions"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) != 1 {
+		t.Fatalf("NewHandler: got %d errors, want 1", len(result.Errors))
+	}
+	if got, want := result.Errors[0].Extensions.Code, profile.NotFound.String(); got != want {
+		t.Errorf("NewHandler: got code %q, want %q", got, want)
+	}
+}
+
+func TestAddErrorExtensions(t *testing.T) {
+	_, codes := withErrorCodes(context.Background())
+	body := []byte(`{"data":{"node":null}}`)
+	got, err := addErrorExtensions(body, codes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("addErrorExtensions: got %s, want %s", got, body)
+	}
+
+	if _, err := addErrorExtensions([]byte("<html>"), codes); err == nil {
+		t.Error("addErrorExtensions: error should not be nil with an invalid body")
+	}
+}
+
+func TestHandlerErrorViolations(t *testing.T) {
+	_, codes := withErrorCodes(context.Background())
+	ctx := context.WithValue(context.Background(), errorsContextKey{}, codes)
+	report(ctx, profile.InvalidFields("invalid profile", profile.FieldViolation{
+		Field:       "email",
+		Description: "must be a valid email address",
+	}))
+
+	body := []byte(`{"data":null,"errors":[{"message":"invalid profile"}]}`)
+	got, err := addErrorExtensions(body, codes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result struct {
+		Errors []struct {
+			Extensions struct {
+				Code       string                   `json:"code"`
+				Violations []profile.FieldViolation `json:"violations"`
+			} `json:"extensions"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(got, &result); err != nil {
+		t.Fatal(err)
+	}
+	extensions := result.Errors[0].Extensions
+	if extensions.Code != profile.InvalidArgument.String() || len(extensions.Violations) != 1 || extensions.Violations[0].Field != "email" {
+		t.Errorf("addErrorExtensions: got %+v, want the email violation", extensions)
+	}
+}
@@ -0,0 +1,227 @@
+package graphql
+
+import (
+	"sort"
+	"time"
+
+	"github.com/benkim0414/superego/pkg/profile"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/relay"
+	"golang.org/x/net/context"
+)
+
+var (
+	metadataEntryType  *graphql.Object
+	identityType       *graphql.Object
+	metadataEntryInput *graphql.InputObject
+)
+
+// newIdentityTypes creates the types of linked identities. GraphQL has no
+// map type, so metadata is a list of key-value entries sorted by key.
+func newIdentityTypes() {
+	// type MetadataEntry {
+	//   key: String!
+	//   value: String!
+	// }
+	metadataEntryType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "MetadataEntry",
+		Fields: graphql.Fields{
+			"key": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"value": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+		},
+	})
+
+	// type Identity {
+	//   provider: String!
+	//   subject: String!
+	//   linkedAt: String!
+	//   metadata: [MetadataEntry!]!
+	// }
+	identityType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Identity",
+		Fields: graphql.Fields{
+			"provider": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"subject": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"linkedAt": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "The time the identity was linked, in RFC 3339 format.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if identity, ok := p.Source.(profile.Identity); ok {
+						return identity.LinkedAt.Format(time.RFC3339), nil
+					}
+					return nil, nil
+				},
+			},
+			"metadata": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(metadataEntryType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					identity, ok := p.Source.(profile.Identity)
+					if !ok {
+						return nil, nil
+					}
+					entries := []map[string]interface{}{}
+					for key, value := range identity.Metadata {
+						entries = append(entries, map[string]interface{}{"key": key, "value": value})
+					}
+					sort.Slice(entries, func(i, j int) bool {
+						return entries[i]["key"].(string) < entries[j]["key"].(string)
+					})
+					return entries, nil
+				},
+			},
+		},
+	})
+
+	// input MetadataEntryInput {
+	//   key: String!
+	//   value: String!
+	// }
+	metadataEntryInput = graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "MetadataEntryInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"key": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"value": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+		},
+	})
+}
+
+// identityMutations returns the mutations which link identities to profiles
+// and unlink them.
+func identityMutations(resolver Resolver) graphql.Fields {
+	outputFields := graphql.Fields{
+		"profile": &graphql.Field{
+			Type: profileType,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if payload, ok := p.Source.(map[string]interface{}); ok {
+					return payload["profile"], nil
+				}
+				return nil, nil
+			},
+		},
+	}
+
+	// input LinkIdentityInput {
+	//   clientMutationId: String!
+	//   profileId: ID!
+	//   provider: String!
+	//   subject: String!
+	//   metadata: [MetadataEntryInput!]
+	//   expectedRevision: Int
+	// }
+	//
+	// type LinkIdentityPayload {
+	//   clientMutationId: String!
+	//   profile: Profile
+	// }
+	linkIdentity := relay.MutationWithClientMutationID(relay.MutationConfig{
+		Name: "LinkIdentity",
+		InputFields: graphql.InputObjectConfigFieldMap{
+			"profileId": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+			"provider": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"subject": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"metadata": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewList(graphql.NewNonNull(metadataEntryInput)),
+			},
+			"expectedRevision": expectedRevisionInput(),
+		},
+		OutputFields: outputFields,
+		MutateAndGetPayload: func(inputMap map[string]interface{}, info graphql.ResolveInfo, ctx context.Context) (map[string]interface{}, error) {
+			id, err := profileID(inputMap["profileId"])
+			if err != nil {
+				return nil, report(ctx, err)
+			}
+			identity := profile.Identity{}
+			identity.Provider, _ = inputMap["provider"].(string)
+			identity.Subject, _ = inputMap["subject"].(string)
+			if entries, ok := inputMap["metadata"].([]interface{}); ok && len(entries) > 0 {
+				identity.Metadata = map[string]string{}
+				for _, entry := range entries {
+					entry, _ := entry.(map[string]interface{})
+					key, _ := entry["key"].(string)
+					value, _ := entry["value"].(string)
+					identity.Metadata[key] = value
+				}
+			}
+			p, err := resolver.LinkIdentity(withExpectedRevision(ctx, inputMap), id, identity)
+			if err != nil {
+				return nil, report(ctx, err)
+			}
+			return map[string]interface{}{"profile": p}, nil
+		},
+	})
+
+	// input UnlinkIdentityInput {
+	//   clientMutationId: String!
+	//   profileId: ID!
+	//   provider: String!
+	//   subject: String!
+	//   expectedRevision: Int
+	// }
+	//
+	// type UnlinkIdentityPayload {
+	//   clientMutationId: String!
+	//   profile: Profile
+	// }
+	unlinkIdentity := relay.MutationWithClientMutationID(relay.MutationConfig{
+		Name: "UnlinkIdentity",
+		InputFields: graphql.InputObjectConfigFieldMap{
+			"profileId": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+			"provider": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"subject": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"expectedRevision": expectedRevisionInput(),
+		},
+		OutputFields: outputFields,
+		MutateAndGetPayload: func(inputMap map[string]interface{}, info graphql.ResolveInfo, ctx context.Context) (map[string]interface{}, error) {
+			id, err := profileID(inputMap["profileId"])
+			if err != nil {
+				return nil, report(ctx, err)
+			}
+			provider, _ := inputMap["provider"].(string)
+			subject, _ := inputMap["subject"].(string)
+			p, err := resolver.UnlinkIdentity(withExpectedRevision(ctx, inputMap), id, provider, subject)
+			if err != nil {
+				return nil, report(ctx, err)
+			}
+			return map[string]interface{}{"profile": p}, nil
+		},
+	})
+
+	return graphql.Fields{
+		"linkIdentity":   linkIdentity,
+		"unlinkIdentity": unlinkIdentity,
+	}
+}
+
+// profileID returns the id of the profile with the given global id.
+func profileID(globalID interface{}) (string, error) {
+	s, _ := globalID.(string)
+	resolvedID := relay.FromGlobalID(s)
+	if resolvedID == nil || resolvedID.Type != "Profile" || resolvedID.ID == "" {
+		return "", profile.Errorf(profile.InvalidArgument, "invalid profile id %q", s)
+	}
+	return resolvedID.ID, nil
+}
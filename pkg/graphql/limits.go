@@ -0,0 +1,301 @@
+package graphql
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/benkim0414/superego/pkg/profile"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// Limits bounds the cost of the operations served by the handler, which are
+// rejected before they are executed if they exceed any of them. A zero limit
+// is not enforced. Introspection fields are not counted.
+type Limits struct {
+	// MaxDepth is the maximum nesting of fields, where the root fields of an
+	// operation have a depth of 1.
+	MaxDepth int
+	// MaxComplexity is the maximum complexity of an operation, which is the
+	// sum of the complexity of its root fields. The complexity of a field is
+	// its cost plus the complexity of its selections, multiplied by the
+	// number of items it returns: the first argument of a connection, or
+	// profile.DefaultPageSize without a positive one, or the length of a
+	// list argument such as the ids of nodes.
+	MaxComplexity int
+	// MaxAliases is the maximum number of aliased fields of an operation.
+	MaxAliases int
+	// FieldCosts sets the cost of fields keyed by "Type.field", e.g.
+	// "Query.searchProfiles". Other fields cost 1.
+	FieldCosts map[string]int
+}
+
+// DefaultLimits are the limits of the handler without the QueryLimits option.
+var DefaultLimits = Limits{
+	MaxDepth:      10,
+	MaxComplexity: 1000,
+	MaxAliases:    20,
+	FieldCosts: map[string]int{
+		"Query.searchProfiles": 5,
+	},
+}
+
+// limitError is the error of an operation which exceeds a limit.
+type limitError struct {
+	// Limit is the name of the exceeded limit: depth, complexity or aliases.
+	Limit   string
+	Message string
+}
+
+func (e *limitError) Error() string { return e.Message }
+
+// check returns the error of the first limit which the operation of the
+// document with the given name, or its only operation, exceeds.
+func (l Limits) check(schema *graphql.Schema, doc *ast.Document, operationName string, variables map[string]interface{}) *limitError {
+	a := &analysis{
+		schema:    schema,
+		limits:    l,
+		fragments: map[string]*ast.FragmentDefinition{},
+		variables: variables,
+		spreads:   map[string]measure{},
+		visiting:  map[string]bool{},
+	}
+	var operation *ast.OperationDefinition
+	for _, definition := range doc.Definitions {
+		switch definition := definition.(type) {
+		case *ast.FragmentDefinition:
+			if definition.Name != nil {
+				a.fragments[definition.Name.Value] = definition
+			}
+		case *ast.OperationDefinition:
+			if operation == nil && (operationName == "" || (definition.Name != nil && definition.Name.Value == operationName)) {
+				operation = definition
+			}
+		}
+	}
+	if operation == nil {
+		return nil
+	}
+
+	var root graphql.Type
+	switch operation.Operation {
+	case ast.OperationTypeQuery:
+		root = schema.QueryType()
+	case ast.OperationTypeMutation:
+		root = schema.MutationType()
+	case ast.OperationTypeSubscription:
+		root = schema.SubscriptionType()
+	}
+	m := a.measure(operation.SelectionSet, root)
+
+	switch {
+	case l.MaxDepth > 0 && m.depth > l.MaxDepth:
+		return &limitError{"depth", fmt.Sprintf("query depth %d exceeds the maximum of %d", m.depth, l.MaxDepth)}
+	case l.MaxComplexity > 0 && m.complexity > l.MaxComplexity:
+		return &limitError{"complexity", fmt.Sprintf("query complexity %d exceeds the maximum of %d", m.complexity, l.MaxComplexity)}
+	case l.MaxAliases > 0 && m.aliases > l.MaxAliases:
+		return &limitError{"aliases", fmt.Sprintf("query has %d aliases, more than the maximum of %d", m.aliases, l.MaxAliases)}
+	}
+	return nil
+}
+
+// analysis measures the depth, complexity and aliases of an operation. It
+// runs before validation, so it tolerates unknown fields and fragments.
+type analysis struct {
+	schema    *graphql.Schema
+	limits    Limits
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+
+	// spreads holds the measures of the fragments, keyed by the name of the
+	// fragment and of the type it is spread into, so that a fragment spread
+	// many times, as by nested fragments which each spread the next one
+	// twice, is only measured once rather than an exponential number of
+	// times. visiting holds the fragments being measured, which break
+	// cycles.
+	spreads  map[string]measure
+	visiting map[string]bool
+}
+
+// measure is the depth, complexity and aliases of a selection set, where
+// the depth is relative to the field of the selection set.
+type measure struct {
+	depth      int
+	complexity int
+	aliases    int
+}
+
+// add adds the measure of selections of the same selection set.
+func (m *measure) add(n measure) {
+	if n.depth > m.depth {
+		m.depth = n.depth
+	}
+	m.complexity = saturatingAdd(m.complexity, n.complexity)
+	m.aliases = saturatingAdd(m.aliases, n.aliases)
+}
+
+// measure returns the measure of the selections of a field of the given
+// type.
+func (a *analysis) measure(set *ast.SelectionSet, parent graphql.Type) measure {
+	var m measure
+	if set == nil {
+		return m
+	}
+	for _, selection := range set.Selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if selection.Name == nil || strings.HasPrefix(selection.Name.Value, "__") {
+				continue
+			}
+			name := selection.Name.Value
+
+			cost := 1
+			var fieldType graphql.Type
+			var args []*graphql.Argument
+			if parent != nil {
+				if c, ok := a.limits.FieldCosts[parent.Name()+"."+name]; ok {
+					cost = c
+				}
+				if def := fieldDefinition(parent, name); def != nil {
+					fieldType, _ = graphql.GetNamed(def.Type).(graphql.Type)
+					args = def.Args
+				}
+			}
+			children := a.measure(selection.SelectionSet, fieldType)
+			field := measure{
+				depth:      children.depth + 1,
+				complexity: saturatingAdd(cost, saturatingMul(a.items(selection, args), children.complexity)),
+				aliases:    children.aliases,
+			}
+			if selection.Alias != nil && selection.Alias.Value != name {
+				field.aliases = saturatingAdd(field.aliases, 1)
+			}
+			m.add(field)
+		case *ast.InlineFragment:
+			typ := parent
+			if selection.TypeCondition != nil && selection.TypeCondition.Name != nil {
+				typ = a.schema.Type(selection.TypeCondition.Name.Value)
+			}
+			m.add(a.measure(selection.SelectionSet, typ))
+		case *ast.FragmentSpread:
+			if selection.Name != nil {
+				m.add(a.spread(selection.Name.Value, parent))
+			}
+		}
+	}
+	return m
+}
+
+// spread returns the measure of the fragment of the given name spread into
+// a selection set of the given type.
+func (a *analysis) spread(name string, parent graphql.Type) measure {
+	fragment, ok := a.fragments[name]
+	if !ok || a.visiting[name] {
+		return measure{}
+	}
+	typ := parent
+	if fragment.TypeCondition != nil && fragment.TypeCondition.Name != nil {
+		typ = a.schema.Type(fragment.TypeCondition.Name.Value)
+	}
+	key := name
+	if typ != nil {
+		key += "." + typ.Name()
+	}
+	if m, ok := a.spreads[key]; ok {
+		return m
+	}
+	a.visiting[name] = true
+	m := a.measure(fragment.SelectionSet, typ)
+	delete(a.visiting, name)
+	a.spreads[key] = m
+	return m
+}
+
+// items returns the number of items which the field returns, as described by
+// Limits.MaxComplexity.
+func (a *analysis) items(field *ast.Field, args []*graphql.Argument) int {
+	for _, arg := range args {
+		if arg.Name() != "first" {
+			continue
+		}
+		for _, given := range field.Arguments {
+			if given.Name != nil && given.Name.Value == "first" {
+				// like the connections, a first which is not positive
+				// returns a page of the default size.
+				if first, ok := a.intValue(given.Value); ok && first > 0 {
+					return first
+				}
+			}
+		}
+		return profile.DefaultPageSize
+	}
+	n := 1
+	for _, given := range field.Arguments {
+		switch value := given.Value.(type) {
+		case *ast.ListValue:
+			if len(value.Values) > n {
+				n = len(value.Values)
+			}
+		case *ast.Variable:
+			if value.Name == nil {
+				continue
+			}
+			if list, ok := a.variables[value.Name.Value].([]interface{}); ok && len(list) > n {
+				n = len(list)
+			}
+		}
+	}
+	return n
+}
+
+// intValue returns the value of an integer argument, given literally or as
+// a variable.
+func (a *analysis) intValue(value ast.Value) (int, bool) {
+	switch value := value.(type) {
+	case *ast.IntValue:
+		n, err := strconv.Atoi(value.Value)
+		return n, err == nil
+	case *ast.Variable:
+		if value.Name == nil {
+			return 0, false
+		}
+		switch n := a.variables[value.Name.Value].(type) {
+		case int:
+			return n, true
+		case float64:
+			if n > math.MaxInt32 {
+				return math.MaxInt32, true
+			}
+			return int(n), true
+		}
+	}
+	return 0, false
+}
+
+// fieldDefinition returns the definition of the field of an object or an
+// interface, or nil if there is no such field.
+func fieldDefinition(t graphql.Type, name string) *graphql.FieldDefinition {
+	switch t := t.(type) {
+	case *graphql.Object:
+		return t.Fields()[name]
+	case *graphql.Interface:
+		return t.Fields()[name]
+	}
+	return nil
+}
+
+func saturatingAdd(a, b int) int {
+	if a > math.MaxInt32-b {
+		return math.MaxInt32
+	}
+	return a + b
+}
+
+func saturatingMul(a, b int) int {
+	if a != 0 && b > math.MaxInt32/a {
+		return math.MaxInt32
+	}
+	return a * b
+}
@@ -0,0 +1,169 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/benkim0414/superego/pkg/profile"
+	"github.com/go-kit/kit/metrics"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/graphql-go/relay"
+)
+
+func TestLimits(t *testing.T) {
+	schema, err := NewSchema(profile.FakeService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := Limits{
+		MaxDepth:      4,
+		MaxComplexity: 50,
+		MaxAliases:    2,
+		FieldCosts:    map[string]int{"Query.searchProfiles": 10},
+	}
+	tests := []struct {
+		query     string
+		variables map[string]interface{}
+		want      string
+	}{
+		{`{ node(id: "x") { id } }`, nil, ""},
+		// introspection fields are not counted.
+		{`{ __schema { types { fields { type { ofType { ofType { ofType { name } } } } } } } }`, nil, ""},
+		{`{ profiles(first: 5) { edges { node { name { formatted } } } } }`, nil, "depth"},
+		// 1 + 5 * (edges 1 + (node 1 + id 1))
+		{`{ profiles(first: 5) { edges { node { id } } } }`, nil, ""},
+		{`{ profiles(first: 20) { edges { node { id } } } }`, nil, "complexity"},
+		{`query($first: Int) { profiles(first: $first) { edges { node { id } } } }`, map[string]interface{}{"first": 20}, "complexity"},
+		// profile.DefaultPageSize without the first argument.
+		{`{ profiles { edges { node { id } } } }`, nil, "complexity"},
+		{`{ profiles(first: 0) { edges { node { id } } } }`, nil, "complexity"},
+		{`{ profiles(first: -1) { edges { node { id } } } }`, nil, "complexity"},
+		{`query($first: Int) { profiles(first: $first) { edges { node { id } } } }`, map[string]interface{}{"first": 0}, "complexity"},
+		// 10 + 5 * 3, with the cost of searchProfiles.
+		{`{ searchProfiles(first: 5) { edges { node { id } } } }`, nil, ""},
+		{`{ searchProfiles(first: 14) { edges { node { id } } } }`, nil, "complexity"},
+		{`{ nodes(ids: ["a", "b", "c"]) { id } }`, nil, ""},
+		{`query($ids: [ID!]!) { nodes(ids: $ids) { id } }`, map[string]interface{}{"ids": make([]interface{}, 50)}, "complexity"},
+		{`{ a: node(id: "a") { id } b: node(id: "b") { id } node(id: "c") { id } }`, nil, ""},
+		{`{ a: node(id: "a") { id } b: node(id: "b") { id } c: node(id: "c") { id } }`, nil, "aliases"},
+		{`{ ...f ...f } fragment f on Query { a: node(id: "a") { id } b: node(id: "b") { id } }`, nil, "aliases"},
+		{`{ ...f } fragment f on Query { ...f }`, nil, ""},
+		{`{ node(id: "a") { ... on Profile { name { ... on Name { formatted } } } } }`, nil, ""},
+		{`query a { node(id: "a") { id } } query b { profiles { edges { node { id } } } }`, nil, ""},
+	}
+	for _, tt := range tests {
+		doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(tt.query)})})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		if err := limits.check(&schema, doc, "", tt.variables); err != nil {
+			got = err.Limit
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q limit exceeded, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestLimitsNestedFragments(t *testing.T) {
+	schema, err := NewSchema(profile.FakeService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each fragment spreads the next one twice, which is 2^n fields if the
+	// fragments are expanded on every spread.
+	const n = 64
+	query := `{ ...f0 }`
+	for i := 0; i < n; i++ {
+		query += fmt.Sprintf(" fragment f%d on Query { ...f%d ...f%d }", i, i+1, i+1)
+	}
+	query += fmt.Sprintf(` fragment f%d on Query { node(id: "a") { id } }`, n)
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(query)})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan *limitError)
+	go func() { done <- DefaultLimits.check(&schema, doc, "", nil) }()
+	select {
+	case err := <-done:
+		if err == nil || err.Limit != "complexity" {
+			t.Errorf("got %v, want the complexity limit exceeded", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the analysis of nested fragments did not finish")
+	}
+}
+
+// counts records the values added to a counter by label values.
+type counts map[string]float64
+
+func (c counts) With(labelValues ...string) metrics.Counter {
+	return labeledCounter{c, labelValues[len(labelValues)-1]}
+}
+func (c counts) Add(delta float64) { c[""] += delta }
+
+type labeledCounter struct {
+	counts counts
+	label  string
+}
+
+func (c labeledCounter) With(labelValues ...string) metrics.Counter { return c }
+func (c labeledCounter) Add(delta float64)                          { c.counts[c.label] += delta }
+
+func TestHandlerLimits(t *testing.T) {
+	schema, err := NewSchema(profile.FakeService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected := counts{}
+	h := NewHandler(&schema, QueryLimits(Limits{MaxDepth: 2}), RejectedQueries(rejected))
+
+	id := relay.ToGlobalID("Profile", "unknown")
+	for _, query := range []string{
+		`{ node(id: "` + id + `") { id } }`,
+		`{ node(id: "` + id + `") { ... on Profile { name { formatted } } } }`,
+	} {
+		body, err := json.Marshal(map[string]interface{}{"query": query})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		var result struct {
+			Data   map[string]interface{} `json:"data"`
+			Errors []struct {
+				Message    string `json:"message"`
+				Extensions struct {
+					Code  string `json:"code"`
+					Limit string `json:"limit"`
+				} `json:"extensions"`
+			} `json:"errors"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Errors) != 1 {
+			t.Fatalf("%s: got errors %+v, want 1", query, result.Errors)
+		}
+		e := result.Errors[0]
+		switch {
+		case rejected["depth"] == 0 && (e.Extensions.Code != profile.NotFound.String() || result.Data == nil):
+			t.Errorf("%s: got %+v, want the query executed", query, e)
+		case rejected["depth"] == 1 && (e.Extensions.Code != profile.InvalidArgument.String() || e.Extensions.Limit != "depth" || result.Data != nil):
+			t.Errorf("%s: got %+v, want the query rejected", query, e)
+		}
+	}
+	if rejected["depth"] != 1 {
+		t.Errorf("got %v rejected queries, want 1 for depth", rejected)
+	}
+}
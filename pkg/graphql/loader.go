@@ -0,0 +1,165 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+
+	"github.com/benkim0414/superego/pkg/profile"
+	"github.com/go-kit/kit/metrics"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/relay"
+)
+
+type loaderContextKey struct{}
+
+// loader loads the profiles looked up by the node fields of a single GraphQL
+// request. The executor resolves fields one at a time, so lookups cannot be
+// coalesced as they are made, as a DataLoader would; instead, the first
+// lookup collects the ids of all the node fields of the operation and gets
+// them with a single GetProfiles call, and later lookups are served from
+// its results.
+type loader struct {
+	batchSizes metrics.Histogram
+
+	mu       sync.Mutex
+	profiles map[string]*profile.Profile
+}
+
+// withLoader returns a copy of the context which loads the profiles of the
+// node fields of a request in batches, whose sizes are observed by the
+// histogram if it is not nil.
+func withLoader(ctx context.Context, batchSizes metrics.Histogram) context.Context {
+	return context.WithValue(ctx, loaderContextKey{}, newLoader(batchSizes))
+}
+
+func newLoader(batchSizes metrics.Histogram) *loader {
+	return &loader{batchSizes: batchSizes, profiles: map[string]*profile.Profile{}}
+}
+
+// loaderFrom returns the loader of the context, or a loader for the single
+// lookup if the request is not served by the handler.
+func loaderFrom(ctx context.Context) *loader {
+	if l, ok := ctx.Value(loaderContextKey{}).(*loader); ok {
+		return l
+	}
+	return newLoader(nil)
+}
+
+// load returns the profile with the given id, loading it along with the
+// profiles of the other node fields of the operation if it is not loaded
+// yet. It returns profile.ErrNoSuchEntity if there is no such profile.
+func (l *loader) load(ctx context.Context, resolver Resolver, id string, info graphql.ResolveInfo) (*profile.Profile, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.profiles[id]; !ok {
+		ids := []string{id}
+		queued := map[string]bool{id: true}
+		for _, other := range nodeIDs(info) {
+			if _, ok := l.profiles[other]; !ok && !queued[other] {
+				ids = append(ids, other)
+				queued[other] = true
+			}
+		}
+		for len(ids) > 0 {
+			n := len(ids)
+			if n > profile.MaxBatchSize {
+				n = profile.MaxBatchSize
+			}
+			profiles, err := resolver.GetProfiles(ctx, ids[:n])
+			if err != nil {
+				return nil, err
+			}
+			if l.batchSizes != nil {
+				l.batchSizes.Observe(float64(n))
+			}
+			for i, p := range profiles {
+				l.profiles[ids[i]] = p
+			}
+			ids = ids[n:]
+		}
+	}
+	if p := l.profiles[id]; p != nil {
+		return p, nil
+	}
+	return nil, profile.ErrNoSuchEntity
+}
+
+// nodeIDs returns the ids of the profiles looked up by the node and nodes
+// fields of the operation, including those of its fragments.
+func nodeIDs(info graphql.ResolveInfo) []string {
+	operation, ok := info.Operation.(*ast.OperationDefinition)
+	if !ok {
+		return nil
+	}
+	var ids []string
+	seen := map[string]bool{}
+	var collect func(*ast.SelectionSet)
+	collect = func(set *ast.SelectionSet) {
+		if set == nil {
+			return
+		}
+		for _, selection := range set.Selections {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				if name := selection.Name; name != nil && (name.Value == "node" || name.Value == "nodes") {
+					for _, arg := range selection.Arguments {
+						ids = append(ids, argumentIDs(arg.Value, info.VariableValues)...)
+					}
+				}
+				collect(selection.SelectionSet)
+			case *ast.InlineFragment:
+				collect(selection.SelectionSet)
+			case *ast.FragmentSpread:
+				if selection.Name == nil || seen[selection.Name.Value] {
+					continue
+				}
+				seen[selection.Name.Value] = true
+				if fragment, ok := info.Fragments[selection.Name.Value].(*ast.FragmentDefinition); ok {
+					collect(fragment.SelectionSet)
+				}
+			}
+		}
+	}
+	collect(operation.SelectionSet)
+	return ids
+}
+
+// argumentIDs returns the ids of the profiles of the global ids of an
+// argument value, which is either a string or a list of strings, given
+// literally or as a variable.
+func argumentIDs(value ast.Value, variables map[string]interface{}) []string {
+	var globalIDs []interface{}
+	switch value := value.(type) {
+	case *ast.StringValue:
+		globalIDs = append(globalIDs, value.Value)
+	case *ast.ListValue:
+		for _, v := range value.Values {
+			if s, ok := v.(*ast.StringValue); ok {
+				globalIDs = append(globalIDs, s.Value)
+			}
+		}
+	case *ast.Variable:
+		if value.Name == nil {
+			return nil
+		}
+		switch v := variables[value.Name.Value].(type) {
+		case []interface{}:
+			globalIDs = v
+		default:
+			globalIDs = append(globalIDs, v)
+		}
+	}
+	var ids []string
+	for _, globalID := range globalIDs {
+		s, ok := globalID.(string)
+		if !ok {
+			continue
+		}
+		if resolvedID := relay.FromGlobalID(s); resolvedID != nil && resolvedID.Type == "Profile" {
+			ids = append(ids, resolvedID.ID)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,120 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/benkim0414/superego/pkg/profile"
+	"github.com/go-kit/kit/metrics"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/relay"
+)
+
+// countingResolver counts the calls to the profile lookups of the resolver.
+type countingResolver struct {
+	Resolver
+	gets    int
+	batches [][]string
+}
+
+func (r *countingResolver) GetProfile(ctx context.Context, id string) (*profile.Profile, error) {
+	r.gets++
+	return r.Resolver.GetProfile(ctx, id)
+}
+
+func (r *countingResolver) GetProfiles(ctx context.Context, ids []string) ([]*profile.Profile, error) {
+	r.batches = append(r.batches, ids)
+	return r.Resolver.GetProfiles(ctx, ids)
+}
+
+// observations records the values observed by a histogram.
+type observations []float64
+
+func (o *observations) With(labelValues ...string) metrics.Histogram { return o }
+func (o *observations) Observe(value float64)                        { *o = append(*o, value) }
+
+func TestLoader(t *testing.T) {
+	ctx := context.Background()
+	for _, id := range []string{"loader-a", "loader-b", "loader-c"} {
+		if _, err := profile.FakeService.PostProfile(ctx, &profile.Profile{ID: id, Email: id + "@gunwoo.org"}); err != nil {
+			t.Fatal(err)
+		}
+		defer profile.FakeService.DeleteProfile(ctx, id)
+	}
+	resolver := &countingResolver{Resolver: profile.FakeService}
+	schema, err := NewSchema(resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var batchSizes observations
+	result := graphql.Do(graphql.Params{
+		Schema: schema,
+		RequestString: `query($b: ID!, $ids: [ID!]!) {
+			a: node(id: "` + relay.ToGlobalID("Profile", "loader-a") + `") { id }
+			b: node(id: $b) { id }
+			...nodes
+		}
+		fragment nodes on Query {
+			nodes(ids: $ids) { id }
+		}`,
+		VariableValues: map[string]interface{}{
+			"b":   relay.ToGlobalID("Profile", "loader-b"),
+			"ids": []interface{}{relay.ToGlobalID("Profile", "loader-c"), relay.ToGlobalID("Profile", "loader-a"), relay.ToGlobalID("Profile", "unknown")},
+		},
+		Context: withLoader(ctx, &batchSizes),
+	})
+	if len(result.Errors) > 0 {
+		t.Fatal(result.Errors)
+	}
+	b, err := json.Marshal(result.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"a":{"id":"` + relay.ToGlobalID("Profile", "loader-a") + `"},` +
+		`"b":{"id":"` + relay.ToGlobalID("Profile", "loader-b") + `"},` +
+		`"nodes":[{"id":"` + relay.ToGlobalID("Profile", "loader-c") + `"},{"id":"` + relay.ToGlobalID("Profile", "loader-a") + `"},null]}`
+	if string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+
+	if resolver.gets != 0 {
+		t.Errorf("got %d GetProfile calls, want 0", resolver.gets)
+	}
+	// the root fields are not resolved in a fixed order.
+	for _, ids := range resolver.batches {
+		sort.Strings(ids)
+	}
+	if want := [][]string{{"loader-a", "loader-b", "loader-c", "unknown"}}; !reflect.DeepEqual(resolver.batches, want) {
+		t.Errorf("got GetProfiles calls %v, want %v", resolver.batches, want)
+	}
+	if want := (observations{4}); !reflect.DeepEqual(batchSizes, want) {
+		t.Errorf("got batch sizes %v, want %v", batchSizes, want)
+	}
+}
+
+func TestLoaderInvalidIDs(t *testing.T) {
+	schema, err := NewSchema(profile.FakeService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// node lookups of other types, or of invalid ids, are not loaded.
+	result := graphql.Do(graphql.Params{
+		Schema:        schema,
+		RequestString: `{ nodes(ids: ["invalid", "` + relay.ToGlobalID("Identity", "x") + `"]) { id } }`,
+		Context:       withLoader(context.Background(), nil),
+	})
+	if len(result.Errors) > 0 {
+		t.Fatal(result.Errors)
+	}
+	b, err := json.Marshal(result.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `{"nodes":[null,null]}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,138 @@
+package graphql
+
+import (
+	"github.com/benkim0414/superego/pkg/profile"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/relay"
+	"golang.org/x/net/context"
+)
+
+// mergeMutations returns the mutation which merges duplicate profiles.
+func mergeMutations(resolver Resolver) graphql.Fields {
+	// enum MergePolicy {
+	//   PREFER_SURVIVOR
+	//   PREFER_NEWEST
+	//   EXPLICIT
+	// }
+	mergePolicyEnum := graphql.NewEnum(graphql.EnumConfig{
+		Name: "MergePolicy",
+		Values: graphql.EnumValueConfigMap{
+			"PREFER_SURVIVOR": &graphql.EnumValueConfig{
+				Value:       string(profile.PreferSurvivor),
+				Description: "Keep the values of the surviving profile.",
+			},
+			"PREFER_NEWEST": &graphql.EnumValueConfig{
+				Value:       string(profile.PreferNewest),
+				Description: "Take the values of the most recently updated profile.",
+			},
+			"EXPLICIT": &graphql.EnumValueConfig{
+				Value:       string(profile.Explicit),
+				Description: "Require a choice for every conflicting field.",
+			},
+		},
+	})
+
+	// enum MergeSource {
+	//   SURVIVOR
+	//   VICTIM
+	// }
+	mergeSourceEnum := graphql.NewEnum(graphql.EnumConfig{
+		Name: "MergeSource",
+		Values: graphql.EnumValueConfigMap{
+			"SURVIVOR": &graphql.EnumValueConfig{Value: string(profile.Survivor)},
+			"VICTIM":   &graphql.EnumValueConfig{Value: string(profile.Victim)},
+		},
+	})
+
+	// input MergeFieldChoice {
+	//   field: String!
+	//   source: MergeSource!
+	// }
+	mergeFieldChoiceInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "MergeFieldChoice",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"field": &graphql.InputObjectFieldConfig{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "The path of the field, such as \"aboutMe\" or \"name.givenName\".",
+			},
+			"source": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(mergeSourceEnum),
+			},
+		},
+	})
+
+	// input MergeProfilesInput {
+	//   clientMutationId: String!
+	//   survivorId: ID!
+	//   victimId: ID!
+	//   policy: MergePolicy
+	//   fields: [MergeFieldChoice!]
+	//   expectedRevision: Int
+	// }
+	//
+	// type MergeProfilesPayload {
+	//   clientMutationId: String!
+	//   profile: Profile
+	// }
+	mergeProfiles := relay.MutationWithClientMutationID(relay.MutationConfig{
+		Name: "MergeProfiles",
+		InputFields: graphql.InputObjectConfigFieldMap{
+			"survivorId": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+			"victimId": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+			"policy": &graphql.InputObjectFieldConfig{
+				Type: mergePolicyEnum,
+			},
+			"fields": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewList(graphql.NewNonNull(mergeFieldChoiceInput)),
+			},
+			"expectedRevision": expectedRevisionInput(),
+		},
+		OutputFields: graphql.Fields{
+			"profile": &graphql.Field{
+				Type: profileType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if payload, ok := p.Source.(map[string]interface{}); ok {
+						return payload["profile"], nil
+					}
+					return nil, nil
+				},
+			},
+		},
+		MutateAndGetPayload: func(inputMap map[string]interface{}, info graphql.ResolveInfo, ctx context.Context) (map[string]interface{}, error) {
+			survivorID, err := profileID(inputMap["survivorId"])
+			if err != nil {
+				return nil, report(ctx, err)
+			}
+			victimID, err := profileID(inputMap["victimId"])
+			if err != nil {
+				return nil, report(ctx, err)
+			}
+			strategy := profile.MergeStrategy{}
+			if policy, ok := inputMap["policy"].(string); ok {
+				strategy.Policy = profile.MergePolicy(policy)
+			}
+			if choices, ok := inputMap["fields"].([]interface{}); ok && len(choices) > 0 {
+				strategy.Fields = map[string]profile.MergeSource{}
+				for _, choice := range choices {
+					choice, _ := choice.(map[string]interface{})
+					field, _ := choice["field"].(string)
+					source, _ := choice["source"].(string)
+					strategy.Fields[field] = profile.MergeSource(source)
+				}
+			}
+			p, err := resolver.MergeProfiles(withExpectedRevision(ctx, inputMap), survivorID, victimID, strategy)
+			if err != nil {
+				return nil, report(ctx, err)
+			}
+			return map[string]interface{}{"profile": p}, nil
+		},
+	})
+
+	return graphql.Fields{
+		"mergeProfiles": mergeProfiles,
+	}
+}
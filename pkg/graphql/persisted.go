@@ -0,0 +1,175 @@
+package graphql
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/benkim0414/superego/pkg/profile"
+)
+
+// persistedQueryExtension is the extension of a request which refers to a
+// query by its hash, as defined by Apollo's automatic persisted queries.
+type persistedQueryExtension struct {
+	Version    int    `json:"version"`
+	SHA256Hash string `json:"sha256Hash"`
+}
+
+// QueryStore stores persisted queries by their SHA-256 hash.
+type QueryStore interface {
+	// Get returns the query with the given hash.
+	Get(hash string) (string, bool)
+	// Put stores the query with the given hash.
+	Put(hash, query string)
+}
+
+// DefaultQueryStoreSize is the number of queries of the store of the server.
+const DefaultQueryStoreSize = 1000
+
+// NewQueryStore returns an in-memory store of at most size queries, which
+// evicts the least recently used queries.
+func NewQueryStore(size int) QueryStore {
+	return &lruQueryStore{size: size, ll: list.New(), entries: map[string]*list.Element{}}
+}
+
+type lruQueryStore struct {
+	size int
+
+	mu      sync.Mutex
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	hash, query string
+}
+
+func (s *lruQueryStore) Get(hash string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[hash]
+	if !ok {
+		return "", false
+	}
+	s.ll.MoveToFront(e)
+	return e.Value.(*lruEntry).query, true
+}
+
+func (s *lruQueryStore) Put(hash, query string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[hash]; ok {
+		s.ll.MoveToFront(e)
+		return
+	}
+	s.entries[hash] = s.ll.PushFront(&lruEntry{hash, query})
+	for s.ll.Len() > s.size {
+		e := s.ll.Back()
+		s.ll.Remove(e)
+		delete(s.entries, e.Value.(*lruEntry).hash)
+	}
+}
+
+// LoadManifest reads an allow-list manifest: a JSON object which maps the
+// SHA-256 hashes of queries, in hex, to the queries, e.g.
+//
+//	{"7f56e67dd21ab3f30d1ff8b7bed08893f0a0db86449836189b361dd1e56ddb4b": "{ __typename }"}
+func LoadManifest(r io.Reader) (map[string]string, error) {
+	var manifest map[string]string
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, err
+	}
+	for hash, query := range manifest {
+		if hashQuery(query) != strings.ToLower(hash) {
+			return nil, fmt.Errorf("graphql: hash %s of the manifest does not match its query", hash)
+		}
+	}
+	return manifest, nil
+}
+
+// PersistedQueries enables automatic persisted queries: a client may send
+// the SHA-256 hash of a query instead of its text, once it has registered
+// the query by sending both, and the queries are kept in the store.
+func PersistedQueries(store QueryStore) Option {
+	return func(rh *requestHandler) {
+		rh.queries = store
+	}
+}
+
+// AllowList only allows the queries of the manifest, as returned by
+// LoadManifest, which may be sent either as text or as hashes. Other queries
+// are rejected, and cannot be registered as persisted queries.
+func AllowList(manifest map[string]string) Option {
+	return func(rh *requestHandler) {
+		rh.allowList = map[string]string{}
+		for hash, query := range manifest {
+			rh.allowList[strings.ToLower(hash)] = query
+		}
+	}
+}
+
+// hashQuery returns the SHA-256 hash of the query in hex.
+func hashQuery(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// resolvePersistedQuery sets the query of a request which refers to a
+// persisted query by its hash, registers the query of a request which sends
+// both, and rejects the queries which are not allowed. The error messages
+// and codes of unknown and unsupported persisted queries are those which
+// Apollo clients expect.
+func (h *requestHandler) resolvePersistedQuery(req *graphQLRequest) *requestError {
+	pq := req.Extensions.PersistedQuery
+	if pq == nil {
+		if h.allowList != nil && req.Query != "" {
+			if _, ok := h.allowList[hashQuery(req.Query)]; !ok {
+				return notAllowed()
+			}
+		}
+		return nil
+	}
+	if h.queries == nil && h.allowList == nil {
+		return &requestError{"PersistedQueryNotSupported", map[string]interface{}{"code": "PERSISTED_QUERY_NOT_SUPPORTED"}}
+	}
+	if pq.Version != 1 {
+		return &requestError{fmt.Sprintf("unsupported persisted query version %d", pq.Version), map[string]interface{}{"code": profile.InvalidArgument.String()}}
+	}
+	hash := strings.ToLower(pq.SHA256Hash)
+
+	if req.Query == "" {
+		var query string
+		var ok bool
+		if h.allowList != nil {
+			query, ok = h.allowList[hash]
+		} else {
+			query, ok = h.queries.Get(hash)
+		}
+		if !ok {
+			return &requestError{"PersistedQueryNotFound", map[string]interface{}{"code": "PERSISTED_QUERY_NOT_FOUND"}}
+		}
+		req.Query = query
+		return nil
+	}
+
+	if hashQuery(req.Query) != hash {
+		return &requestError{"provided sha does not match query", map[string]interface{}{"code": profile.InvalidArgument.String()}}
+	}
+	if h.allowList != nil {
+		if _, ok := h.allowList[hash]; !ok {
+			return notAllowed()
+		}
+		return nil
+	}
+	h.queries.Put(hash, req.Query)
+	return nil
+}
+
+func notAllowed() *requestError {
+	return &requestError{"query is not in the allow-list", map[string]interface{}{"code": profile.PermissionDenied.String()}}
+}
@@ -0,0 +1,150 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/benkim0414/superego/pkg/profile"
+)
+
+func TestQueryStore(t *testing.T) {
+	s := NewQueryStore(2)
+	s.Put("a", "{ a }")
+	s.Put("b", "{ b }")
+	s.Get("a")
+	s.Put("c", "{ c }")
+	if _, ok := s.Get("b"); ok {
+		t.Error("Get(b): the least recently used query should be evicted")
+	}
+	for _, hash := range []string{"a", "c"} {
+		if _, ok := s.Get(hash); !ok {
+			t.Errorf("Get(%s): got no query", hash)
+		}
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	manifest, err := LoadManifest(strings.NewReader(`{"` + hashQuery("{ __typename }") + `": "{ __typename }"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != 1 {
+		t.Errorf("LoadManifest: got %v, want 1 query", manifest)
+	}
+	if _, err := LoadManifest(strings.NewReader(`{"` + hashQuery("{ a }") + `": "{ b }"}`)); err == nil {
+		t.Error("LoadManifest: error should not be nil with a mismatched hash")
+	}
+}
+
+type persistedResult struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []struct {
+		Message    string `json:"message"`
+		Extensions struct {
+			Code string `json:"code"`
+		} `json:"extensions"`
+	} `json:"errors"`
+}
+
+// doPersisted sends a GraphQL request with the query and the hash of the
+// persisted query extension, if they are not empty, in the body of a POST
+// request, or in the query parameters of a GET request.
+func doPersisted(t *testing.T, h http.Handler, method, query, hash string) persistedResult {
+	request := map[string]interface{}{}
+	if query != "" {
+		request["query"] = query
+	}
+	var extensions []byte
+	if hash != "" {
+		extensions, _ = json.Marshal(map[string]interface{}{
+			"persistedQuery": map[string]interface{}{"version": 1, "sha256Hash": hash},
+		})
+		request["extensions"] = json.RawMessage(extensions)
+	}
+	var r *http.Request
+	if method == http.MethodGet {
+		q := url.Values{}
+		if query != "" {
+			q.Set("query", query)
+		}
+		if hash != "" {
+			q.Set("extensions", string(extensions))
+		}
+		r = httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	} else {
+		body, err := json.Marshal(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var result persistedResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// check checks that the result has data, or the error with the given code.
+func (result persistedResult) check(t *testing.T, name, code string) {
+	if code == "" {
+		if len(result.Errors) > 0 || result.Data["__typename"] != "Query" {
+			t.Errorf("%s: got %+v, want data", name, result)
+		}
+		return
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Extensions.Code != code {
+		t.Errorf("%s: got %+v, want a %s error", name, result, code)
+	}
+}
+
+func TestHandlerPersistedQueries(t *testing.T) {
+	schema, err := NewSchema(profile.FakeService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := "{ __typename }"
+	hash := hashQuery(query)
+
+	h := NewHandler(&schema)
+	doPersisted(t, h, http.MethodGet, "", hash).check(t, "without persisted queries", "PERSISTED_QUERY_NOT_SUPPORTED")
+
+	h = NewHandler(&schema, PersistedQueries(NewQueryStore(DefaultQueryStoreSize)))
+	result := doPersisted(t, h, http.MethodGet, "", hash)
+	result.check(t, "unknown hash", "PERSISTED_QUERY_NOT_FOUND")
+	if result.Errors[0].Message != "PersistedQueryNotFound" {
+		t.Errorf("unknown hash: got message %q, want PersistedQueryNotFound", result.Errors[0].Message)
+	}
+	doPersisted(t, h, http.MethodPost, query, hashQuery("{ a }")).check(t, "mismatched hash", profile.InvalidArgument.String())
+	doPersisted(t, h, http.MethodPost, query, hash).check(t, "registration", "")
+	doPersisted(t, h, http.MethodGet, "", hash).check(t, "GET hash", "")
+	doPersisted(t, h, http.MethodPost, "", hash).check(t, "POST hash", "")
+	doPersisted(t, h, http.MethodPost, "{ __typename __typename }", "").check(t, "query", "")
+}
+
+func TestHandlerAllowList(t *testing.T) {
+	schema, err := NewSchema(profile.FakeService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := "{ __typename }"
+	hash := hashQuery(query)
+	h := NewHandler(&schema, PersistedQueries(NewQueryStore(DefaultQueryStoreSize)), AllowList(map[string]string{hash: query}))
+
+	doPersisted(t, h, http.MethodGet, "", hash).check(t, "allowed hash", "")
+	doPersisted(t, h, http.MethodPost, query, "").check(t, "allowed query", "")
+	doPersisted(t, h, http.MethodPost, query, hash).check(t, "allowed query and hash", "")
+
+	other := "{ __typename __typename }"
+	doPersisted(t, h, http.MethodPost, other, "").check(t, "other query", profile.PermissionDenied.String())
+	doPersisted(t, h, http.MethodPost, other, hashQuery(other)).check(t, "other registration", profile.PermissionDenied.String())
+	doPersisted(t, h, http.MethodGet, "", hashQuery(other)).check(t, "other hash", "PERSISTED_QUERY_NOT_FOUND")
+}
@@ -0,0 +1,244 @@
+package graphql
+
+import (
+	"github.com/benkim0414/superego/pkg/profile"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/relay"
+	"golang.org/x/net/context"
+)
+
+// profileInputFields returns the input fields of the writable fields of a
+// profile. The name is flattened into its components, as in createProfile.
+func profileInputFields(email graphql.Input) graphql.InputObjectConfigFieldMap {
+	return graphql.InputObjectConfigFieldMap{
+		"displayName": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"formatted":   &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"familyName":  &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"givenName":   &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"email":       &graphql.InputObjectFieldConfig{Type: email},
+		"imageUrl":    &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"aboutMe":     &graphql.InputObjectFieldConfig{Type: graphql.String},
+	}
+}
+
+// profileFromInput returns the profile described by the input fields of
+// profileInputFields.
+func profileFromInput(inputMap map[string]interface{}) *profile.Profile {
+	p := &profile.Profile{}
+	p.DisplayName, _ = inputMap["displayName"].(string)
+	p.Name.Formatted, _ = inputMap["formatted"].(string)
+	p.Name.FamilyName, _ = inputMap["familyName"].(string)
+	p.Name.GivenName, _ = inputMap["givenName"].(string)
+	p.Email, _ = inputMap["email"].(string)
+	p.ImageURL, _ = inputMap["imageUrl"].(string)
+	p.AboutMe, _ = inputMap["aboutMe"].(string)
+	return p
+}
+
+// mergePatchFromInput returns a merge patch of the input fields of
+// profileInputFields which are given, so that the omitted fields are left
+// unchanged. This version of GraphQL has no null literal, so an empty string
+// clears a field instead.
+func mergePatchFromInput(inputMap map[string]interface{}) profile.MergePatch {
+	patch := profile.MergePatch{}
+	for _, field := range []string{"displayName", "email", "imageUrl", "aboutMe"} {
+		if value, ok := inputMap[field].(string); ok {
+			patch[field] = value
+		}
+	}
+	name := map[string]interface{}{}
+	for _, field := range []string{"formatted", "familyName", "givenName"} {
+		if value, ok := inputMap[field].(string); ok {
+			name[field] = value
+		}
+	}
+	if len(name) > 0 {
+		patch["name"] = name
+	}
+	return patch
+}
+
+// patchFromInput returns the patch of the input fields of a patch mutation,
+// which overwrites exactly the fields of the updateMask input field if it is
+// given, clearing the omitted ones, and is a merge patch otherwise. The paths
+// of the mask are those of the REST API, such as "name.givenName".
+func patchFromInput(inputMap map[string]interface{}) (profile.Patch, error) {
+	paths, ok := inputMap["updateMask"].([]interface{})
+	if !ok {
+		return mergePatchFromInput(inputMap), nil
+	}
+	mask := profile.FieldMask{}
+	for _, path := range paths {
+		if path, ok := path.(string); ok {
+			mask = append(mask, path)
+		}
+	}
+	if err := mask.Validate(); err != nil {
+		return nil, err
+	}
+	return profile.MaskedPatch{Profile: profileFromInput(inputMap), Mask: mask}, nil
+}
+
+// withExpectedRevision returns a copy of the context which expects the
+// revision of the expectedRevision input field, if it is given.
+func withExpectedRevision(ctx context.Context, inputMap map[string]interface{}) context.Context {
+	if revision, ok := inputMap["expectedRevision"].(int); ok {
+		return profile.WithExpectedRevision(ctx, int64(revision))
+	}
+	return ctx
+}
+
+// expectedRevisionInput returns the input field of the revision of the
+// profile which a mutation expects, as read by withExpectedRevision.
+func expectedRevisionInput() *graphql.InputObjectFieldConfig {
+	return &graphql.InputObjectFieldConfig{
+		Type:        graphql.Int,
+		Description: "The revision of the profile which the mutation expects, which fails otherwise.",
+	}
+}
+
+// profileMutations returns the mutations which update and delete profiles.
+func profileMutations(resolver Resolver) graphql.Fields {
+	outputFields := graphql.Fields{
+		"profile": &graphql.Field{
+			Type: profileType,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if payload, ok := p.Source.(map[string]interface{}); ok {
+					return payload["profile"], nil
+				}
+				return nil, nil
+			},
+		},
+	}
+	expectedRevision := expectedRevisionInput()
+
+	// input UpdateProfileInput {
+	//   clientMutationId: String!
+	//   id: ID!
+	//   displayName: String
+	//   formatted: String
+	//   familyName: String
+	//   givenName: String
+	//   email: String!
+	//   imageUrl: String
+	//   aboutMe: String
+	//   expectedRevision: Int
+	// }
+	//
+	// type UpdateProfilePayload {
+	//   clientMutationId: String!
+	//   profile: Profile
+	// }
+	updateInputFields := profileInputFields(graphql.NewNonNull(graphql.String))
+	updateInputFields["id"] = &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.ID)}
+	updateInputFields["expectedRevision"] = expectedRevision
+	updateProfile := relay.MutationWithClientMutationID(relay.MutationConfig{
+		Name:         "UpdateProfile",
+		InputFields:  updateInputFields,
+		OutputFields: outputFields,
+		MutateAndGetPayload: func(inputMap map[string]interface{}, info graphql.ResolveInfo, ctx context.Context) (map[string]interface{}, error) {
+			id, err := profileID(inputMap["id"])
+			if err != nil {
+				return nil, report(ctx, err)
+			}
+			p := profileFromInput(inputMap)
+			p.ID = id
+			p, err = resolver.PutProfile(withExpectedRevision(ctx, inputMap), id, p)
+			if err != nil {
+				return nil, report(ctx, err)
+			}
+			return map[string]interface{}{"profile": p}, nil
+		},
+	})
+
+	// input PatchProfileInput {
+	//   clientMutationId: String!
+	//   id: ID!
+	//   displayName: String
+	//   formatted: String
+	//   familyName: String
+	//   givenName: String
+	//   email: String
+	//   imageUrl: String
+	//   aboutMe: String
+	//   updateMask: [String!]
+	//   expectedRevision: Int
+	// }
+	//
+	// type PatchProfilePayload {
+	//   clientMutationId: String!
+	//   profile: Profile
+	// }
+	patchInputFields := profileInputFields(graphql.String)
+	patchInputFields["id"] = &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.ID)}
+	patchInputFields["updateMask"] = &graphql.InputObjectFieldConfig{
+		Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+		Description: "The paths of the fields to overwrite, such as name.givenName, which clears the omitted ones.",
+	}
+	patchInputFields["expectedRevision"] = expectedRevision
+	patchProfile := relay.MutationWithClientMutationID(relay.MutationConfig{
+		Name:         "PatchProfile",
+		InputFields:  patchInputFields,
+		OutputFields: outputFields,
+		MutateAndGetPayload: func(inputMap map[string]interface{}, info graphql.ResolveInfo, ctx context.Context) (map[string]interface{}, error) {
+			id, err := profileID(inputMap["id"])
+			if err != nil {
+				return nil, report(ctx, err)
+			}
+			patch, err := patchFromInput(inputMap)
+			if err != nil {
+				return nil, report(ctx, err)
+			}
+			p, err := resolver.PatchProfile(withExpectedRevision(ctx, inputMap), id, patch)
+			if err != nil {
+				return nil, report(ctx, err)
+			}
+			return map[string]interface{}{"profile": p}, nil
+		},
+	})
+
+	// input DeleteProfileInput {
+	//   clientMutationId: String!
+	//   id: ID!
+	//   expectedRevision: Int
+	// }
+	//
+	// type DeleteProfilePayload {
+	//   clientMutationId: String!
+	//   deletedProfileId: ID!
+	// }
+	deleteProfile := relay.MutationWithClientMutationID(relay.MutationConfig{
+		Name: "DeleteProfile",
+		InputFields: graphql.InputObjectConfigFieldMap{
+			"id":               &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.ID)},
+			"expectedRevision": expectedRevision,
+		},
+		OutputFields: graphql.Fields{
+			"deletedProfileId": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.ID),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if payload, ok := p.Source.(map[string]interface{}); ok {
+						return payload["deletedProfileId"], nil
+					}
+					return nil, nil
+				},
+			},
+		},
+		MutateAndGetPayload: func(inputMap map[string]interface{}, info graphql.ResolveInfo, ctx context.Context) (map[string]interface{}, error) {
+			id, err := profileID(inputMap["id"])
+			if err != nil {
+				return nil, report(ctx, err)
+			}
+			if err := resolver.DeleteProfile(withExpectedRevision(ctx, inputMap), id); err != nil {
+				return nil, report(ctx, err)
+			}
+			return map[string]interface{}{"deletedProfileId": relay.ToGlobalID("Profile", id)}, nil
+		},
+	})
+
+	return graphql.Fields{
+		"updateProfile": updateProfile,
+		"patchProfile":  patchProfile,
+		"deleteProfile": deleteProfile,
+	}
+}
@@ -1,8 +1,11 @@
 package graphql
 
 import (
+	"encoding/base64"
 	"errors"
-	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/benkim0414/superego/pkg/profile"
 	"github.com/graphql-go/graphql"
@@ -11,9 +14,10 @@ import (
 )
 
 var (
-	nodeDefinitions *relay.NodeDefinitions
-	nameType        *graphql.Object
-	profileType     *graphql.Object
+	nodeDefinitions             *relay.NodeDefinitions
+	nameType                    *graphql.Object
+	profileType                 *graphql.Object
+	profileConnectionDefinition *relay.GraphQLConnectionDefinitions
 )
 
 func NewSchema(resolver Resolver) (graphql.Schema, error) {
@@ -24,10 +28,17 @@ func NewSchema(resolver Resolver) (graphql.Schema, error) {
 	nodeDefinitions = relay.NewNodeDefinitions(relay.NodeDefinitionsConfig{
 		IDFetcher: func(id string, info graphql.ResolveInfo, ctx context.Context) (interface{}, error) {
 			resolvedID := relay.FromGlobalID(id)
+			if resolvedID == nil {
+				return nil, errors.New("Unknown node type")
+			}
 
 			switch resolvedID.Type {
 			case "Profile":
-				return resolver.GetProfile(ctx, resolvedID.ID)
+				p, err := loaderFrom(ctx).load(ctx, resolver, resolvedID.ID, info)
+				if err != nil {
+					return nil, report(ctx, err)
+				}
+				return p, nil
 			default:
 				return nil, errors.New("Unknown node type")
 			}
@@ -40,6 +51,8 @@ func NewSchema(resolver Resolver) (graphql.Schema, error) {
 		},
 	})
 
+	newIdentityTypes()
+
 	// type Name {
 	//   formatted: String!
 	//   familyName: String!
@@ -52,8 +65,10 @@ func NewSchema(resolver Resolver) (graphql.Schema, error) {
 				Type: graphql.NewNonNull(graphql.String),
 				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
 					if name, ok := p.Source.(profile.Name); ok {
-						formatted := fmt.Sprintf("%s %s", name.GivenName, name.FamilyName)
-						return formatted, nil
+						if name.Formatted != "" {
+							return name.Formatted, nil
+						}
+						return strings.TrimSpace(name.GivenName + " " + name.FamilyName), nil
 					}
 					return nil, nil
 				},
@@ -74,6 +89,9 @@ func NewSchema(resolver Resolver) (graphql.Schema, error) {
 	//   email: String!
 	//   imageUrl: String
 	//   aboutMe: String
+	//   revision: Int!
+	//   updateTime: String!
+	//   identities: [Identity!]!
 	// }
 	profileType = graphql.NewObject(graphql.ObjectConfig{
 		Name: "Profile",
@@ -94,57 +112,181 @@ func NewSchema(resolver Resolver) (graphql.Schema, error) {
 			"aboutMe": &graphql.Field{
 				Type: graphql.String,
 			},
+			"revision": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Int),
+				Description: "The revision of the profile, incremented on every update.",
+			},
+			"updateTime": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "The time the profile was last modified, in RFC 3339 format.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if source, ok := p.Source.(*profile.Profile); ok {
+						return source.UpdateTime.Format(time.RFC3339), nil
+					}
+					return nil, nil
+				},
+			},
+			"identities": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(identityType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if source, ok := p.Source.(*profile.Profile); ok && source.Identities != nil {
+						return source.Identities, nil
+					}
+					return []profile.Identity{}, nil
+				},
+			},
 		},
 		Interfaces: []*graphql.Interface{
 			nodeDefinitions.NodeInterface,
 		},
 	})
 
+	// type ProfileConnection {
+	//   edges: [ProfileEdge]
+	//   pageInfo: PageInfo!
+	// }
+	profileConnectionDefinition = relay.ConnectionDefinitions(relay.ConnectionConfig{
+		Name:     "Profile",
+		NodeType: profileType,
+	})
+
 	// type Query {
 	//   node(id: String!): Node
+	//   nodes(ids: [ID!]!): [Node]!
+	//   profiles(first: Int, after: String, orderBy: String): ProfileConnection
+	//   searchProfiles(
+	//     email: String, emailPrefix: String,
+	//     displayName: String, displayNamePrefix: String,
+	//     familyName: String, familyNamePrefix: String,
+	//     givenName: String, givenNamePrefix: String,
+	//     first: Int, after: String, orderBy: String
+	//   ): ProfileConnection
+	//   profileByIdentity(provider: String!, subject: String!): Profile
 	// }
 	queryType := graphql.NewObject(graphql.ObjectConfig{
 		Name: "Query",
 		Fields: graphql.Fields{
 			"node": nodeDefinitions.NodeField,
+			"nodes": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(nodeDefinitions.NodeInterface)),
+				Description: "Fetches objects given their IDs, or null for those which do not exist.",
+				Args: graphql.FieldConfigArgument{
+					"ids": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.ID))),
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					ids, _ := p.Args["ids"].([]interface{})
+					nodes := make([]interface{}, len(ids))
+					l := loaderFrom(p.Context)
+					for i, id := range ids {
+						s, _ := id.(string)
+						resolvedID := relay.FromGlobalID(s)
+						if resolvedID == nil || resolvedID.Type != "Profile" {
+							continue
+						}
+						node, err := l.load(p.Context, resolver, resolvedID.ID, p.Info)
+						if err == profile.ErrNoSuchEntity {
+							continue
+						}
+						if err != nil {
+							return nil, report(p.Context, err)
+						}
+						nodes[i] = node
+					}
+					return nodes, nil
+				},
+			},
+			"profiles": &graphql.Field{
+				Type: profileConnectionDefinition.ConnectionType,
+				Args: relay.NewConnectionArgs(graphql.FieldConfigArgument{
+					"orderBy": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					opts := profile.ListOptions{}
+					if orderBy, ok := p.Args["orderBy"].(string); ok {
+						opts.OrderBy = orderBy
+					}
+					return resolveProfileConnection(p, func(pageSize int, pageToken string) ([]*profile.Profile, string, error) {
+						opts.PageSize, opts.PageToken = pageSize, pageToken
+						return resolver.ListProfiles(p.Context, opts)
+					})
+				},
+			},
+			"searchProfiles": &graphql.Field{
+				Type: profileConnectionDefinition.ConnectionType,
+				Args: relay.NewConnectionArgs(graphql.FieldConfigArgument{
+					"email":             &graphql.ArgumentConfig{Type: graphql.String},
+					"emailPrefix":       &graphql.ArgumentConfig{Type: graphql.String},
+					"displayName":       &graphql.ArgumentConfig{Type: graphql.String},
+					"displayNamePrefix": &graphql.ArgumentConfig{Type: graphql.String},
+					"familyName":        &graphql.ArgumentConfig{Type: graphql.String},
+					"familyNamePrefix":  &graphql.ArgumentConfig{Type: graphql.String},
+					"givenName":         &graphql.ArgumentConfig{Type: graphql.String},
+					"givenNamePrefix":   &graphql.ArgumentConfig{Type: graphql.String},
+					"orderBy":           &graphql.ArgumentConfig{Type: graphql.String},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					arg := func(name string) string {
+						s, _ := p.Args[name].(string)
+						return s
+					}
+					query := profile.SearchQuery{
+						Email:             arg("email"),
+						EmailPrefix:       arg("emailPrefix"),
+						DisplayName:       arg("displayName"),
+						DisplayNamePrefix: arg("displayNamePrefix"),
+						FamilyName:        arg("familyName"),
+						FamilyNamePrefix:  arg("familyNamePrefix"),
+						GivenName:         arg("givenName"),
+						GivenNamePrefix:   arg("givenNamePrefix"),
+					}
+					opts := profile.ListOptions{OrderBy: arg("orderBy")}
+					return resolveProfileConnection(p, func(pageSize int, pageToken string) ([]*profile.Profile, string, error) {
+						opts.PageSize, opts.PageToken = pageSize, pageToken
+						return resolver.SearchProfiles(p.Context, query, opts)
+					})
+				},
+			},
+			"profileByIdentity": &graphql.Field{
+				Type: profileType,
+				Args: graphql.FieldConfigArgument{
+					"provider": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"subject":  &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					provider, _ := p.Args["provider"].(string)
+					subject, _ := p.Args["subject"].(string)
+					profile, err := resolver.ResolveIdentity(p.Context, provider, subject)
+					if err != nil {
+						return nil, report(p.Context, err)
+					}
+					return profile, nil
+				},
+			},
 		},
 	})
 
 	// input CreateProfileInput {
-	//   clientMutationID: String!
+	//   clientMutationId: String!
 	//   displayName: String
-	//   name: Name
+	//   formatted: String
+	//   familyName: String
+	//   givenName: String
 	//   email: String!
 	//   imageUrl: String
 	//   aboutMe: String
 	// }
 	//
-	// input CreateProfilePayload {
-	//   clientMutationID: String!
+	// type CreateProfilePayload {
+	//   clientMutationId: String!
 	//   profile: Profile
 	// }
 	profileMutation := relay.MutationWithClientMutationID(relay.MutationConfig{
-		Name: "CreateProfile",
-		InputFields: graphql.InputObjectConfigFieldMap{
-			"displayName": &graphql.InputObjectFieldConfig{
-				Type: graphql.String,
-			},
-			"familyName": &graphql.InputObjectFieldConfig{
-				Type: graphql.String,
-			},
-			"givenName": &graphql.InputObjectFieldConfig{
-				Type: graphql.String,
-			},
-			"email": &graphql.InputObjectFieldConfig{
-				Type: graphql.NewNonNull(graphql.String),
-			},
-			"imageUrl": &graphql.InputObjectFieldConfig{
-				Type: graphql.String,
-			},
-			"aboutMe": &graphql.InputObjectFieldConfig{
-				Type: graphql.String,
-			},
-		},
+		Name:        "CreateProfile",
+		InputFields: profileInputFields(graphql.NewNonNull(graphql.String)),
 		OutputFields: graphql.Fields{
 			"profile": &graphql.Field{
 				Type: profileType,
@@ -152,42 +294,20 @@ func NewSchema(resolver Resolver) (graphql.Schema, error) {
 					if payload, ok := p.Source.(map[string]interface{}); ok {
 						ctx := p.Context
 						profileID := payload["profileId"].(string)
-						return resolver.GetProfile(ctx, profileID)
+						profile, err := resolver.GetProfile(ctx, profileID)
+						if err != nil {
+							return nil, report(ctx, err)
+						}
+						return profile, nil
 					}
 					return nil, nil
 				},
 			},
 		},
 		MutateAndGetPayload: func(inputMap map[string]interface{}, info graphql.ResolveInfo, ctx context.Context) (map[string]interface{}, error) {
-			email := inputMap["email"].(string)
-			p := &profile.Profile{
-				Email: email,
-			}
-
-			if displayName, ok := inputMap["displayName"].(string); ok {
-				p.DisplayName = displayName
-			}
-
-			name := profile.Name{}
-			if familyName, ok := inputMap["familyName"].(string); ok {
-				name.FamilyName = familyName
-			}
-			if givenName, ok := inputMap["givenName"].(string); ok {
-				name.GivenName = givenName
-			}
-			p.Name = name
-
-			if imageURL, ok := inputMap["imageUrl"].(string); ok {
-				p.ImageURL = imageURL
-			}
-
-			if aboutMe, ok := inputMap["aboutMe"].(string); ok {
-				p.AboutMe = aboutMe
-			}
-
-			profile, err := resolver.PostProfile(ctx, p)
+			profile, err := resolver.PostProfile(ctx, profileFromInput(inputMap))
 			if err != nil {
-				return nil, err
+				return nil, report(ctx, err)
 			}
 
 			return map[string]interface{}{
@@ -198,16 +318,113 @@ func NewSchema(resolver Resolver) (graphql.Schema, error) {
 
 	// type Mutation {
 	//   createProfile(input CreateProfileInput!): CreateProfilePayload
+	//   updateProfile(input UpdateProfileInput!): UpdateProfilePayload
+	//   patchProfile(input PatchProfileInput!): PatchProfilePayload
+	//   deleteProfile(input DeleteProfileInput!): DeleteProfilePayload
+	//   linkIdentity(input LinkIdentityInput!): LinkIdentityPayload
+	//   unlinkIdentity(input UnlinkIdentityInput!): UnlinkIdentityPayload
+	//   mergeProfiles(input MergeProfilesInput!): MergeProfilesPayload
 	// }
+	mutationFields := graphql.Fields{
+		"createProfile": profileMutation,
+	}
+	for name, field := range profileMutations(resolver) {
+		mutationFields[name] = field
+	}
+	for name, field := range identityMutations(resolver) {
+		mutationFields[name] = field
+	}
+	for name, field := range mergeMutations(resolver) {
+		mutationFields[name] = field
+	}
 	mutationType := graphql.NewObject(graphql.ObjectConfig{
-		Name: "Mutation",
-		Fields: graphql.Fields{
-			"createProfile": profileMutation,
-		},
+		Name:   "Mutation",
+		Fields: mutationFields,
 	})
 
 	return graphql.NewSchema(graphql.SchemaConfig{
-		Query:    queryType,
-		Mutation: mutationType,
+		Query:        queryType,
+		Mutation:     mutationType,
+		Subscription: newSubscriptionType(),
 	})
 }
+
+// profileCursor is the position after an edge of a profile connection. The
+// page tokens of the service are opaque, so it is the token of the page of
+// the service holding the edge, along with the number of profiles of that
+// page up to and including the edge.
+type profileCursor struct {
+	pageToken string
+	offset    int
+}
+
+func (c profileCursor) encode() relay.ConnectionCursor {
+	return relay.ConnectionCursor(base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(c.offset) + ":" + c.pageToken)))
+}
+
+// decodeProfileCursor decodes a cursor of profileCursor.encode. An empty
+// cursor decodes to the start of the first page.
+func decodeProfileCursor(cursor relay.ConnectionCursor) (profileCursor, error) {
+	if cursor == "" {
+		return profileCursor{}, nil
+	}
+	invalid := profile.Errorf(profile.InvalidArgument, "invalid cursor %q", cursor)
+	b, err := base64.RawURLEncoding.DecodeString(string(cursor))
+	if err != nil {
+		return profileCursor{}, invalid
+	}
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 {
+		return profileCursor{}, invalid
+	}
+	offset, err := strconv.Atoi(parts[0])
+	if err != nil || offset < 0 {
+		return profileCursor{}, invalid
+	}
+	return profileCursor{pageToken: parts[1], offset: offset}, nil
+}
+
+// resolveProfileConnection resolves a page of profiles into a connection,
+// listing the pages of the service with the given page size and token.
+// Profiles can only be paginated forwards. Every edge has a cursor, which
+// lists the page of the service holding the edge again and skips the
+// profiles up to the edge, unless it is the last of its page.
+func resolveProfileConnection(p graphql.ResolveParams, list func(pageSize int, pageToken string) ([]*profile.Profile, string, error)) (interface{}, error) {
+	args := relay.NewConnectionArguments(p.Args)
+	if args.Last >= 0 || args.Before != "" {
+		return nil, report(p.Context, profile.Errorf(profile.InvalidArgument, "backward pagination is not supported"))
+	}
+	after, err := decodeProfileCursor(args.After)
+	if err != nil {
+		return nil, report(p.Context, err)
+	}
+	first := args.First
+	if first <= 0 {
+		first = profile.DefaultPageSize
+	}
+
+	// the service may return fewer profiles than asked for, such as up to
+	// profile.MaxPageSize.
+	profiles, nextPageToken, err := list(after.offset+first, after.pageToken)
+	if err != nil {
+		return nil, report(p.Context, err)
+	}
+
+	conn := relay.NewConnection()
+	end := after
+	for i := after.offset; i < len(profiles); i++ {
+		end = profileCursor{pageToken: after.pageToken, offset: i + 1}
+		if i == len(profiles)-1 && nextPageToken != "" {
+			end = profileCursor{pageToken: nextPageToken}
+		}
+		conn.Edges = append(conn.Edges, &relay.Edge{Node: profiles[i], Cursor: end.encode()})
+	}
+	if len(conn.Edges) == 0 && nextPageToken != "" {
+		end = profileCursor{pageToken: nextPageToken}
+	}
+	conn.PageInfo = relay.PageInfo{
+		EndCursor:   end.encode(),
+		HasNextPage: nextPageToken != "",
+	}
+	return conn, nil
+}
@@ -0,0 +1,495 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/benkim0414/superego/pkg/profile"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/relay"
+)
+
+// do executes the request against a schema of the fake service, and decodes
+// its data into v. It returns the messages of the errors of the result.
+func do(t *testing.T, request string, variables map[string]interface{}, v interface{}) []string {
+	messages, _ := execute(t, request, variables, v)
+	return messages
+}
+
+// doCodes is like do, but returns the codes of the errors of the result, as
+// reported in their extensions.
+func doCodes(t *testing.T, request string, variables map[string]interface{}, v interface{}) []string {
+	_, codes := execute(t, request, variables, v)
+	return codes
+}
+
+func execute(t *testing.T, request string, variables map[string]interface{}, v interface{}) (messages, codes []string) {
+	schema, err := NewSchema(profile.FakeService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, errorCodes := withErrorCodes(context.Background())
+	result := graphql.Do(graphql.Params{
+		Schema:         schema,
+		RequestString:  request,
+		VariableValues: variables,
+		Context:        ctx,
+	})
+	for _, e := range result.Errors {
+		messages = append(messages, e.Message)
+		code, _, _ := errorCodes.lookup(e.Message)
+		codes = append(codes, code.String())
+	}
+	b, err := json.Marshal(result.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		t.Fatal(err)
+	}
+	return messages, codes
+}
+
+type profilePayload struct {
+	ClientMutationID string `json:"clientMutationId"`
+	Profile          *struct {
+		ID          string `json:"id"`
+		DisplayName string `json:"displayName"`
+		Name        struct {
+			Formatted  string `json:"formatted"`
+			FamilyName string `json:"familyName"`
+			GivenName  string `json:"givenName"`
+		} `json:"name"`
+		Email    string `json:"email"`
+		AboutMe  string `json:"aboutMe"`
+		Revision int    `json:"revision"`
+	} `json:"profile"`
+}
+
+const profileFields = `clientMutationId profile { id displayName name { formatted familyName givenName } email aboutMe revision }`
+
+func TestCreateProfileMutation(t *testing.T) {
+	var data struct {
+		CreateProfile profilePayload `json:"createProfile"`
+	}
+	errs := do(t, `mutation {
+		createProfile(input: {clientMutationId: "1", email: "create@gunwoo.org", formatted: "Kim Gunwoo", givenName: "Gunwoo"}) {`+profileFields+`}
+	}`, nil, &data)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	payload := data.CreateProfile
+	if payload.ClientMutationID != "1" || payload.Profile == nil {
+		t.Fatalf("createProfile: got %+v", payload)
+	}
+	// the fake service does not generate ids, so the profile has none.
+	defer profile.FakeService.DeleteProfile(context.Background(), "")
+	if got, want := payload.Profile.Name.Formatted, "Kim Gunwoo"; got != want {
+		t.Errorf("createProfile: got formatted name %q, want %q", got, want)
+	}
+	if got, want := payload.Profile.Name.GivenName, "Gunwoo"; got != want {
+		t.Errorf("createProfile: got given name %q, want %q", got, want)
+	}
+}
+
+func TestUpdateProfileMutation(t *testing.T) {
+	ctx := context.Background()
+	if _, err := profile.FakeService.PostProfile(ctx, &profile.Profile{ID: "update", Email: "update@gunwoo.org", AboutMe: "about"}); err != nil {
+		t.Fatal(err)
+	}
+	defer profile.FakeService.DeleteProfile(ctx, "update")
+	id := relay.ToGlobalID("Profile", "update")
+
+	var data struct {
+		UpdateProfile profilePayload `json:"updateProfile"`
+	}
+	errs := do(t, `mutation($id: ID!) {
+		updateProfile(input: {clientMutationId: "2", id: $id, email: "update@gunwoo.org", displayName: "Ben", expectedRevision: 1}) {`+profileFields+`}
+	}`, map[string]interface{}{"id": id}, &data)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	p := data.UpdateProfile.Profile
+	if p == nil || p.ID != id || p.DisplayName != "Ben" || p.AboutMe != "" || p.Revision != 2 {
+		t.Errorf("updateProfile: got %+v, want the profile replaced at revision 2", p)
+	}
+
+	codes := doCodes(t, `mutation($id: ID!) {
+		updateProfile(input: {clientMutationId: "3", id: $id, email: "update@gunwoo.org", expectedRevision: 1}) {`+profileFields+`}
+	}`, map[string]interface{}{"id": id}, &data)
+	if len(codes) != 1 || codes[0] != profile.FailedPrecondition.String() {
+		t.Errorf("updateProfile: got errors %v, want a revision mismatch", codes)
+	}
+}
+
+func TestPatchProfileMutation(t *testing.T) {
+	ctx := context.Background()
+	_, err := profile.FakeService.PostProfile(ctx, &profile.Profile{
+		ID:      "patch",
+		Email:   "patch@gunwoo.org",
+		Name:    profile.Name{FamilyName: "Kim", GivenName: "Gunwoo"},
+		AboutMe: "about",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer profile.FakeService.DeleteProfile(ctx, "patch")
+
+	var data struct {
+		PatchProfile profilePayload `json:"patchProfile"`
+	}
+	errs := do(t, `mutation($id: ID!) {
+		patchProfile(input: {clientMutationId: "4", id: $id, givenName: "Ben", aboutMe: ""}) {`+profileFields+`}
+	}`, map[string]interface{}{"id": relay.ToGlobalID("Profile", "patch")}, &data)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	p := data.PatchProfile.Profile
+	if p == nil || p.Name.GivenName != "Ben" || p.Name.FamilyName != "Kim" || p.AboutMe != "" || p.Email != "patch@gunwoo.org" {
+		t.Errorf("patchProfile: got %+v, want the given fields patched", p)
+	}
+
+	codes := doCodes(t, `mutation($id: ID!) {
+		patchProfile(input: {clientMutationId: "4", id: $id, displayName: "Ben", expectedRevision: 1}) {`+profileFields+`}
+	}`, map[string]interface{}{"id": relay.ToGlobalID("Profile", "patch")}, &data)
+	if len(codes) != 1 || codes[0] != profile.FailedPrecondition.String() {
+		t.Errorf("patchProfile: got errors %v, want a revision mismatch", codes)
+	}
+
+	// an update mask overwrites exactly its fields, clearing the omitted ones.
+	errs = do(t, `mutation($id: ID!) {
+		patchProfile(input: {clientMutationId: "4", id: $id, displayName: "Ben", aboutMe: "ignored", updateMask: ["displayName", "name.familyName"]}) {`+profileFields+`}
+	}`, map[string]interface{}{"id": relay.ToGlobalID("Profile", "patch")}, &data)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	p = data.PatchProfile.Profile
+	if p == nil || p.DisplayName != "Ben" || p.Name.FamilyName != "" || p.Name.GivenName != "Ben" || p.AboutMe != "" || p.Email != "patch@gunwoo.org" {
+		t.Errorf("patchProfile: got %+v, want the fields of the mask overwritten", p)
+	}
+
+	for _, mask := range []string{`["unknown"]`, `["revision"]`, `[]`} {
+		codes := doCodes(t, `mutation($id: ID!) {
+			patchProfile(input: {clientMutationId: "4", id: $id, updateMask: `+mask+`}) {`+profileFields+`}
+		}`, map[string]interface{}{"id": relay.ToGlobalID("Profile", "patch")}, &data)
+		if len(codes) != 1 || codes[0] != profile.InvalidArgument.String() {
+			t.Errorf("patchProfile: got errors %v for the mask %s, want an invalid mask", codes, mask)
+		}
+	}
+}
+
+func TestDeleteProfileMutation(t *testing.T) {
+	ctx := context.Background()
+	if _, err := profile.FakeService.PostProfile(ctx, &profile.Profile{ID: "delete", Email: "delete@gunwoo.org"}); err != nil {
+		t.Fatal(err)
+	}
+	id := relay.ToGlobalID("Profile", "delete")
+
+	var data struct {
+		DeleteProfile struct {
+			ClientMutationID string `json:"clientMutationId"`
+			DeletedProfileID string `json:"deletedProfileId"`
+		} `json:"deleteProfile"`
+	}
+	codes := doCodes(t, `mutation($id: ID!) {
+		deleteProfile(input: {clientMutationId: "5", id: $id, expectedRevision: 2}) { clientMutationId deletedProfileId }
+	}`, map[string]interface{}{"id": id}, &data)
+	if len(codes) != 1 || codes[0] != profile.FailedPrecondition.String() {
+		t.Errorf("deleteProfile: got errors %v, want a revision mismatch", codes)
+	}
+
+	errs := do(t, `mutation($id: ID!) {
+		deleteProfile(input: {clientMutationId: "5", id: $id}) { clientMutationId deletedProfileId }
+	}`, map[string]interface{}{"id": id}, &data)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if data.DeleteProfile.ClientMutationID != "5" || data.DeleteProfile.DeletedProfileID != id {
+		t.Errorf("deleteProfile: got %+v, want %q deleted", data.DeleteProfile, id)
+	}
+	if _, err := profile.FakeService.GetProfile(ctx, "delete"); profile.ErrorCode(err) != profile.NotFound {
+		t.Errorf("GetProfile: got %v, want %v", err, profile.NotFound)
+	}
+
+	errs = do(t, `mutation {
+		deleteProfile(input: {clientMutationId: "6", id: "invalid"}) { deletedProfileId }
+	}`, nil, &data)
+	if len(errs) != 1 {
+		t.Errorf("deleteProfile: got errors %v, want an invalid id", errs)
+	}
+}
+
+func TestIdentityMutations(t *testing.T) {
+	ctx := context.Background()
+	if _, err := profile.FakeService.PostProfile(ctx, &profile.Profile{ID: "identity", Email: "identity@gunwoo.org"}); err != nil {
+		t.Fatal(err)
+	}
+	defer profile.FakeService.DeleteProfile(ctx, "identity")
+	id := relay.ToGlobalID("Profile", "identity")
+	const identityFields = `clientMutationId profile { id revision identities { provider subject metadata { key value } } }`
+
+	type identityPayload struct {
+		ClientMutationID string `json:"clientMutationId"`
+		Profile          *struct {
+			ID         string `json:"id"`
+			Revision   int    `json:"revision"`
+			Identities []struct {
+				Provider string `json:"provider"`
+				Subject  string `json:"subject"`
+				Metadata []struct {
+					Key   string `json:"key"`
+					Value string `json:"value"`
+				} `json:"metadata"`
+			} `json:"identities"`
+		} `json:"profile"`
+	}
+	var link struct {
+		LinkIdentity identityPayload `json:"linkIdentity"`
+	}
+	errs := do(t, `mutation($id: ID!) {
+		linkIdentity(input: {clientMutationId: "7", profileId: $id, provider: "github", subject: "gunwoo", metadata: [{key: "login", value: "benkim0414"}], expectedRevision: 1}) {`+identityFields+`}
+	}`, map[string]interface{}{"id": id}, &link)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	p := link.LinkIdentity.Profile
+	if p == nil || p.ID != id || p.Revision != 2 || len(p.Identities) != 1 || p.Identities[0].Subject != "gunwoo" ||
+		len(p.Identities[0].Metadata) != 1 || p.Identities[0].Metadata[0].Value != "benkim0414" {
+		t.Errorf("linkIdentity: got %+v, want the identity linked at revision 2", p)
+	}
+
+	var resolved struct {
+		ProfileByIdentity *struct {
+			ID string `json:"id"`
+		} `json:"profileByIdentity"`
+	}
+	if errs := do(t, `{ profileByIdentity(provider: "github", subject: "gunwoo") { id } }`, nil, &resolved); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if resolved.ProfileByIdentity == nil || resolved.ProfileByIdentity.ID != id {
+		t.Errorf("profileByIdentity: got %+v, want %s", resolved.ProfileByIdentity, id)
+	}
+
+	// the mutations fail at another revision.
+	codes := doCodes(t, `mutation($id: ID!) {
+		linkIdentity(input: {clientMutationId: "8", profileId: $id, provider: "google", subject: "gunwoo", expectedRevision: 1}) {`+identityFields+`}
+	}`, map[string]interface{}{"id": id}, &link)
+	if len(codes) != 1 || codes[0] != profile.FailedPrecondition.String() {
+		t.Errorf("linkIdentity: got errors %v, want a revision mismatch", codes)
+	}
+	var unlink struct {
+		UnlinkIdentity identityPayload `json:"unlinkIdentity"`
+	}
+	codes = doCodes(t, `mutation($id: ID!) {
+		unlinkIdentity(input: {clientMutationId: "9", profileId: $id, provider: "github", subject: "gunwoo", expectedRevision: 1}) {`+identityFields+`}
+	}`, map[string]interface{}{"id": id}, &unlink)
+	if len(codes) != 1 || codes[0] != profile.FailedPrecondition.String() {
+		t.Errorf("unlinkIdentity: got errors %v, want a revision mismatch", codes)
+	}
+
+	errs = do(t, `mutation($id: ID!) {
+		unlinkIdentity(input: {clientMutationId: "10", profileId: $id, provider: "github", subject: "gunwoo", expectedRevision: 2}) {`+identityFields+`}
+	}`, map[string]interface{}{"id": id}, &unlink)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if p := unlink.UnlinkIdentity.Profile; p == nil || p.Revision != 3 || len(p.Identities) != 0 {
+		t.Errorf("unlinkIdentity: got %+v, want no identities at revision 3", p)
+	}
+	codes = doCodes(t, `{ profileByIdentity(provider: "github", subject: "gunwoo") { id } }`, nil, &resolved)
+	if len(codes) != 1 || codes[0] != profile.NotFound.String() {
+		t.Errorf("profileByIdentity: got errors %v, want %v", codes, profile.NotFound)
+	}
+}
+
+func TestMergeProfilesMutation(t *testing.T) {
+	ctx := context.Background()
+	for _, p := range []*profile.Profile{
+		{ID: "merge-survivor", Email: "merge-survivor@gunwoo.org", AboutMe: "survivor"},
+		{ID: "merge-victim", Email: "merge-victim@gunwoo.org", DisplayName: "Victim", AboutMe: "victim"},
+	} {
+		if _, err := profile.FakeService.PostProfile(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+		defer profile.FakeService.DeleteProfile(ctx, p.ID)
+	}
+	variables := map[string]interface{}{
+		"survivorId": relay.ToGlobalID("Profile", "merge-survivor"),
+		"victimId":   relay.ToGlobalID("Profile", "merge-victim"),
+	}
+
+	var data struct {
+		MergeProfiles profilePayload `json:"mergeProfiles"`
+	}
+	codes := doCodes(t, `mutation($survivorId: ID!, $victimId: ID!) {
+		mergeProfiles(input: {clientMutationId: "11", survivorId: $survivorId, victimId: $victimId, expectedRevision: 2}) {`+profileFields+`}
+	}`, variables, &data)
+	if len(codes) != 1 || codes[0] != profile.FailedPrecondition.String() {
+		t.Errorf("mergeProfiles: got errors %v, want a revision mismatch", codes)
+	}
+
+	errs := do(t, `mutation($survivorId: ID!, $victimId: ID!) {
+		mergeProfiles(input: {clientMutationId: "12", survivorId: $survivorId, victimId: $victimId, policy: PREFER_SURVIVOR, fields: [{field: "aboutMe", source: VICTIM}], expectedRevision: 1}) {`+profileFields+`}
+	}`, variables, &data)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	p := data.MergeProfiles.Profile
+	if p == nil || p.ID != variables["survivorId"] || p.Email != "merge-survivor@gunwoo.org" || p.DisplayName != "Victim" || p.AboutMe != "victim" || p.Revision != 2 {
+		t.Errorf("mergeProfiles: got %+v, want the victim merged into the survivor at revision 2", p)
+	}
+	if _, err := profile.FakeService.GetProfile(ctx, "merge-victim"); err != nil {
+		t.Errorf("GetProfile: got %v, want the victim redirected to the survivor", err)
+	}
+
+	codes = doCodes(t, `mutation($survivorId: ID!) {
+		mergeProfiles(input: {clientMutationId: "13", survivorId: $survivorId, victimId: $survivorId}) {`+profileFields+`}
+	}`, variables, &data)
+	if len(codes) != 1 || codes[0] != profile.InvalidArgument.String() {
+		t.Errorf("mergeProfiles: got errors %v, want an invalid merge", codes)
+	}
+}
+
+type profileConnection struct {
+	Edges []struct {
+		Cursor string `json:"cursor"`
+		Node   struct {
+			ID          string `json:"id"`
+			DisplayName string `json:"displayName"`
+		} `json:"node"`
+	} `json:"edges"`
+	PageInfo struct {
+		HasNextPage bool   `json:"hasNextPage"`
+		EndCursor   string `json:"endCursor"`
+	} `json:"pageInfo"`
+}
+
+const connectionFields = `edges { cursor node { id displayName } } pageInfo { hasNextPage endCursor }`
+
+// postConnectionProfiles posts the profiles listed by the connection tests,
+// whose display names are in the reverse order of their ids, and returns a
+// function which deletes them.
+func postConnectionProfiles(t *testing.T) func() {
+	ctx := context.Background()
+	for _, p := range []*profile.Profile{
+		{ID: "connection-a", Email: "connection-a@gunwoo.org", DisplayName: "C", Name: profile.Name{FamilyName: "Kim"}},
+		{ID: "connection-b", Email: "connection-b@gunwoo.org", DisplayName: "B", Name: profile.Name{FamilyName: "Kim"}},
+		{ID: "connection-c", Email: "connection-c@gunwoo.org", DisplayName: "A", Name: profile.Name{FamilyName: "Lee"}},
+	} {
+		if _, err := profile.FakeService.PostProfile(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		for _, id := range []string{"connection-a", "connection-b", "connection-c"} {
+			profile.FakeService.DeleteProfile(ctx, id)
+		}
+	}
+}
+
+// pages follows the end cursors of the connection of the field from the
+// first page, and returns the display names of the nodes of every page.
+func pages(t *testing.T, field, args string) [][]string {
+	var names [][]string
+	variables := map[string]interface{}{"after": ""}
+	for {
+		var data map[string]profileConnection
+		errs := do(t, `query($after: String) { `+field+`(first: 2, after: $after`+args+`) {`+connectionFields+`} }`, variables, &data)
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		conn := data[field]
+		if len(conn.Edges) > 2 {
+			t.Errorf("%s: got %d edges, want at most 2", field, len(conn.Edges))
+		}
+		var page []string
+		for _, edge := range conn.Edges {
+			page = append(page, edge.Node.DisplayName)
+		}
+		names = append(names, page)
+		if n := len(conn.Edges); n > 0 && conn.Edges[n-1].Cursor != conn.PageInfo.EndCursor {
+			t.Errorf("%s: got the cursor %q of the last edge, want the end cursor %q", field, conn.Edges[n-1].Cursor, conn.PageInfo.EndCursor)
+		}
+		if !conn.PageInfo.HasNextPage {
+			// the end cursor of the last page resumes after its last edge.
+			variables["after"] = conn.PageInfo.EndCursor
+			errs := do(t, `query($after: String) { `+field+`(first: 2, after: $after`+args+`) {`+connectionFields+`} }`, variables, &data)
+			if len(errs) > 0 || len(data[field].Edges) != 0 || data[field].PageInfo.HasNextPage {
+				t.Errorf("%s: got %+v, %v after the last page, want no edges", field, data[field], errs)
+			}
+			return names
+		}
+		variables["after"] = conn.PageInfo.EndCursor
+	}
+}
+
+func TestProfilesConnection(t *testing.T) {
+	defer postConnectionProfiles(t)()
+
+	// other tests may leave profiles behind, which are skipped.
+	var got []string
+	for _, page := range pages(t, "profiles", `, orderBy: "-email"`) {
+		for _, name := range page {
+			if name == "A" || name == "B" || name == "C" {
+				got = append(got, name)
+			}
+		}
+	}
+	if want := []string{"A", "B", "C"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("profiles: got %v, want %v", got, want)
+	}
+
+	var data struct {
+		Profiles *profileConnection `json:"profiles"`
+	}
+	for _, args := range []string{`last: 1`, `orderBy: "unknown"`, `first: 1, after: "invalid"`} {
+		codes := doCodes(t, `{ profiles(`+args+`) {`+connectionFields+`} }`, nil, &data)
+		if len(codes) != 1 || codes[0] != profile.InvalidArgument.String() {
+			t.Errorf("profiles(%s): got errors %v, want %v", args, codes, profile.InvalidArgument)
+		}
+	}
+}
+
+func TestSearchProfilesConnection(t *testing.T) {
+	defer postConnectionProfiles(t)()
+
+	got := pages(t, "searchProfiles", `, emailPrefix: "connection-", orderBy: "-email"`)
+	if want := [][]string{{"A", "B"}, {"C"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("searchProfiles: got pages %v, want %v", got, want)
+	}
+	got = pages(t, "searchProfiles", `, familyName: "Kim", orderBy: "-displayName"`)
+	if want := [][]string{{"C", "B"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("searchProfiles: got pages %v, want %v", got, want)
+	}
+
+	// every edge has a cursor, which resumes after it.
+	const search = `query($first: Int, $after: String) { searchProfiles(first: $first, after: $after, emailPrefix: "connection-", orderBy: "-email") {` + connectionFields + `} }`
+	var page map[string]profileConnection
+	if errs := do(t, search, map[string]interface{}{"first": 2}, &page); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	for i, edge := range page["searchProfiles"].Edges {
+		var resumed map[string]profileConnection
+		if errs := do(t, search, map[string]interface{}{"first": 2, "after": edge.Cursor}, &resumed); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		var got []string
+		for _, edge := range resumed["searchProfiles"].Edges {
+			got = append(got, edge.Node.DisplayName)
+		}
+		want := [][]string{{"B", "C"}, {"C"}}[i]
+		if !reflect.DeepEqual(got, want) || resumed["searchProfiles"].PageInfo.HasNextPage {
+			t.Errorf("searchProfiles after edge %d: got %v, %+v, want %v", i, got, resumed["searchProfiles"].PageInfo, want)
+		}
+	}
+
+	var data struct {
+		SearchProfiles *profileConnection `json:"searchProfiles"`
+	}
+	codes := doCodes(t, `{ searchProfiles(emailPrefix: "connection-", orderBy: "aboutMe") {`+connectionFields+`} }`, nil, &data)
+	if len(codes) != 1 || codes[0] != profile.InvalidArgument.String() {
+		t.Errorf("searchProfiles: got errors %v, want %v", codes, profile.InvalidArgument)
+	}
+}
@@ -0,0 +1,420 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/benkim0414/superego/pkg/service"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/graphql-go/relay"
+)
+
+// newSubscriptionType returns the root type of subscriptions. Its fields are
+// resolved against the service.Change which triggers the execution of a
+// subscription, and are null if the change is not subscribed to.
+func newSubscriptionType() *graphql.Object {
+
+	// enum ProfileChangeType {
+	//   CREATED
+	//   UPDATED
+	//   DELETED
+	// }
+	changeTypeEnum := graphql.NewEnum(graphql.EnumConfig{
+		Name: "ProfileChangeType",
+		Values: graphql.EnumValueConfigMap{
+			"CREATED": &graphql.EnumValueConfig{Value: string(service.Created)},
+			"UPDATED": &graphql.EnumValueConfig{Value: string(service.Updated)},
+			"DELETED": &graphql.EnumValueConfig{Value: string(service.Deleted)},
+		},
+	})
+
+	// type ProfileChange {
+	//   type: ProfileChangeType!
+	//   id: ID!
+	//   profile: Profile
+	// }
+	changeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ProfileChange",
+		Fields: graphql.Fields{
+			"type": &graphql.Field{
+				Type: graphql.NewNonNull(changeTypeEnum),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if c, ok := p.Source.(service.Change); ok {
+						return string(c.Type), nil
+					}
+					return nil, nil
+				},
+			},
+			"id": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.ID),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if c, ok := p.Source.(service.Change); ok {
+						return relay.ToGlobalID("Profile", c.ID), nil
+					}
+					return nil, nil
+				},
+			},
+			"profile": &graphql.Field{
+				Type:        profileType,
+				Description: "The profile after the change, or null if it was deleted.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if c, ok := p.Source.(service.Change); ok && c.Profile != nil {
+						return c.Profile, nil
+					}
+					return nil, nil
+				},
+			},
+		},
+	})
+
+	// type Subscription {
+	//   profileChanged(id: ID!): ProfileChange
+	//   profilesChanged: ProfileChange
+	// }
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "Subscription",
+		Fields: graphql.Fields{
+			"profileChanged": &graphql.Field{
+				Type:        changeType,
+				Description: "The changes of the profile with the given ID.",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					c, ok := p.Source.(service.Change)
+					if !ok {
+						return nil, nil
+					}
+					id, _ := p.Args["id"].(string)
+					if resolvedID := relay.FromGlobalID(id); resolvedID == nil || resolvedID.Type != "Profile" || resolvedID.ID != c.ID {
+						return nil, nil
+					}
+					return c, nil
+				},
+			},
+			"profilesChanged": &graphql.Field{
+				Type:        changeType,
+				Description: "The changes of all profiles.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if c, ok := p.Source.(service.Change); ok {
+						return c, nil
+					}
+					return nil, nil
+				},
+			},
+		},
+	})
+}
+
+// The types of the messages of the graphql-ws protocol, as defined by
+// https://github.com/apollographql/subscriptions-transport-ws/blob/master/PROTOCOL.md
+const (
+	gqlConnectionInit      = "connection_init"
+	gqlConnectionAck       = "connection_ack"
+	gqlConnectionKeepAlive = "ka"
+	gqlConnectionTerminate = "connection_terminate"
+	gqlStart               = "start"
+	gqlData                = "data"
+	gqlError               = "error"
+	gqlComplete            = "complete"
+	gqlStop                = "stop"
+)
+
+// subprotocol is the WebSocket subprotocol of the graphql-ws protocol.
+const subprotocol = "graphql-ws"
+
+// KeepAlive is the interval at which keep-alive messages are sent over the
+// connections of subscriptions, so that idle connections are not closed.
+var KeepAlive = 30 * time.Second
+
+type operationMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// subscriptionServer serves the operations of a single graphql-ws
+// connection. Subscriptions are executed against every change published to
+// the event bus, and their results sent unless none of their fields apply
+// to the change; queries and mutations are executed once. Operations run in
+// their own goroutines, so that they do not block the reading of messages.
+type subscriptionServer struct {
+	h    *requestHandler
+	conn *wsConn
+
+	initialized bool
+	wg          sync.WaitGroup
+
+	mu         sync.Mutex
+	operations map[string]*operation
+}
+
+// operation is a running operation of a graphql-ws connection.
+type operation struct {
+	// stop cancels the operation.
+	stop func()
+}
+
+// serveSubscriptions upgrades the request to a graphql-ws connection, and
+// serves its operations until the connection is closed.
+func serveSubscriptions(w http.ResponseWriter, r *http.Request, h *requestHandler) {
+	conn, err := upgrade(w, r, subprotocol, h.origins)
+	if err != nil {
+		return
+	}
+	s := &subscriptionServer{
+		h:          h,
+		conn:       conn,
+		operations: map[string]*operation{},
+	}
+	s.serve(r.Context())
+}
+
+func (s *subscriptionServer) serve(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		s.stopAll()
+		s.wg.Wait()
+		s.conn.Close()
+	}()
+
+	for {
+		b, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg operationMessage
+		if err := json.Unmarshal(b, &msg); err != nil {
+			s.sendError("", "invalid message: "+err.Error())
+			continue
+		}
+		switch msg.Type {
+		case gqlConnectionInit:
+			s.send(operationMessage{Type: gqlConnectionAck})
+			s.send(operationMessage{Type: gqlConnectionKeepAlive})
+			if !s.initialized {
+				s.initialized = true
+				s.wg.Add(1)
+				go s.keepAlive(ctx)
+			}
+		case gqlStart:
+			s.start(ctx, msg)
+		case gqlStop:
+			s.stop(msg.ID)
+		case gqlConnectionTerminate:
+			return
+		default:
+			s.sendError(msg.ID, "unknown message type "+msg.Type)
+		}
+	}
+}
+
+// keepAlive sends keep-alive messages until the context is done.
+func (s *subscriptionServer) keepAlive(ctx context.Context) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(KeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.send(operationMessage{Type: gqlConnectionKeepAlive})
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// start executes the operation of a start message.
+func (s *subscriptionServer) start(ctx context.Context, msg operationMessage) {
+	if msg.ID == "" {
+		s.sendError("", "missing operation id")
+		return
+	}
+	var payload graphQLRequest
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		s.sendError(msg.ID, "invalid payload: "+err.Error())
+		return
+	}
+	if err := s.h.resolvePersistedQuery(&payload); err != nil {
+		s.sendRequestError(msg.ID, err)
+		return
+	}
+	s.mu.Lock()
+	_, exists := s.operations[msg.ID]
+	s.mu.Unlock()
+	if exists {
+		s.sendError(msg.ID, "operation "+msg.ID+" already started")
+		return
+	}
+
+	doc, errs := s.parse(payload.Query)
+	if len(errs) == 0 {
+		if err := s.h.checkLimits(doc, payload.OperationName, payload.Variables); err != nil {
+			// the result has the extensions of the error, as over HTTP.
+			b, _ := json.Marshal(map[string]interface{}{"errors": []*requestError{err}})
+			s.send(operationMessage{ID: msg.ID, Type: gqlData, Payload: b})
+			s.send(operationMessage{ID: msg.ID, Type: gqlComplete})
+			return
+		}
+	}
+	if len(errs) > 0 {
+		s.sendResult(msg.ID, &graphql.Result{Errors: errs}, nil)
+		s.send(operationMessage{ID: msg.ID, Type: gqlComplete})
+		return
+	}
+	params := graphql.ExecuteParams{
+		Schema:        *s.h.schema,
+		AST:           doc,
+		OperationName: payload.OperationName,
+		Args:          payload.Variables,
+	}
+	if !isSubscription(doc, payload.OperationName) {
+		ctx, cancel := context.WithCancel(ctx)
+		op := &operation{stop: cancel}
+		s.mu.Lock()
+		s.operations[msg.ID] = op
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer cancel()
+			var codes *errorCodes
+			params.Context, codes = withErrorCodes(withLoader(ctx, s.h.batchSizes))
+			result := graphql.Execute(params)
+			// a stopped operation has been completed by stop.
+			s.mu.Lock()
+			running := s.operations[msg.ID] == op
+			if running {
+				delete(s.operations, msg.ID)
+			}
+			s.mu.Unlock()
+			if running {
+				s.sendResult(msg.ID, result, codes)
+				s.send(operationMessage{ID: msg.ID, Type: gqlComplete})
+			}
+		}()
+		return
+	}
+
+	changes, unsubscribe := s.h.bus.Subscribe()
+	s.mu.Lock()
+	s.operations[msg.ID] = &operation{stop: unsubscribe}
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for c := range changes {
+			var codes *errorCodes
+			params.Root = c
+			params.Context, codes = withErrorCodes(withLoader(ctx, s.h.batchSizes))
+			if result := graphql.Execute(params); len(result.Errors) > 0 || !isNullResult(result) {
+				s.sendResult(msg.ID, result, codes)
+			}
+		}
+	}()
+}
+
+// stop cancels the operation with the given id.
+func (s *subscriptionServer) stop(id string) {
+	s.mu.Lock()
+	op, ok := s.operations[id]
+	delete(s.operations, id)
+	s.mu.Unlock()
+	if ok {
+		op.stop()
+		s.send(operationMessage{ID: id, Type: gqlComplete})
+	}
+}
+
+// stopAll cancels all the operations of the connection.
+func (s *subscriptionServer) stopAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, op := range s.operations {
+		op.stop()
+		delete(s.operations, id)
+	}
+}
+
+// parse parses and validates the query.
+func (s *subscriptionServer) parse(query string) (*ast.Document, []gqlerrors.FormattedError) {
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(query), Name: "GraphQL request"}),
+	})
+	if err != nil {
+		return nil, gqlerrors.FormatErrors(err)
+	}
+	if result := graphql.ValidateDocument(s.h.schema, doc, nil); !result.IsValid {
+		return nil, result.Errors
+	}
+	return doc, nil
+}
+
+func (s *subscriptionServer) sendResult(id string, result *graphql.Result, codes *errorCodes) {
+	b, err := json.Marshal(result)
+	if err != nil {
+		s.sendError(id, err.Error())
+		return
+	}
+	// the errors of resolvers have the extensions which they have over HTTP.
+	if codes != nil {
+		if withExtensions, err := addErrorExtensions(b, codes); err == nil {
+			b = withExtensions
+		}
+	}
+	s.send(operationMessage{ID: id, Type: gqlData, Payload: b})
+}
+
+func (s *subscriptionServer) sendError(id, message string) {
+	b, _ := json.Marshal(map[string]string{"message": message})
+	s.send(operationMessage{ID: id, Type: gqlError, Payload: b})
+}
+
+// sendRequestError sends the error of a rejected operation with its
+// extensions.
+func (s *subscriptionServer) sendRequestError(id string, err *requestError) {
+	b, _ := json.Marshal(err)
+	s.send(operationMessage{ID: id, Type: gqlError, Payload: b})
+}
+
+func (s *subscriptionServer) send(msg operationMessage) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	s.conn.WriteMessage(b)
+}
+
+// isSubscription reports whether the operation of the document with the
+// given name, or its only operation, is a subscription.
+func isSubscription(doc *ast.Document, operationName string) bool {
+	for _, definition := range doc.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if operationName == "" || (operation.Name != nil && operation.Name.Value == operationName) {
+			return operation.Operation == ast.OperationTypeSubscription
+		}
+	}
+	return false
+}
+
+// isNullResult reports whether all the fields of the result are null.
+func isNullResult(result *graphql.Result) bool {
+	data, _ := result.Data.(map[string]interface{})
+	for _, v := range data {
+		if v != nil {
+			return false
+		}
+	}
+	return true
+}
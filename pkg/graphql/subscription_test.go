@@ -0,0 +1,359 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benkim0414/superego/pkg/profile"
+	"github.com/benkim0414/superego/pkg/service"
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/relay"
+)
+
+// wsClient is the client side of a graphql-ws connection.
+type wsClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func dialSubscriptions(t *testing.T, server *httptest.Server) *wsClient {
+	dialer := websocket.Dialer{Subprotocols: []string{subprotocol}}
+	conn, resp, err := dialer.Dial("ws://"+server.Listener.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != subprotocol {
+		t.Fatalf("got subprotocol %q, want %q", got, subprotocol)
+	}
+	return &wsClient{t: t, conn: conn}
+}
+
+// send sends the message in a text frame, which the client masks.
+func (c *wsClient) send(msg operationMessage) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := c.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// sendFrame sends a raw frame with the given first header byte, masked
+// unless unmasked is set, bypassing the checks of the client.
+func (c *wsClient) sendFrame(b0 byte, payload []byte, unmasked bool) {
+	frame := []byte{b0, byte(len(payload))}
+	if len(payload) >= 126 {
+		frame = []byte{b0, 126, byte(len(payload) >> 8), byte(len(payload))}
+	}
+	if unmasked {
+		frame = append(frame, payload...)
+	} else {
+		mask := [4]byte{1, 2, 3, 4}
+		frame[1] |= 0x80
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	}
+	if _, err := c.conn.UnderlyingConn().Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *wsClient) start(id, query string) {
+	payload, _ := json.Marshal(graphQLRequest{Query: query})
+	c.send(operationMessage{ID: id, Type: gqlStart, Payload: payload})
+}
+
+// read returns the next message other than a keep-alive.
+func (c *wsClient) read() operationMessage {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, b, err := c.conn.ReadMessage()
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		var msg operationMessage
+		if err := json.Unmarshal(b, &msg); err != nil {
+			c.t.Fatal(err)
+		}
+		if msg.Type != gqlConnectionKeepAlive {
+			return msg
+		}
+	}
+}
+
+// expect reads the next message and checks its id and type.
+func (c *wsClient) expect(id, typ string) operationMessage {
+	msg := c.read()
+	if msg.ID != id || msg.Type != typ {
+		c.t.Fatalf("got %s message %q (%s), want %s message %q", msg.Type, msg.ID, msg.Payload, typ, id)
+	}
+	return msg
+}
+
+func TestSubscriptions(t *testing.T) {
+	bus := service.NewEventBus()
+	svc := service.NewPublishingMiddleware(bus)(profile.FakeService)
+	schema, err := NewSchema(svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewHandler(&schema, Subscriptions(bus)))
+	defer server.Close()
+	c := dialSubscriptions(t, server)
+	defer c.conn.Close()
+
+	c.send(operationMessage{Type: gqlConnectionInit})
+	c.expect("", gqlConnectionAck)
+
+	c.start("1", `subscription { profileChanged(id: "`+relay.ToGlobalID("Profile", "subscribed")+`") { type id profile { email } } }`)
+	c.start("2", `subscription { profilesChanged { type } }`)
+	// operations are started in order, so the subscriptions are active once
+	// the query completes.
+	c.start("3", `{ nodes(ids: []) { id } }`)
+	c.expect("3", gqlData)
+	c.expect("3", gqlComplete)
+
+	ctx := context.Background()
+	if _, err := svc.PostProfile(ctx, &profile.Profile{ID: "unsubscribed", Email: "unsubscribed@gunwoo.org"}); err != nil {
+		t.Fatal(err)
+	}
+	defer svc.DeleteProfile(ctx, "unsubscribed")
+	msg := c.expect("2", gqlData)
+	if got, want := string(msg.Payload), `{"data":{"profilesChanged":{"type":"CREATED"}}}`; got != want {
+		t.Errorf("profilesChanged: got %s, want %s", got, want)
+	}
+	c.send(operationMessage{ID: "2", Type: gqlStop})
+	c.expect("2", gqlComplete)
+
+	if _, err := svc.PostProfile(ctx, &profile.Profile{ID: "subscribed", Email: "subscribed@gunwoo.org"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.DeleteProfile(ctx, "subscribed"); err != nil {
+		t.Fatal(err)
+	}
+	id := relay.ToGlobalID("Profile", "subscribed")
+	for _, want := range []string{
+		`{"data":{"profileChanged":{"id":"` + id + `","profile":{"email":"subscribed@gunwoo.org"},"type":"CREATED"}}}`,
+		`{"data":{"profileChanged":{"id":"` + id + `","profile":null,"type":"DELETED"}}}`,
+	} {
+		msg := c.expect("1", gqlData)
+		if got := string(msg.Payload); got != want {
+			t.Errorf("profileChanged: got %s, want %s", got, want)
+		}
+	}
+
+	// the errors of resolvers have the extensions which they have over HTTP.
+	c.start("5", `{ node(id: "`+relay.ToGlobalID("Profile", "unknown")+`") { id } }`)
+	msg = c.expect("5", gqlData)
+	if !strings.Contains(string(msg.Payload), `"code":"NOT_FOUND"`) {
+		t.Errorf("got %s, want a NOT_FOUND error", msg.Payload)
+	}
+	c.expect("5", gqlComplete)
+
+	c.start("4", `subscription { unknown }`)
+	msg = c.expect("4", gqlData)
+	if !strings.Contains(string(msg.Payload), `"errors"`) {
+		t.Errorf("got %s, want errors", msg.Payload)
+	}
+	c.expect("4", gqlComplete)
+
+	c.send(operationMessage{Type: gqlConnectionTerminate})
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := c.conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("got %v, want the connection closed", err)
+	}
+}
+
+// blockingResolver resolves identities once the context is done.
+type blockingResolver struct {
+	Resolver
+}
+
+func (r blockingResolver) ResolveIdentity(ctx context.Context, provider, subject string) (*profile.Profile, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestSubscriptionsConcurrentOperations(t *testing.T) {
+	bus := service.NewEventBus()
+	schema, err := NewSchema(blockingResolver{profile.FakeService})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewHandler(&schema, Subscriptions(bus)))
+	defer server.Close()
+	c := dialSubscriptions(t, server)
+	defer c.conn.Close()
+
+	c.send(operationMessage{Type: gqlConnectionInit})
+	c.expect("", gqlConnectionAck)
+
+	// a query which blocks does not block the other operations.
+	c.start("1", `{ profileByIdentity(provider: "github", subject: "blocked") { id } }`)
+	c.start("2", `{ nodes(ids: []) { id } }`)
+	c.expect("2", gqlData)
+	c.expect("2", gqlComplete)
+	c.send(operationMessage{ID: "1", Type: gqlStop})
+	c.expect("1", gqlComplete)
+}
+
+func TestSubscriptionsHandshake(t *testing.T) {
+	bus := service.NewEventBus()
+	schema, err := NewSchema(profile.FakeService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(&schema, Subscriptions(bus))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Connection", "keep-alive, Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d without the graphql-ws subprotocol, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestSubscriptionsOrigin(t *testing.T) {
+	bus := service.NewEventBus()
+	schema, err := NewSchema(profile.FakeService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		origins []string
+		origin  string
+		allowed bool
+	}{
+		{nil, "", true},
+		{nil, "http://example.com", true},
+		{nil, "https://evil.com", false},
+		{[]string{"https://gunwoo.org"}, "https://GUNWOO.org", true},
+		{[]string{"https://gunwoo.org"}, "http://example.com", false},
+		{[]string{"*"}, "https://evil.com", true},
+	}
+	for _, tt := range tests {
+		h := NewHandler(&schema, Subscriptions(bus), AllowedOrigins(tt.origins...))
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		r.Header.Set("Sec-WebSocket-Protocol", subprotocol)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		// the recorder can not be hijacked, which fails allowed handshakes.
+		if got := w.Code != http.StatusForbidden; got != tt.allowed {
+			t.Errorf("%v: got status %d for origin %q, want allowed %v", tt.origins, w.Code, tt.origin, tt.allowed)
+		}
+	}
+}
+
+func TestSubscriptionsProtocolErrors(t *testing.T) {
+	bus := service.NewEventBus()
+	schema, err := NewSchema(profile.FakeService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewHandler(&schema, Subscriptions(bus)))
+	defer server.Close()
+
+	const (
+		fin          = 0x80
+		rsv1         = 0x40
+		continuation = 0x0
+		text         = 0x1
+		ping         = 0x9
+	)
+	init := []byte(`{"type":"connection_init"}`)
+	tests := []struct {
+		name   string
+		frames func(c *wsClient)
+	}{
+		{"unmasked frame", func(c *wsClient) {
+			c.sendFrame(fin|text, init, true)
+		}},
+		{"reserved bit", func(c *wsClient) {
+			c.sendFrame(fin|rsv1|text, init, false)
+		}},
+		{"fragmented control frame", func(c *wsClient) {
+			c.sendFrame(ping, nil, false)
+		}},
+		{"large control frame", func(c *wsClient) {
+			c.sendFrame(fin|ping, make([]byte, 126), false)
+		}},
+		{"unexpected continuation", func(c *wsClient) {
+			c.sendFrame(fin|continuation, init, false)
+		}},
+		{"interleaved message", func(c *wsClient) {
+			c.sendFrame(text, init[:10], false)
+			c.sendFrame(fin|text, init, false)
+		}},
+	}
+	for _, tt := range tests {
+		c := dialSubscriptions(t, server)
+		tt.frames(c)
+		c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, _, err := c.conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseProtocolError) {
+			t.Errorf("%s: got %v, want a close frame with status %d", tt.name, err, websocket.CloseProtocolError)
+		}
+		c.conn.Close()
+	}
+}
+
+func TestSubscriptionsRejected(t *testing.T) {
+	bus := service.NewEventBus()
+	schema, err := NewSchema(profile.FakeService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(&schema, Subscriptions(bus), PersistedQueries(NewQueryStore(10)), QueryLimits(Limits{MaxDepth: 1}))
+	server := httptest.NewServer(h)
+	defer server.Close()
+	c := dialSubscriptions(t, server)
+	defer c.conn.Close()
+
+	c.send(operationMessage{Type: gqlConnectionInit})
+	c.expect("", gqlConnectionAck)
+
+	// the errors have the extensions which they have over HTTP.
+	type rejection struct {
+		Message    string            `json:"message"`
+		Extensions map[string]string `json:"extensions"`
+	}
+	c.start("1", `{ node(id: "x") { id } }`)
+	var result struct {
+		Errors []rejection `json:"errors"`
+	}
+	if err := json.Unmarshal(c.expect("1", gqlData).Payload, &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Extensions["code"] != profile.InvalidArgument.String() || result.Errors[0].Extensions["limit"] != "depth" {
+		t.Errorf("got errors %+v, want the depth limit exceeded", result.Errors)
+	}
+	c.expect("1", gqlComplete)
+
+	payload := []byte(`{"extensions": {"persistedQuery": {"version": 1, "sha256Hash": "` + hashQuery(`{ unknown }`) + `"}}}`)
+	c.send(operationMessage{ID: "2", Type: gqlStart, Payload: payload})
+	var e rejection
+	if err := json.Unmarshal(c.expect("2", gqlError).Payload, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Extensions["code"] != "PERSISTED_QUERY_NOT_FOUND" {
+		t.Errorf("got error %+v, want PERSISTED_QUERY_NOT_FOUND", e)
+	}
+}
@@ -0,0 +1,118 @@
+package graphql
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxMessageSize is the maximum size of a message received over a WebSocket.
+const maxMessageSize = 1 << 20
+
+// closeTimeout is the time limit of sending a close frame.
+const closeTimeout = time.Second
+
+// isWebSocketUpgrade reports whether the request asks to upgrade the
+// connection to a WebSocket.
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// headerContains reports whether the comma-separated values of the header
+// contain the token, regardless of case.
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h[http.CanonicalHeaderKey(name)] {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn is a WebSocket connection which exchanges text messages. Messages
+// can be written concurrently, but must be read by a single goroutine.
+type wsConn struct {
+	conn *websocket.Conn
+
+	mu sync.Mutex
+}
+
+// checkOrigin reports whether the origin of the request is allowed, so that
+// the pages of other sites can not open connections with the credentials of
+// their visitors. Requests without an Origin header do not come from
+// browsers and are allowed. Without allowed origins, only the origin of the
+// host of the request is allowed; "*" allows any origin.
+func checkOrigin(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(allowed) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, o := range allowed {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// upgrade completes the WebSocket handshake of the request with the given
+// subprotocol, which the client must offer, and takes over its connection.
+// It replies with an error if the handshake is invalid or if the origin of
+// the request is not allowed.
+func upgrade(w http.ResponseWriter, r *http.Request, protocol string, origins []string) (*wsConn, error) {
+	if !headerContains(r.Header, "Sec-WebSocket-Protocol", protocol) {
+		http.Error(w, "unsupported WebSocket subprotocol, want "+protocol, http.StatusBadRequest)
+		return nil, errors.New("websocket: unsupported subprotocol")
+	}
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{protocol},
+		CheckOrigin: func(r *http.Request) bool {
+			return checkOrigin(r, origins)
+		},
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the frames which violate the protocol, such as unmasked frames, close
+	// the connection with the status 1002.
+	conn.SetReadLimit(maxMessageSize)
+	return &wsConn{conn: conn}, nil
+}
+
+// ReadMessage returns the next text or binary message, answering the pings
+// received in the meantime. It returns io.EOF when the peer closes the
+// connection.
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	_, message, err := c.conn.ReadMessage()
+	if _, ok := err.(*websocket.CloseError); ok {
+		return nil, io.EOF
+	}
+	return message, err
+}
+
+// WriteMessage sends the message in a single text frame.
+func (c *wsConn) WriteMessage(message []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteMessage(websocket.TextMessage, message)
+}
+
+// Close sends a close frame and closes the connection.
+func (c *wsConn) Close() error {
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(closeTimeout))
+	return c.conn.Close()
+}
@@ -0,0 +1,76 @@
+package profile
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Config configures the backend opened by Open. Every backend uses the
+// fields it needs and ignores the others.
+type Config struct {
+	// ProjectID is the Google Cloud project of the datastore backend.
+	ProjectID string
+	// Options configure the datastore backend.
+	Options []Option
+	// Path is the file of the log of the disk backend.
+	Path string
+	// DiskOptions configure the disk backend.
+	DiskOptions []DiskOption
+	// DSN is the data source name of the database of the postgres backend.
+	DSN string
+}
+
+// Backend opens a Service which stores the profiles, along with a function
+// which releases its resources.
+type Backend func(ctx context.Context, config Config) (Service, func() error, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]Backend{}
+)
+
+// Register makes a backend available to Open by name. Backends register
+// themselves in init functions, like the "datastore", "disk", "memory" and
+// "postgres" backends of this package. It panics if the backend is nil or the
+// name is taken.
+func Register(name string, backend Backend) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if backend == nil {
+		panic("profile: Register backend is nil")
+	}
+	if _, ok := backends[name]; ok {
+		panic("profile: Register called twice for backend " + name)
+	}
+	backends[name] = backend
+}
+
+// Backends returns the sorted names of the registered backends.
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens the backend registered with the given name. The returned
+// function must be called to release the resources of the service.
+func Open(ctx context.Context, name string, config Config) (Service, func() error, error) {
+	backendsMu.RLock()
+	backend, ok := backends[name]
+	backendsMu.RUnlock()
+
+	if !ok {
+		return nil, nil, fmt.Errorf("profile: unknown backend %q (registered: %s)", name, strings.Join(Backends(), ", "))
+	}
+	return backend(ctx, config)
+}
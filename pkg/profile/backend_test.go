@@ -0,0 +1,38 @@
+package profile
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+	if got, want := Backends(), []string{"datastore", "disk", "memory", "postgres"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Backends: got %v, want %v", got, want)
+	}
+
+	s, closeService, err := Open(ctx, "memory", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeService()
+	if _, ok := s.(*MemoryService); !ok {
+		t.Errorf("Open: got %T, want *MemoryService", s)
+	}
+
+	if _, _, err := Open(ctx, "unknown", Config{}); err == nil {
+		t.Error("Open: error should not be nil for an unknown backend")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Register: should panic for a registered name")
+		}
+	}()
+	Register("memory", func(context.Context, Config) (Service, func() error, error) {
+		return nil, nil, nil
+	})
+}
@@ -0,0 +1,70 @@
+package profile_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/benkim0414/superego/internal/testutil"
+	"github.com/benkim0414/superego/pkg/profile"
+	"github.com/benkim0414/superego/pkg/profile/profiletest"
+
+	"cloud.google.com/go/datastore"
+)
+
+func TestFakeServiceConformance(t *testing.T) {
+	profiletest.Run(t, func(t *testing.T) profile.Service {
+		return profile.NewFakeService()
+	})
+}
+
+func TestMemoryServiceConformance(t *testing.T) {
+	profiletest.Run(t, func(t *testing.T) profile.Service {
+		return profile.NewMemoryService()
+	})
+}
+
+func TestDiskServiceConformance(t *testing.T) {
+	profiletest.Run(t, func(t *testing.T) profile.Service {
+		s, err := profile.OpenDiskService(filepath.Join(t.TempDir(), "superego.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestDatastoreServiceConformance(t *testing.T) {
+	profiletest.Run(t, func(t *testing.T) profile.Service {
+		tc := testutil.EmulatorTestContext(t)
+		client, err := datastore.NewClient(context.Background(), tc.ProjectID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+		// the concurrency tests contend on a single profile.
+		return profile.NewService(client, profile.Namespace(tc.Namespace), profile.MaxAttempts(10))
+	})
+}
+
+func TestPostgresServiceConformance(t *testing.T) {
+	profiletest.Run(t, func(t *testing.T) profile.Service {
+		tc := testutil.PostgresTestContext(t)
+		ctx := context.Background()
+		db, err := sql.Open(profile.PostgresDriver, tc.PostgresDSN)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if _, err := profile.NewMigrator(db).Up(ctx); err != nil {
+			t.Fatal(err)
+		}
+		s, err := profile.OpenPostgresService(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
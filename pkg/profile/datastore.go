@@ -2,102 +2,858 @@ package profile
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math/rand"
+	"time"
 
 	"cloud.google.com/go/datastore"
+	"github.com/go-kit/kit/metrics"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
 	// datastore entity kind for Profile
 	profileKind = "Profile"
+	// datastore entity kind for emailReservation
+	emailKind = "ProfileEmail"
+	// datastore entity kind for identityReservation
+	identityKind = "ProfileIdentity"
+	// datastore entity kind for profileRedirect
+	redirectKind = "ProfileRedirect"
+
+	// the datastore property which stores the identities of a Profile.
+	identitiesProperty = "Identities"
+
+	// DefaultMaxAttempts is the number of times a transaction is attempted
+	// when it fails due to contention.
+	DefaultMaxAttempts = 5
 )
 
+func init() {
+	Register("datastore", func(ctx context.Context, config Config) (Service, func() error, error) {
+		client, err := datastore.NewClient(ctx, config.ProjectID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("datastore: could not connect: %v", err)
+		}
+		return NewService(client, config.Options...), client.Close, nil
+	})
+}
+
+// transactionBackoff is the delay before the first retry of a transaction,
+// which is doubled for every subsequent retry.
+var transactionBackoff = 20 * time.Millisecond
+
+// Option configures the datastore service.
+type Option func(*datastoreService)
+
+// MaxAttempts sets the number of times a transaction is attempted when it
+// fails due to contention. It defaults to DefaultMaxAttempts.
+func MaxAttempts(attempts int) Option {
+	return func(s *datastoreService) {
+		if attempts > 0 {
+			s.maxAttempts = attempts
+		}
+	}
+}
+
+// Namespace sets the datastore namespace of the entities of the service,
+// which are isolated from the entities of other namespaces. It defaults to
+// the default namespace. The ids of profiles of other namespaces are
+// invalid.
+func Namespace(namespace string) Option {
+	return func(s *datastoreService) {
+		s.namespace = namespace
+	}
+}
+
+// TransactionRetries sets the histogram which observes how many times a
+// transaction was retried due to contention, labeled by "method".
+func TransactionRetries(h metrics.Histogram) Option {
+	return func(s *datastoreService) {
+		s.retries = h
+	}
+}
+
 type datastoreService struct {
-	client *datastore.Client
+	client      *datastore.Client
+	namespace   string
+	maxAttempts int
+	retries     metrics.Histogram
+}
+
+func newDatastoreService(client *datastore.Client, opts ...Option) Service {
+	s := &datastoreService{client: client, maxAttempts: DefaultMaxAttempts}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// emailReservation reserves an email address for a single profile. It is
+// keyed by the emailKey of the address, so that the reservation can be
+// checked and updated in the same transaction as the profile.
+type emailReservation struct {
+	Profile *datastore.Key
 }
 
-func newDatastoreService(client *datastore.Client) Service {
-	return &datastoreService{client: client}
+// identityReservation reserves an external identity for a single profile.
+// It is keyed by the identityKey of the identity.
+type identityReservation struct {
+	Profile *datastore.Key
+}
+
+// profileRedirect is the tombstone of a profile which has been merged into
+// another. It is keyed by the encoded key of the merged profile.
+type profileRedirect struct {
+	Profile *datastore.Key
+}
+
+// Load implements datastore.PropertyLoadSaver. The identities of a profile
+// are stored as a single unindexed JSON property, since datastore does not
+// support maps; they are resolved through their reservations instead.
+func (p *Profile) Load(props []datastore.Property) error {
+	p.Identities = nil
+	var rest []datastore.Property
+	for _, prop := range props {
+		if prop.Name != identitiesProperty {
+			rest = append(rest, prop)
+			continue
+		}
+		s, _ := prop.Value.(string)
+		if err := json.Unmarshal([]byte(s), &p.Identities); err != nil {
+			return err
+		}
+	}
+	return datastore.LoadStruct(p, rest)
+}
+
+// Save implements datastore.PropertyLoadSaver.
+func (p *Profile) Save() ([]datastore.Property, error) {
+	props, err := datastore.SaveStruct(p)
+	if err != nil || len(p.Identities) == 0 {
+		return props, err
+	}
+	b, err := json.Marshal(p.Identities)
+	if err != nil {
+		return nil, err
+	}
+	return append(props, datastore.Property{
+		Name:    identitiesProperty,
+		Value:   string(b),
+		NoIndex: true,
+	}), nil
 }
 
 func (s *datastoreService) PostProfile(ctx context.Context, p *Profile) (*Profile, error) {
-	key := datastore.IncompleteKey(profileKind, nil)
-	key, err := s.client.Put(ctx, key, p)
+	// identities can only be linked to an existing profile.
+	p.Identities = nil
+	// the key is allocated up front, since the reservation of the email
+	// refers to it in the same transaction.
+	incomplete := datastore.IncompleteKey(profileKind, nil)
+	incomplete.Namespace = s.namespace
+	keys, err := s.client.AllocateIDs(ctx, []*datastore.Key{incomplete})
 	if err != nil {
-		return nil, fmt.Errorf("datastore: could not put Profile: %v", err)
+		return nil, datastoreError(err, "could not allocate Profile id")
+	}
+	key := keys[0]
+	p.Revision = 1
+	p.UpdateTime = time.Now().UTC()
+	err = s.runInTransaction(ctx, "PostProfile", func(tx *datastore.Transaction) error {
+		if err := reserveEmail(tx, key, "", p.Email); err != nil {
+			return err
+		}
+		if _, err := tx.Put(key, p); err != nil {
+			return datastoreError(err, "could not put Profile")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	p.ID = key.Encode()
 	return p, nil
 }
 
 func (s *datastoreService) GetProfile(ctx context.Context, id string) (*Profile, error) {
-	key, err := datastore.DecodeKey(id)
+	key, err := s.decodeKey(id)
 	if err != nil {
-		return nil, fmt.Errorf("datastore: invalid Profile id: %v", err)
+		return nil, err
 	}
-	profile := &Profile{}
-	err = s.client.Get(ctx, key, profile)
-	if err == datastore.ErrNoSuchEntity {
-		return nil, fmt.Errorf("datastore: could not get Profile: %v", err)
+	for redirects := 0; ; redirects++ {
+		profile := &Profile{}
+		err := s.client.Get(ctx, key, profile)
+		if err == nil {
+			profile.ID = key.Encode()
+			return profile, nil
+		}
+		if err != datastore.ErrNoSuchEntity || redirects == maxRedirects {
+			return nil, datastoreError(err, "could not get Profile")
+		}
+		r := &profileRedirect{}
+		if err := s.client.Get(ctx, redirectKey(key), r); err == datastore.ErrNoSuchEntity {
+			return nil, datastoreError(err, "could not get Profile")
+		} else if err != nil {
+			return nil, datastoreError(err, "could not get Profile redirect")
+		}
+		key = r.Profile
 	}
-	profile.ID = id
-	return profile, nil
+}
+
+// GetProfiles looks up all of the profiles with GetMulti, and then the
+// redirects of the missing ones, level by level, so that a batch takes at
+// most maxRedirects+1 round trips regardless of its size.
+func (s *datastoreService) GetProfiles(ctx context.Context, ids []string) ([]*Profile, error) {
+	profiles := make([]*Profile, len(ids))
+	// keys holds the key which is looked up next for every pending id.
+	keys := make([]*datastore.Key, len(ids))
+	var pending []int
+	for i, id := range ids {
+		key, err := s.decodeKey(id)
+		if err != nil {
+			continue
+		}
+		keys[i] = key
+		pending = append(pending, i)
+	}
+	for redirects := 0; len(pending) > 0; redirects++ {
+		batch := make([]*datastore.Key, len(pending))
+		dst := make([]*Profile, len(pending))
+		for j, i := range pending {
+			batch[j], dst[j] = keys[i], &Profile{}
+		}
+		found, err := s.getMulti(ctx, batch, dst)
+		if err != nil {
+			return nil, datastoreError(err, "could not get Profiles")
+		}
+		var missing []int
+		for j, i := range pending {
+			if found[j] {
+				dst[j].ID = keys[i].Encode()
+				profiles[i] = dst[j]
+			} else {
+				missing = append(missing, i)
+			}
+		}
+		if len(missing) == 0 || redirects == maxRedirects {
+			break
+		}
+
+		batch = make([]*datastore.Key, len(missing))
+		redirectDst := make([]*profileRedirect, len(missing))
+		for j, i := range missing {
+			batch[j], redirectDst[j] = redirectKey(keys[i]), &profileRedirect{}
+		}
+		found, err = s.getMulti(ctx, batch, redirectDst)
+		if err != nil {
+			return nil, datastoreError(err, "could not get Profile redirects")
+		}
+		pending = nil
+		for j, i := range missing {
+			if found[j] {
+				keys[i] = redirectDst[j].Profile
+				pending = append(pending, i)
+			}
+		}
+	}
+	return profiles, nil
+}
+
+// getMulti looks up the entities of the keys into dst, and reports which of
+// them were found. A missing entity is not an error.
+func (s *datastoreService) getMulti(ctx context.Context, keys []*datastore.Key, dst interface{}) ([]bool, error) {
+	found := make([]bool, len(keys))
+	err := s.client.GetMulti(ctx, keys, dst)
+	if me, ok := err.(datastore.MultiError); ok {
+		for j, err := range me {
+			switch err {
+			case nil:
+				found[j] = true
+			case datastore.ErrNoSuchEntity:
+			default:
+				return nil, err
+			}
+		}
+		return found, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for j := range found {
+		found[j] = true
+	}
+	return found, nil
 }
 
 func (s *datastoreService) PutProfile(ctx context.Context, id string, p *Profile) (*Profile, error) {
-	key, err := datastore.DecodeKey(id)
+	key, err := s.decodeKey(id)
 	if err != nil {
-		return nil, fmt.Errorf("datastore: invalid Profile id: %v", err)
+		return nil, err
 	}
-	_, err = s.client.Put(ctx, key, p)
+	err = s.runInTransaction(ctx, "PutProfile", func(tx *datastore.Transaction) error {
+		// PUT creates the profile if it does not exist yet, whose revision
+		// is zero.
+		existing := &Profile{}
+		err := tx.Get(key, existing)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return datastoreError(err, "could not get Profile")
+		}
+		if err := checkRevision(ctx, existing.Revision); err != nil {
+			return err
+		}
+		if err := reserveEmail(tx, key, existing.Email, p.Email); err != nil {
+			return err
+		}
+		p.Identities = existing.Identities
+		p.Revision = existing.Revision + 1
+		p.UpdateTime = time.Now().UTC()
+		if _, err := tx.Put(key, p); err != nil {
+			return datastoreError(err, "could not put Profile")
+		}
+		// a profile put at the id of a merged profile replaces its redirect.
+		if err := tx.Delete(redirectKey(key)); err != nil {
+			return datastoreError(err, "could not delete Profile redirect")
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("datastore: could not put Profile: %v", err)
+		return nil, err
 	}
+	p.ID = id
 	return p, nil
 }
 
-func (s *datastoreService) PatchProfile(ctx context.Context, id string, p *Profile) (*Profile, error) {
-	key, err := datastore.DecodeKey(id)
+func (s *datastoreService) PatchProfile(ctx context.Context, id string, patch Patch) (*Profile, error) {
+	key, err := s.decodeKey(id)
 	if err != nil {
-		return nil, fmt.Errorf("datastore: invalid Profile id: %v", err)
+		return nil, err
 	}
-	profile := &Profile{}
-	err = s.client.Get(ctx, key, profile)
-	if err == datastore.ErrNoSuchEntity {
-		return nil, fmt.Errorf("datastore: could not get Profile: %v", err)
+	var profile *Profile
+	err = s.runInTransaction(ctx, "PatchProfile", func(tx *datastore.Transaction) error {
+		// the function may be retried, so the profile is loaded afresh on
+		// every attempt rather than merged into the result of a failed one.
+		profile = &Profile{}
+		if err := tx.Get(key, profile); err != nil {
+			return datastoreError(err, "could not get Profile")
+		}
+		if err := checkRevision(ctx, profile.Revision); err != nil {
+			return err
+		}
+		email := profile.Email
+		if err := patch.Apply(profile); err != nil {
+			return err
+		}
+		if err := reserveEmail(tx, key, email, profile.Email); err != nil {
+			return err
+		}
+		profile.Revision++
+		profile.UpdateTime = time.Now().UTC()
+
+		if _, err := tx.Put(key, profile); err != nil {
+			return datastoreError(err, "could not put Profile")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	profile.ID = id
+	return profile, nil
+}
 
-	// assume that it's not possible to PATCH the ID, and that it's not
-	// possible to PATCH any field to its zero value. That is, the zero value
-	// means not specified.
-	if p.DisplayName != "" {
-		profile.DisplayName = p.DisplayName
+func (s *datastoreService) DeleteProfile(ctx context.Context, id string) error {
+	key, err := s.decodeKey(id)
+	if err != nil {
+		return err
 	}
-	if p.Email != "" {
-		profile.Email = p.Email
+	return s.runInTransaction(ctx, "DeleteProfile", func(tx *datastore.Transaction) error {
+		profile := &Profile{}
+		if err := tx.Get(key, profile); err != nil {
+			return datastoreError(err, "could not get Profile")
+		}
+		if err := checkRevision(ctx, profile.Revision); err != nil {
+			return err
+		}
+		if err := reserveEmail(tx, key, profile.Email, ""); err != nil {
+			return err
+		}
+		for _, identity := range profile.Identities {
+			if err := tx.Delete(identityReservationKey(s.namespace, identity.Provider, identity.Subject)); err != nil {
+				return datastoreError(err, "could not delete identity reservation")
+			}
+		}
+		if err := tx.Delete(key); err != nil {
+			return datastoreError(err, "could not delete Profile")
+		}
+		return nil
+	})
+}
+
+func (s *datastoreService) LookupProfile(ctx context.Context, email string) (*Profile, error) {
+	if emailKey(email) == "" {
+		return nil, Errorf(InvalidArgument, "datastore: empty email")
 	}
-	if p.ImageURL != "" {
-		profile.ImageURL = p.ImageURL
+	r := &emailReservation{}
+	if err := s.client.Get(ctx, emailReservationKey(s.namespace, email), r); err != nil {
+		return nil, datastoreError(err, "could not get email reservation")
 	}
-	if p.AboutMe != "" {
-		profile.AboutMe = p.AboutMe
+	return s.GetProfile(ctx, r.Profile.Encode())
+}
+
+func (s *datastoreService) LinkIdentity(ctx context.Context, id string, identity Identity) (*Profile, error) {
+	if err := validateIdentity(identity.Provider, identity.Subject); err != nil {
+		return nil, err
+	}
+	return s.updateIdentities(ctx, "LinkIdentity", id, func(tx *datastore.Transaction, key *datastore.Key, p *Profile) error {
+		reservationKey := identityReservationKey(s.namespace, identity.Provider, identity.Subject)
+		r := &identityReservation{}
+		err := tx.Get(reservationKey, r)
+		if err == nil && !r.Profile.Equal(key) {
+			return identityInUse(r.Profile.Encode())
+		}
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return datastoreError(err, "could not get identity reservation")
+		}
+		if _, err := tx.Put(reservationKey, &identityReservation{Profile: key}); err != nil {
+			return datastoreError(err, "could not put identity reservation")
+		}
+		p.linkIdentity(identity)
+		return nil
+	})
+}
+
+func (s *datastoreService) UnlinkIdentity(ctx context.Context, id string, provider, subject string) (*Profile, error) {
+	return s.updateIdentities(ctx, "UnlinkIdentity", id, func(tx *datastore.Transaction, key *datastore.Key, p *Profile) error {
+		if err := p.unlinkIdentity(provider, subject); err != nil {
+			return err
+		}
+		if err := tx.Delete(identityReservationKey(s.namespace, provider, subject)); err != nil {
+			return datastoreError(err, "could not delete identity reservation")
+		}
+		return nil
+	})
+}
+
+func (s *datastoreService) ResolveIdentity(ctx context.Context, provider, subject string) (*Profile, error) {
+	if err := validateIdentity(provider, subject); err != nil {
+		return nil, err
+	}
+	r := &identityReservation{}
+	if err := s.client.Get(ctx, identityReservationKey(s.namespace, provider, subject), r); err != nil {
+		return nil, datastoreError(err, "could not get identity reservation")
 	}
+	return s.GetProfile(ctx, r.Profile.Encode())
+}
 
-	_, err = s.client.Put(ctx, key, profile)
+// updateIdentities runs f on the profile with the given id in a transaction,
+// and stores the profile with the next revision if f succeeds.
+func (s *datastoreService) updateIdentities(ctx context.Context, method, id string, f func(tx *datastore.Transaction, key *datastore.Key, p *Profile) error) (*Profile, error) {
+	key, err := s.decodeKey(id)
 	if err != nil {
-		return nil, fmt.Errorf("datastore: could not put Profile: %v", err)
+		return nil, err
 	}
+	var profile *Profile
+	err = s.runInTransaction(ctx, method, func(tx *datastore.Transaction) error {
+		profile = &Profile{}
+		if err := tx.Get(key, profile); err != nil {
+			return datastoreError(err, "could not get Profile")
+		}
+		if err := checkRevision(ctx, profile.Revision); err != nil {
+			return err
+		}
+		if err := f(tx, key, profile); err != nil {
+			return err
+		}
+		profile.Revision++
+		profile.UpdateTime = time.Now().UTC()
+		if _, err := tx.Put(key, profile); err != nil {
+			return datastoreError(err, "could not put Profile")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	profile.ID = id
 	return profile, nil
 }
 
-func (s *datastoreService) DeleteProfile(ctx context.Context, id string) error {
-	key, err := datastore.DecodeKey(id)
+func (s *datastoreService) MergeProfiles(ctx context.Context, survivorID, victimID string, strategy MergeStrategy) (*Profile, error) {
+	if err := validateMerge(survivorID, victimID); err != nil {
+		return nil, err
+	}
+	survivorKey, err := s.decodeKey(survivorID)
 	if err != nil {
-		return fmt.Errorf("datastore: invalid Profile id: %v", err)
+		return nil, err
 	}
-	err = s.client.Delete(ctx, key)
+	victimKey, err := s.decodeKey(victimID)
+	if err != nil {
+		return nil, err
+	}
+	var merged *Profile
+	err = s.runInTransaction(ctx, "MergeProfiles", func(tx *datastore.Transaction) error {
+		survivor, victim := &Profile{}, &Profile{}
+		if err := tx.Get(survivorKey, survivor); err != nil {
+			return datastoreError(err, "could not get surviving Profile")
+		}
+		if err := tx.Get(victimKey, victim); err != nil {
+			return datastoreError(err, "could not get merged Profile")
+		}
+		if err := checkRevision(ctx, survivor.Revision); err != nil {
+			return err
+		}
+		var err error
+		merged, err = strategy.merge(survivor, victim)
+		if err != nil {
+			return err
+		}
+
+		// reads in a transaction do not observe its writes, so the
+		// reservation of the email of the victim is moved to the survivor
+		// directly rather than released and reserved again.
+		if emailKey(victim.Email) != "" && emailKey(victim.Email) == emailKey(merged.Email) {
+			reservationKey := emailReservationKey(s.namespace, merged.Email)
+			if _, err := tx.Put(reservationKey, &emailReservation{Profile: survivorKey}); err != nil {
+				return datastoreError(err, "could not put email reservation")
+			}
+			err = reserveEmail(tx, survivorKey, survivor.Email, "")
+		} else if err = reserveEmail(tx, victimKey, victim.Email, ""); err == nil {
+			err = reserveEmail(tx, survivorKey, survivor.Email, merged.Email)
+		}
+		if err != nil {
+			return err
+		}
+		for _, identity := range victim.Identities {
+			reservationKey := identityReservationKey(s.namespace, identity.Provider, identity.Subject)
+			if _, err := tx.Put(reservationKey, &identityReservation{Profile: survivorKey}); err != nil {
+				return datastoreError(err, "could not put identity reservation")
+			}
+		}
+
+		merged.Revision++
+		merged.UpdateTime = time.Now().UTC()
+		if _, err := tx.Put(survivorKey, merged); err != nil {
+			return datastoreError(err, "could not put Profile")
+		}
+		if err := tx.Delete(victimKey); err != nil {
+			return datastoreError(err, "could not delete Profile")
+		}
+		if _, err := tx.Put(redirectKey(victimKey), &profileRedirect{Profile: survivorKey}); err != nil {
+			return datastoreError(err, "could not put Profile redirect")
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("datastore: could not delete Profile: %v", err)
+		return nil, err
+	}
+	merged.ID = survivorID
+	return merged, nil
+}
+
+func (s *datastoreService) ListProfiles(ctx context.Context, opts ListOptions) ([]*Profile, string, error) {
+	return s.query(ctx, nil, opts)
+}
+
+func (s *datastoreService) SearchProfiles(ctx context.Context, query SearchQuery, opts ListOptions) ([]*Profile, string, error) {
+	filters, err := query.filters()
+	if err != nil {
+		return nil, "", err
+	}
+	if opts, err = searchOptions(filters, opts); err != nil {
+		return nil, "", err
+	}
+	for _, f := range filters {
+		if f.emailKey {
+			return s.searchEmail(ctx, f.value, filters, opts)
+		}
+	}
+	return s.query(ctx, filters, opts)
+}
+
+// searchEmail returns the profile which reserves the email address if it
+// matches all of the filters, since the Email property holds the address as
+// it was written rather than its emailKey. At most one profile matches, so
+// there is no next page.
+func (s *datastoreService) searchEmail(ctx context.Context, email string, filters []searchFilter, opts ListOptions) ([]*Profile, string, error) {
+	if _, err := opts.pageSize(); err != nil {
+		return nil, "", err
+	}
+	if _, _, err := parseOrderBy(opts.OrderBy); err != nil {
+		return nil, "", err
+	}
+	profiles := []*Profile{}
+	r := &emailReservation{}
+	err := s.client.Get(ctx, emailReservationKey(s.namespace, email), r)
+	if err == datastore.ErrNoSuchEntity {
+		return profiles, "", nil
+	}
+	if err != nil {
+		return nil, "", datastoreError(err, "could not get email reservation")
+	}
+	p := &Profile{}
+	err = s.client.Get(ctx, r.Profile, p)
+	if err == datastore.ErrNoSuchEntity {
+		return profiles, "", nil
+	}
+	if err != nil {
+		return nil, "", datastoreError(err, "could not get Profile")
+	}
+	p.ID = r.Profile.Encode()
+	if match(filters, p) {
+		profiles = append(profiles, p)
+	}
+	return profiles, "", nil
+}
+
+// query runs a Profile query with the given filters and returns a page of
+// the results along with the cursor to the next page.
+func (s *datastoreService) query(ctx context.Context, filters []searchFilter, opts ListOptions) ([]*Profile, string, error) {
+	pageSize, err := opts.pageSize()
+	if err != nil {
+		return nil, "", err
+	}
+	field, desc, err := parseOrderBy(opts.OrderBy)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// searchOptions orders the profiles by the field of a prefix filter, since
+	// Datastore requires the first sort order to be on the property of an
+	// inequality filter.
+	q := datastore.NewQuery(profileKind).Namespace(s.namespace).Limit(pageSize)
+	for _, f := range filters {
+		if !f.prefix {
+			q = q.Filter(f.field.property+" =", f.value)
+			continue
+		}
+		// a prefix is matched by the range [prefix, prefix+U+10FFFF), since
+		// strings are ordered by their UTF-8 encoding.
+		q = q.Filter(f.field.property+" >=", f.value).
+			Filter(f.field.property+" <", f.value+"\U0010FFFF")
+	}
+	if field.property != "" {
+		if desc {
+			q = q.Order("-" + field.property)
+		} else {
+			q = q.Order(field.property)
+		}
+	}
+	if opts.PageToken != "" {
+		cursor, err := datastore.DecodeCursor(opts.PageToken)
+		if err != nil {
+			return nil, "", Errorf(InvalidArgument, "datastore: invalid page token %q", opts.PageToken)
+		}
+		q = q.Start(cursor)
+	}
+
+	profiles := []*Profile{}
+	it := s.client.Run(ctx, q)
+	for {
+		profile := &Profile{}
+		key, err := it.Next(profile)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, "", datastoreError(err, "could not query Profiles")
+		}
+		profile.ID = key.Encode()
+		profiles = append(profiles, profile)
+	}
+
+	// a short page means that the query has been exhausted.
+	if len(profiles) < pageSize {
+		return profiles, "", nil
+	}
+	cursor, err := it.Cursor()
+	if err != nil {
+		return nil, "", datastoreError(err, "could not get cursor")
+	}
+	return profiles, cursor.String(), nil
+}
+
+// runInTransaction runs f in a datastore transaction, which is retried with
+// exponential backoff up to maxAttempts times if it fails due to contention.
+// The number of retries is observed by the retries histogram of the method.
+// Errors returned by f are returned unchanged, so f should return an *Error.
+func (s *datastoreService) runInTransaction(ctx context.Context, method string, f func(tx *datastore.Transaction) error) error {
+	return s.retry(ctx, method, func() error {
+		_, err := s.client.RunInTransaction(ctx, f, datastore.MaxAttempts(1))
+		return err
+	})
+}
+
+// retry runs a single attempt of a transaction until it succeeds, fails for
+// another reason than contention, or has been attempted maxAttempts times.
+func (s *datastoreService) retry(ctx context.Context, method string, attempt func() error) error {
+	var retries int
+	defer func() {
+		if s.retries != nil {
+			s.retries.With("method", method).Observe(float64(retries))
+		}
+	}()
+
+	backoff := transactionBackoff
+	for {
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+		if !contention(err) || retries+1 >= s.maxAttempts {
+			if _, ok := err.(*Error); ok {
+				return err
+			}
+			return datastoreError(err, "could not run transaction")
+		}
+		retries++
+
+		// jitter spreads out the retries of concurrent transactions which
+		// failed at the same time.
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return &Error{Code: Unavailable, Message: "datastore: could not run transaction", Err: ctx.Err()}
+		}
+		backoff *= 2
+	}
+}
+
+// contention reports whether a transaction failed due to contention with
+// another transaction, either when it was committed or when it read an
+// entity, in which case the error of the read is wrapped in an *Error.
+func contention(err error) bool {
+	if e, ok := err.(*Error); ok {
+		err = e.Err
+	}
+	if err == datastore.ErrConcurrentTransaction {
+		return true
+	}
+	s, ok := status.FromError(err)
+	return ok && s.Code() == codes.Aborted
+}
+
+// reserveEmail moves the reservation of the profile with the given key from
+// one email address to another in the transaction. It fails with a Conflict
+// error if the address is reserved by another profile.
+// Empty addresses are not reserved.
+func reserveEmail(tx *datastore.Transaction, key *datastore.Key, from, to string) error {
+	if emailKey(from) == emailKey(to) {
+		return nil
+	}
+	if emailKey(to) != "" {
+		reservationKey := emailReservationKey(key.Namespace, to)
+		r := &emailReservation{}
+		err := tx.Get(reservationKey, r)
+		if err == nil && !r.Profile.Equal(key) {
+			return emailInUse(r.Profile.Encode())
+		}
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return datastoreError(err, "could not get email reservation")
+		}
+		if _, err := tx.Put(reservationKey, &emailReservation{Profile: key}); err != nil {
+			return datastoreError(err, "could not put email reservation")
+		}
+	}
+	if emailKey(from) != "" {
+		reservationKey := emailReservationKey(key.Namespace, from)
+		r := &emailReservation{}
+		err := tx.Get(reservationKey, r)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return datastoreError(err, "could not get email reservation")
+		}
+		// the reservation is only released by its owner.
+		if err == nil && r.Profile.Equal(key) {
+			if err := tx.Delete(reservationKey); err != nil {
+				return datastoreError(err, "could not delete email reservation")
+			}
+		}
 	}
 	return nil
 }
+
+// emailReservationKey returns the key of the reservation of an email
+// address in the namespace.
+func emailReservationKey(namespace, email string) *datastore.Key {
+	key := datastore.NameKey(emailKind, emailKey(email), nil)
+	key.Namespace = namespace
+	return key
+}
+
+// identityReservationKey returns the key of the reservation of an identity
+// in the namespace.
+func identityReservationKey(namespace, provider, subject string) *datastore.Key {
+	key := datastore.NameKey(identityKind, identityKey(provider, subject), nil)
+	key.Namespace = namespace
+	return key
+}
+
+// redirectKey returns the key of the redirect of a merged profile, which is
+// in the namespace of the profile.
+func redirectKey(key *datastore.Key) *datastore.Key {
+	redirect := datastore.NameKey(redirectKind, key.Encode(), nil)
+	redirect.Namespace = key.Namespace
+	return redirect
+}
+
+// decodeKey decodes the id of a Profile of the namespace of the service.
+func (s *datastoreService) decodeKey(id string) (*datastore.Key, error) {
+	key, err := decodeKey(id)
+	if err != nil {
+		return nil, err
+	}
+	if key.Namespace != s.namespace {
+		return nil, Errorf(InvalidArgument, "datastore: invalid Profile id: namespace %q", key.Namespace)
+	}
+	return key, nil
+}
+
+// decodeKey decodes the id of a Profile into its datastore key.
+func decodeKey(id string) (*datastore.Key, error) {
+	key, err := datastore.DecodeKey(id)
+	if err != nil {
+		return nil, &Error{Code: InvalidArgument, Message: "datastore: invalid Profile id", Err: err}
+	}
+	if key.Kind != profileKind {
+		return nil, Errorf(InvalidArgument, "datastore: invalid Profile id: kind %q", key.Kind)
+	}
+	return key, nil
+}
+
+// datastoreError wraps an error returned by the datastore client into an
+// *Error with the code corresponding to the cause.
+func datastoreError(err error, message string) error {
+	return &Error{Code: datastoreCode(err), Message: "datastore: " + message, Err: err}
+}
+
+// datastoreCode returns the Code corresponding to an error returned by the
+// datastore client.
+func datastoreCode(err error) Code {
+	switch err {
+	case datastore.ErrNoSuchEntity:
+		return NotFound
+	case datastore.ErrInvalidKey:
+		return InvalidArgument
+	case datastore.ErrConcurrentTransaction:
+		return Conflict
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return Unknown
+	}
+	switch s.Code() {
+	case codes.NotFound:
+		return NotFound
+	case codes.InvalidArgument, codes.OutOfRange:
+		return InvalidArgument
+	case codes.AlreadyExists, codes.Aborted:
+		return Conflict
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return PermissionDenied
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return Unavailable
+	}
+	return Unknown
+}
@@ -0,0 +1,119 @@
+package profile
+
+import (
+	"context"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// EmailConflict is an email address which is used by more than one profile
+// of the datastore, such as profiles written before email addresses were
+// reserved. Only the profile holding the reservation of the address is
+// returned by LookupProfile; the others are best reviewed with the
+// duplicates subcommand and merged with MergeProfiles.
+type EmailConflict struct {
+	// The email address, as it is compared.
+	Email string
+	// The id of the profile which reserves the address.
+	ReservedBy string
+	// The ids of the other profiles using the address.
+	ProfileIDs []string
+}
+
+// BackfillEmailReservations reserves the email addresses of the profiles of
+// the datastore which have none, such as the profiles written before email
+// addresses were reserved, and returns the number of reservations created
+// along with the addresses used by more than one profile. An address is
+// reserved by the first of its profiles in key order which still uses it.
+// Reservations which exist are kept, so it is safe to run while the service
+// is serving and to run again.
+func BackfillEmailReservations(ctx context.Context, client *datastore.Client, opts ...Option) (int, []EmailConflict, error) {
+	s := newDatastoreService(client, opts...).(*datastoreService)
+
+	var emails []string
+	profiles := map[string][]*datastore.Key{}
+	it := client.Run(ctx, datastore.NewQuery(profileKind).Namespace(s.namespace))
+	for {
+		p := &Profile{}
+		key, err := it.Next(p)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return 0, nil, datastoreError(err, "could not query Profiles")
+		}
+		email := emailKey(p.Email)
+		if email == "" {
+			continue
+		}
+		if _, ok := profiles[email]; !ok {
+			emails = append(emails, email)
+		}
+		profiles[email] = append(profiles[email], key)
+	}
+
+	reserved := 0
+	var conflicts []EmailConflict
+	for _, email := range emails {
+		keys := profiles[email]
+		var owner *datastore.Key
+		var created bool
+		err := s.runInTransaction(ctx, "BackfillEmailReservations", func(tx *datastore.Transaction) error {
+			owner, created = nil, false
+			reservationKey := emailReservationKey(s.namespace, email)
+			r := &emailReservation{}
+			err := tx.Get(reservationKey, r)
+			if err == nil {
+				owner = r.Profile
+				return nil
+			}
+			if err != datastore.ErrNoSuchEntity {
+				return datastoreError(err, "could not get email reservation")
+			}
+			// the profiles may have been updated or deleted since the query.
+			for _, key := range keys {
+				p := &Profile{}
+				err := tx.Get(key, p)
+				if err == datastore.ErrNoSuchEntity {
+					continue
+				}
+				if err != nil {
+					return datastoreError(err, "could not get Profile")
+				}
+				if emailKey(p.Email) == email {
+					owner = key
+					break
+				}
+			}
+			if owner == nil {
+				return nil
+			}
+			if _, err := tx.Put(reservationKey, &emailReservation{Profile: owner}); err != nil {
+				return datastoreError(err, "could not put email reservation")
+			}
+			created = true
+			return nil
+		})
+		if err != nil {
+			return reserved, conflicts, err
+		}
+		if created {
+			reserved++
+		}
+		if owner == nil {
+			continue
+		}
+
+		var others []string
+		for _, key := range keys {
+			if !key.Equal(owner) {
+				others = append(others, key.Encode())
+			}
+		}
+		if len(others) > 0 {
+			conflicts = append(conflicts, EmailConflict{Email: email, ReservedBy: owner.Encode(), ProfileIDs: others})
+		}
+	}
+	return reserved, conflicts, nil
+}
@@ -2,15 +2,21 @@ package profile
 
 import (
 	"context"
+	"errors"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/benkim0414/superego/internal/testutil"
 
 	"cloud.google.com/go/datastore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestDatastoreService(t *testing.T) {
-	tc := testutil.SystemTestContext(t)
+	tc := testutil.EmulatorTestContext(t)
 	ctx := context.Background()
 
 	client, err := datastore.NewClient(
@@ -22,7 +28,7 @@ func TestDatastoreService(t *testing.T) {
 	}
 	defer client.Close()
 
-	s := newDatastoreService(client)
+	s := newDatastoreService(client, Namespace(tc.Namespace))
 
 	p := &Profile{
 		Email: "gunwoo@gunwoo.org",
@@ -63,8 +69,377 @@ func TestDatastoreService(t *testing.T) {
 		t.Errorf("PatchProfile: got %q, want %q", got.Email, p.Email)
 	}
 
+	profiles, _, err := s.ListProfiles(ctx, ListOptions{OrderBy: "email"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) == 0 {
+		t.Errorf("ListProfiles: got no profiles, want at least one")
+	}
+
 	err = s.DeleteProfile(ctx, p.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
+
+func TestDatastorePatchProfileConcurrently(t *testing.T) {
+	tc := testutil.EmulatorTestContext(t)
+	ctx := context.Background()
+
+	client, err := datastore.NewClient(ctx, tc.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	patches := []*Profile{
+		{DisplayName: "Gunwoo Kim"},
+		{ImageURL: "https://gunwoo.org/gunwoo.png"},
+		{AboutMe: "superego"},
+		{Email: "ben.kim@greenenergytrading.com.au"},
+	}
+	s := newDatastoreService(client, Namespace(tc.Namespace), MaxAttempts(len(patches)*2))
+
+	p, err := s.PostProfile(ctx, &Profile{Email: "gunwoo@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.DeleteProfile(ctx, p.ID)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(patches))
+	for _, patch := range patches {
+		wg.Add(1)
+		go func(patch *Profile) {
+			defer wg.Done()
+			if _, err := s.PatchProfile(ctx, p.ID, patch); err != nil {
+				errs <- err
+			}
+		}(patch)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("PatchProfile: %v", err)
+	}
+
+	got, err := s.GetProfile(ctx, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Profile{
+		ID:          p.ID,
+		DisplayName: "Gunwoo Kim",
+		Email:       "ben.kim@greenenergytrading.com.au",
+		ImageURL:    "https://gunwoo.org/gunwoo.png",
+		AboutMe:     "superego",
+		Revision:    int64(1 + len(patches)),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetProfile: got %+v, want %+v", got, want)
+	}
+}
+
+func TestDatastoreEmailUniqueness(t *testing.T) {
+	tc := testutil.EmulatorTestContext(t)
+	ctx := context.Background()
+
+	client, err := datastore.NewClient(ctx, tc.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	s := newDatastoreService(client, Namespace(tc.Namespace))
+	p, err := s.PostProfile(ctx, &Profile{Email: "unique@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.DeleteProfile(ctx, p.ID)
+
+	got, err := s.LookupProfile(ctx, "Unique@Gunwoo.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != p.ID {
+		t.Errorf("LookupProfile: got %v, want %v", got, p)
+	}
+
+	_, err = s.PostProfile(ctx, &Profile{Email: "UNIQUE@gunwoo.org"})
+	if e, ok := err.(*Error); !ok || e.Code != Conflict || e.ExistingID != p.ID {
+		t.Errorf("PostProfile: got %v, want a conflict with %q", err, p.ID)
+	}
+
+	other, err := s.PostProfile(ctx, &Profile{Email: "other@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PatchProfile(ctx, other.ID, MergePatch{"email": p.Email}); ErrorCode(err) != Conflict {
+		t.Errorf("PatchProfile: got %v, want %v", err, Conflict)
+	}
+
+	// deleting a profile releases its email address.
+	if err := s.DeleteProfile(ctx, other.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LookupProfile(ctx, "other@gunwoo.org"); ErrorCode(err) != NotFound {
+		t.Errorf("LookupProfile: got %v, want %v", err, NotFound)
+	}
+}
+
+func TestDatastoreCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want Code
+	}{
+		{datastore.ErrNoSuchEntity, NotFound},
+		{datastore.ErrInvalidKey, InvalidArgument},
+		{datastore.ErrConcurrentTransaction, Conflict},
+		{status.Error(codes.PermissionDenied, ""), PermissionDenied},
+		{status.Error(codes.Unavailable, ""), Unavailable},
+		{status.Error(codes.Internal, ""), Unknown},
+		{errors.New(""), Unknown},
+	}
+	for _, tt := range tests {
+		if got := datastoreCode(tt.err); got != tt.want {
+			t.Errorf("datastoreCode(%v): got %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDatastoreRetry(t *testing.T) {
+	ctx := context.Background()
+	s := &datastoreService{maxAttempts: 3}
+	defer func(backoff time.Duration) { transactionBackoff = backoff }(transactionBackoff)
+	transactionBackoff = time.Millisecond
+
+	// a read aborted by contention fails the body of the transaction with the
+	// wrapped error of the read, as tx.Get is wrapped by datastoreError.
+	aborted := datastoreError(status.Error(codes.Aborted, "too much contention"), "could not get Profile")
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		// the code of the error, if the transaction fails.
+		want Code
+	}{
+		{"aborted read", []error{aborted, aborted, nil}, 3, -1},
+		{"concurrent commit", []error{datastore.ErrConcurrentTransaction, nil}, 2, -1},
+		{"too many attempts", []error{aborted, aborted, aborted, nil}, 3, Conflict},
+		{"conflict", []error{emailInUse("gunwoo"), nil}, 1, Conflict},
+		{"unavailable", []error{status.Error(codes.Unavailable, ""), nil}, 1, Unavailable},
+	}
+	for _, tt := range tests {
+		attempts := 0
+		err := s.retry(ctx, "Test", func() error {
+			err := tt.errs[attempts]
+			attempts++
+			return err
+		})
+		if attempts != tt.attempts || (err == nil) != (tt.want < 0) || err != nil && ErrorCode(err) != tt.want {
+			t.Errorf("%s: got %v after %d attempts, want %v after %d", tt.name, err, attempts, tt.want, tt.attempts)
+		}
+	}
+}
+
+func TestDecodeKey(t *testing.T) {
+	_, err := decodeKey("invalid")
+	if code := ErrorCode(err); code != InvalidArgument {
+		t.Errorf("decodeKey: got %v, want %v", code, InvalidArgument)
+	}
+
+	_, err = decodeKey(datastore.NameKey("Kind", "name", nil).Encode())
+	if code := ErrorCode(err); code != InvalidArgument {
+		t.Errorf("decodeKey: got %v, want %v", code, InvalidArgument)
+	}
+
+	want := datastore.NameKey(profileKind, "gunwoo", nil)
+	got, err := decodeKey(want.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(want) {
+		t.Errorf("decodeKey: got %v, want %v", got, want)
+	}
+
+	// the keys of other namespaces are invalid ids of a service.
+	s := &datastoreService{namespace: "test"}
+	if _, err := s.decodeKey(want.Encode()); ErrorCode(err) != InvalidArgument {
+		t.Errorf("decodeKey: got %v, want %v for another namespace", err, InvalidArgument)
+	}
+	want.Namespace = "test"
+	if got, err := s.decodeKey(want.Encode()); err != nil || !got.Equal(want) {
+		t.Errorf("decodeKey: got %v, %v, want %v", got, err, want)
+	}
+}
+
+func TestDatastoreMergeProfiles(t *testing.T) {
+	tc := testutil.EmulatorTestContext(t)
+	ctx := context.Background()
+
+	client, err := datastore.NewClient(ctx, tc.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	s := newDatastoreService(client, Namespace(tc.Namespace))
+	survivor, err := s.PostProfile(ctx, &Profile{Email: "survivor@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.DeleteProfile(ctx, survivor.ID)
+	victim, err := s.PostProfile(ctx, &Profile{Email: "victim@gunwoo.org", AboutMe: "Codercat"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LinkIdentity(ctx, victim.ID, Identity{Provider: "github", Subject: "merge"}); err != nil {
+		t.Fatal(err)
+	}
+
+	strategy := MergeStrategy{Fields: map[string]MergeSource{"email": Victim}}
+	merged, err := s.MergeProfiles(ctx, survivor.ID, victim.ID, strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Email != victim.Email || merged.AboutMe != victim.AboutMe || len(merged.Identities) != 1 {
+		t.Errorf("MergeProfiles: got %v", merged)
+	}
+
+	for _, get := range []func() (*Profile, error){
+		func() (*Profile, error) { return s.GetProfile(ctx, victim.ID) },
+		func() (*Profile, error) { return s.LookupProfile(ctx, victim.Email) },
+		func() (*Profile, error) { return s.ResolveIdentity(ctx, "github", "merge") },
+	} {
+		got, err := get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != survivor.ID {
+			t.Errorf("got %v, want the survivor %q", got, survivor.ID)
+		}
+	}
+	if _, err := s.LookupProfile(ctx, "survivor@gunwoo.org"); ErrorCode(err) != NotFound {
+		t.Errorf("LookupProfile: got %v, want %v", err, NotFound)
+	}
+}
+
+func TestDatastoreGetProfiles(t *testing.T) {
+	tc := testutil.EmulatorTestContext(t)
+	ctx := context.Background()
+
+	client, err := datastore.NewClient(ctx, tc.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	s := newDatastoreService(client, Namespace(tc.Namespace))
+	p, err := s.PostProfile(ctx, &Profile{Email: "batch@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.DeleteProfile(ctx, p.ID)
+	survivor, err := s.PostProfile(ctx, &Profile{Email: "batch-survivor@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.DeleteProfile(ctx, survivor.ID)
+	victim, err := s.PostProfile(ctx, &Profile{Email: "batch-victim@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.MergeProfiles(ctx, survivor.ID, victim.ID, MergeStrategy{}); err != nil {
+		t.Fatal(err)
+	}
+	missing := datastore.IDKey(profileKind, 1, nil).Encode()
+
+	got, err := s.GetProfiles(ctx, []string{p.ID, missing, victim.ID, "invalid", p.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{p.ID, "", survivor.ID, "", p.ID}
+	if len(got) != len(want) {
+		t.Fatalf("GetProfiles: got %d profiles, want %d", len(got), len(want))
+	}
+	for i, id := range want {
+		if (got[i] == nil) != (id == "") || got[i] != nil && got[i].ID != id {
+			t.Errorf("GetProfiles: got %v at %d, want %q", got[i], i, id)
+		}
+	}
+}
+
+func TestBackfillEmailReservations(t *testing.T) {
+	tc := testutil.EmulatorTestContext(t)
+	ctx := context.Background()
+
+	client, err := datastore.NewClient(ctx, tc.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// legacy profiles are written without reserving their email addresses.
+	legacy := func(email string) string {
+		key := datastore.IncompleteKey(profileKind, nil)
+		key.Namespace = tc.Namespace
+		key, err := client.Put(ctx, key, &Profile{Email: email, Revision: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key.Encode()
+	}
+	unique := legacy("legacy@gunwoo.org")
+	shared := []string{legacy("shared@gunwoo.org"), legacy("Shared@Gunwoo.org")}
+	legacy("")
+
+	s := newDatastoreService(client, Namespace(tc.Namespace))
+	reserved, err := s.PostProfile(ctx, &Profile{Email: "reserved@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreserved := legacy("RESERVED@gunwoo.org")
+
+	created, conflicts, err := BackfillEmailReservations(ctx, client, Namespace(tc.Namespace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 2 {
+		t.Errorf("BackfillEmailReservations: got %d reservations, want 2", created)
+	}
+	got := map[string]EmailConflict{}
+	for _, c := range conflicts {
+		got[c.Email] = c
+	}
+	if c := got["reserved@gunwoo.org"]; c.ReservedBy != reserved.ID || !reflect.DeepEqual(c.ProfileIDs, []string{unreserved}) {
+		t.Errorf("BackfillEmailReservations: got %+v, want the conflict with %q", c, reserved.ID)
+	}
+	if c := got["shared@gunwoo.org"]; len(c.ProfileIDs) != 1 || c.ReservedBy == c.ProfileIDs[0] ||
+		(c.ReservedBy != shared[0] && c.ReservedBy != shared[1]) {
+		t.Errorf("BackfillEmailReservations: got %+v, want the conflict of %v", c, shared)
+	}
+	if len(conflicts) != 2 {
+		t.Errorf("BackfillEmailReservations: got %d conflicts, want 2", len(conflicts))
+	}
+
+	p, err := s.LookupProfile(ctx, "legacy@gunwoo.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ID != unique {
+		t.Errorf("LookupProfile: got %q, want %q", p.ID, unique)
+	}
+	if _, err := s.PostProfile(ctx, &Profile{Email: "legacy@gunwoo.org"}); ErrorCode(err) != Conflict {
+		t.Errorf("PostProfile: got %v, want %v", err, Conflict)
+	}
+
+	// the existing reservations are kept.
+	created, conflicts, err = BackfillEmailReservations(ctx, client, Namespace(tc.Namespace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 0 || len(conflicts) != 2 {
+		t.Errorf("BackfillEmailReservations: got %d reservations and %v, want none and the same conflicts", created, conflicts)
+	}
+}
@@ -0,0 +1,420 @@
+package profile
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("disk", func(_ context.Context, config Config) (Service, func() error, error) {
+		if config.Path == "" {
+			return nil, nil, errors.New("disk: the path of the log is not set")
+		}
+		s, err := OpenDiskService(config.Path, config.DiskOptions...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s.Close, nil
+	})
+}
+
+const (
+	// DefaultCompactThreshold is the number of records appended to the log
+	// of a disk service after which it is compacted.
+	DefaultCompactThreshold = 10000
+
+	// recordHeaderSize is the size of the header of a record: the length of
+	// its payload, the CRC-32C checksum of the length, and the CRC-32C
+	// checksum of the payload.
+	recordHeaderSize = 12
+	// maxRecordSize is the maximum size of the payload of a record, so that
+	// a corrupted length can not make OpenDiskService allocate an arbitrary
+	// amount of memory.
+	maxRecordSize = 256 << 20
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	errDiskClosed = Errorf(Unavailable, "disk: the log is closed")
+)
+
+// DiskOption configures the disk service.
+type DiskOption func(*DiskService)
+
+// SyncInterval sets how often the log is flushed to stable storage. Zero,
+// the default, syncs every write before it is acknowledged, so that no
+// acknowledged write is lost by a crash. A positive interval syncs in the
+// background instead, which may lose the writes of the last interval, and a
+// negative interval leaves it to the operating system.
+func SyncInterval(d time.Duration) DiskOption {
+	return func(s *DiskService) {
+		s.interval = d
+	}
+}
+
+// CompactThreshold sets the number of records appended to the log after
+// which it is compacted, provided that it has more records than profiles.
+// It defaults to DefaultCompactThreshold, and zero disables compaction
+// other than by Compact.
+func CompactThreshold(records int) DiskOption {
+	return func(s *DiskService) {
+		s.threshold = records
+	}
+}
+
+// DiskService is a Service which stores the profiles in a single file, for
+// single-node deployments which have no Datastore, such as a VM or a laptop.
+// The profiles and the indexes of their email addresses and identities are
+// held by a MemoryService, and every mutation is appended to a log before it
+// is applied, so that the state is restored by replaying the log when the
+// file is opened again. The log is compacted into a snapshot of the state
+// once it has grown by CompactThreshold records.
+//
+// Records are checksummed, so that a record torn by a crash is discarded
+// when the log is replayed, while a corrupted record followed by others
+// fails OpenDiskService rather than losing them. The length of a record has
+// a checksum of its own, so that a corrupted length is not mistaken for a
+// record which runs past the end of the file. The file is locked, on
+// the platforms which support it, so that a single process can open it.
+type DiskService struct {
+	*MemoryService
+
+	path      string
+	interval  time.Duration
+	threshold int
+
+	// mu guards the fields below and the writes to the file. It is
+	// acquired after the lock of the MemoryService, if both are held.
+	mu sync.Mutex
+	f  *os.File
+	// size is the size of the records of the file.
+	size int64
+	// records is the number of records of the file.
+	records int
+	// compactAt is the number of records at which the log is compacted.
+	compactAt int
+	// dirty reports whether there are writes which are not synced.
+	dirty bool
+	// err is the error which broke the log, after which writes fail, so
+	// that no write is acknowledged after a lost one.
+	err error
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// OpenDiskService opens the log at path, which is created if it does not
+// exist, and restores the profiles stored in it. The service must be closed
+// to release the file.
+func OpenDiskService(path string, opts ...DiskOption) (*DiskService, error) {
+	s := &DiskService{
+		MemoryService: NewMemoryService(),
+		path:          path,
+		threshold:     DefaultCompactThreshold,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	_, err := os.Stat(path)
+	created := os.IsNotExist(err)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("disk: could not open the log: %v", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("disk: could not lock %s, which may be open in another process: %v", path, err)
+	}
+	// the file of a new log is only durable once the directory is synced.
+	if created {
+		if err := syncDir(filepath.Dir(path)); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("disk: could not sync the directory of the log: %v", err)
+		}
+	}
+	if err := s.replay(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// a record torn by a crash is discarded, so that the next record
+	// follows the last valid one.
+	if err := f.Truncate(s.size); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("disk: could not truncate the log: %v", err)
+	}
+	s.f = f
+	s.compactAt = s.threshold
+	s.MemoryService.journal = s
+
+	if s.interval > 0 {
+		s.done = make(chan struct{})
+		s.wg.Add(1)
+		go s.syncEvery(s.interval)
+	}
+	return s, nil
+}
+
+// replay applies the records of the file, and sets the size and the number
+// of the valid records.
+func (s *DiskService) replay(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("disk: could not read the log: %v", err)
+	}
+	r := bufio.NewReader(f)
+	header := make([]byte, recordHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return nil
+		} else if err == io.ErrUnexpectedEOF {
+			return s.invalidRecord(f, info.Size())
+		} else if err != nil {
+			return fmt.Errorf("disk: could not read the log: %v", err)
+		}
+		n, ok := recordLength(header)
+		if !ok || s.size+recordHeaderSize+int64(n) > info.Size() {
+			return s.invalidRecord(f, info.Size())
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return fmt.Errorf("disk: could not read the log: %v", err)
+		}
+		if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[8:]) {
+			return s.invalidRecord(f, info.Size())
+		}
+		m := &mutation{}
+		if err := json.Unmarshal(payload, m); err != nil {
+			return fmt.Errorf("disk: invalid record at offset %d of %s: %v", s.size, s.path, err)
+		}
+		s.MemoryService.apply(m)
+		s.size += recordHeaderSize + int64(n)
+		s.records++
+	}
+}
+
+// recordLength returns the length of the payload of the record of the
+// header, and whether it is valid.
+func recordLength(header []byte) (uint32, bool) {
+	n := binary.BigEndian.Uint32(header)
+	if crc32.Checksum(header[:4], crcTable) != binary.BigEndian.Uint32(header[4:]) {
+		return 0, false
+	}
+	return n, n > 0 && n <= maxRecordSize
+}
+
+// invalidRecord returns nil if the invalid record at the end of the valid
+// records is the last one of the file, which is the record being written
+// when the process crashed, or the error of a corrupted log otherwise.
+func (s *DiskService) invalidRecord(f *os.File, size int64) error {
+	// the rest of the file is either the torn record, whose header is
+	// incomplete or whose valid length runs to the end of the file, or zeros
+	// which the file system allocated for it. A corrupted length says
+	// nothing about where the record ends, so it is never taken for a torn
+	// record.
+	next := s.size + recordHeaderSize
+	if next > size {
+		return nil
+	}
+	header := make([]byte, recordHeaderSize)
+	if _, err := f.ReadAt(header, s.size); err != nil {
+		return fmt.Errorf("disk: could not read the log: %v", err)
+	}
+	if n, ok := recordLength(header); ok && next+int64(n) >= size {
+		return nil
+	}
+	rest := make([]byte, size-s.size)
+	if _, err := f.ReadAt(rest, s.size); err != nil {
+		return fmt.Errorf("disk: could not read the log: %v", err)
+	}
+	for _, b := range rest {
+		if b != 0 {
+			return fmt.Errorf("disk: corrupted record at offset %d of %s", s.size, s.path)
+		}
+	}
+	return nil
+}
+
+// record appends the mutation to the log. It implements journal.
+func (s *DiskService) record(m *mutation) error {
+	b, err := encodeRecord(m)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	if _, err := s.f.WriteAt(b, s.size); err != nil {
+		// the partial record is discarded, unless the file can not be
+		// truncated, in which case it would hide the records after it.
+		e := &Error{Code: Unavailable, Message: "disk: could not append to the log", Err: err}
+		if err := s.f.Truncate(s.size); err != nil {
+			s.err = e
+		}
+		return e
+	}
+	if s.interval == 0 {
+		// after a failed sync, the state of the written data is unknown.
+		if err := s.f.Sync(); err != nil {
+			s.err = &Error{Code: Unavailable, Message: "disk: could not sync the log", Err: err}
+			return s.err
+		}
+	} else {
+		s.dirty = true
+	}
+	s.size += int64(len(b))
+	s.records++
+	return nil
+}
+
+// applied compacts the log once it has grown past the threshold. It
+// implements journal.
+func (s *DiskService) applied() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.threshold > 0 && s.records >= s.compactAt && s.records > len(s.MemoryService.profiles) {
+		// the log is still valid if the compaction fails, so it is only
+		// attempted again once the log has grown by the threshold.
+		if err := s.compact(); err != nil {
+			s.compactAt = s.records + s.threshold
+		}
+	}
+}
+
+// Compact rewrites the log as a single record of the current state.
+func (s *DiskService) Compact() error {
+	s.MemoryService.mu.Lock()
+	defer s.MemoryService.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	return s.compact()
+}
+
+// compact writes the state to a new file, which atomically replaces the
+// log. The locks of the service and of the MemoryService must be held.
+func (s *DiskService) compact() error {
+	snapshot := s.MemoryService.snapshot()
+	b, err := encodeRecord(&mutation{Reset: true, Put: snapshot.Profiles, Redirects: snapshot.Redirects})
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".compact"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return &Error{Code: Unavailable, Message: "disk: could not create the compacted log", Err: err}
+	}
+	err = lockFile(f)
+	if err == nil {
+		_, err = f.Write(b)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return &Error{Code: Unavailable, Message: "disk: could not write the compacted log", Err: err}
+	}
+
+	s.f.Close()
+	s.f, s.size, s.records, s.dirty = f, int64(len(b)), 1, false
+	s.compactAt = s.threshold
+	// the rename is only durable once the directory is synced.
+	if err := syncDir(filepath.Dir(s.path)); err != nil {
+		return &Error{Code: Unavailable, Message: "disk: could not sync the directory of the log", Err: err}
+	}
+	return nil
+}
+
+// syncEvery syncs the writes to the log at the given interval, until the
+// service is closed.
+func (s *DiskService) syncEvery(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		if s.dirty && s.err == nil {
+			if err := s.f.Sync(); err != nil {
+				s.err = &Error{Code: Unavailable, Message: "disk: could not sync the log", Err: err}
+			}
+			s.dirty = false
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Close syncs and closes the log. Profiles can still be read once the
+// service is closed, but no longer written.
+func (s *DiskService) Close() error {
+	s.mu.Lock()
+	if s.err == errDiskClosed {
+		s.mu.Unlock()
+		return nil
+	}
+	err := s.err
+	s.err = errDiskClosed
+	s.mu.Unlock()
+
+	if s.done != nil {
+		close(s.done)
+		s.wg.Wait()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil && s.dirty {
+		err = s.f.Sync()
+	}
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// encodeRecord returns the record of the mutation: the header, followed by
+// its JSON encoding.
+func encodeRecord(m *mutation) ([]byte, error) {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return nil, &Error{Code: Unknown, Message: "disk: could not encode the record", Err: err}
+	}
+	if len(payload) > maxRecordSize {
+		return nil, Errorf(InvalidArgument, "disk: the record of %d bytes exceeds the maximum of %d", len(payload), maxRecordSize)
+	}
+	b := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(len(payload)))
+	binary.BigEndian.PutUint32(b[4:], crc32.Checksum(b[:4], crcTable))
+	binary.BigEndian.PutUint32(b[8:], crc32.Checksum(payload, crcTable))
+	copy(b[recordHeaderSize:], payload)
+	return b, nil
+}
@@ -0,0 +1,16 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+
+package profile
+
+import "os"
+
+// lockFile does nothing on the platforms without flock, where the file must
+// not be opened by more than one process.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// syncDir does nothing on the platforms where directories can not be synced.
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,203 @@
+package profile
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func openDisk(t *testing.T, path string, opts ...DiskOption) *DiskService {
+	s, err := OpenDiskService(path, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestDiskService(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "superego.log")
+	s := openDisk(t, path)
+
+	survivor, err := s.PostProfile(ctx, &Profile{DisplayName: "gunwoo", Email: "gunwoo@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	victim, err := s.PostProfile(ctx, &Profile{Email: "ben@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := s.PostProfile(ctx, &Profile{Email: "deleted@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PatchProfile(ctx, survivor.ID, &Profile{AboutMe: "Codercat"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LinkIdentity(ctx, victim.ID, Identity{Provider: "github", Subject: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.MergeProfiles(ctx, survivor.ID, victim.ID, MergeStrategy{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteProfile(ctx, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+	want := s.Snapshot()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PostProfile(ctx, &Profile{}); ErrorCode(err) != Unavailable {
+		t.Errorf("PostProfile: got %v, want an Unavailable error once closed", err)
+	}
+
+	s = openDisk(t, path)
+	defer s.Close()
+	if got := s.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Snapshot: got %+v, want %+v", got, want)
+	}
+	if got, err := s.ResolveIdentity(ctx, "github", "1"); err != nil || got.ID != survivor.ID {
+		t.Errorf("ResolveIdentity: got %v, %v, want the survivor", got, err)
+	}
+	// the email address of the victim is released by the merge.
+	if _, err := s.LookupProfile(ctx, "ben@gunwoo.org"); err != ErrNoSuchEntity {
+		t.Errorf("LookupProfile: got %v, want %v", err, ErrNoSuchEntity)
+	}
+	if _, err := s.PostProfile(ctx, &Profile{Email: "GUNWOO@gunwoo.org"}); ErrorCode(err) != Conflict {
+		t.Errorf("PostProfile: got %v, want a Conflict error for a used email", err)
+	}
+}
+
+func TestDiskServiceLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "superego.log")
+	s := openDisk(t, path)
+	defer s.Close()
+	if _, err := OpenDiskService(path); err == nil {
+		t.Error("OpenDiskService: error should not be nil for an open log")
+	}
+}
+
+func TestDiskServiceTornRecord(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "superego.log")
+	s := openDisk(t, path)
+	p, err := s.PostProfile(ctx, &Profile{Email: "gunwoo@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tail := range [][]byte{
+		// a torn header.
+		{0, 0},
+		// a record longer than the rest of the file.
+		tornRecord(256, "{"),
+		// a record with an invalid checksum.
+		tornRecord(2, "{}"),
+		// zeros allocated for a record.
+		make([]byte, 64),
+	} {
+		if err := appendFile(path, tail); err != nil {
+			t.Fatal(err)
+		}
+		s = openDisk(t, path)
+		if got, err := s.GetProfile(ctx, p.ID); err != nil || got.Email != p.Email {
+			t.Errorf("GetProfile: got %v, %v, want %v", got, err, p)
+		}
+		s.Close()
+		if got, err := os.Stat(path); err != nil || got.Size() != info.Size() {
+			t.Errorf("OpenDiskService: the torn record %v was not discarded", tail)
+		}
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, corrupt := range map[string]func(b []byte){
+		"payload": func(b []byte) { b[recordHeaderSize] ^= 0xff },
+		// a length past the end of the file must not pass for a torn record.
+		"length": func(b []byte) { b[1] ^= 0xff },
+	} {
+		// a corrupted record followed by a valid one is not discarded.
+		corrupted := append(append([]byte{}, b...), b...)
+		corrupt(corrupted)
+		if err := ioutil.WriteFile(path, corrupted, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenDiskService(path); err == nil {
+			t.Errorf("OpenDiskService: error should not be nil for a corrupted %s", name)
+		}
+		if got, err := ioutil.ReadFile(path); err != nil || len(got) != len(corrupted) {
+			t.Errorf("OpenDiskService: the log with a corrupted %s was truncated", name)
+		}
+	}
+}
+
+// tornRecord returns a record whose header has a valid length and an invalid
+// checksum of the payload, which is what a crash leaves of the last record.
+func tornRecord(n uint32, payload string) []byte {
+	b := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(b, n)
+	binary.BigEndian.PutUint32(b[4:], crc32.Checksum(b[:4], crcTable))
+	binary.BigEndian.PutUint32(b[8:], crc32.Checksum([]byte(payload), crcTable)+1)
+	return append(b, payload...)
+}
+
+func appendFile(path string, b []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func TestDiskServiceCompact(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "superego.log")
+	s := openDisk(t, path, CompactThreshold(4), SyncInterval(-1))
+
+	p, err := s.PostProfile(ctx, &Profile{Email: "gunwoo@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, aboutMe := range []string{"a", "b", "c", "d", "e"} {
+		if _, err := s.PatchProfile(ctx, p.ID, &Profile{AboutMe: aboutMe}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the log is compacted at the fourth record, and has two more.
+	if s.records != 3 {
+		t.Errorf("got %d records, want 3", s.records)
+	}
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if s.records != 1 {
+		t.Errorf("got %d records, want 1 after Compact", s.records)
+	}
+	want := s.Snapshot()
+	s.Close()
+
+	s = openDisk(t, path)
+	defer s.Close()
+	if got := s.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Snapshot: got %+v, want %+v", got, want)
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("got %v, want the compacted log renamed", err)
+	}
+}
@@ -0,0 +1,21 @@
+package profile
+
+import "strings"
+
+// emailKey returns the key which identifies the owner of an email address.
+// Addresses are compared case-insensitively, since hardly any mail system
+// treats the local part as case-sensitive.
+func emailKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// emailInUse returns the Conflict error for an email address which is
+// already used by the profile with the given id.
+func emailInUse(id string) error {
+	return &Error{
+		Code:       Conflict,
+		Message:    "email is already used by profile " + id,
+		Violations: []FieldViolation{{Field: "email", Description: "is already in use"}},
+		ExistingID: id,
+	}
+}
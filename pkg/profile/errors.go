@@ -0,0 +1,113 @@
+package profile
+
+import "fmt"
+
+// Code describes the kind of failure of a profile service operation.
+// Transports map codes to their own status codes, such as HTTP statuses.
+type Code int
+
+const (
+	// Unknown is the code of an error which has no specific kind.
+	Unknown Code = iota
+	// NotFound means that the requested profile does not exist.
+	NotFound
+	// InvalidArgument means that the caller specified an invalid argument,
+	// such as a malformed id or an unsupported option.
+	InvalidArgument
+	// Conflict means that the operation conflicts with the current state of
+	// the profile, such as a concurrent modification.
+	Conflict
+	// PermissionDenied means that the caller is not allowed to perform the
+	// operation.
+	PermissionDenied
+	// Unavailable means that the backend is currently unavailable, and the
+	// operation may succeed if retried.
+	Unavailable
+	// FailedPrecondition means that the profile is not in the state expected
+	// by the caller, such as a revision mismatch.
+	FailedPrecondition
+)
+
+var codeNames = map[Code]string{
+	Unknown:            "UNKNOWN",
+	NotFound:           "NOT_FOUND",
+	InvalidArgument:    "INVALID_ARGUMENT",
+	Conflict:           "CONFLICT",
+	PermissionDenied:   "PERMISSION_DENIED",
+	Unavailable:        "UNAVAILABLE",
+	FailedPrecondition: "FAILED_PRECONDITION",
+}
+
+// String returns the stable, machine-readable name of the code.
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return codeNames[Unknown]
+}
+
+// ParseCode returns the code with the given name, as returned by String, or
+// Unknown if there is no such code.
+func ParseCode(name string) Code {
+	for code, n := range codeNames {
+		if n == name {
+			return code
+		}
+	}
+	return Unknown
+}
+
+var (
+	// ErrNoSuchEntity is returned when a profile does not exist.
+	ErrNoSuchEntity = &Error{Code: NotFound, Message: "no such entity"}
+)
+
+// FieldViolation describes a single invalid field of a request.
+type FieldViolation struct {
+	// The path of the invalid field, such as "email" or "name.givenName".
+	Field string `json:"field"`
+	// A human-readable description of why the field is invalid.
+	Description string `json:"description"`
+}
+
+// Error is the error returned by profile services.
+type Error struct {
+	// The kind of the error.
+	Code Code
+	// A human-readable description of the error.
+	Message string
+	// The invalid fields of the request, if the error is caused by them.
+	Violations []FieldViolation
+	// The id of the existing profile which the request conflicts with, if
+	// the error is caused by it.
+	ExistingID string
+	// The underlying error, if any.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+// Errorf returns an *Error with the given code and formatted message.
+func Errorf(code Code, format string, args ...interface{}) error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// InvalidFields returns an InvalidArgument error with the given field
+// violations.
+func InvalidFields(message string, violations ...FieldViolation) error {
+	return &Error{Code: InvalidArgument, Message: message, Violations: violations}
+}
+
+// ErrorCode returns the code of the error, which is Unknown unless the error
+// is an *Error.
+func ErrorCode(err error) Code {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return Unknown
+}
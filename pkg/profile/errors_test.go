@@ -0,0 +1,83 @@
+package profile
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		err  *Error
+		want string
+	}{
+		{&Error{Code: NotFound, Message: "no such entity"}, "no such entity"},
+		{&Error{Code: Unavailable, Message: "datastore: could not get Profile", Err: errors.New("unavailable")}, "datastore: could not get Profile: unavailable"},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error: got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want Code
+	}{
+		{ErrNoSuchEntity, NotFound},
+		{Errorf(InvalidArgument, "invalid page size %d", -1), InvalidArgument},
+		{errors.New("unknown"), Unknown},
+		{nil, Unknown},
+	}
+	for _, tt := range tests {
+		if got := ErrorCode(tt.err); got != tt.want {
+			t.Errorf("ErrorCode(%v): got %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestCodeString(t *testing.T) {
+	tests := []struct {
+		code Code
+		want string
+	}{
+		{NotFound, "NOT_FOUND"},
+		{PermissionDenied, "PERMISSION_DENIED"},
+		{Code(-1), "UNKNOWN"},
+	}
+	for _, tt := range tests {
+		if got := tt.code.String(); got != tt.want {
+			t.Errorf("String: got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestParseCode(t *testing.T) {
+	tests := []struct {
+		name string
+		want Code
+	}{
+		{"NOT_FOUND", NotFound},
+		{"FAILED_PRECONDITION", FailedPrecondition},
+		{"UNKNOWN", Unknown},
+		{"not_found", Unknown},
+		{"", Unknown},
+	}
+	for _, tt := range tests {
+		if got := ParseCode(tt.name); got != tt.want {
+			t.Errorf("ParseCode(%q): got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInvalidFields(t *testing.T) {
+	v := FieldViolation{Field: "pageSize", Description: "must be a number"}
+	err := InvalidFields("invalid list options", v)
+	if code := ErrorCode(err); code != InvalidArgument {
+		t.Errorf("InvalidFields: got %v, want %v", code, InvalidArgument)
+	}
+	if got := err.(*Error).Violations; len(got) != 1 || got[0] != v {
+		t.Errorf("InvalidFields: got %v, want %v", got, []FieldViolation{v})
+	}
+}
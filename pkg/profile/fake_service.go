@@ -2,26 +2,58 @@ package profile
 
 import (
 	"context"
-	"errors"
 	"sync"
-)
-
-var (
-	ErrNoSuchEntity = errors.New("no such entity")
+	"time"
 )
 
 // fakeService is a simple fake service for testing.
 type fakeService struct {
 	mu       sync.RWMutex
 	profiles map[string]*Profile
+	// redirects maps the ids of merged profiles to their survivors.
+	redirects map[string]string
+	// generateIDs stores the profiles posted without an id at a generated
+	// one, like the other backends, rather than at the empty id.
+	generateIDs bool
+}
+
+// FakeService is a fake Service shared by the tests of several packages,
+// which stores profiles at the ids given by the callers. Use NewFakeService
+// for an isolated fake, or NewMemoryService for an isolated store with the
+// semantics of the datastore service.
+var FakeService = newFakeService()
+
+// NewFakeService returns an empty fake Service, which is not shared with the
+// tests of other packages. Unlike FakeService, it generates the ids of the
+// profiles posted without one.
+func NewFakeService() Service {
+	f := newFakeService()
+	f.generateIDs = true
+	return f
 }
 
-var FakeService = &fakeService{profiles: map[string]*Profile{}}
+func newFakeService() *fakeService {
+	return &fakeService{profiles: map[string]*Profile{}, redirects: map[string]string{}}
+}
 
 func (f *fakeService) PostProfile(_ context.Context, p *Profile) (*Profile, error) {
+	if p.ID == "" && f.generateIDs {
+		id, err := newProfileID()
+		if err != nil {
+			return &Profile{}, err
+		}
+		p.ID = id
+	}
+
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	if err := f.checkEmail(p.ID, p.Email); err != nil {
+		return &Profile{}, err
+	}
+	p.Identities = nil
+	p.Revision = 1
+	p.UpdateTime = time.Now().UTC()
 	f.profiles[p.ID] = p
 	return p, nil
 }
@@ -30,22 +62,58 @@ func (f *fakeService) GetProfile(_ context.Context, id string) (*Profile, error)
 	f.mu.RLock()
 	defer f.mu.RUnlock()
 
-	p, ok := f.profiles[id]
-	if !ok {
-		return &Profile{}, ErrNoSuchEntity
+	for redirects := 0; ; redirects++ {
+		if p, ok := f.profiles[id]; ok {
+			return p, nil
+		}
+		survivorID, ok := f.redirects[id]
+		if !ok || redirects == maxRedirects {
+			return &Profile{}, ErrNoSuchEntity
+		}
+		id = survivorID
 	}
-	return p, nil
 }
 
-func (f *fakeService) PutProfile(_ context.Context, id string, p *Profile) (*Profile, error) {
+func (f *fakeService) GetProfiles(ctx context.Context, ids []string) ([]*Profile, error) {
+	profiles := make([]*Profile, len(ids))
+	for i, id := range ids {
+		p, err := f.GetProfile(ctx, id)
+		if err == ErrNoSuchEntity {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		profiles[i] = p
+	}
+	return profiles, nil
+}
+
+func (f *fakeService) PutProfile(ctx context.Context, id string, p *Profile) (*Profile, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	var revision int64
+	var identities []Identity
+	if existing, ok := f.profiles[id]; ok {
+		revision, identities = existing.Revision, existing.Identities
+	}
+	if err := checkRevision(ctx, revision); err != nil {
+		return &Profile{}, err
+	}
+	if err := f.checkEmail(id, p.Email); err != nil {
+		return &Profile{}, err
+	}
+	p.ID = id
+	p.Identities = identities
+	p.Revision = revision + 1
+	p.UpdateTime = time.Now().UTC()
 	f.profiles[p.ID] = p
+	delete(f.redirects, p.ID)
 	return p, nil
 }
 
-func (f *fakeService) PatchProfile(_ context.Context, id string, p *Profile) (*Profile, error) {
+func (f *fakeService) PatchProfile(ctx context.Context, id string, patch Patch) (*Profile, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
@@ -53,31 +121,193 @@ func (f *fakeService) PatchProfile(_ context.Context, id string, p *Profile) (*P
 	if !ok {
 		return &Profile{}, ErrNoSuchEntity
 	}
-
-	if p.DisplayName != "" {
-		existing.DisplayName = p.DisplayName
-	}
-	if p.Email != "" {
-		existing.Email = p.Email
+	if err := checkRevision(ctx, existing.Revision); err != nil {
+		return &Profile{}, err
 	}
-	if p.ImageURL != "" {
-		existing.ImageURL = p.ImageURL
+
+	// the patch is applied to a copy, so that a failed patch leaves the
+	// stored profile unchanged.
+	patched := *existing
+	if err := patch.Apply(&patched); err != nil {
+		return &Profile{}, err
 	}
-	if p.AboutMe != "" {
-		existing.AboutMe = p.AboutMe
+	if err := f.checkEmail(id, patched.Email); err != nil {
+		return &Profile{}, err
 	}
+	patched.ID = id
+	patched.Revision++
+	patched.UpdateTime = time.Now().UTC()
 
-	f.profiles[id] = existing
-	return p, nil
+	f.profiles[id] = &patched
+	return &patched, nil
 }
 
-func (f *fakeService) DeleteProfile(_ context.Context, id string) error {
+func (f *fakeService) DeleteProfile(ctx context.Context, id string) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	if _, ok := f.profiles[id]; !ok {
+	existing, ok := f.profiles[id]
+	if !ok {
 		return ErrNoSuchEntity
 	}
+	if err := checkRevision(ctx, existing.Revision); err != nil {
+		return err
+	}
 	delete(f.profiles, id)
 	return nil
 }
+
+func (f *fakeService) LookupProfile(_ context.Context, email string) (*Profile, error) {
+	if emailKey(email) == "" {
+		return &Profile{}, Errorf(InvalidArgument, "empty email")
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, p := range f.profiles {
+		if emailKey(p.Email) == emailKey(email) {
+			return p, nil
+		}
+	}
+	return &Profile{}, ErrNoSuchEntity
+}
+
+func (f *fakeService) LinkIdentity(ctx context.Context, id string, identity Identity) (*Profile, error) {
+	if err := validateIdentity(identity.Provider, identity.Subject); err != nil {
+		return &Profile{}, err
+	}
+	return f.updateIdentities(ctx, id, func(p *Profile) error {
+		if owner := f.resolve(identity.Provider, identity.Subject); owner != nil && owner.ID != id {
+			return identityInUse(owner.ID)
+		}
+		p.linkIdentity(identity)
+		return nil
+	})
+}
+
+func (f *fakeService) UnlinkIdentity(ctx context.Context, id string, provider, subject string) (*Profile, error) {
+	return f.updateIdentities(ctx, id, func(p *Profile) error {
+		return p.unlinkIdentity(provider, subject)
+	})
+}
+
+func (f *fakeService) ResolveIdentity(_ context.Context, provider, subject string) (*Profile, error) {
+	if err := validateIdentity(provider, subject); err != nil {
+		return &Profile{}, err
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if p := f.resolve(provider, subject); p != nil {
+		return p, nil
+	}
+	return &Profile{}, ErrNoSuchEntity
+}
+
+func (f *fakeService) MergeProfiles(ctx context.Context, survivorID, victimID string, strategy MergeStrategy) (*Profile, error) {
+	if err := validateMerge(survivorID, victimID); err != nil {
+		return &Profile{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	survivor, ok := f.profiles[survivorID]
+	if !ok {
+		return &Profile{}, ErrNoSuchEntity
+	}
+	victim, ok := f.profiles[victimID]
+	if !ok {
+		return &Profile{}, ErrNoSuchEntity
+	}
+	if err := checkRevision(ctx, survivor.Revision); err != nil {
+		return &Profile{}, err
+	}
+	merged, err := strategy.merge(survivor, victim)
+	if err != nil {
+		return &Profile{}, err
+	}
+	merged.Revision++
+	merged.UpdateTime = time.Now().UTC()
+
+	delete(f.profiles, victimID)
+	f.profiles[survivorID] = merged
+	f.redirects[victimID] = survivorID
+	return merged, nil
+}
+
+// updateIdentities applies f to a copy of the profile with the given id, and
+// stores the copy with the next revision if f succeeds.
+func (f *fakeService) updateIdentities(ctx context.Context, id string, update func(p *Profile) error) (*Profile, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	existing, ok := f.profiles[id]
+	if !ok {
+		return &Profile{}, ErrNoSuchEntity
+	}
+	if err := checkRevision(ctx, existing.Revision); err != nil {
+		return &Profile{}, err
+	}
+	updated := *existing
+	if err := update(&updated); err != nil {
+		return &Profile{}, err
+	}
+	updated.Revision++
+	updated.UpdateTime = time.Now().UTC()
+	f.profiles[id] = &updated
+	return &updated, nil
+}
+
+// resolve returns the profile which the identity is linked to, or nil.
+// f.mu must be held.
+func (f *fakeService) resolve(provider, subject string) *Profile {
+	for _, p := range f.profiles {
+		if p.findIdentity(provider, subject) >= 0 {
+			return p
+		}
+	}
+	return nil
+}
+
+// checkEmail returns a Conflict error if the email address is used by any
+// profile other than the one with the given id. f.mu must be held.
+func (f *fakeService) checkEmail(id, email string) error {
+	if emailKey(email) == "" {
+		return nil
+	}
+	for _, p := range f.profiles {
+		if p.ID != id && emailKey(p.Email) == emailKey(email) {
+			return emailInUse(p.ID)
+		}
+	}
+	return nil
+}
+
+func (f *fakeService) ListProfiles(_ context.Context, opts ListOptions) ([]*Profile, string, error) {
+	return f.query(nil, opts)
+}
+
+func (f *fakeService) SearchProfiles(_ context.Context, query SearchQuery, opts ListOptions) ([]*Profile, string, error) {
+	filters, err := query.filters()
+	if err != nil {
+		return nil, "", err
+	}
+	if opts, err = searchOptions(filters, opts); err != nil {
+		return nil, "", err
+	}
+	return f.query(filters, opts)
+}
+
+func (f *fakeService) query(filters []searchFilter, opts ListOptions) ([]*Profile, string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	profiles := make([]*Profile, 0, len(f.profiles))
+	for _, p := range f.profiles {
+		profiles = append(profiles, p)
+	}
+	return queryProfiles(profiles, filters, opts)
+}
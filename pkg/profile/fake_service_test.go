@@ -6,11 +6,24 @@ import (
 	"testing"
 )
 
+// newFakeServiceWith returns a fake which stores the given profiles, so that
+// the tests do not depend on each other through the shared FakeService.
+func newFakeServiceWith(t *testing.T, profiles ...*Profile) *fakeService {
+	f := newFakeService()
+	for _, p := range profiles {
+		if _, err := f.PostProfile(context.Background(), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
 func TestFakeServicePostProfile(t *testing.T) {
 	ctx := context.Background()
+	f := newFakeService()
 	p := &Profile{ID: "gunwoo", Email: "gunwoo@gunwoo.org"}
 
-	got, err := FakeService.PostProfile(ctx, p)
+	got, err := f.PostProfile(ctx, p)
 	if !reflect.DeepEqual(got, p) {
 		t.Errorf("PostProfile: got %v, want %v", got, p)
 	}
@@ -21,9 +34,14 @@ func TestFakeServicePostProfile(t *testing.T) {
 
 func TestFakeServiceGetProfile(t *testing.T) {
 	ctx := context.Background()
-	p := &Profile{ID: "gunwoo", Email: "gunwoo@gunwoo.org"}
+	f := newFakeServiceWith(t, &Profile{ID: "gunwoo", Email: "gunwoo@gunwoo.org"})
+	p := &Profile{ID: "gunwoo", Email: "gunwoo@gunwoo.org", Revision: 1}
 
-	got, err := FakeService.GetProfile(ctx, p.ID)
+	got, err := f.GetProfile(ctx, p.ID)
+	if got.UpdateTime.IsZero() {
+		t.Errorf("GetProfile: got zero UpdateTime")
+	}
+	p.UpdateTime = got.UpdateTime
 	if !reflect.DeepEqual(got, p) {
 		t.Errorf("GetProfile: got %v, want %v", got, p)
 	}
@@ -31,7 +49,7 @@ func TestFakeServiceGetProfile(t *testing.T) {
 		t.Errorf("GetProfile: error should be nil, not %v", err)
 	}
 
-	got, err = FakeService.GetProfile(ctx, "invalid")
+	got, err = f.GetProfile(ctx, "invalid")
 	if !reflect.DeepEqual(got, &Profile{}) {
 		t.Errorf("GetProfile: profile should be empty, not %v", got)
 	}
@@ -40,11 +58,24 @@ func TestFakeServiceGetProfile(t *testing.T) {
 	}
 }
 
+func TestFakeServiceGetProfiles(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeServiceWith(t, &Profile{ID: "gunwoo", Email: "gunwoo@gunwoo.org"})
+	got, err := f.GetProfiles(ctx, []string{"gunwoo", "invalid", "gunwoo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0] == nil || got[0].ID != "gunwoo" || got[1] != nil || got[2] != got[0] {
+		t.Errorf("GetProfiles: got %v, want gunwoo, nil and gunwoo", got)
+	}
+}
+
 func TestFakeServicePutProfile(t *testing.T) {
 	ctx := context.Background()
+	f := newFakeServiceWith(t, &Profile{ID: "gunwoo", Email: "gunwoo@gunwoo.org"})
 	p := &Profile{ID: "gunwoo", Email: "benkim@greenenergytrading.com.au"}
 
-	got, err := FakeService.PutProfile(ctx, p.ID, p)
+	got, err := f.PutProfile(ctx, p.ID, p)
 	if !reflect.DeepEqual(got, p) {
 		t.Errorf("PutProfile: got %v, want %v", got, p)
 	}
@@ -55,6 +86,7 @@ func TestFakeServicePutProfile(t *testing.T) {
 
 func TestFakeServicePatchProfile(t *testing.T) {
 	ctx := context.Background()
+	f := newFakeServiceWith(t, &Profile{ID: "gunwoo", Email: "benkim@greenenergytrading.com.au"})
 	p := &Profile{
 		ID:          "gunwoo",
 		DisplayName: "benkim0414",
@@ -62,16 +94,26 @@ func TestFakeServicePatchProfile(t *testing.T) {
 		ImageURL:    "https://octodex.github.com/images/codercat.jpg",
 		AboutMe:     "Codercat",
 	}
+	existing, err := f.GetProfile(ctx, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	got, err := FakeService.PatchProfile(ctx, p.ID, p)
-	if !reflect.DeepEqual(got, p) {
-		t.Errorf("PatchProfile: got %v, want %v", got, p)
+	got, err := f.PatchProfile(ctx, p.ID, p)
+	want := *p
+	want.Revision = existing.Revision + 1
+	if !got.UpdateTime.After(existing.UpdateTime) {
+		t.Errorf("PatchProfile: got UpdateTime %v, want after %v", got.UpdateTime, existing.UpdateTime)
+	}
+	want.UpdateTime = got.UpdateTime
+	if !reflect.DeepEqual(got, &want) {
+		t.Errorf("PatchProfile: got %v, want %v", got, &want)
 	}
 	if err != nil {
 		t.Errorf("PatchProfile: error should be nil, not %v", err)
 	}
 
-	got, err = FakeService.PatchProfile(ctx, "invalid", p)
+	got, err = f.PatchProfile(ctx, "invalid", p)
 	if !reflect.DeepEqual(got, &Profile{}) {
 		t.Errorf("PatchProfile: profile should be empty, not %v", got)
 	}
@@ -82,15 +124,199 @@ func TestFakeServicePatchProfile(t *testing.T) {
 
 func TestFakeServiceDeleteProfile(t *testing.T) {
 	ctx := context.Background()
+	f := newFakeServiceWith(t, &Profile{ID: "gunwoo", Email: "gunwoo@gunwoo.org"})
 	id := "gunwoo"
 
-	err := FakeService.DeleteProfile(ctx, id)
+	err := f.DeleteProfile(ctx, id)
 	if err != nil {
 		t.Errorf("DeleteProfile: error should be nil, not %v", err)
 	}
 
-	err = FakeService.DeleteProfile(ctx, "invalid")
+	err = f.DeleteProfile(ctx, "invalid")
 	if err != ErrNoSuchEntity {
 		t.Errorf("GetProfile: got %v, want %v", err, ErrNoSuchEntity)
 	}
 }
+
+func TestFakeServiceListProfiles(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeService()
+	profiles := []*Profile{
+		{ID: "c", Email: "a@gunwoo.org"},
+		{ID: "a", Email: "c@gunwoo.org"},
+		{ID: "b", Email: "b@gunwoo.org"},
+	}
+	for _, p := range profiles {
+		if _, err := f.PostProfile(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, token, err := f.ListProfiles(ctx, ListOptions{PageSize: 2, OrderBy: "email"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []*Profile{profiles[0], profiles[2]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListProfiles: got %v, want %v", got, want)
+	}
+	if token == "" {
+		t.Fatal("ListProfiles: next page token should not be empty")
+	}
+
+	got, token, err = f.ListProfiles(ctx, ListOptions{PageSize: 2, PageToken: token, OrderBy: "email"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []*Profile{profiles[1]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListProfiles: got %v, want %v", got, want)
+	}
+	if token != "" {
+		t.Errorf("ListProfiles: next page token should be empty, not %q", token)
+	}
+
+	got, _, err = f.ListProfiles(ctx, ListOptions{OrderBy: "-email"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []*Profile{profiles[1], profiles[2], profiles[0]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListProfiles: got %v, want %v", got, want)
+	}
+
+	got, _, err = f.ListProfiles(ctx, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []*Profile{profiles[1], profiles[2], profiles[0]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListProfiles: got %v, want %v", got, want)
+	}
+
+	_, _, err = f.ListProfiles(ctx, ListOptions{PageToken: "invalid"})
+	if err == nil {
+		t.Error("ListProfiles: error should not be nil with an invalid page token")
+	}
+}
+
+func TestFakeServiceSearchProfiles(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeService()
+	profiles := []*Profile{
+		{ID: "search-a", Email: "gunwoo@gunwoo.org", Name: Name{FamilyName: "Kim"}},
+		{ID: "search-b", Email: "ben.kim@greenenergytrading.com.au", Name: Name{FamilyName: "Kim"}},
+		{ID: "search-c", Email: "gunwoo.kim@gunwoo.org", Name: Name{FamilyName: "Lee"}},
+	}
+	for _, p := range profiles {
+		if _, err := f.PostProfile(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, _, err := f.SearchProfiles(ctx, SearchQuery{EmailPrefix: "gunwoo", FamilyName: "Kim"}, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []*Profile{profiles[0]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SearchProfiles: got %v, want %v", got, want)
+	}
+
+	got, _, err = f.SearchProfiles(ctx, SearchQuery{FamilyName: "Kim"}, ListOptions{OrderBy: "email"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []*Profile{profiles[1], profiles[0]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SearchProfiles: got %v, want %v", got, want)
+	}
+
+	_, _, err = f.SearchProfiles(ctx, SearchQuery{EmailPrefix: "gunwoo", FamilyNamePrefix: "K"}, ListOptions{})
+	if err != errMultiplePrefixFilters {
+		t.Errorf("SearchProfiles: got %v, want %v", err, errMultiplePrefixFilters)
+	}
+}
+
+func TestFakeServiceExpectedRevision(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeService()
+	p := &Profile{ID: "revision", Email: "gunwoo@gunwoo.org"}
+	if _, err := f.PostProfile(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := WithExpectedRevision(ctx, p.Revision+1)
+	if _, err := f.PutProfile(stale, p.ID, p); ErrorCode(err) != FailedPrecondition {
+		t.Errorf("PutProfile: got %v, want %v", err, FailedPrecondition)
+	}
+	if _, err := f.PatchProfile(stale, p.ID, p); ErrorCode(err) != FailedPrecondition {
+		t.Errorf("PatchProfile: got %v, want %v", err, FailedPrecondition)
+	}
+	if err := f.DeleteProfile(stale, p.ID); ErrorCode(err) != FailedPrecondition {
+		t.Errorf("DeleteProfile: got %v, want %v", err, FailedPrecondition)
+	}
+
+	got, err := f.PutProfile(WithExpectedRevision(ctx, 1), p.ID, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Revision != 2 {
+		t.Errorf("PutProfile: got revision %d, want %d", got.Revision, 2)
+	}
+}
+
+func TestFakeServicePatchProfileFailed(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeService()
+	p := &Profile{ID: "failed-patch", Email: "gunwoo@gunwoo.org", AboutMe: "Codercat"}
+	if _, err := f.PostProfile(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+
+	patch := JSONPatch{
+		{Op: "remove", Path: "/aboutMe"},
+		{Op: "test", Path: "/email", Value: []byte(`"ben@gunwoo.org"`)},
+	}
+	if _, err := f.PatchProfile(ctx, p.ID, patch); ErrorCode(err) != Conflict {
+		t.Errorf("PatchProfile: got %v, want %v", err, Conflict)
+	}
+	got, err := f.GetProfile(ctx, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.AboutMe != p.AboutMe || got.Revision != 1 {
+		t.Errorf("PatchProfile: failed patch changed the profile to %v", got)
+	}
+}
+
+func TestFakeServiceEmailUniqueness(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeService()
+	p := &Profile{ID: "unique", Email: "unique@gunwoo.org"}
+	if _, err := f.PostProfile(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := f.LookupProfile(ctx, "Unique@Gunwoo.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != p.ID {
+		t.Errorf("LookupProfile: got %v, want %v", got, p)
+	}
+	if _, err := f.LookupProfile(ctx, "nobody@gunwoo.org"); err != ErrNoSuchEntity {
+		t.Errorf("LookupProfile: got %v, want %v", err, ErrNoSuchEntity)
+	}
+
+	other := &Profile{ID: "other", Email: "UNIQUE@gunwoo.org"}
+	_, err = f.PostProfile(ctx, other)
+	if e, ok := err.(*Error); !ok || e.Code != Conflict || e.ExistingID != p.ID {
+		t.Errorf("PostProfile: got %v, want a conflict with %q", err, p.ID)
+	}
+
+	other.Email = "other@gunwoo.org"
+	if _, err := f.PostProfile(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.PatchProfile(ctx, other.ID, MergePatch{"email": p.Email}); ErrorCode(err) != Conflict {
+		t.Errorf("PatchProfile: got %v, want %v", err, Conflict)
+	}
+	if _, err := f.PatchProfile(ctx, p.ID, MergePatch{"email": "UNIQUE@gunwoo.org"}); err != nil {
+		t.Errorf("PatchProfile: changing the case of its own email: got %v, want nil", err)
+	}
+}
@@ -0,0 +1,146 @@
+package profile
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// FieldMask is a set of paths of fields of the JSON representation of a
+// profile, such as "displayName" or "name.givenName". A path to an object,
+// such as "name", includes all of its fields.
+type FieldMask []string
+
+// fieldPaths are the valid paths of a FieldMask.
+var fieldPaths = map[string]bool{
+	"id":              true,
+	"displayName":     true,
+	"name":            true,
+	"name.formatted":  true,
+	"name.familyName": true,
+	"name.givenName":  true,
+	"email":           true,
+	"imageUrl":        true,
+	"aboutMe":         true,
+	"revision":        true,
+	"updateTime":      true,
+	"identities":      true,
+}
+
+// ParseFieldMask parses a comma-separated list of field paths, such as
+// "displayName,name.givenName". An empty string is the empty mask.
+func ParseFieldMask(s string) (FieldMask, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var mask FieldMask
+	for _, path := range strings.Split(s, ",") {
+		mask = append(mask, strings.TrimSpace(path))
+	}
+	if err := mask.Validate(); err != nil {
+		return nil, err
+	}
+	return mask, nil
+}
+
+// Validate returns an InvalidArgument error if any of the paths of the mask
+// is not a field of a profile.
+func (m FieldMask) Validate() error {
+	for _, path := range m {
+		if !fieldPaths[path] {
+			return Errorf(InvalidArgument, "invalid field mask: unknown field %q", path)
+		}
+	}
+	return nil
+}
+
+// Project returns the JSON representation of the profile, which only
+// contains the fields of the mask, or all of them if the mask is empty.
+func (m FieldMask) Project(p *Profile) (map[string]interface{}, error) {
+	doc, err := profileDocument(p)
+	if err != nil || len(m) == 0 {
+		return doc, err
+	}
+	projection := map[string]interface{}{}
+	for _, path := range m {
+		copyField(projection, doc, strings.Split(path, "."))
+	}
+	return projection, nil
+}
+
+// copyField copies the field at the given path from src to dst, creating
+// the parent objects of the field in dst if necessary. A field missing from
+// src is removed from dst.
+func copyField(dst, src map[string]interface{}, path []string) {
+	for _, name := range path[:len(path)-1] {
+		child, ok := src[name].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+		}
+		src = child
+		if _, ok := dst[name].(map[string]interface{}); !ok {
+			dst[name] = map[string]interface{}{}
+		}
+		dst = dst[name].(map[string]interface{})
+	}
+	name := path[len(path)-1]
+	if value, ok := src[name]; ok {
+		dst[name] = value
+	} else {
+		delete(dst, name)
+	}
+}
+
+// Partial is a profile of which only the fields of the mask are encoded
+// into JSON, or all of them if the mask is empty.
+type Partial struct {
+	*Profile
+	Mask FieldMask
+}
+
+// MarshalJSON encodes the fields of the mask of the profile.
+func (p Partial) MarshalJSON() ([]byte, error) {
+	if len(p.Mask) == 0 {
+		return json.Marshal(p.Profile)
+	}
+	projection, err := p.Mask.Project(p.Profile)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(projection)
+}
+
+// MaskedPatch overwrites exactly the fields of the mask with the fields of
+// the profile, which clears the fields that are empty in the profile.
+type MaskedPatch struct {
+	Profile *Profile
+	Mask    FieldMask
+}
+
+// Apply applies the masked patch to the profile.
+func (patch MaskedPatch) Apply(p *Profile) error {
+	if len(patch.Mask) == 0 {
+		return Errorf(InvalidArgument, "invalid update mask: no fields")
+	}
+	if err := patch.Mask.Validate(); err != nil {
+		return err
+	}
+	for _, path := range patch.Mask {
+		for _, field := range immutableFields {
+			if path == field {
+				return immutableFieldError(field)
+			}
+		}
+	}
+	src, err := profileDocument(patch.Profile)
+	if err != nil {
+		return err
+	}
+	dst, err := profileDocument(p)
+	if err != nil {
+		return err
+	}
+	for _, path := range patch.Mask {
+		copyField(dst, src, strings.Split(path, "."))
+	}
+	return applyDocument(p, dst)
+}
@@ -0,0 +1,112 @@
+package profile
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseFieldMask(t *testing.T) {
+	tests := []struct {
+		s    string
+		want FieldMask
+		code Code
+	}{
+		{"", nil, Unknown},
+		{"displayName, name.givenName", FieldMask{"displayName", "name.givenName"}, Unknown},
+		{"displayName,unknown", nil, InvalidArgument},
+		{"displayName,", nil, InvalidArgument},
+	}
+	for _, tt := range tests {
+		got, err := ParseFieldMask(tt.s)
+		if code := ErrorCode(err); err != nil && code != tt.code || err == nil && tt.code != Unknown {
+			t.Errorf("ParseFieldMask(%q): got error %v, want %v", tt.s, err, tt.code)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseFieldMask(%q): got %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestPartialMarshalJSON(t *testing.T) {
+	p := newPatchTestProfile()
+	tests := []struct {
+		mask FieldMask
+		want string
+	}{
+		{
+			FieldMask{"displayName", "name.givenName"},
+			`{"displayName":"benkim0414","name":{"givenName":"Gunwoo"}}`,
+		},
+		{
+			FieldMask{"name", "name.givenName", "revision"},
+			`{"name":{"familyName":"Kim","formatted":"","givenName":"Gunwoo"},"revision":2}`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(Partial{Profile: p, Mask: tt.mask})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("MarshalJSON(%q): got %s, want %s", tt.mask, got, tt.want)
+		}
+	}
+
+	got, err := json.Marshal(Partial{Profile: p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("MarshalJSON: got %s, want %s", got, want)
+	}
+}
+
+func TestMaskedPatchApply(t *testing.T) {
+	tests := []struct {
+		patch MaskedPatch
+		want  func(p *Profile)
+		code  Code
+	}{
+		{
+			patch: MaskedPatch{Profile: &Profile{ImageURL: "https://gunwoo.org/gunwoo.png"}, Mask: FieldMask{"aboutMe", "imageUrl"}},
+			want: func(p *Profile) {
+				p.AboutMe, p.ImageURL = "", "https://gunwoo.org/gunwoo.png"
+			},
+		},
+		{
+			patch: MaskedPatch{Profile: &Profile{Name: Name{GivenName: "Ben"}}, Mask: FieldMask{"name.givenName"}},
+			want: func(p *Profile) {
+				p.Name.GivenName = "Ben"
+			},
+		},
+		{
+			patch: MaskedPatch{Profile: &Profile{}, Mask: FieldMask{"name"}},
+			want: func(p *Profile) {
+				p.Name = Name{}
+			},
+		},
+		{patch: MaskedPatch{Profile: &Profile{}}, code: InvalidArgument},
+		{patch: MaskedPatch{Profile: &Profile{}, Mask: FieldMask{"revision"}}, code: InvalidArgument},
+		{patch: MaskedPatch{Profile: &Profile{}, Mask: FieldMask{"unknown"}}, code: InvalidArgument},
+	}
+	for _, tt := range tests {
+		p := newPatchTestProfile()
+		err := tt.patch.Apply(p)
+		if code := ErrorCode(err); err != nil && code != tt.code || err == nil && tt.want == nil {
+			t.Errorf("Apply(%q): got error %v, want %v", tt.patch.Mask, err, tt.code)
+			continue
+		}
+		want := newPatchTestProfile()
+		if tt.want != nil {
+			tt.want(want)
+		}
+		if !reflect.DeepEqual(p, want) {
+			t.Errorf("Apply(%q): got %v, want %v", tt.patch.Mask, p, want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package profile
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// MaxSubjectLength is the maximum length of the subject of an identity in
+// bytes.
+const MaxSubjectLength = 256
+
+// Identity is an account of a person at an external service, such as
+// "google" or "github", which is linked to their profile. Every identity is
+// linked to at most one profile.
+type Identity struct {
+	// The name of the service, such as "github".
+	Provider string `json:"provider"`
+	// The id of the account at the service.
+	Subject string `json:"subject"`
+	// The time the identity was linked to the profile.
+	LinkedAt time.Time `json:"linkedAt"`
+	// Optional service-specific attributes of the account, such as a login.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// providerPattern restricts the names of providers, so that a provider and a
+// subject can be joined into a single unambiguous key.
+var providerPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]*$`)
+
+// validateIdentity returns an InvalidArgument error if the provider or the
+// subject of an identity is malformed.
+func validateIdentity(provider, subject string) error {
+	var violations []FieldViolation
+	if !providerPattern.MatchString(provider) {
+		violations = append(violations, FieldViolation{
+			Field:       "provider",
+			Description: "must be lowercase letters, digits, '.' and '-'",
+		})
+	}
+	if subject == "" || len(subject) > MaxSubjectLength {
+		violations = append(violations, FieldViolation{
+			Field:       "subject",
+			Description: fmt.Sprintf("must be 1 to %d bytes", MaxSubjectLength),
+		})
+	}
+	if len(violations) > 0 {
+		return InvalidFields("invalid identity", violations...)
+	}
+	return nil
+}
+
+// identityKey returns the key which identifies the owner of an identity.
+func identityKey(provider, subject string) string {
+	return provider + ":" + subject
+}
+
+// findIdentity returns the index of the identity in the profile, or -1.
+func (p *Profile) findIdentity(provider, subject string) int {
+	for i, identity := range p.Identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return i
+		}
+	}
+	return -1
+}
+
+// linkIdentity links the identity to the profile, or replaces its metadata if
+// it is already linked. The identities of the profile are copied, since they
+// may be shared with a stored profile.
+func (p *Profile) linkIdentity(identity Identity) {
+	identities := make([]Identity, len(p.Identities), len(p.Identities)+1)
+	copy(identities, p.Identities)
+	if i := p.findIdentity(identity.Provider, identity.Subject); i >= 0 {
+		identities[i].Metadata = identity.Metadata
+	} else {
+		if identity.LinkedAt.IsZero() {
+			identity.LinkedAt = time.Now().UTC()
+		}
+		identities = append(identities, identity)
+	}
+	p.Identities = identities
+}
+
+// unlinkIdentity unlinks the identity from the profile, and returns a
+// NotFound error if it is not linked.
+func (p *Profile) unlinkIdentity(provider, subject string) error {
+	i := p.findIdentity(provider, subject)
+	if i < 0 {
+		return Errorf(NotFound, "identity %s is not linked to the profile", identityKey(provider, subject))
+	}
+	identities := make([]Identity, 0, len(p.Identities)-1)
+	identities = append(identities, p.Identities[:i]...)
+	p.Identities = append(identities, p.Identities[i+1:]...)
+	return nil
+}
+
+// identityInUse returns the Conflict error for an identity which is already
+// linked to the profile with the given id.
+func identityInUse(id string) error {
+	return &Error{
+		Code:       Conflict,
+		Message:    "identity is already linked to profile " + id,
+		ExistingID: id,
+	}
+}
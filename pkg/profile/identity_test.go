@@ -0,0 +1,142 @@
+package profile
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+func TestValidateIdentity(t *testing.T) {
+	tests := []struct {
+		provider, subject string
+		valid             bool
+	}{
+		{"github", "1234", true},
+		{"accounts.google.com", "a:b/c", true},
+		{"", "1234", false},
+		{"GitHub", "1234", false},
+		{"git:hub", "1234", false},
+		{"github", "", false},
+	}
+	for _, tt := range tests {
+		err := validateIdentity(tt.provider, tt.subject)
+		if (err == nil) != tt.valid {
+			t.Errorf("validateIdentity(%q, %q): got %v, want valid %t", tt.provider, tt.subject, err, tt.valid)
+		}
+	}
+}
+
+func TestLinkIdentity(t *testing.T) {
+	linkedAt := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	stored := &Profile{Identities: []Identity{{Provider: "github", Subject: "1", LinkedAt: linkedAt}}}
+
+	p := *stored
+	p.linkIdentity(Identity{Provider: "github", Subject: "1", Metadata: map[string]string{"login": "benkim0414"}})
+	p.linkIdentity(Identity{Provider: "google", Subject: "2"})
+	if len(stored.Identities) != 1 || stored.Identities[0].Metadata != nil {
+		t.Errorf("linkIdentity: modified the identities of the stored profile: %v", stored.Identities)
+	}
+	if len(p.Identities) != 2 {
+		t.Fatalf("linkIdentity: got %d identities, want 2", len(p.Identities))
+	}
+	if got := p.Identities[0]; !got.LinkedAt.Equal(linkedAt) || got.Metadata["login"] != "benkim0414" {
+		t.Errorf("linkIdentity: got %v, want the metadata to be replaced", got)
+	}
+	if p.Identities[1].LinkedAt.IsZero() {
+		t.Errorf("linkIdentity: got zero LinkedAt for a new identity")
+	}
+
+	if err := p.unlinkIdentity("github", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Identities) != 1 || p.Identities[0].Provider != "google" {
+		t.Errorf("unlinkIdentity: got %v", p.Identities)
+	}
+	if err := p.unlinkIdentity("github", "1"); ErrorCode(err) != NotFound {
+		t.Errorf("unlinkIdentity: got %v, want %v", err, NotFound)
+	}
+}
+
+func TestProfileLoadSave(t *testing.T) {
+	p := &Profile{
+		Email:    "gunwoo@gunwoo.org",
+		Revision: 2,
+		Identities: []Identity{{
+			Provider: "github",
+			Subject:  "1",
+			LinkedAt: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+			Metadata: map[string]string{"login": "benkim0414"},
+		}},
+	}
+	props, err := p.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &Profile{}
+	if err := got.Load(props); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, p) {
+		t.Errorf("Load: got %v, want %v", got, p)
+	}
+
+	var _ datastore.PropertyLoadSaver = p
+}
+
+func TestFakeServiceIdentities(t *testing.T) {
+	ctx := context.Background()
+	p := &Profile{ID: "identities", Email: "identities@gunwoo.org"}
+	if _, err := FakeService.PostProfile(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	defer FakeService.DeleteProfile(ctx, p.ID)
+	other := &Profile{ID: "other-identities", Email: "other-identities@gunwoo.org"}
+	if _, err := FakeService.PostProfile(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	defer FakeService.DeleteProfile(ctx, other.ID)
+
+	identity := Identity{Provider: "github", Subject: "1234"}
+	got, err := FakeService.LinkIdentity(ctx, p.ID, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Identities) != 1 || got.Revision != 2 {
+		t.Errorf("LinkIdentity: got %v, want one identity with revision 2", got)
+	}
+
+	_, err = FakeService.LinkIdentity(ctx, other.ID, identity)
+	if e, ok := err.(*Error); !ok || e.Code != Conflict || e.ExistingID != p.ID {
+		t.Errorf("LinkIdentity: got %v, want a conflict with %q", err, p.ID)
+	}
+
+	got, err = FakeService.ResolveIdentity(ctx, "github", "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != p.ID {
+		t.Errorf("ResolveIdentity: got %v, want %v", got, p)
+	}
+
+	// identities survive replacing the profile, but can not be patched.
+	if _, err := FakeService.PutProfile(ctx, p.ID, &Profile{ID: p.ID, Email: p.Email}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FakeService.PatchProfile(ctx, p.ID, MergePatch{"identities": nil}); ErrorCode(err) != InvalidArgument {
+		t.Errorf("PatchProfile: got %v, want %v", err, InvalidArgument)
+	}
+
+	got, err = FakeService.UnlinkIdentity(ctx, p.ID, "github", "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Identities) != 0 {
+		t.Errorf("UnlinkIdentity: got %v, want no identities", got.Identities)
+	}
+	if _, err := FakeService.ResolveIdentity(ctx, "github", "1234"); err != ErrNoSuchEntity {
+		t.Errorf("ResolveIdentity: got %v, want %v", err, ErrNoSuchEntity)
+	}
+}
@@ -0,0 +1,154 @@
+package profile
+
+import (
+	"encoding/base64"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultPageSize is the number of profiles returned by ListProfiles
+	// when ListOptions.PageSize is not specified.
+	DefaultPageSize = 20
+	// MaxPageSize is the maximum number of profiles returned by a single
+	// ListProfiles call.
+	MaxPageSize = 100
+)
+
+// ListOptions specifies the optional parameters to ListProfiles.
+type ListOptions struct {
+	// The maximum number of profiles to return.
+	PageSize int `json:"pageSize"`
+	// The opaque token returned by a previous ListProfiles call as
+	// nextPageToken, used to retrieve the subsequent page.
+	PageToken string `json:"pageToken"`
+	// The field to order the results by, such as "email".
+	// A leading "-" sorts in descending order.
+	OrderBy string `json:"orderBy"`
+}
+
+// pageSize returns the effective page size of the options.
+func (o ListOptions) pageSize() (int, error) {
+	switch {
+	case o.PageSize < 0:
+		return 0, Errorf(InvalidArgument, "invalid page size %d", o.PageSize)
+	case o.PageSize == 0:
+		return DefaultPageSize, nil
+	case o.PageSize > MaxPageSize:
+		return MaxPageSize, nil
+	}
+	return o.PageSize, nil
+}
+
+// orderField describes a field which profiles can be ordered by.
+type orderField struct {
+	// The datastore property name of the field.
+	property string
+	// value returns the value of the field from the given profile.
+	value func(p *Profile) string
+}
+
+var orderFields = map[string]orderField{
+	"displayName": {
+		property: "DisplayName",
+		value:    func(p *Profile) string { return p.DisplayName },
+	},
+	"email": {
+		property: "Email",
+		value:    func(p *Profile) string { return p.Email },
+	},
+	"name.familyName": {
+		property: "Name.FamilyName",
+		value:    func(p *Profile) string { return p.Name.FamilyName },
+	},
+	"name.givenName": {
+		property: "Name.GivenName",
+		value:    func(p *Profile) string { return p.Name.GivenName },
+	},
+}
+
+// parseOrderBy parses the OrderBy option into the field to order by and
+// whether the order is descending. The zero orderField is returned if
+// orderBy is empty, which means the backend's natural (key) order.
+func parseOrderBy(orderBy string) (field orderField, desc bool, err error) {
+	if orderBy == "" {
+		return orderField{}, false, nil
+	}
+	name := orderBy
+	if strings.HasPrefix(name, "-") {
+		name, desc = name[1:], true
+	}
+	field, ok := orderFields[name]
+	if !ok {
+		return orderField{}, false, Errorf(InvalidArgument, "invalid order by field %q", orderBy)
+	}
+	return field, desc, nil
+}
+
+// encodeOffsetToken encodes an offset into an opaque page token.
+func encodeOffsetToken(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+// decodeOffsetToken decodes a page token produced by encodeOffsetToken.
+// An empty token decodes to the zero offset.
+func decodeOffsetToken(token string) (int, error) {
+	if token == "" {
+		return 0, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, Errorf(InvalidArgument, "invalid page token %q", token)
+	}
+	offset, err := strconv.Atoi(string(b))
+	if err != nil || offset < 0 {
+		return 0, Errorf(InvalidArgument, "invalid page token %q", token)
+	}
+	return offset, nil
+}
+
+// queryProfiles returns a page of the profiles matching all of the filters,
+// in the order of the options, along with the token to the next page. It
+// implements ListProfiles and SearchProfiles for the backends which hold
+// all of the profiles in memory, and sorts the given slice in place.
+func queryProfiles(profiles []*Profile, filters []searchFilter, opts ListOptions) ([]*Profile, string, error) {
+	pageSize, err := opts.pageSize()
+	if err != nil {
+		return nil, "", err
+	}
+	field, desc, err := parseOrderBy(opts.OrderBy)
+	if err != nil {
+		return nil, "", err
+	}
+	offset, err := decodeOffsetToken(opts.PageToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	matched := profiles[:0]
+	for _, p := range profiles {
+		if match(filters, p) {
+			matched = append(matched, p)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if field.value != nil && field.value(a) != field.value(b) {
+			if desc {
+				return field.value(a) > field.value(b)
+			}
+			return field.value(a) < field.value(b)
+		}
+		return a.ID < b.ID
+	})
+
+	if offset >= len(matched) {
+		return []*Profile{}, "", nil
+	}
+	end := offset + pageSize
+	if end >= len(matched) {
+		return matched[offset:], "", nil
+	}
+	return matched[offset:end], encodeOffsetToken(end), nil
+}
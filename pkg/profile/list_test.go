@@ -0,0 +1,66 @@
+package profile
+
+import "testing"
+
+func TestListOptionsPageSize(t *testing.T) {
+	tests := []struct {
+		pageSize int
+		want     int
+		wantErr  bool
+	}{
+		{0, DefaultPageSize, false},
+		{10, 10, false},
+		{MaxPageSize + 1, MaxPageSize, false},
+		{-1, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ListOptions{PageSize: tt.pageSize}.pageSize()
+		if got != tt.want {
+			t.Errorf("pageSize(%d): got %d, want %d", tt.pageSize, got, tt.want)
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("pageSize(%d): unexpected error %v", tt.pageSize, err)
+		}
+	}
+}
+
+func TestParseOrderBy(t *testing.T) {
+	tests := []struct {
+		orderBy  string
+		property string
+		desc     bool
+		wantErr  bool
+	}{
+		{"", "", false, false},
+		{"email", "Email", false, false},
+		{"-name.familyName", "Name.FamilyName", true, false},
+		{"aboutMe", "", false, true},
+	}
+	for _, tt := range tests {
+		field, desc, err := parseOrderBy(tt.orderBy)
+		if field.property != tt.property || desc != tt.desc {
+			t.Errorf("parseOrderBy(%q): got (%q, %t), want (%q, %t)", tt.orderBy, field.property, desc, tt.property, tt.desc)
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseOrderBy(%q): unexpected error %v", tt.orderBy, err)
+		}
+	}
+}
+
+func TestOffsetToken(t *testing.T) {
+	for _, offset := range []int{0, 1, 20, 1000} {
+		got, err := decodeOffsetToken(encodeOffsetToken(offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != offset {
+			t.Errorf("decodeOffsetToken: got %d, want %d", got, offset)
+		}
+	}
+
+	for _, token := range []string{"invalid!", encodeOffsetToken(-1)} {
+		if _, err := decodeOffsetToken(token); err == nil {
+			t.Errorf("decodeOffsetToken(%q): error should not be nil", token)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package profile
+
+import (
+	"os"
+	"testing"
+
+	"github.com/benkim0414/superego/internal/testutil"
+)
+
+// TestMain stops the Datastore emulator and the PostgreSQL cluster started by
+// the datastore and postgres tests.
+func TestMain(m *testing.M) {
+	os.Exit(testutil.Main(m))
+}
@@ -0,0 +1,508 @@
+package profile
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("memory", func(context.Context, Config) (Service, func() error, error) {
+		return NewMemoryService(), func() error { return nil }, nil
+	})
+}
+
+// MemoryService is a Service which keeps the profiles in memory, with the
+// same semantics as the datastore service: PostProfile generates the ids,
+// email addresses and identities are unique across profiles, and merged
+// profiles redirect to their survivors. Profiles are copied in and out, so
+// callers never share them with the store. It is safe for concurrent use.
+type MemoryService struct {
+	mu sync.RWMutex
+	// journal records the mutations before they are applied, if it is set.
+	journal journal
+
+	profiles  map[string]*Profile
+	redirects map[string]string
+	// emails maps the emailKey of addresses to the ids of their profiles.
+	emails map[string]string
+	// identities maps the identityKey of identities to the ids of their
+	// profiles.
+	identities map[string]string
+}
+
+// NewMemoryService returns an empty MemoryService.
+func NewMemoryService() *MemoryService {
+	s := &MemoryService{}
+	s.reset()
+	return s
+}
+
+// mutation is a change of the state of a MemoryService, which is recorded by
+// its journal before it is applied.
+type mutation struct {
+	// Reset deletes all of the profiles and redirects first.
+	Reset bool `json:"reset,omitempty"`
+	// Delete are the ids of the deleted profiles.
+	Delete []string `json:"delete,omitempty"`
+	// Put are the stored profiles, which replace the redirects at their ids.
+	Put []*Profile `json:"put,omitempty"`
+	// Redirects maps the ids of merged profiles to their survivors.
+	Redirects map[string]string `json:"redirects,omitempty"`
+}
+
+// journal records the mutations of a MemoryService, such as the log of the
+// disk backend. Its methods are called with the lock of the service held.
+type journal interface {
+	// record records the mutation, which is only applied if it succeeds.
+	record(m *mutation) error
+	// applied is called once a recorded mutation has been applied.
+	applied()
+}
+
+// MemorySnapshot is a copy of the state of a MemoryService, which can be
+// encoded as JSON.
+type MemorySnapshot struct {
+	// Profiles are the stored profiles, ordered by id.
+	Profiles []*Profile `json:"profiles"`
+	// Redirects maps the ids of merged profiles to their survivors.
+	Redirects map[string]string `json:"redirects,omitempty"`
+}
+
+// Snapshot returns a copy of the state of the service.
+func (s *MemoryService) Snapshot() MemorySnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.snapshot()
+}
+
+// snapshot returns a copy of the state of the service. s.mu must be held.
+func (s *MemoryService) snapshot() MemorySnapshot {
+	snapshot := MemorySnapshot{
+		Profiles:  make([]*Profile, 0, len(s.profiles)),
+		Redirects: make(map[string]string, len(s.redirects)),
+	}
+	for _, p := range s.profiles {
+		snapshot.Profiles = append(snapshot.Profiles, p.clone())
+	}
+	sort.Slice(snapshot.Profiles, func(i, j int) bool {
+		return snapshot.Profiles[i].ID < snapshot.Profiles[j].ID
+	})
+	for id, survivorID := range s.redirects {
+		snapshot.Redirects[id] = survivorID
+	}
+	return snapshot
+}
+
+// Restore replaces the state of the service with the snapshot. It returns an
+// InvalidArgument error, and leaves the state unchanged, if the snapshot has
+// a profile without an id, or profiles which share an id, an email address
+// or an identity.
+func (s *MemoryService) Restore(snapshot MemorySnapshot) error {
+	restored := NewMemoryService()
+	m := &mutation{Reset: true, Redirects: snapshot.Redirects}
+	for _, p := range snapshot.Profiles {
+		if p.ID == "" {
+			return Errorf(InvalidArgument, "memory: snapshot has a Profile without id")
+		}
+		if _, ok := restored.profiles[p.ID]; ok {
+			return Errorf(InvalidArgument, "memory: snapshot has Profile %s twice", p.ID)
+		}
+		if err := restored.checkEmail(p.ID, p.Email); err != nil {
+			return &Error{Code: InvalidArgument, Message: "memory: invalid snapshot", Err: err}
+		}
+		for _, identity := range p.Identities {
+			if ownerID, ok := restored.identities[identityKey(identity.Provider, identity.Subject)]; ok {
+				return &Error{Code: InvalidArgument, Message: "memory: invalid snapshot", Err: identityInUse(ownerID)}
+			}
+		}
+		p = p.clone()
+		restored.store(nil, p)
+		m.Put = append(m.Put, p)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commit(m)
+}
+
+// Reset deletes all of the profiles.
+func (s *MemoryService) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commit(&mutation{Reset: true})
+}
+
+func (s *MemoryService) reset() {
+	s.profiles = map[string]*Profile{}
+	s.redirects = map[string]string{}
+	s.emails = map[string]string{}
+	s.identities = map[string]string{}
+}
+
+func (s *MemoryService) PostProfile(ctx context.Context, p *Profile) (*Profile, error) {
+	id, err := newProfileID()
+	if err != nil {
+		return nil, err
+	}
+	created := p.clone()
+	created.ID = id
+	// identities can only be linked to an existing profile.
+	created.Identities = nil
+	created.Revision = 1
+	created.UpdateTime = time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkEmail(id, created.Email); err != nil {
+		return nil, err
+	}
+	if err := s.commit(&mutation{Put: []*Profile{created}}); err != nil {
+		return nil, err
+	}
+	return created.clone(), nil
+}
+
+func (s *MemoryService) GetProfile(_ context.Context, id string) (*Profile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p := s.get(id)
+	if p == nil {
+		return nil, ErrNoSuchEntity
+	}
+	return p.clone(), nil
+}
+
+func (s *MemoryService) GetProfiles(_ context.Context, ids []string) ([]*Profile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	profiles := make([]*Profile, len(ids))
+	for i, id := range ids {
+		if p := s.get(id); p != nil {
+			profiles[i] = p.clone()
+		}
+	}
+	return profiles, nil
+}
+
+func (s *MemoryService) PutProfile(ctx context.Context, id string, p *Profile) (*Profile, error) {
+	if id == "" {
+		return nil, Errorf(InvalidArgument, "memory: invalid Profile id")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// PUT creates the profile if it does not exist yet, whose revision is
+	// zero.
+	existing := s.profiles[id]
+	var revision int64
+	var identities []Identity
+	if existing != nil {
+		revision, identities = existing.Revision, existing.clone().Identities
+	}
+	if err := checkRevision(ctx, revision); err != nil {
+		return nil, err
+	}
+	if err := s.checkEmail(id, p.Email); err != nil {
+		return nil, err
+	}
+	put := p.clone()
+	put.ID = id
+	put.Identities = identities
+	put.Revision = revision + 1
+	put.UpdateTime = time.Now().UTC()
+	// a profile put at the id of a merged profile replaces its redirect.
+	if err := s.commit(&mutation{Put: []*Profile{put}}); err != nil {
+		return nil, err
+	}
+	return put.clone(), nil
+}
+
+func (s *MemoryService) PatchProfile(ctx context.Context, id string, patch Patch) (*Profile, error) {
+	return s.update(ctx, id, func(p *Profile) error {
+		if err := patch.Apply(p); err != nil {
+			return err
+		}
+		p.ID = id
+		return s.checkEmail(id, p.Email)
+	})
+}
+
+func (s *MemoryService) DeleteProfile(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.profiles[id]
+	if !ok {
+		return ErrNoSuchEntity
+	}
+	if err := checkRevision(ctx, existing.Revision); err != nil {
+		return err
+	}
+	return s.commit(&mutation{Delete: []string{id}})
+}
+
+func (s *MemoryService) LookupProfile(_ context.Context, email string) (*Profile, error) {
+	if emailKey(email) == "" {
+		return nil, Errorf(InvalidArgument, "empty email")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.emails[emailKey(email)]
+	if !ok {
+		return nil, ErrNoSuchEntity
+	}
+	return s.profiles[id].clone(), nil
+}
+
+func (s *MemoryService) LinkIdentity(ctx context.Context, id string, identity Identity) (*Profile, error) {
+	if err := validateIdentity(identity.Provider, identity.Subject); err != nil {
+		return nil, err
+	}
+	return s.update(ctx, id, func(p *Profile) error {
+		if ownerID, ok := s.identities[identityKey(identity.Provider, identity.Subject)]; ok && ownerID != id {
+			return identityInUse(ownerID)
+		}
+		p.linkIdentity(identity)
+		return nil
+	})
+}
+
+func (s *MemoryService) UnlinkIdentity(ctx context.Context, id string, provider, subject string) (*Profile, error) {
+	return s.update(ctx, id, func(p *Profile) error {
+		return p.unlinkIdentity(provider, subject)
+	})
+}
+
+func (s *MemoryService) ResolveIdentity(_ context.Context, provider, subject string) (*Profile, error) {
+	if err := validateIdentity(provider, subject); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.identities[identityKey(provider, subject)]
+	if !ok {
+		return nil, ErrNoSuchEntity
+	}
+	return s.profiles[id].clone(), nil
+}
+
+func (s *MemoryService) MergeProfiles(ctx context.Context, survivorID, victimID string, strategy MergeStrategy) (*Profile, error) {
+	if err := validateMerge(survivorID, victimID); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	survivor, ok := s.profiles[survivorID]
+	if !ok {
+		return nil, ErrNoSuchEntity
+	}
+	victim, ok := s.profiles[victimID]
+	if !ok {
+		return nil, ErrNoSuchEntity
+	}
+	if err := checkRevision(ctx, survivor.Revision); err != nil {
+		return nil, err
+	}
+	merged, err := strategy.merge(survivor.clone(), victim.clone())
+	if err != nil {
+		return nil, err
+	}
+	merged.Revision++
+	merged.UpdateTime = time.Now().UTC()
+
+	// the victim is deleted first, so that its email address and identities
+	// are released to the survivor.
+	err = s.commit(&mutation{
+		Delete:    []string{victimID},
+		Put:       []*Profile{merged},
+		Redirects: map[string]string{victimID: survivorID},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return merged.clone(), nil
+}
+
+func (s *MemoryService) ListProfiles(_ context.Context, opts ListOptions) ([]*Profile, string, error) {
+	return s.query(nil, opts)
+}
+
+func (s *MemoryService) SearchProfiles(_ context.Context, query SearchQuery, opts ListOptions) ([]*Profile, string, error) {
+	filters, err := query.filters()
+	if err != nil {
+		return nil, "", err
+	}
+	if opts, err = searchOptions(filters, opts); err != nil {
+		return nil, "", err
+	}
+	return s.query(filters, opts)
+}
+
+func (s *MemoryService) query(filters []searchFilter, opts ListOptions) ([]*Profile, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	profiles := make([]*Profile, 0, len(s.profiles))
+	for _, p := range s.profiles {
+		profiles = append(profiles, p)
+	}
+	page, token, err := queryProfiles(profiles, filters, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	for i, p := range page {
+		page[i] = p.clone()
+	}
+	return page, token, nil
+}
+
+// update applies f to a copy of the profile with the given id, and stores
+// the copy with the next revision if f succeeds.
+func (s *MemoryService) update(ctx context.Context, id string, f func(p *Profile) error) (*Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.profiles[id]
+	if !ok {
+		return nil, ErrNoSuchEntity
+	}
+	if err := checkRevision(ctx, existing.Revision); err != nil {
+		return nil, err
+	}
+	updated := existing.clone()
+	if err := f(updated); err != nil {
+		return nil, err
+	}
+	updated.Revision++
+	updated.UpdateTime = time.Now().UTC()
+	if err := s.commit(&mutation{Put: []*Profile{updated}}); err != nil {
+		return nil, err
+	}
+	return updated.clone(), nil
+}
+
+// commit records the mutation in the journal, if any, and applies it.
+// s.mu must be held.
+func (s *MemoryService) commit(m *mutation) error {
+	if s.journal != nil {
+		if err := s.journal.record(m); err != nil {
+			return err
+		}
+	}
+	s.apply(m)
+	if s.journal != nil {
+		s.journal.applied()
+	}
+	return nil
+}
+
+// apply applies the mutation, whose profiles are stored without copies.
+// s.mu must be held.
+func (s *MemoryService) apply(m *mutation) {
+	if m.Reset {
+		s.reset()
+	}
+	for _, id := range m.Delete {
+		if existing, ok := s.profiles[id]; ok {
+			s.store(existing, nil)
+		}
+	}
+	for _, p := range m.Put {
+		s.store(s.profiles[p.ID], p)
+		delete(s.redirects, p.ID)
+	}
+	for id, survivorID := range m.Redirects {
+		s.redirects[id] = survivorID
+	}
+}
+
+// get returns the stored profile with the given id, following the redirects
+// of merged profiles, or nil. s.mu must be held.
+func (s *MemoryService) get(id string) *Profile {
+	for redirects := 0; ; redirects++ {
+		if p, ok := s.profiles[id]; ok {
+			return p
+		}
+		survivorID, ok := s.redirects[id]
+		if !ok || redirects == maxRedirects {
+			return nil
+		}
+		id = survivorID
+	}
+}
+
+// store replaces the stored profile old, if it is not nil, with p, if it is
+// not nil, and updates the indexes of email addresses and identities.
+// s.mu must be held.
+func (s *MemoryService) store(old, p *Profile) {
+	if old != nil {
+		if s.emails[emailKey(old.Email)] == old.ID {
+			delete(s.emails, emailKey(old.Email))
+		}
+		for _, identity := range old.Identities {
+			delete(s.identities, identityKey(identity.Provider, identity.Subject))
+		}
+		delete(s.profiles, old.ID)
+	}
+	if p != nil {
+		if key := emailKey(p.Email); key != "" {
+			s.emails[key] = p.ID
+		}
+		for _, identity := range p.Identities {
+			s.identities[identityKey(identity.Provider, identity.Subject)] = p.ID
+		}
+		s.profiles[p.ID] = p
+	}
+}
+
+// checkEmail returns a Conflict error if the email address is used by any
+// profile other than the one with the given id. s.mu must be held.
+func (s *MemoryService) checkEmail(id, email string) error {
+	if ownerID, ok := s.emails[emailKey(email)]; ok && ownerID != id {
+		return emailInUse(ownerID)
+	}
+	return nil
+}
+
+// newProfileID returns a random id for a profile.
+func newProfileID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", &Error{Code: Unavailable, Message: "could not generate Profile id", Err: err}
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// clone returns a deep copy of the profile, which shares no identities or
+// metadata with it.
+func (p *Profile) clone() *Profile {
+	c := *p
+	if p.Identities != nil {
+		c.Identities = make([]Identity, len(p.Identities))
+		for i, identity := range p.Identities {
+			if identity.Metadata != nil {
+				metadata := make(map[string]string, len(identity.Metadata))
+				for k, v := range identity.Metadata {
+					metadata[k] = v
+				}
+				identity.Metadata = metadata
+			}
+			c.Identities[i] = identity
+		}
+	}
+	return &c
+}
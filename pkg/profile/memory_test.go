@@ -0,0 +1,210 @@
+package profile
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMemoryService(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryService()
+
+	p := &Profile{ID: "chosen", DisplayName: "gunwoo", Email: "gunwoo@gunwoo.org"}
+	got, err := s.PostProfile(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID == "" || got.ID == "chosen" || got.Revision != 1 || got.UpdateTime.IsZero() {
+		t.Errorf("PostProfile: got %+v, want a generated id at revision 1", got)
+	}
+	if p.ID != "chosen" || p.Revision != 0 {
+		t.Errorf("PostProfile: the profile of the caller was modified: %+v", p)
+	}
+	other, err := s.PostProfile(ctx, &Profile{Email: "other@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ID == got.ID {
+		t.Errorf("PostProfile: got the id %s twice", got.ID)
+	}
+	if _, err := s.PostProfile(ctx, &Profile{Email: "GUNWOO@gunwoo.org"}); ErrorCode(err) != Conflict {
+		t.Errorf("PostProfile: got %v, want a Conflict error for a used email", err)
+	}
+
+	// returned profiles are copies.
+	got.DisplayName = "modified"
+	if stored, _ := s.GetProfile(ctx, got.ID); stored.DisplayName != "gunwoo" {
+		t.Errorf("GetProfile: got %q, want the stored profile unchanged", stored.DisplayName)
+	}
+
+	patched, err := s.PatchProfile(ctx, got.ID, &Profile{AboutMe: "Codercat"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.DisplayName != "gunwoo" || patched.Email != "gunwoo@gunwoo.org" || patched.AboutMe != "Codercat" || patched.Revision != 2 {
+		t.Errorf("PatchProfile: got %+v, want the patched profile at revision 2", patched)
+	}
+	if _, err := s.PatchProfile(ctx, other.ID, MergePatch{"email": "gunwoo@gunwoo.org"}); ErrorCode(err) != Conflict {
+		t.Errorf("PatchProfile: got %v, want a Conflict error for a used email", err)
+	}
+	if _, err := s.PatchProfile(WithExpectedRevision(ctx, 1), got.ID, &Profile{AboutMe: "stale"}); ErrorCode(err) != FailedPrecondition {
+		t.Errorf("PatchProfile: got %v, want a FailedPrecondition error for a stale revision", err)
+	}
+
+	put, err := s.PutProfile(ctx, got.ID, &Profile{Email: "ben@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if put.ID != got.ID || put.AboutMe != "" || put.Revision != 3 {
+		t.Errorf("PutProfile: got %+v, want the replaced profile at revision 3", put)
+	}
+	// the email address of the replaced profile is released.
+	if _, err := s.PutProfile(ctx, other.ID, &Profile{Email: "gunwoo@gunwoo.org"}); err != nil {
+		t.Errorf("PutProfile: error should be nil, not %v", err)
+	}
+	if lookup, err := s.LookupProfile(ctx, "Ben@gunwoo.org"); err != nil || lookup.ID != got.ID {
+		t.Errorf("LookupProfile: got %v, %v, want %s", lookup, err, got.ID)
+	}
+
+	if err := s.DeleteProfile(ctx, got.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetProfile(ctx, got.ID); err != ErrNoSuchEntity {
+		t.Errorf("GetProfile: got %v, want %v", err, ErrNoSuchEntity)
+	}
+	if _, err := s.LookupProfile(ctx, "ben@gunwoo.org"); err != ErrNoSuchEntity {
+		t.Errorf("LookupProfile: got %v, want %v", err, ErrNoSuchEntity)
+	}
+	if err := s.DeleteProfile(ctx, got.ID); err != ErrNoSuchEntity {
+		t.Errorf("DeleteProfile: got %v, want %v", err, ErrNoSuchEntity)
+	}
+}
+
+func TestMemoryServiceMergeProfiles(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryService()
+
+	survivor, _ := s.PostProfile(ctx, &Profile{DisplayName: "gunwoo"})
+	victim, _ := s.PostProfile(ctx, &Profile{Email: "gunwoo@gunwoo.org"})
+	if _, err := s.LinkIdentity(ctx, victim.ID, Identity{Provider: "github", Subject: "1", Metadata: map[string]string{"login": "benkim0414"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LinkIdentity(ctx, survivor.ID, Identity{Provider: "github", Subject: "1"}); ErrorCode(err) != Conflict {
+		t.Errorf("LinkIdentity: got %v, want a Conflict error for a linked identity", err)
+	}
+
+	merged, err := s.MergeProfiles(ctx, survivor.ID, victim.ID, MergeStrategy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.ID != survivor.ID || merged.DisplayName != "gunwoo" || merged.Email != "gunwoo@gunwoo.org" || len(merged.Identities) != 1 {
+		t.Errorf("MergeProfiles: got %+v, want the survivor with the email and the identity of the victim", merged)
+	}
+	if got, err := s.GetProfile(ctx, victim.ID); err != nil || got.ID != survivor.ID {
+		t.Errorf("GetProfile: got %v, %v, want the survivor", got, err)
+	}
+	if got, err := s.ResolveIdentity(ctx, "github", "1"); err != nil || got.ID != survivor.ID {
+		t.Errorf("ResolveIdentity: got %v, %v, want the survivor", got, err)
+	}
+	if got, err := s.LookupProfile(ctx, "gunwoo@gunwoo.org"); err != nil || got.ID != survivor.ID {
+		t.Errorf("LookupProfile: got %v, %v, want the survivor", got, err)
+	}
+
+	// the metadata of returned identities is a copy.
+	merged.Identities[0].Metadata["login"] = "modified"
+	if got, _ := s.GetProfile(ctx, survivor.ID); got.Identities[0].Metadata["login"] != "benkim0414" {
+		t.Errorf("GetProfile: got %v, want the stored identity unchanged", got.Identities)
+	}
+
+	unlinked, err := s.UnlinkIdentity(ctx, survivor.ID, "github", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unlinked.Identities) != 0 {
+		t.Errorf("UnlinkIdentity: got %v, want no identities", unlinked.Identities)
+	}
+	if _, err := s.ResolveIdentity(ctx, "github", "1"); err != ErrNoSuchEntity {
+		t.Errorf("ResolveIdentity: got %v, want %v", err, ErrNoSuchEntity)
+	}
+}
+
+func TestMemoryServiceListProfiles(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryService()
+	for _, email := range []string{"c@gunwoo.org", "a@gunwoo.org", "b@gunwoo.org"} {
+		if _, err := s.PostProfile(ctx, &Profile{Email: email}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var emails []string
+	opts := ListOptions{PageSize: 2, OrderBy: "email"}
+	for {
+		profiles, next, err := s.ListProfiles(ctx, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range profiles {
+			emails = append(emails, p.Email)
+		}
+		if next == "" {
+			break
+		}
+		opts.PageToken = next
+	}
+	if want := []string{"a@gunwoo.org", "b@gunwoo.org", "c@gunwoo.org"}; !reflect.DeepEqual(emails, want) {
+		t.Errorf("ListProfiles: got %v, want %v", emails, want)
+	}
+
+	profiles, _, err := s.SearchProfiles(ctx, SearchQuery{EmailPrefix: "b"}, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 1 || profiles[0].Email != "b@gunwoo.org" {
+		t.Errorf("SearchProfiles: got %v, want b@gunwoo.org", profiles)
+	}
+}
+
+func TestMemoryServiceSnapshot(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryService()
+	survivor, _ := s.PostProfile(ctx, &Profile{Email: "survivor@gunwoo.org"})
+	victim, _ := s.PostProfile(ctx, &Profile{Email: "victim@gunwoo.org"})
+	if _, err := s.MergeProfiles(ctx, survivor.ID, victim.ID, MergeStrategy{}); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := s.Snapshot()
+
+	if _, err := s.PostProfile(ctx, &Profile{Email: "after@gunwoo.org"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Snapshot(); !reflect.DeepEqual(got, snapshot) {
+		t.Errorf("Snapshot: got %+v, want %+v", got, snapshot)
+	}
+	if _, err := s.LookupProfile(ctx, "after@gunwoo.org"); err != ErrNoSuchEntity {
+		t.Errorf("LookupProfile: got %v, want %v after Restore", err, ErrNoSuchEntity)
+	}
+	if got, err := s.GetProfile(ctx, victim.ID); err != nil || got.ID != survivor.ID {
+		t.Errorf("GetProfile: got %v, %v, want the survivor after Restore", got, err)
+	}
+
+	invalid := MemorySnapshot{Profiles: []*Profile{
+		{ID: "a", Email: "same@gunwoo.org"},
+		{ID: "b", Email: "SAME@gunwoo.org"},
+	}}
+	if err := s.Restore(invalid); ErrorCode(err) != InvalidArgument {
+		t.Errorf("Restore: got %v, want an InvalidArgument error", err)
+	}
+	if got := s.Snapshot(); !reflect.DeepEqual(got, snapshot) {
+		t.Errorf("Snapshot: got %+v, want the state unchanged by a failed Restore", got)
+	}
+
+	s.Reset()
+	if got := s.Snapshot(); len(got.Profiles) != 0 || len(got.Redirects) != 0 {
+		t.Errorf("Snapshot: got %+v, want no profiles after Reset", got)
+	}
+}
@@ -0,0 +1,160 @@
+package profile
+
+import (
+	"fmt"
+	"sort"
+)
+
+// MergePolicy decides which of two merged profiles wins a field for which
+// both of them have different, non-empty values.
+type MergePolicy string
+
+const (
+	// PreferSurvivor keeps the values of the surviving profile.
+	PreferSurvivor MergePolicy = "preferSurvivor"
+	// PreferNewest takes the values of the most recently updated profile.
+	PreferNewest MergePolicy = "preferNewest"
+	// Explicit requires a choice in MergeStrategy.Fields for every
+	// conflicting field, and fails the merge otherwise.
+	Explicit MergePolicy = "explicit"
+)
+
+// MergeSource is one of the two merged profiles.
+type MergeSource string
+
+const (
+	// Survivor is the profile which the other profile is merged into.
+	Survivor MergeSource = "survivor"
+	// Victim is the profile which is merged into the other profile, and
+	// deleted.
+	Victim MergeSource = "victim"
+)
+
+// MergeStrategy describes how MergeProfiles combines the fields of two
+// profiles. A field which is empty in one of the profiles takes the value of
+// the other; the policy only decides between different, non-empty values,
+// unless the field has an explicit choice.
+type MergeStrategy struct {
+	// The policy for conflicting fields. It defaults to PreferSurvivor.
+	Policy MergePolicy `json:"policy,omitempty"`
+	// Explicit choices by field path, such as "aboutMe" or "name.givenName",
+	// which take precedence over the policy, even if the chosen value is
+	// empty.
+	Fields map[string]MergeSource `json:"fields,omitempty"`
+}
+
+// mergeFields are the fields of a profile which are combined by a merge, by
+// their paths.
+var mergeFields = []struct {
+	path  string
+	value func(p *Profile) *string
+}{
+	{"displayName", func(p *Profile) *string { return &p.DisplayName }},
+	{"name.formatted", func(p *Profile) *string { return &p.Name.Formatted }},
+	{"name.familyName", func(p *Profile) *string { return &p.Name.FamilyName }},
+	{"name.givenName", func(p *Profile) *string { return &p.Name.GivenName }},
+	{"email", func(p *Profile) *string { return &p.Email }},
+	{"imageUrl", func(p *Profile) *string { return &p.ImageURL }},
+	{"aboutMe", func(p *Profile) *string { return &p.AboutMe }},
+}
+
+// Validate returns an InvalidArgument error if the policy or any of the
+// choices of the strategy is unknown.
+func (s MergeStrategy) Validate() error {
+	var violations []FieldViolation
+	switch s.Policy {
+	case "", PreferSurvivor, PreferNewest, Explicit:
+	default:
+		violations = append(violations, FieldViolation{
+			Field:       "policy",
+			Description: fmt.Sprintf("must be one of %s, %s or %s, not %q", PreferSurvivor, PreferNewest, Explicit, s.Policy),
+		})
+	}
+	for _, path := range sortedKeys(s.Fields) {
+		if !isMergeField(path) {
+			violations = append(violations, FieldViolation{Field: "fields." + path, Description: "is not a mergeable field"})
+			continue
+		}
+		if source := s.Fields[path]; source != Survivor && source != Victim {
+			violations = append(violations, FieldViolation{
+				Field:       "fields." + path,
+				Description: fmt.Sprintf("must be %s or %s, not %q", Survivor, Victim, source),
+			})
+		}
+	}
+	if len(violations) > 0 {
+		return InvalidFields("invalid merge strategy", violations...)
+	}
+	return nil
+}
+
+// merge returns the survivor combined with the victim according to the
+// strategy, with the identities of both. The id, the revision and the update
+// time of the survivor are kept.
+func (s MergeStrategy) merge(survivor, victim *Profile) (*Profile, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	merged := *survivor
+	var conflicts []FieldViolation
+	for _, field := range mergeFields {
+		value, other := field.value(&merged), *field.value(victim)
+		switch source, ok := s.Fields[field.path]; {
+		case ok:
+			if source == Victim {
+				*value = other
+			}
+		case *value == other || other == "":
+		case *value == "":
+			*value = other
+		case s.Policy == PreferNewest:
+			if victim.UpdateTime.After(survivor.UpdateTime) {
+				*value = other
+			}
+		case s.Policy == Explicit:
+			conflicts = append(conflicts, FieldViolation{Field: "fields." + field.path, Description: "conflicts and requires a choice"})
+		}
+	}
+	if len(conflicts) > 0 {
+		return nil, InvalidFields("merge conflict", conflicts...)
+	}
+
+	merged.Identities = append([]Identity(nil), survivor.Identities...)
+	for _, identity := range victim.Identities {
+		if merged.findIdentity(identity.Provider, identity.Subject) < 0 {
+			merged.Identities = append(merged.Identities, identity)
+		}
+	}
+	return &merged, nil
+}
+
+// validateMerge returns an InvalidArgument error if a profile is merged into
+// itself.
+func validateMerge(survivorID, victimID string) error {
+	if survivorID == victimID {
+		return InvalidFields("invalid merge", FieldViolation{Field: "victimId", Description: "must differ from the survivor"})
+	}
+	return nil
+}
+
+func isMergeField(path string) bool {
+	for _, field := range mergeFields {
+		if field.path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]MergeSource) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// maxRedirects is the maximum number of redirects GetProfile follows, so
+// that a chain of merges can not make it loop.
+const maxRedirects = 8
@@ -0,0 +1,140 @@
+package profile
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMergeStrategyMerge(t *testing.T) {
+	older := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	survivor := &Profile{
+		ID:          "survivor",
+		DisplayName: "gunwoo",
+		Email:       "survivor@gunwoo.org",
+		Revision:    3,
+		UpdateTime:  older,
+		Identities:  []Identity{{Provider: "github", Subject: "1"}},
+	}
+	victim := &Profile{
+		ID:          "victim",
+		DisplayName: "benkim0414",
+		Email:       "victim@gunwoo.org",
+		AboutMe:     "Codercat",
+		UpdateTime:  older.Add(time.Hour),
+		Identities:  []Identity{{Provider: "github", Subject: "1"}, {Provider: "google", Subject: "2"}},
+	}
+
+	tests := []struct {
+		strategy    MergeStrategy
+		displayName string
+		email       string
+	}{
+		{MergeStrategy{}, "gunwoo", "survivor@gunwoo.org"},
+		{MergeStrategy{Policy: PreferSurvivor}, "gunwoo", "survivor@gunwoo.org"},
+		{MergeStrategy{Policy: PreferNewest}, "benkim0414", "victim@gunwoo.org"},
+		{MergeStrategy{Policy: PreferNewest, Fields: map[string]MergeSource{"email": Survivor}}, "benkim0414", "survivor@gunwoo.org"},
+		{MergeStrategy{Policy: Explicit, Fields: map[string]MergeSource{"displayName": Victim, "email": Survivor}}, "benkim0414", "survivor@gunwoo.org"},
+	}
+	for _, tt := range tests {
+		got, err := tt.strategy.merge(survivor, victim)
+		if err != nil {
+			t.Errorf("merge(%+v): %v", tt.strategy, err)
+			continue
+		}
+		if got.DisplayName != tt.displayName || got.Email != tt.email {
+			t.Errorf("merge(%+v): got %q and %q, want %q and %q", tt.strategy, got.DisplayName, got.Email, tt.displayName, tt.email)
+		}
+		// empty fields are filled in regardless of the policy.
+		if got.AboutMe != "Codercat" {
+			t.Errorf("merge(%+v): got AboutMe %q, want %q", tt.strategy, got.AboutMe, "Codercat")
+		}
+		if got.ID != survivor.ID || got.Revision != survivor.Revision || !got.UpdateTime.Equal(survivor.UpdateTime) {
+			t.Errorf("merge(%+v): got %v, want the id, revision and update time of the survivor", tt.strategy, got)
+		}
+		if len(got.Identities) != 2 {
+			t.Errorf("merge(%+v): got identities %v, want 2", tt.strategy, got.Identities)
+		}
+	}
+	if len(survivor.Identities) != 1 {
+		t.Errorf("merge: modified the identities of the survivor: %v", survivor.Identities)
+	}
+
+	_, err := MergeStrategy{Policy: Explicit}.merge(survivor, victim)
+	e, ok := err.(*Error)
+	if !ok || e.Code != InvalidArgument || len(e.Violations) != 2 {
+		t.Errorf("merge: got %v, want a violation for each of the 2 conflicting fields", err)
+	}
+}
+
+func TestMergeStrategyValidate(t *testing.T) {
+	tests := []struct {
+		strategy MergeStrategy
+		valid    bool
+	}{
+		{MergeStrategy{}, true},
+		{MergeStrategy{Policy: Explicit, Fields: map[string]MergeSource{"name.givenName": Victim}}, true},
+		{MergeStrategy{Policy: "preferOldest"}, false},
+		{MergeStrategy{Fields: map[string]MergeSource{"id": Victim}}, false},
+		{MergeStrategy{Fields: map[string]MergeSource{"email": "both"}}, false},
+	}
+	for _, tt := range tests {
+		err := tt.strategy.Validate()
+		if (err == nil) != tt.valid {
+			t.Errorf("Validate(%+v): got %v, want valid %t", tt.strategy, err, tt.valid)
+		}
+	}
+}
+
+func TestFakeServiceMergeProfiles(t *testing.T) {
+	ctx := context.Background()
+	survivor := &Profile{ID: "merge-survivor", Email: "merge-survivor@gunwoo.org"}
+	if _, err := FakeService.PostProfile(ctx, survivor); err != nil {
+		t.Fatal(err)
+	}
+	defer FakeService.DeleteProfile(ctx, survivor.ID)
+	victim := &Profile{ID: "merge-victim", Email: "merge-victim@gunwoo.org", AboutMe: "Codercat"}
+	if _, err := FakeService.PostProfile(ctx, victim); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FakeService.LinkIdentity(ctx, victim.ID, Identity{Provider: "github", Subject: "merge"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := FakeService.MergeProfiles(ctx, survivor.ID, survivor.ID, MergeStrategy{}); ErrorCode(err) != InvalidArgument {
+		t.Errorf("MergeProfiles: got %v, want %v", err, InvalidArgument)
+	}
+	if _, err := FakeService.MergeProfiles(WithExpectedRevision(ctx, 2), survivor.ID, victim.ID, MergeStrategy{}); ErrorCode(err) != FailedPrecondition {
+		t.Errorf("MergeProfiles: got %v, want %v", err, FailedPrecondition)
+	}
+
+	merged, err := FakeService.MergeProfiles(ctx, survivor.ID, victim.ID, MergeStrategy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.AboutMe != "Codercat" || merged.Revision != 2 || len(merged.Identities) != 1 {
+		t.Errorf("MergeProfiles: got %v", merged)
+	}
+
+	got, err := FakeService.GetProfile(ctx, victim.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != survivor.ID {
+		t.Errorf("GetProfile: got %v, want the survivor %q", got, survivor.ID)
+	}
+	got, err = FakeService.ResolveIdentity(ctx, "github", "merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != survivor.ID {
+		t.Errorf("ResolveIdentity: got %v, want the survivor %q", got, survivor.ID)
+	}
+	// the email of the victim is released.
+	if _, err := FakeService.LookupProfile(ctx, victim.Email); err != ErrNoSuchEntity {
+		t.Errorf("LookupProfile: got %v, want %v", err, ErrNoSuchEntity)
+	}
+	if _, err := FakeService.MergeProfiles(ctx, survivor.ID, victim.ID, MergeStrategy{}); err != ErrNoSuchEntity {
+		t.Errorf("MergeProfiles: got %v, want %v", err, ErrNoSuchEntity)
+	}
+}
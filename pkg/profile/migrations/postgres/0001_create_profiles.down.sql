@@ -0,0 +1,3 @@
+DROP TABLE profile_redirects;
+DROP TABLE profile_identities;
+DROP TABLE profiles;
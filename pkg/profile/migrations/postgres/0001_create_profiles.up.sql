@@ -0,0 +1,38 @@
+-- Profiles keep the fields which are filtered, ordered or constrained in
+-- columns, and the other fields in the attributes document, so that fields
+-- can be added without a migration. Text is compared bytewise, like the
+-- other backends.
+CREATE TABLE profiles (
+    id           TEXT COLLATE "C" PRIMARY KEY,
+    display_name TEXT COLLATE "C" NOT NULL DEFAULT '',
+    family_name  TEXT COLLATE "C" NOT NULL DEFAULT '',
+    given_name   TEXT COLLATE "C" NOT NULL DEFAULT '',
+    email        TEXT COLLATE "C" NOT NULL DEFAULT '',
+    -- the normalized email address, which is NULL if it is empty.
+    email_key    TEXT COLLATE "C" UNIQUE,
+    revision     BIGINT NOT NULL,
+    update_time  TIMESTAMPTZ NOT NULL,
+    attributes   JSONB NOT NULL DEFAULT '{}',
+    identities   JSONB NOT NULL DEFAULT '[]'
+);
+
+CREATE INDEX profiles_display_name_idx ON profiles (display_name, id);
+CREATE INDEX profiles_family_name_idx ON profiles (family_name, id);
+CREATE INDEX profiles_given_name_idx ON profiles (given_name, id);
+CREATE INDEX profiles_email_idx ON profiles (email, id);
+
+-- An identity is linked to at most one profile.
+CREATE TABLE profile_identities (
+    provider   TEXT COLLATE "C" NOT NULL,
+    subject    TEXT COLLATE "C" NOT NULL,
+    profile_id TEXT COLLATE "C" NOT NULL REFERENCES profiles (id) ON DELETE CASCADE,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX profile_identities_profile_id_idx ON profile_identities (profile_id);
+
+-- The redirects of merged profiles to their survivors.
+CREATE TABLE profile_redirects (
+    id          TEXT COLLATE "C" PRIMARY KEY,
+    survivor_id TEXT COLLATE "C" NOT NULL
+);
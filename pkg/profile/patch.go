@@ -0,0 +1,257 @@
+package profile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Patch describes a partial update of a profile, which is shared by all of
+// the backends so that they have the same semantics.
+type Patch interface {
+	// Apply applies the patch to the given profile in place. The id, the
+	// revision, the update time and the identities of the profile can not be
+	// patched.
+	Apply(p *Profile) error
+}
+
+// Apply merges the non-zero fields of the profile into p, which means that
+// a field can not be cleared. It is the semantics of a PATCH with a plain
+// JSON profile; use MergePatch or JSONPatch to clear fields.
+func (patch *Profile) Apply(p *Profile) error {
+	if patch.DisplayName != "" {
+		p.DisplayName = patch.DisplayName
+	}
+	if patch.Name.Formatted != "" {
+		p.Name.Formatted = patch.Name.Formatted
+	}
+	if patch.Name.FamilyName != "" {
+		p.Name.FamilyName = patch.Name.FamilyName
+	}
+	if patch.Name.GivenName != "" {
+		p.Name.GivenName = patch.Name.GivenName
+	}
+	if patch.Email != "" {
+		p.Email = patch.Email
+	}
+	if patch.ImageURL != "" {
+		p.ImageURL = patch.ImageURL
+	}
+	if patch.AboutMe != "" {
+		p.AboutMe = patch.AboutMe
+	}
+	return nil
+}
+
+// MergePatch is a JSON Merge Patch (RFC 7396) of the JSON representation of
+// a profile, where null removes a field, that is, resets it to its zero
+// value.
+type MergePatch map[string]interface{}
+
+// Apply applies the merge patch to the profile.
+func (patch MergePatch) Apply(p *Profile) error {
+	for _, field := range immutableFields {
+		if _, ok := patch[field]; ok {
+			return immutableFieldError(field)
+		}
+	}
+	doc, err := profileDocument(p)
+	if err != nil {
+		return err
+	}
+	return applyDocument(p, mergePatch(doc, map[string]interface{}(patch)))
+}
+
+// mergePatch applies the merge patch to the target as specified by RFC 7396.
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = mergePatch(targetObject[name], value)
+	}
+	return targetObject
+}
+
+// Operation is a single operation of a JSONPatch.
+type Operation struct {
+	// The operation to perform: "add", "remove", "replace" or "test".
+	Op string `json:"op"`
+	// The JSON Pointer (RFC 6901) to the target field, such as
+	// "/name/givenName".
+	Path string `json:"path"`
+	// The value to add, replace or test.
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONPatch is a JSON Patch (RFC 6902) of the JSON representation of a
+// profile. Operations are applied in order, and the patch is atomic: if a
+// "test" operation fails, the profile is left unchanged and an error with
+// the Conflict code is returned. The only array of a profile, its
+// identities, can not be patched, so the "move" and "copy" operations and
+// array indices are not supported.
+type JSONPatch []Operation
+
+// Apply applies the JSON patch to the profile.
+func (patch JSONPatch) Apply(p *Profile) error {
+	doc, err := profileDocument(p)
+	if err != nil {
+		return err
+	}
+	for i, op := range patch {
+		if err := op.apply(doc); err != nil {
+			if e, ok := err.(*Error); ok {
+				e.Message = fmt.Sprintf("json patch: operation %d: %s", i, e.Message)
+			}
+			return err
+		}
+	}
+	return applyDocument(p, doc)
+}
+
+// apply applies the operation to the JSON document of a profile.
+func (op Operation) apply(doc map[string]interface{}) error {
+	tokens, err := parsePointer(op.Path)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		return invalidPath(op.Path, "must not be the whole profile")
+	}
+	for _, field := range immutableFields {
+		if tokens[0] == field {
+			return immutableFieldError(field)
+		}
+	}
+
+	parent := doc
+	for _, token := range tokens[:len(tokens)-1] {
+		child, ok := parent[token].(map[string]interface{})
+		if !ok {
+			return invalidPath(op.Path, "does not exist")
+		}
+		parent = child
+	}
+	name := tokens[len(tokens)-1]
+	current, exists := parent[name]
+
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return InvalidFields("missing value", FieldViolation{Field: "value", Description: fmt.Sprintf("is required by %q", op.Op)})
+		}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return InvalidFields("invalid value", FieldViolation{Field: "value", Description: err.Error()})
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		parent[name] = value
+	case "remove":
+		if !exists {
+			return invalidPath(op.Path, "does not exist")
+		}
+		delete(parent, name)
+	case "replace":
+		if !exists {
+			return invalidPath(op.Path, "does not exist")
+		}
+		parent[name] = value
+	case "test":
+		if !exists || !reflect.DeepEqual(current, value) {
+			return Errorf(Conflict, "test of %q failed", op.Path)
+		}
+	default:
+		return InvalidFields("unsupported operation", FieldViolation{
+			Field:       "op",
+			Description: fmt.Sprintf("must be one of add, remove, replace or test, not %q", op.Op),
+		})
+	}
+	return nil
+}
+
+// parsePointer parses a JSON Pointer (RFC 6901) into its reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, invalidPath(pointer, "must start with \"/\"")
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+// invalidPath returns an InvalidArgument error for the path of an operation.
+func invalidPath(path, description string) error {
+	return InvalidFields("invalid path", FieldViolation{Field: "path", Description: fmt.Sprintf("%q %s", path, description)})
+}
+
+// immutableFields are the fields of the JSON representation of a profile
+// which are managed by the backend, or by dedicated operations.
+var immutableFields = []string{"id", "revision", "updateTime", "identities"}
+
+func immutableFieldError(field string) error {
+	return InvalidFields("immutable field", FieldViolation{Field: field, Description: "can not be patched"})
+}
+
+// profileDocument returns the JSON representation of the profile as a
+// generic JSON object.
+func profileDocument(p *Profile) (map[string]interface{}, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, &Error{Code: Unknown, Message: "could not encode profile", Err: err}
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, &Error{Code: Unknown, Message: "could not decode profile", Err: err}
+	}
+	return doc, nil
+}
+
+// applyDocument replaces the fields of the profile with the patched JSON
+// document, keeping the fields which can not be patched. Fields missing from
+// the document are reset to their zero values.
+func applyDocument(p *Profile, doc interface{}) error {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return &Error{Code: Unknown, Message: "could not encode patched profile", Err: err}
+	}
+	patched := &Profile{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(patched); err != nil {
+		return invalidDocument(err)
+	}
+	patched.ID, patched.Revision, patched.UpdateTime, patched.Identities = p.ID, p.Revision, p.UpdateTime, p.Identities
+	*p = *patched
+	return nil
+}
+
+// invalidDocument returns an InvalidArgument error for a patched JSON
+// document which is not a valid profile.
+func invalidDocument(err error) error {
+	if e, ok := err.(*json.UnmarshalTypeError); ok {
+		return InvalidFields("invalid patch", FieldViolation{
+			Field:       e.Field,
+			Description: fmt.Sprintf("must be %s, not %s", e.Type, e.Value),
+		})
+	}
+	return &Error{Code: InvalidArgument, Message: "invalid patch", Err: err}
+}
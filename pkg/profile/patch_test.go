@@ -0,0 +1,144 @@
+package profile
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func newPatchTestProfile() *Profile {
+	return &Profile{
+		ID:          "gunwoo",
+		DisplayName: "benkim0414",
+		Name:        Name{FamilyName: "Kim", GivenName: "Gunwoo"},
+		Email:       "gunwoo@gunwoo.org",
+		ImageURL:    "https://octodex.github.com/images/codercat.jpg",
+		AboutMe:     "Codercat",
+		Revision:    2,
+	}
+}
+
+func TestProfileApply(t *testing.T) {
+	p := newPatchTestProfile()
+	patch := &Profile{ID: "other", Name: Name{GivenName: "Ben"}, AboutMe: "", Revision: 7}
+	if err := patch.Apply(p); err != nil {
+		t.Fatal(err)
+	}
+
+	want := newPatchTestProfile()
+	want.Name.GivenName = "Ben"
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("Apply: got %v, want %v", p, want)
+	}
+}
+
+func TestMergePatchApply(t *testing.T) {
+	tests := []struct {
+		patch string
+		want  func(p *Profile)
+		code  Code
+	}{
+		{
+			patch: `{"aboutMe": null, "imageUrl": null}`,
+			want: func(p *Profile) {
+				p.AboutMe, p.ImageURL = "", ""
+			},
+		},
+		{
+			patch: `{"name": {"givenName": "Ben", "formatted": "Ben Kim"}}`,
+			want: func(p *Profile) {
+				p.Name.GivenName, p.Name.Formatted = "Ben", "Ben Kim"
+			},
+		},
+		{
+			patch: `{"name": null}`,
+			want: func(p *Profile) {
+				p.Name = Name{}
+			},
+		},
+		{patch: `{"id": "other"}`, code: InvalidArgument},
+		{patch: `{"revision": 3}`, code: InvalidArgument},
+		{patch: `{"unknown": "field"}`, code: InvalidArgument},
+		{patch: `{"email": 1}`, code: InvalidArgument},
+	}
+	for _, tt := range tests {
+		var patch MergePatch
+		if err := json.Unmarshal([]byte(tt.patch), &patch); err != nil {
+			t.Fatal(err)
+		}
+		p := newPatchTestProfile()
+		err := patch.Apply(p)
+		if code := ErrorCode(err); err != nil && code != tt.code || err == nil && tt.want == nil {
+			t.Errorf("Apply(%s): got error %v, want %v", tt.patch, err, tt.code)
+			continue
+		}
+		want := newPatchTestProfile()
+		if tt.want != nil {
+			tt.want(want)
+		}
+		if !reflect.DeepEqual(p, want) {
+			t.Errorf("Apply(%s): got %v, want %v", tt.patch, p, want)
+		}
+	}
+}
+
+func TestJSONPatchApply(t *testing.T) {
+	tests := []struct {
+		patch string
+		want  func(p *Profile)
+		code  Code
+	}{
+		{
+			patch: `[{"op": "remove", "path": "/aboutMe"}, {"op": "replace", "path": "/name/givenName", "value": "Ben"}]`,
+			want: func(p *Profile) {
+				p.AboutMe, p.Name.GivenName = "", "Ben"
+			},
+		},
+		{
+			patch: `[{"op": "test", "path": "/email", "value": "gunwoo@gunwoo.org"}, {"op": "add", "path": "/email", "value": "ben@gunwoo.org"}]`,
+			want: func(p *Profile) {
+				p.Email = "ben@gunwoo.org"
+			},
+		},
+		{
+			patch: `[{"op": "replace", "path": "/email", "value": "ben@gunwoo.org"}, {"op": "test", "path": "/aboutMe", "value": "Octocat"}]`,
+			code:  Conflict,
+		},
+		{patch: `[{"op": "replace", "path": "/revision", "value": 3}]`, code: InvalidArgument},
+		{patch: `[{"op": "replace", "path": "/unknown", "value": 3}]`, code: InvalidArgument},
+		{patch: `[{"op": "add", "path": "/unknown", "value": 3}]`, code: InvalidArgument},
+		{patch: `[{"op": "replace", "path": "/email"}]`, code: InvalidArgument},
+		{patch: `[{"op": "move", "path": "/email"}]`, code: InvalidArgument},
+		{patch: `[{"op": "remove", "path": "email"}]`, code: InvalidArgument},
+		{patch: `[{"op": "remove", "path": ""}]`, code: InvalidArgument},
+	}
+	for _, tt := range tests {
+		var patch JSONPatch
+		if err := json.Unmarshal([]byte(tt.patch), &patch); err != nil {
+			t.Fatal(err)
+		}
+		p := newPatchTestProfile()
+		err := patch.Apply(p)
+		if code := ErrorCode(err); err != nil && code != tt.code || err == nil && tt.want == nil {
+			t.Errorf("Apply(%s): got error %v, want %v", tt.patch, err, tt.code)
+			continue
+		}
+		want := newPatchTestProfile()
+		if tt.want != nil {
+			tt.want(want)
+		}
+		if !reflect.DeepEqual(p, want) {
+			t.Errorf("Apply(%s): got %v, want %v", tt.patch, p, want)
+		}
+	}
+}
+
+func TestParsePointer(t *testing.T) {
+	got, err := parsePointer("/name/a~1b~0c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"name", "a/b~c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePointer: got %q, want %q", got, want)
+	}
+}
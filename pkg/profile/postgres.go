@@ -0,0 +1,759 @@
+package profile
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PostgresDriver is the name of the database/sql driver of the postgres
+// backend, which is registered by github.com/lib/pq. The driver is not
+// linked into this package, so that the other backends do not depend on it;
+// the superego command links it.
+const PostgresDriver = "postgres"
+
+func init() {
+	Register("postgres", func(ctx context.Context, config Config) (Service, func() error, error) {
+		if !driverRegistered(PostgresDriver) {
+			return nil, nil, fmt.Errorf("postgres: no %q database/sql driver is linked into the binary", PostgresDriver)
+		}
+		db, err := sql.Open(PostgresDriver, config.DSN)
+		if err != nil {
+			return nil, nil, fmt.Errorf("postgres: could not connect: %v", err)
+		}
+		s, err := OpenPostgresService(ctx, db)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return s, db.Close, nil
+	})
+}
+
+func driverRegistered(name string) bool {
+	for _, driver := range sql.Drivers() {
+		if driver == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SQLSTATE codes of the postgres errors which are handled by the service.
+const (
+	uniqueViolation      = "23505"
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+)
+
+// postgresColumns are the columns of a profile, in the order of scanProfile.
+const postgresColumns = `id, display_name, family_name, given_name, email, revision, update_time, attributes, identities`
+
+// postgresOrderColumns maps the datastore properties of the orderFields to
+// their columns.
+var postgresOrderColumns = map[string]string{
+	"DisplayName":     "display_name",
+	"Email":           "email",
+	"Name.FamilyName": "family_name",
+	"Name.GivenName":  "given_name",
+}
+
+// postgresAttributes are the fields of a profile which are stored in the
+// JSONB attributes column, since they are neither filtered nor ordered by.
+type postgresAttributes struct {
+	FormattedName string `json:"name.formatted,omitempty"`
+	ImageURL      string `json:"imageUrl,omitempty"`
+	AboutMe       string `json:"aboutMe,omitempty"`
+}
+
+// PostgresService is a Service which stores the profiles in PostgreSQL,
+// whose schema is created by the migrations of a Migrator. Email addresses
+// and identities are kept unique by constraints, and every update runs in a
+// transaction which locks the rows of the updated profiles.
+type PostgresService struct {
+	db          *sql.DB
+	maxAttempts int
+}
+
+// OpenPostgresService returns the service of the database, whose schema
+// must be at the version of the latest migration.
+func OpenPostgresService(ctx context.Context, db *sql.DB) (*PostgresService, error) {
+	m := NewMigrator(db)
+	version, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if version != m.Latest() {
+		return nil, fmt.Errorf("postgres: the schema is at version %d, not %d; run superego migrate up", version, m.Latest())
+	}
+	return &PostgresService{db: db, maxAttempts: DefaultMaxAttempts}, nil
+}
+
+// rowScanner is a *sql.Row or *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanProfile scans the postgresColumns of a row into a profile.
+func scanProfile(row rowScanner) (*Profile, error) {
+	p := &Profile{}
+	var attributes, identities []byte
+	err := row.Scan(&p.ID, &p.DisplayName, &p.Name.FamilyName, &p.Name.GivenName, &p.Email, &p.Revision, &p.UpdateTime, &attributes, &identities)
+	if err != nil {
+		return nil, err
+	}
+	var a postgresAttributes
+	if err := json.Unmarshal(attributes, &a); err != nil {
+		return nil, err
+	}
+	p.Name.Formatted, p.ImageURL, p.AboutMe = a.FormattedName, a.ImageURL, a.AboutMe
+	if err := json.Unmarshal(identities, &p.Identities); err != nil {
+		return nil, err
+	}
+	if len(p.Identities) == 0 {
+		p.Identities = nil
+	}
+	p.UpdateTime = p.UpdateTime.UTC()
+	return p, nil
+}
+
+// querier is a *sql.DB or *sql.Tx.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// get returns the profile with the given id, following the redirects of
+// merged profiles. The row of the profile is locked if lock is set, in
+// which case redirects are not followed.
+func (s *PostgresService) get(ctx context.Context, q querier, id string, lock bool) (*Profile, error) {
+	query := `SELECT ` + postgresColumns + ` FROM profiles WHERE id = $1`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+	for redirects := 0; ; redirects++ {
+		p, err := scanProfile(q.QueryRowContext(ctx, query, id))
+		if err == nil {
+			return p, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, postgresError(err, "could not get Profile")
+		}
+		if lock || redirects == maxRedirects {
+			return nil, ErrNoSuchEntity
+		}
+		err = q.QueryRowContext(ctx, `SELECT survivor_id FROM profile_redirects WHERE id = $1`, id).Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil, ErrNoSuchEntity
+		} else if err != nil {
+			return nil, postgresError(err, "could not get Profile redirect")
+		}
+	}
+}
+
+// put inserts or updates the profile, and replaces the redirect at its id.
+func (s *PostgresService) put(ctx context.Context, tx *sql.Tx, p *Profile) error {
+	attributes, err := json.Marshal(postgresAttributes{p.Name.Formatted, p.ImageURL, p.AboutMe})
+	if err != nil {
+		return &Error{Code: Unknown, Message: "postgres: could not encode attributes", Err: err}
+	}
+	identities := p.Identities
+	if identities == nil {
+		identities = []Identity{}
+	}
+	b, err := json.Marshal(identities)
+	if err != nil {
+		return &Error{Code: Unknown, Message: "postgres: could not encode identities", Err: err}
+	}
+	var key sql.NullString
+	if k := emailKey(p.Email); k != "" {
+		key = sql.NullString{String: k, Valid: true}
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO profiles (`+postgresColumns+`, email_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (id) DO UPDATE SET
+			display_name = EXCLUDED.display_name,
+			family_name = EXCLUDED.family_name,
+			given_name = EXCLUDED.given_name,
+			email = EXCLUDED.email,
+			revision = EXCLUDED.revision,
+			update_time = EXCLUDED.update_time,
+			attributes = EXCLUDED.attributes,
+			identities = EXCLUDED.identities,
+			email_key = EXCLUDED.email_key`,
+		p.ID, p.DisplayName, p.Name.FamilyName, p.Name.GivenName, p.Email, p.Revision, p.UpdateTime, string(attributes), string(b), key)
+	if sqlState(err) == uniqueViolation {
+		// a concurrent transaction took the email address after it was
+		// checked. The owner is looked up outside of the transaction, which
+		// is aborted by the error.
+		if err := s.checkEmail(ctx, s.db, p.ID, p.Email); err != nil {
+			return err
+		}
+		return postgresError(err, "could not put Profile")
+	}
+	if err != nil {
+		return postgresError(err, "could not put Profile")
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM profile_redirects WHERE id = $1`, p.ID); err != nil {
+		return postgresError(err, "could not delete Profile redirect")
+	}
+	return nil
+}
+
+// checkEmail returns a Conflict error if the email address is used by any
+// profile other than the one with the given id.
+func (s *PostgresService) checkEmail(ctx context.Context, q querier, id, email string) error {
+	if emailKey(email) == "" {
+		return nil
+	}
+	var ownerID string
+	err := q.QueryRowContext(ctx, `SELECT id FROM profiles WHERE email_key = $1`, emailKey(email)).Scan(&ownerID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return postgresError(err, "could not get email owner")
+	}
+	if ownerID != id {
+		return emailInUse(ownerID)
+	}
+	return nil
+}
+
+func (s *PostgresService) PostProfile(ctx context.Context, p *Profile) (*Profile, error) {
+	id, err := newProfileID()
+	if err != nil {
+		return nil, err
+	}
+	created := p.clone()
+	created.ID = id
+	// identities can only be linked to an existing profile.
+	created.Identities = nil
+	created.Revision = 1
+	created.UpdateTime = time.Now().UTC().Truncate(time.Microsecond)
+	err = s.runInTransaction(ctx, func(tx *sql.Tx) error {
+		if err := s.checkEmail(ctx, tx, id, created.Email); err != nil {
+			return err
+		}
+		return s.put(ctx, tx, created)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (s *PostgresService) GetProfile(ctx context.Context, id string) (*Profile, error) {
+	return s.get(ctx, s.db, id, false)
+}
+
+// GetProfiles looks up all of the profiles with a single query, and then the
+// redirects of the missing ones, level by level, like the datastore service.
+func (s *PostgresService) GetProfiles(ctx context.Context, ids []string) ([]*Profile, error) {
+	profiles := make([]*Profile, len(ids))
+	// lookups holds the id which is looked up next for every pending index.
+	lookups := make([]string, len(ids))
+	copy(lookups, ids)
+	pending := make([]int, len(ids))
+	for i := range ids {
+		pending[i] = i
+	}
+	for redirects := 0; len(pending) > 0; redirects++ {
+		found := map[string]*Profile{}
+		rows, err := s.db.QueryContext(ctx, `SELECT `+postgresColumns+` FROM profiles WHERE id IN (`+placeholders(len(pending))+`)`, pendingArgs(lookups, pending)...)
+		if err != nil {
+			return nil, postgresError(err, "could not get Profiles")
+		}
+		for rows.Next() {
+			p, err := scanProfile(rows)
+			if err != nil {
+				rows.Close()
+				return nil, postgresError(err, "could not get Profiles")
+			}
+			found[p.ID] = p
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, postgresError(err, "could not get Profiles")
+		}
+
+		var missing []int
+		for _, i := range pending {
+			if p, ok := found[lookups[i]]; ok {
+				profiles[i] = p.clone()
+			} else {
+				missing = append(missing, i)
+			}
+		}
+		if len(missing) == 0 || redirects == maxRedirects {
+			break
+		}
+
+		survivors := map[string]string{}
+		rows, err = s.db.QueryContext(ctx, `SELECT id, survivor_id FROM profile_redirects WHERE id IN (`+placeholders(len(missing))+`)`, pendingArgs(lookups, missing)...)
+		if err != nil {
+			return nil, postgresError(err, "could not get Profile redirects")
+		}
+		for rows.Next() {
+			var id, survivorID string
+			if err := rows.Scan(&id, &survivorID); err != nil {
+				rows.Close()
+				return nil, postgresError(err, "could not get Profile redirects")
+			}
+			survivors[id] = survivorID
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, postgresError(err, "could not get Profile redirects")
+		}
+		pending = nil
+		for _, i := range missing {
+			if survivorID, ok := survivors[lookups[i]]; ok {
+				lookups[i] = survivorID
+				pending = append(pending, i)
+			}
+		}
+	}
+	return profiles, nil
+}
+
+func (s *PostgresService) PutProfile(ctx context.Context, id string, p *Profile) (*Profile, error) {
+	if id == "" {
+		return nil, Errorf(InvalidArgument, "postgres: invalid Profile id")
+	}
+	put := p.clone()
+	err := s.runInTransaction(ctx, func(tx *sql.Tx) error {
+		// PUT creates the profile if it does not exist yet, whose revision
+		// is zero.
+		existing, err := s.get(ctx, tx, id, true)
+		if err == ErrNoSuchEntity {
+			existing, err = &Profile{}, nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := checkRevision(ctx, existing.Revision); err != nil {
+			return err
+		}
+		if err := s.checkEmail(ctx, tx, id, put.Email); err != nil {
+			return err
+		}
+		put.ID = id
+		put.Identities = existing.Identities
+		put.Revision = existing.Revision + 1
+		put.UpdateTime = time.Now().UTC().Truncate(time.Microsecond)
+		// a profile put at the id of a merged profile replaces its redirect.
+		return s.put(ctx, tx, put)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return put, nil
+}
+
+func (s *PostgresService) PatchProfile(ctx context.Context, id string, patch Patch) (*Profile, error) {
+	return s.update(ctx, id, func(tx *sql.Tx, p *Profile) error {
+		if err := patch.Apply(p); err != nil {
+			return err
+		}
+		p.ID = id
+		return s.checkEmail(ctx, tx, id, p.Email)
+	})
+}
+
+func (s *PostgresService) DeleteProfile(ctx context.Context, id string) error {
+	return s.runInTransaction(ctx, func(tx *sql.Tx) error {
+		existing, err := s.get(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+		if err := checkRevision(ctx, existing.Revision); err != nil {
+			return err
+		}
+		// the reservations of the identities are deleted by the cascade.
+		if _, err := tx.ExecContext(ctx, `DELETE FROM profiles WHERE id = $1`, id); err != nil {
+			return postgresError(err, "could not delete Profile")
+		}
+		return nil
+	})
+}
+
+func (s *PostgresService) LookupProfile(ctx context.Context, email string) (*Profile, error) {
+	if emailKey(email) == "" {
+		return nil, Errorf(InvalidArgument, "empty email")
+	}
+	p, err := scanProfile(s.db.QueryRowContext(ctx, `SELECT `+postgresColumns+` FROM profiles WHERE email_key = $1`, emailKey(email)))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoSuchEntity
+	}
+	if err != nil {
+		return nil, postgresError(err, "could not look up Profile")
+	}
+	return p, nil
+}
+
+func (s *PostgresService) LinkIdentity(ctx context.Context, id string, identity Identity) (*Profile, error) {
+	if err := validateIdentity(identity.Provider, identity.Subject); err != nil {
+		return nil, err
+	}
+	return s.update(ctx, id, func(tx *sql.Tx, p *Profile) error {
+		// the reservation is inserted before anything is read, since a
+		// concurrent insert of the same identity blocks on the unique key
+		// until the other transaction finishes, whereas a read does not.
+		res, err := tx.ExecContext(ctx, `INSERT INTO profile_identities (provider, subject, profile_id) VALUES ($1, $2, $3)
+			ON CONFLICT (provider, subject) DO NOTHING`, identity.Provider, identity.Subject, id)
+		if err != nil {
+			return postgresError(err, "could not put identity reservation")
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return postgresError(err, "could not put identity reservation")
+		} else if n == 0 {
+			var ownerID string
+			err := tx.QueryRowContext(ctx, `SELECT profile_id FROM profile_identities WHERE provider = $1 AND subject = $2`,
+				identity.Provider, identity.Subject).Scan(&ownerID)
+			if err != nil {
+				return postgresError(err, "could not get identity reservation")
+			}
+			if ownerID != id {
+				return identityInUse(ownerID)
+			}
+		}
+		p.linkIdentity(identity)
+		return nil
+	})
+}
+
+func (s *PostgresService) UnlinkIdentity(ctx context.Context, id string, provider, subject string) (*Profile, error) {
+	return s.update(ctx, id, func(tx *sql.Tx, p *Profile) error {
+		if err := p.unlinkIdentity(provider, subject); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM profile_identities WHERE provider = $1 AND subject = $2 AND profile_id = $3`, provider, subject, id)
+		if err != nil {
+			return postgresError(err, "could not delete identity reservation")
+		}
+		return nil
+	})
+}
+
+func (s *PostgresService) ResolveIdentity(ctx context.Context, provider, subject string) (*Profile, error) {
+	if err := validateIdentity(provider, subject); err != nil {
+		return nil, err
+	}
+	p, err := scanProfile(s.db.QueryRowContext(ctx, `SELECT `+postgresColumns+` FROM profiles
+		WHERE id = (SELECT profile_id FROM profile_identities WHERE provider = $1 AND subject = $2)`, provider, subject))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoSuchEntity
+	}
+	if err != nil {
+		return nil, postgresError(err, "could not resolve identity")
+	}
+	return p, nil
+}
+
+func (s *PostgresService) MergeProfiles(ctx context.Context, survivorID, victimID string, strategy MergeStrategy) (*Profile, error) {
+	if err := validateMerge(survivorID, victimID); err != nil {
+		return nil, err
+	}
+	var merged *Profile
+	err := s.runInTransaction(ctx, func(tx *sql.Tx) error {
+		// the rows are locked in the order of their ids, so that concurrent
+		// merges of the same profiles can not deadlock.
+		rows, err := tx.QueryContext(ctx, `SELECT `+postgresColumns+` FROM profiles WHERE id IN ($1, $2) ORDER BY id FOR UPDATE`, survivorID, victimID)
+		if err != nil {
+			return postgresError(err, "could not get Profiles")
+		}
+		var survivor, victim *Profile
+		for rows.Next() {
+			p, err := scanProfile(rows)
+			if err != nil {
+				rows.Close()
+				return postgresError(err, "could not get Profiles")
+			}
+			if p.ID == survivorID {
+				survivor = p
+			} else {
+				victim = p
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return postgresError(err, "could not get Profiles")
+		}
+		if survivor == nil || victim == nil {
+			return ErrNoSuchEntity
+		}
+		if err := checkRevision(ctx, survivor.Revision); err != nil {
+			return err
+		}
+		merged, err = strategy.merge(survivor, victim)
+		if err != nil {
+			return err
+		}
+		merged.Revision++
+		merged.UpdateTime = time.Now().UTC().Truncate(time.Microsecond)
+
+		// the victim is deleted first, so that its email address is
+		// released to the survivor, and its identities are moved.
+		if _, err := tx.ExecContext(ctx, `DELETE FROM profiles WHERE id = $1`, victimID); err != nil {
+			return postgresError(err, "could not delete Profile")
+		}
+		if err := s.put(ctx, tx, merged); err != nil {
+			return err
+		}
+		for _, identity := range victim.Identities {
+			_, err := tx.ExecContext(ctx, `INSERT INTO profile_identities (provider, subject, profile_id) VALUES ($1, $2, $3)
+				ON CONFLICT (provider, subject) DO UPDATE SET profile_id = EXCLUDED.profile_id`, identity.Provider, identity.Subject, survivorID)
+			if err != nil {
+				return postgresError(err, "could not put identity reservation")
+			}
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO profile_redirects (id, survivor_id) VALUES ($1, $2)
+			ON CONFLICT (id) DO UPDATE SET survivor_id = EXCLUDED.survivor_id`, victimID, survivorID)
+		if err != nil {
+			return postgresError(err, "could not put Profile redirect")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+func (s *PostgresService) ListProfiles(ctx context.Context, opts ListOptions) ([]*Profile, string, error) {
+	return s.query(ctx, nil, opts)
+}
+
+func (s *PostgresService) SearchProfiles(ctx context.Context, query SearchQuery, opts ListOptions) ([]*Profile, string, error) {
+	filters, err := query.filters()
+	if err != nil {
+		return nil, "", err
+	}
+	if opts, err = searchOptions(filters, opts); err != nil {
+		return nil, "", err
+	}
+	return s.query(ctx, filters, opts)
+}
+
+// query returns a page of the profiles matching all of the filters, in the
+// same order as the other backends, along with the token to the next page.
+func (s *PostgresService) query(ctx context.Context, filters []searchFilter, opts ListOptions) ([]*Profile, string, error) {
+	pageSize, err := opts.pageSize()
+	if err != nil {
+		return nil, "", err
+	}
+	field, desc, err := parseOrderBy(opts.OrderBy)
+	if err != nil {
+		return nil, "", err
+	}
+	offset, err := decodeOffsetToken(opts.PageToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var where []string
+	var args []interface{}
+	for _, f := range filters {
+		column := postgresOrderColumns[f.field.property]
+		if f.emailKey {
+			column = "email_key"
+		}
+		if f.prefix {
+			args = append(args, escapeLike(f.value)+"%")
+			where = append(where, fmt.Sprintf(`%s LIKE $%d`, column, len(args)))
+		} else {
+			args = append(args, f.value)
+			where = append(where, fmt.Sprintf(`%s = $%d`, column, len(args)))
+		}
+	}
+	query := `SELECT ` + postgresColumns + ` FROM profiles`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	query += ` ORDER BY `
+	if column := postgresOrderColumns[field.property]; column != "" {
+		query += column
+		if desc {
+			query += ` DESC`
+		}
+		query += `, `
+	}
+	// a page has one more profile, which tells whether there is a next page.
+	args = append(args, pageSize+1, offset)
+	query += fmt.Sprintf(`id LIMIT $%d OFFSET $%d`, len(args)-1, len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", postgresError(err, "could not query Profiles")
+	}
+	defer rows.Close()
+	profiles := []*Profile{}
+	for rows.Next() {
+		p, err := scanProfile(rows)
+		if err != nil {
+			return nil, "", postgresError(err, "could not query Profiles")
+		}
+		profiles = append(profiles, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", postgresError(err, "could not query Profiles")
+	}
+	if len(profiles) <= pageSize {
+		return profiles, "", nil
+	}
+	return profiles[:pageSize], encodeOffsetToken(offset + pageSize), nil
+}
+
+// update applies f to the profile with the given id, and stores it with the
+// next revision if f succeeds, in a transaction.
+func (s *PostgresService) update(ctx context.Context, id string, f func(tx *sql.Tx, p *Profile) error) (*Profile, error) {
+	var updated *Profile
+	err := s.runInTransaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		updated, err = s.get(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+		if err := checkRevision(ctx, updated.Revision); err != nil {
+			return err
+		}
+		if err := f(tx, updated); err != nil {
+			return err
+		}
+		updated.Revision++
+		updated.UpdateTime = time.Now().UTC().Truncate(time.Microsecond)
+		return s.put(ctx, tx, updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// runInTransaction runs f in a transaction, which is retried with
+// exponential backoff up to maxAttempts times if it fails due to a
+// serialization failure or a deadlock. Errors returned by f are returned
+// unchanged, so f should return an *Error.
+func (s *PostgresService) runInTransaction(ctx context.Context, f func(tx *sql.Tx) error) error {
+	backoff := transactionBackoff
+	for attempt := 1; ; attempt++ {
+		err := s.transaction(ctx, f)
+		if err == nil {
+			return nil
+		}
+		if e, ok := err.(*Error); ok {
+			return e
+		}
+		if state := sqlState(err); (state != serializationFailure && state != deadlockDetected) || attempt >= s.maxAttempts {
+			return postgresError(err, "could not run transaction")
+		}
+
+		// jitter spreads out the retries of concurrent transactions which
+		// failed at the same time.
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return &Error{Code: Unavailable, Message: "postgres: could not run transaction", Err: ctx.Err()}
+		}
+		backoff *= 2
+	}
+}
+
+// transaction runs f in a single transaction, which is committed if f
+// succeeds.
+func (s *PostgresService) transaction(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		// the errors of statements are wrapped by f, unless they need to
+		// be retried.
+		if e, ok := err.(*Error); ok {
+			if cause := sqlState(e.Err); cause == serializationFailure || cause == deadlockDetected {
+				return e.Err
+			}
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// placeholders returns the n placeholders $1, ..., $n.
+func placeholders(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		if i > 1 {
+			b.WriteString(", ")
+		}
+		b.WriteString("$" + strconv.Itoa(i))
+	}
+	return b.String()
+}
+
+// pendingArgs returns the ids at the given indexes as query arguments.
+func pendingArgs(ids []string, indexes []int) []interface{} {
+	args := make([]interface{}, len(indexes))
+	for j, i := range indexes {
+		args[j] = ids[i]
+	}
+	return args
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// sqlState returns the SQLSTATE code of a postgres error, as reported by the
+// lib/pq and the pgx drivers, or "".
+func sqlState(err error) string {
+	var pgx interface{ SQLState() string }
+	if errors.As(err, &pgx) {
+		return pgx.SQLState()
+	}
+	var pq interface{ Get(k byte) string }
+	if errors.As(err, &pq) {
+		return pq.Get('C')
+	}
+	return ""
+}
+
+// postgresError wraps an error returned by the database into an *Error with
+// the code corresponding to the cause.
+func postgresError(err error, message string) error {
+	return &Error{Code: postgresCode(err), Message: "postgres: " + message, Err: err}
+}
+
+// postgresCode returns the Code corresponding to an error returned by the
+// database.
+func postgresCode(err error) Code {
+	if err == context.DeadlineExceeded || err == context.Canceled || err == sql.ErrConnDone {
+		return Unavailable
+	}
+	state := sqlState(err)
+	switch {
+	case state == uniqueViolation, state == serializationFailure, state == deadlockDetected:
+		return Conflict
+	case strings.HasPrefix(state, "22"):
+		// data exceptions, such as a string which is not valid UTF-8.
+		return InvalidArgument
+	case strings.HasPrefix(state, "08"), strings.HasPrefix(state, "53"), strings.HasPrefix(state, "57"):
+		// connection exceptions, insufficient resources and operator
+		// interventions, such as a shutdown.
+		return Unavailable
+	case strings.HasPrefix(state, "28"), state == "42501":
+		return PermissionDenied
+	}
+	return Unknown
+}
@@ -0,0 +1,5 @@
+package profile
+
+// the postgres tests run against the database of the test context with the
+// driver of the superego command.
+import _ "github.com/lib/pq"
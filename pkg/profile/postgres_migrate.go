@@ -0,0 +1,260 @@
+package profile
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrationFiles embed.FS
+
+// migrationPattern matches the names of the files of migrations, such as
+// "0001_create_profiles.up.sql".
+var migrationPattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migrationLock is the key of the advisory lock which serializes the
+// migrations run by concurrent processes.
+const migrationLock = 0x73757065726567 // "superego"
+
+// Migration is a version of the schema of the postgres backend, which is
+// applied by its Up statements and reverted by its Down statements.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration along with whether it is applied.
+type MigrationStatus struct {
+	Migration
+	// AppliedAt is the time the migration was applied, which is zero if it
+	// is pending.
+	AppliedAt time.Time
+}
+
+// PostgresMigrations returns the migrations of the schema of the postgres
+// backend, ordered by version.
+func PostgresMigrations() []Migration {
+	return postgresMigrations
+}
+
+var postgresMigrations = mustParseMigrations(postgresMigrationFiles, "migrations/postgres")
+
+// parseMigrations parses the migrations of the directory, which must have
+// an up and a down file for every version from 1.
+func parseMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		m := migrationPattern.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration %s: invalid name", entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		b, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		} else if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d: names %s and %s differ", version, migration.Name, m[2])
+		}
+		if m[3] == "up" {
+			migration.Up = string(b)
+		} else {
+			migration.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d: the up or the down file is missing", migration.Version)
+		}
+	}
+	return migrations, nil
+}
+
+func mustParseMigrations(fsys fs.FS, dir string) []Migration {
+	migrations, err := parseMigrations(fsys, dir)
+	if err != nil {
+		panic("profile: " + err.Error())
+	}
+	return migrations
+}
+
+// Migrator applies and reverts the migrations of the schema of the postgres
+// backend. The applied versions are recorded in the schema_migrations table,
+// and every migration runs in its own transaction, under an advisory lock
+// which serializes concurrent migrators.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// NewMigrator returns a Migrator of the postgres database.
+func NewMigrator(db *sql.DB) *Migrator {
+	return &Migrator{db: db, migrations: postgresMigrations}
+}
+
+// Latest returns the version of the latest migration.
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Version returns the version of the schema, which is zero before the first
+// migration.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	if err := m.init(ctx); err != nil {
+		return 0, err
+	}
+	var version int
+	err := m.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("postgres: could not get the schema version: %v", err)
+	}
+	return version, nil
+}
+
+// Status returns all of the migrations, along with the time they were
+// applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	if err := m.init(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := m.db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: could not get the applied migrations: %v", err)
+	}
+	defer rows.Close()
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("postgres: could not get the applied migrations: %v", err)
+		}
+		applied[version] = appliedAt.UTC()
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: could not get the applied migrations: %v", err)
+	}
+
+	statuses := make([]MigrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = MigrationStatus{Migration: migration, AppliedAt: applied[migration.Version]}
+	}
+	return statuses, nil
+}
+
+// Up applies the pending migrations, in order, and returns them.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	for {
+		migration, err := m.step(ctx, true)
+		if err != nil || migration == nil {
+			return applied, err
+		}
+		applied = append(applied, *migration)
+	}
+}
+
+// Down reverts the given number of the latest applied migrations, in
+// reverse order, and returns them.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var reverted []Migration
+	for i := 0; i < steps; i++ {
+		migration, err := m.step(ctx, false)
+		if err != nil || migration == nil {
+			return reverted, err
+		}
+		reverted = append(reverted, *migration)
+	}
+	return reverted, nil
+}
+
+// step applies the next migration, or reverts the latest one, in a
+// transaction. It returns nil if there is no such migration.
+func (m *Migrator) step(ctx context.Context, up bool) (*Migration, error) {
+	if err := m.init(ctx); err != nil {
+		return nil, err
+	}
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: could not begin the migration: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1::bigint)`, migrationLock); err != nil {
+		return nil, fmt.Errorf("postgres: could not lock the migrations: %v", err)
+	}
+	var version int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return nil, fmt.Errorf("postgres: could not get the schema version: %v", err)
+	}
+	if version > len(m.migrations) {
+		return nil, fmt.Errorf("postgres: the schema version %d is newer than the latest migration %d", version, len(m.migrations))
+	}
+
+	var migration Migration
+	if up {
+		if version == len(m.migrations) {
+			return nil, nil
+		}
+		migration = m.migrations[version]
+		if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+			return nil, fmt.Errorf("postgres: migration %d_%s failed: %v", migration.Version, migration.Name, err)
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES ($1, now())`, migration.Version)
+	} else {
+		if version == 0 {
+			return nil, nil
+		}
+		migration = m.migrations[version-1]
+		if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+			return nil, fmt.Errorf("postgres: reverting migration %d_%s failed: %v", migration.Version, migration.Name, err)
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("postgres: could not record migration %d: %v", migration.Version, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("postgres: could not commit migration %d: %v", migration.Version, err)
+	}
+	return &migration, nil
+}
+
+// init creates the table of the applied migrations.
+func (m *Migrator) init(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("postgres: could not create the schema_migrations table: %v", err)
+	}
+	return nil
+}
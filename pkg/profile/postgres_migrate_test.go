@@ -0,0 +1,45 @@
+package profile
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPostgresMigrations(t *testing.T) {
+	migrations := PostgresMigrations()
+	if len(migrations) == 0 {
+		t.Fatal("PostgresMigrations: got no migrations")
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 || m.Name == "" || strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			t.Errorf("PostgresMigrations: got %+v at %d", m, i)
+		}
+	}
+	if got := NewMigrator(nil).Latest(); got != len(migrations) {
+		t.Errorf("Latest: got %d, want %d", got, len(migrations))
+	}
+}
+
+func TestParseMigrations(t *testing.T) {
+	file := &fstest.MapFile{Data: []byte("SELECT 1;")}
+	for _, tt := range []struct {
+		name    string
+		files   fstest.MapFS
+		wantErr bool
+	}{
+		{"valid", fstest.MapFS{"0001_a.up.sql": file, "0001_a.down.sql": file, "0002_b.up.sql": file, "0002_b.down.sql": file}, false},
+		{"missing version", fstest.MapFS{"0001_a.up.sql": file, "0001_a.down.sql": file, "0003_c.up.sql": file, "0003_c.down.sql": file}, true},
+		{"missing down", fstest.MapFS{"0001_a.up.sql": file}, true},
+		{"different names", fstest.MapFS{"0001_a.up.sql": file, "0001_b.down.sql": file}, true},
+		{"invalid name", fstest.MapFS{"0001_a.up.sql": file, "0001_a.down.sql": file, "README": file}, true},
+	} {
+		migrations, err := parseMigrations(tt.files, ".")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseMigrations(%s): got %v, %v", tt.name, migrations, err)
+		}
+		if err == nil && (len(migrations) != 2 || migrations[1].Version != 2 || migrations[1].Name != "b") {
+			t.Errorf("parseMigrations(%s): got %+v, want the versions 1 and 2", tt.name, migrations)
+		}
+	}
+}
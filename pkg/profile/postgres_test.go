@@ -0,0 +1,361 @@
+package profile
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/benkim0414/superego/internal/testutil"
+)
+
+// openPostgres returns the service of the test database, whose schema is
+// reset to the latest migration. The test is skipped unless a database is
+// available.
+func openPostgres(t *testing.T) *PostgresService {
+	tc := testutil.PostgresTestContext(t)
+	ctx := context.Background()
+
+	db, err := sql.Open(PostgresDriver, tc.PostgresDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	m := NewMigrator(db)
+	if _, err := m.Down(ctx, m.Latest()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenPostgresService(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPostgresService(t *testing.T) {
+	s := openPostgres(t)
+	ctx := context.Background()
+
+	p, err := s.PostProfile(ctx, &Profile{
+		DisplayName: "gunwoo",
+		Name:        Name{Formatted: "Gunwoo Kim", FamilyName: "Kim", GivenName: "Gunwoo"},
+		Email:       "gunwoo@gunwoo.org",
+		ImageURL:    "https://gunwoo.org/gunwoo.png",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetProfile(ctx, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, p) {
+		t.Errorf("GetProfile: got %+v, want %+v", got, p)
+	}
+
+	p.Email = "ben.kim@greenenergytrading.com.au"
+	put, err := s.PutProfile(ctx, p.ID, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if put.Email != p.Email || put.Revision != 2 {
+		t.Errorf("PutProfile: got %+v, want the email %q at revision 2", put, p.Email)
+	}
+	patched, err := s.PatchProfile(ctx, p.ID, &Profile{AboutMe: "Codercat"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.AboutMe != "Codercat" || patched.Name.Formatted != "Gunwoo Kim" || patched.Revision != 3 {
+		t.Errorf("PatchProfile: got %+v, want the patched profile at revision 3", patched)
+	}
+	if _, err := s.PatchProfile(WithExpectedRevision(ctx, 1), p.ID, &Profile{AboutMe: "stale"}); ErrorCode(err) != FailedPrecondition {
+		t.Errorf("PatchProfile: got %v, want a FailedPrecondition error for a stale revision", err)
+	}
+	if lookup, err := s.LookupProfile(ctx, "Ben.Kim@greenenergytrading.com.au"); err != nil || lookup.ID != p.ID {
+		t.Errorf("LookupProfile: got %v, %v, want %s", lookup, err, p.ID)
+	}
+
+	linked, err := s.LinkIdentity(ctx, p.ID, Identity{Provider: "github", Subject: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(linked.Identities) != 1 {
+		t.Errorf("LinkIdentity: got %v, want one identity", linked.Identities)
+	}
+	if resolved, err := s.ResolveIdentity(ctx, "github", "1"); err != nil || resolved.ID != p.ID {
+		t.Errorf("ResolveIdentity: got %v, %v, want %s", resolved, err, p.ID)
+	}
+	other, err := s.PostProfile(ctx, &Profile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LinkIdentity(ctx, other.ID, Identity{Provider: "github", Subject: "1"}); ErrorCode(err) != Conflict {
+		t.Errorf("LinkIdentity: got %v, want a Conflict error for a linked identity", err)
+	}
+	if unlinked, err := s.UnlinkIdentity(ctx, p.ID, "github", "1"); err != nil || unlinked.Identities != nil {
+		t.Errorf("UnlinkIdentity: got %v, %v, want no identities", unlinked, err)
+	}
+	if _, err := s.ResolveIdentity(ctx, "github", "1"); err != ErrNoSuchEntity {
+		t.Errorf("ResolveIdentity: got %v, want %v", err, ErrNoSuchEntity)
+	}
+
+	if err := s.DeleteProfile(ctx, p.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetProfile(ctx, p.ID); err != ErrNoSuchEntity {
+		t.Errorf("GetProfile: got %v, want %v", err, ErrNoSuchEntity)
+	}
+	if err := s.DeleteProfile(ctx, p.ID); err != ErrNoSuchEntity {
+		t.Errorf("DeleteProfile: got %v, want %v", err, ErrNoSuchEntity)
+	}
+}
+
+func TestPostgresPatchProfileConcurrently(t *testing.T) {
+	s := openPostgres(t)
+	ctx := context.Background()
+
+	patches := []*Profile{
+		{DisplayName: "Gunwoo Kim"},
+		{ImageURL: "https://gunwoo.org/gunwoo.png"},
+		{AboutMe: "superego"},
+		{Email: "ben.kim@greenenergytrading.com.au"},
+	}
+	p, err := s.PostProfile(ctx, &Profile{Email: "gunwoo@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(patches))
+	for _, patch := range patches {
+		wg.Add(1)
+		go func(patch *Profile) {
+			defer wg.Done()
+			if _, err := s.PatchProfile(ctx, p.ID, patch); err != nil {
+				errs <- err
+			}
+		}(patch)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("PatchProfile: %v", err)
+	}
+
+	got, err := s.GetProfile(ctx, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Profile{
+		ID:          p.ID,
+		DisplayName: "Gunwoo Kim",
+		Email:       "ben.kim@greenenergytrading.com.au",
+		ImageURL:    "https://gunwoo.org/gunwoo.png",
+		AboutMe:     "superego",
+		Revision:    int64(1 + len(patches)),
+		UpdateTime:  got.UpdateTime,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetProfile: got %+v, want %+v", got, want)
+	}
+}
+
+func TestPostgresEmailUniqueness(t *testing.T) {
+	s := openPostgres(t)
+	ctx := context.Background()
+
+	// only one of the concurrent posts of an email address succeeds.
+	const n = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := s.PostProfile(ctx, &Profile{Email: fmt.Sprintf("%sunwoo@gunwoo.org", []string{"g", "G"}[i%2])})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	var succeeded int
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		} else if e, ok := err.(*Error); !ok || e.Code != Conflict || e.ExistingID == "" {
+			t.Errorf("PostProfile: got %v, want a Conflict error with the existing id", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("PostProfile: %d posts succeeded, want 1", succeeded)
+	}
+}
+
+func TestPostgresMergeProfiles(t *testing.T) {
+	s := openPostgres(t)
+	ctx := context.Background()
+
+	survivor, err := s.PostProfile(ctx, &Profile{Email: "gunwoo@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	victim, err := s.PostProfile(ctx, &Profile{DisplayName: "ben", Email: "ben@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LinkIdentity(ctx, victim.ID, Identity{Provider: "github", Subject: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := s.MergeProfiles(ctx, survivor.ID, victim.ID, MergeStrategy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.ID != survivor.ID || merged.DisplayName != "ben" || merged.Email != "gunwoo@gunwoo.org" || len(merged.Identities) != 1 {
+		t.Errorf("MergeProfiles: got %+v, want the victim merged into the survivor", merged)
+	}
+	if got, err := s.GetProfile(ctx, victim.ID); err != nil || got.ID != survivor.ID {
+		t.Errorf("GetProfile: got %v, %v, want the victim redirected to %s", got, err, survivor.ID)
+	}
+	if got, err := s.ResolveIdentity(ctx, "github", "1"); err != nil || got.ID != survivor.ID {
+		t.Errorf("ResolveIdentity: got %v, %v, want the survivor", got, err)
+	}
+	got, err := s.GetProfiles(ctx, []string{victim.ID, "unknown", survivor.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0] == nil || got[0].ID != survivor.ID || got[1] != nil || got[2] == nil || got[2].ID != survivor.ID {
+		t.Errorf("GetProfiles: got %v, want the survivor, nil and the survivor", got)
+	}
+	if _, err := s.MergeProfiles(ctx, survivor.ID, victim.ID, MergeStrategy{}); err != ErrNoSuchEntity {
+		t.Errorf("MergeProfiles: got %v, want %v for a merged victim", err, ErrNoSuchEntity)
+	}
+
+	// a profile put at the id of the victim replaces the redirect.
+	if _, err := s.PutProfile(ctx, victim.ID, &Profile{Email: "ben@gunwoo.org"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.GetProfile(ctx, victim.ID); err != nil || got.ID != victim.ID {
+		t.Errorf("GetProfile: got %v, %v, want %s", got, err, victim.ID)
+	}
+}
+
+func TestPostgresListProfiles(t *testing.T) {
+	s := openPostgres(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"carol", "alice", "bob", "al_ice"} {
+		if _, err := s.PostProfile(ctx, &Profile{DisplayName: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var names []string
+	opts := ListOptions{PageSize: 3, OrderBy: "displayName"}
+	for {
+		profiles, next, err := s.ListProfiles(ctx, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range profiles {
+			names = append(names, p.DisplayName)
+		}
+		if next == "" {
+			break
+		}
+		opts.PageToken = next
+	}
+	if want := []string{"al_ice", "alice", "bob", "carol"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ListProfiles: got %v, want %v", names, want)
+	}
+
+	// the wildcards of LIKE are matched literally.
+	profiles, _, err := s.SearchProfiles(ctx, SearchQuery{DisplayNamePrefix: "al_"}, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 1 || profiles[0].DisplayName != "al_ice" {
+		t.Errorf("SearchProfiles: got %v, want al_ice", profiles)
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	s := openPostgres(t)
+	ctx := context.Background()
+	m := NewMigrator(s.db)
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt.IsZero() {
+			t.Errorf("Status: migration %d is pending", status.Version)
+		}
+	}
+	if applied, err := m.Up(ctx); err != nil || len(applied) != 0 {
+		t.Errorf("Up: got %v, %v, want no migrations", applied, err)
+	}
+
+	reverted, err := m.Down(ctx, m.Latest()+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != m.Latest() {
+		t.Errorf("Down: reverted %d migrations, want %d", len(reverted), m.Latest())
+	}
+	if version, err := m.Version(ctx); err != nil || version != 0 {
+		t.Errorf("Version: got %d, %v, want 0", version, err)
+	}
+	if _, err := OpenPostgresService(ctx, s.db); err == nil {
+		t.Error("OpenPostgresService: error should not be nil for a pending migration")
+	}
+}
+
+// pgError is an error of a postgres driver, which reports its SQLSTATE code
+// like pgx.
+type pgError string
+
+func (e pgError) Error() string    { return "pgError " + string(e) }
+func (e pgError) SQLState() string { return string(e) }
+
+// pqError reports its SQLSTATE code like lib/pq.
+type pqError map[byte]string
+
+func (e pqError) Error() string     { return "pqError " + e['C'] }
+func (e pqError) Get(k byte) string { return e[k] }
+
+func TestPostgresCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want Code
+	}{
+		{pgError(uniqueViolation), Conflict},
+		{pqError{'C': uniqueViolation}, Conflict},
+		{fmt.Errorf("commit: %w", pgError(serializationFailure)), Conflict},
+		{pgError("22021"), InvalidArgument},
+		{pgError("08006"), Unavailable},
+		{pgError("57P01"), Unavailable},
+		{pqError{'C': "28P01"}, PermissionDenied},
+		{pgError("42501"), PermissionDenied},
+		{pgError("42P01"), Unknown},
+		{context.DeadlineExceeded, Unavailable},
+		{errors.New(""), Unknown},
+	}
+	for _, tt := range tests {
+		if got := postgresCode(tt.err); got != tt.want {
+			t.Errorf("postgresCode(%v): got %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got, want := escapeLike(`50%_off\`), `50\%\_off\\`; got != want {
+		t.Errorf("escapeLike: got %q, want %q", got, want)
+	}
+}
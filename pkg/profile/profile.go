@@ -1,5 +1,7 @@
 package profile
 
+import "time"
+
 // Profile represents a person's profile.
 type Profile struct {
 	// The ID of the profile
@@ -14,6 +16,13 @@ type Profile struct {
 	ImageURL string `json:"imageUrl"`
 	// A short biography for this person.
 	AboutMe string `json:"aboutMe"`
+	// The revision of the profile, which is incremented by every update.
+	Revision int64 `json:"revision"`
+	// The time the profile was last modified, which is set by the backend.
+	UpdateTime time.Time `json:"updateTime"`
+	// The external identities of the person, which are managed by
+	// LinkIdentity and UnlinkIdentity.
+	Identities []Identity `json:"identities,omitempty" datastore:"-"`
 }
 
 // Name represents the individual components of a person's name.
@@ -0,0 +1,698 @@
+// Package profiletest provides a conformance suite for implementations of
+// profile.Service, so that every backend is held to the same contract.
+package profiletest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/benkim0414/superego/pkg/profile"
+)
+
+// Factory returns the service under test. It is called once per subtest,
+// and may skip the test if the backend is not available, or register
+// cleanups with t.Cleanup.
+//
+// The service does not need to be empty: every subtest uses unique email
+// addresses, names and identities, and only makes assertions about the
+// profiles it created, so that backends shared by several tests, such as a
+// datastore emulator, can be tested too.
+type Factory func(t *testing.T) profile.Service
+
+// Run runs the conformance suite against the services returned by
+// newService, each in its own subtest.
+func Run(t *testing.T, newService Factory) {
+	for _, test := range []struct {
+		name string
+		run  func(t *testing.T, s profile.Service, token string)
+	}{
+		{"PostProfile", testPostProfile},
+		{"GetProfile", testGetProfile},
+		{"GetProfiles", testGetProfiles},
+		{"PutProfile", testPutProfile},
+		{"PatchProfile", testPatchProfile},
+		{"DeleteProfile", testDeleteProfile},
+		{"LookupProfile", testLookupProfile},
+		{"ExpectedRevision", testExpectedRevision},
+		{"Identities", testIdentities},
+		{"MergeProfiles", testMergeProfiles},
+		{"ConcurrentPatches", testConcurrentPatches},
+		{"ConcurrentEmails", testConcurrentEmails},
+		{"ConcurrentIdentities", testConcurrentIdentities},
+		{"ListProfiles", testListProfiles},
+		{"SearchProfiles", testSearchProfiles},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, newService(t), newToken(t))
+		})
+	}
+}
+
+// newToken returns a random lowercase token, which makes the values used by
+// a subtest unique.
+func newToken(t *testing.T) string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// email returns a unique email address for the given user.
+func email(token, user string) string {
+	return user + "." + token + "@gunwoo.org"
+}
+
+func post(t *testing.T, s profile.Service, p *profile.Profile) *profile.Profile {
+	t.Helper()
+	got, err := s.PostProfile(context.Background(), p)
+	if err != nil {
+		t.Fatalf("PostProfile: %v", err)
+	}
+	return got
+}
+
+// missingID returns the id of a profile which does not exist anymore, which
+// is valid for the backend, unlike an arbitrary string.
+func missingID(t *testing.T, s profile.Service, token string) string {
+	t.Helper()
+	p := post(t, s, &profile.Profile{Email: email(token, "missing")})
+	if err := s.DeleteProfile(context.Background(), p.ID); err != nil {
+		t.Fatalf("DeleteProfile: %v", err)
+	}
+	return p.ID
+}
+
+// checkCode reports an error unless err has the given code.
+func checkCode(t *testing.T, method string, err error, want profile.Code) {
+	t.Helper()
+	if got := profile.ErrorCode(err); err == nil || got != want {
+		t.Errorf("%s: got %v, want a %v error", method, err, want)
+	}
+}
+
+func testPostProfile(t *testing.T, s profile.Service, token string) {
+	ctx := context.Background()
+	p := &profile.Profile{
+		DisplayName: "gunwoo",
+		Name:        profile.Name{Formatted: "Gunwoo Kim", FamilyName: "Kim", GivenName: "Gunwoo"},
+		Email:       email(token, "gunwoo"),
+		ImageURL:    "https://octodex.github.com/images/codercat.jpg",
+		AboutMe:     "Codercat",
+		Identities:  []profile.Identity{{Provider: "github", Subject: token}},
+	}
+	got := post(t, s, p)
+	if got.ID == "" || got.Revision != 1 || got.UpdateTime.IsZero() {
+		t.Errorf("PostProfile: got %+v, want an id at revision 1", got)
+	}
+	if got.Identities != nil {
+		t.Errorf("PostProfile: got the identities %v, want none", got.Identities)
+	}
+	other := post(t, s, &profile.Profile{Email: email(token, "other")})
+	if other.ID == got.ID {
+		t.Errorf("PostProfile: got the id %s twice", got.ID)
+	}
+
+	stored, err := s.GetProfile(ctx, got.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.DisplayName != "gunwoo" || stored.Name != p.Name || stored.Email != email(token, "gunwoo") ||
+		stored.ImageURL != p.ImageURL || stored.AboutMe != p.AboutMe || stored.Revision != 1 {
+		t.Errorf("GetProfile: got %+v, want the posted profile", stored)
+	}
+
+	// email addresses are unique regardless of case.
+	_, err = s.PostProfile(ctx, &profile.Profile{Email: strings.ToUpper(email(token, "gunwoo"))})
+	if e, ok := err.(*profile.Error); !ok || e.Code != profile.Conflict || e.ExistingID != got.ID {
+		t.Errorf("PostProfile: got %v, want a Conflict error with the existing id %s", err, got.ID)
+	}
+	// profiles without an email address do not conflict.
+	post(t, s, &profile.Profile{DisplayName: token})
+	post(t, s, &profile.Profile{DisplayName: token})
+}
+
+func testGetProfile(t *testing.T, s profile.Service, token string) {
+	ctx := context.Background()
+	p := post(t, s, &profile.Profile{Email: email(token, "gunwoo")})
+	got, err := s.GetProfile(ctx, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != p.ID || got.Email != p.Email || got.Revision != p.Revision || !got.UpdateTime.Equal(p.UpdateTime) {
+		t.Errorf("GetProfile: got %+v, want %+v", got, p)
+	}
+
+	_, err = s.GetProfile(ctx, missingID(t, s, token))
+	checkCode(t, "GetProfile", err, profile.NotFound)
+}
+
+func testGetProfiles(t *testing.T, s profile.Service, token string) {
+	ctx := context.Background()
+	a := post(t, s, &profile.Profile{Email: email(token, "a")})
+	b := post(t, s, &profile.Profile{Email: email(token, "b")})
+	missing := missingID(t, s, token)
+
+	got, err := s.GetProfiles(ctx, []string{b.ID, missing, a.ID, b.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 || got[0] == nil || got[0].ID != b.ID || got[1] != nil ||
+		got[2] == nil || got[2].ID != a.ID || got[3] == nil || got[3].ID != b.ID {
+		t.Errorf("GetProfiles: got %v, want b, nil, a and b", got)
+	}
+
+	got, err = s.GetProfiles(ctx, nil)
+	if err != nil || len(got) != 0 {
+		t.Errorf("GetProfiles: got %v, %v, want no profiles", got, err)
+	}
+}
+
+func testPutProfile(t *testing.T, s profile.Service, token string) {
+	ctx := context.Background()
+	p := post(t, s, &profile.Profile{DisplayName: "gunwoo", Email: email(token, "gunwoo"), AboutMe: "Codercat"})
+	if _, err := s.LinkIdentity(ctx, p.ID, profile.Identity{Provider: "github", Subject: token}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.PutProfile(ctx, p.ID, &profile.Profile{Email: email(token, "ben")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// PUT replaces all of the fields, but not the identities.
+	if got.ID != p.ID || got.DisplayName != "" || got.AboutMe != "" || got.Email != email(token, "ben") || got.Revision != 3 {
+		t.Errorf("PutProfile: got %+v, want the replaced profile at revision 3", got)
+	}
+	if len(got.Identities) != 1 {
+		t.Errorf("PutProfile: got the identities %v, want the linked identity", got.Identities)
+	}
+	if stored, err := s.GetProfile(ctx, p.ID); err != nil || stored.Email != email(token, "ben") || stored.AboutMe != "" {
+		t.Errorf("GetProfile: got %+v, %v, want the replaced profile", stored, err)
+	}
+
+	// the email address of the replaced profile is released.
+	other := post(t, s, &profile.Profile{Email: email(token, "gunwoo")})
+	_, err = s.PutProfile(ctx, other.ID, &profile.Profile{Email: email(token, "ben")})
+	checkCode(t, "PutProfile", err, profile.Conflict)
+
+	// PUT creates a profile which does not exist.
+	missing := missingID(t, s, token)
+	created, err := s.PutProfile(ctx, missing, &profile.Profile{Email: email(token, "created")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID != missing || created.Revision != 1 {
+		t.Errorf("PutProfile: got %+v, want a created profile at revision 1", created)
+	}
+}
+
+func testPatchProfile(t *testing.T, s profile.Service, token string) {
+	ctx := context.Background()
+	p := post(t, s, &profile.Profile{
+		DisplayName: "gunwoo",
+		Name:        profile.Name{GivenName: "Gunwoo"},
+		Email:       email(token, "gunwoo"),
+		AboutMe:     "Codercat",
+	})
+
+	// a profile patch only sets its non-zero fields.
+	got, err := s.PatchProfile(ctx, p.ID, &profile.Profile{Name: profile.Name{FamilyName: "Kim"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != p.ID || got.DisplayName != "gunwoo" || got.Name.GivenName != "Gunwoo" || got.Name.FamilyName != "Kim" ||
+		got.Email != p.Email || got.AboutMe != "Codercat" || got.Revision != 2 {
+		t.Errorf("PatchProfile: got %+v, want the patched profile at revision 2", got)
+	}
+	if got.UpdateTime.Before(p.UpdateTime) {
+		t.Errorf("PatchProfile: got the update time %v, want at least %v", got.UpdateTime, p.UpdateTime)
+	}
+
+	// a merge patch clears the fields which are null.
+	got, err = s.PatchProfile(ctx, p.ID, profile.MergePatch{"aboutMe": nil, "name": map[string]interface{}{"givenName": "Ben"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.AboutMe != "" || got.Name.GivenName != "Ben" || got.Name.FamilyName != "Kim" || got.DisplayName != "gunwoo" || got.Revision != 3 {
+		t.Errorf("PatchProfile: got %+v, want the merge patch applied at revision 3", got)
+	}
+
+	// a failed patch leaves the profile unchanged.
+	_, err = s.PatchProfile(ctx, p.ID, profile.JSONPatch{
+		{Op: "remove", Path: "/displayName"},
+		{Op: "test", Path: "/email", Value: []byte(`"nobody@gunwoo.org"`)},
+	})
+	checkCode(t, "PatchProfile", err, profile.Conflict)
+	_, err = s.PatchProfile(ctx, p.ID, profile.MergePatch{"id": "other"})
+	checkCode(t, "PatchProfile", err, profile.InvalidArgument)
+	if stored, err := s.GetProfile(ctx, p.ID); err != nil || stored.DisplayName != "gunwoo" || stored.Revision != 3 {
+		t.Errorf("GetProfile: got %+v, %v, want the profile unchanged by the failed patches", stored, err)
+	}
+
+	other := post(t, s, &profile.Profile{Email: email(token, "other")})
+	_, err = s.PatchProfile(ctx, other.ID, profile.MergePatch{"email": strings.ToUpper(p.Email)})
+	checkCode(t, "PatchProfile", err, profile.Conflict)
+	// a profile can change the case of its own email address.
+	if _, err := s.PatchProfile(ctx, p.ID, profile.MergePatch{"email": strings.ToUpper(p.Email)}); err != nil {
+		t.Errorf("PatchProfile: error should be nil, not %v", err)
+	}
+
+	_, err = s.PatchProfile(ctx, missingID(t, s, token), &profile.Profile{AboutMe: "Codercat"})
+	checkCode(t, "PatchProfile", err, profile.NotFound)
+}
+
+func testDeleteProfile(t *testing.T, s profile.Service, token string) {
+	ctx := context.Background()
+	p := post(t, s, &profile.Profile{Email: email(token, "gunwoo")})
+	if _, err := s.LinkIdentity(ctx, p.ID, profile.Identity{Provider: "github", Subject: token}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.DeleteProfile(ctx, p.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.GetProfile(ctx, p.ID)
+	checkCode(t, "GetProfile", err, profile.NotFound)
+	checkCode(t, "DeleteProfile", s.DeleteProfile(ctx, p.ID), profile.NotFound)
+
+	// the email address and the identities of the profile are released.
+	other := post(t, s, &profile.Profile{Email: email(token, "gunwoo")})
+	if _, err := s.LinkIdentity(ctx, other.ID, profile.Identity{Provider: "github", Subject: token}); err != nil {
+		t.Errorf("LinkIdentity: error should be nil, not %v", err)
+	}
+}
+
+func testLookupProfile(t *testing.T, s profile.Service, token string) {
+	ctx := context.Background()
+	p := post(t, s, &profile.Profile{Email: email(token, "gunwoo")})
+
+	got, err := s.LookupProfile(ctx, strings.ToUpper(p.Email))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != p.ID {
+		t.Errorf("LookupProfile: got %s, want %s", got.ID, p.ID)
+	}
+	_, err = s.LookupProfile(ctx, email(token, "nobody"))
+	checkCode(t, "LookupProfile", err, profile.NotFound)
+	_, err = s.LookupProfile(ctx, "")
+	checkCode(t, "LookupProfile", err, profile.InvalidArgument)
+}
+
+func testExpectedRevision(t *testing.T, s profile.Service, token string) {
+	ctx := context.Background()
+	p := post(t, s, &profile.Profile{Email: email(token, "gunwoo")})
+
+	stale := profile.WithExpectedRevision(ctx, p.Revision+1)
+	_, err := s.PutProfile(stale, p.ID, &profile.Profile{Email: p.Email})
+	checkCode(t, "PutProfile", err, profile.FailedPrecondition)
+	_, err = s.PatchProfile(stale, p.ID, &profile.Profile{AboutMe: "stale"})
+	checkCode(t, "PatchProfile", err, profile.FailedPrecondition)
+	_, err = s.LinkIdentity(stale, p.ID, profile.Identity{Provider: "github", Subject: token})
+	checkCode(t, "LinkIdentity", err, profile.FailedPrecondition)
+	checkCode(t, "DeleteProfile", s.DeleteProfile(stale, p.ID), profile.FailedPrecondition)
+
+	got, err := s.PatchProfile(profile.WithExpectedRevision(ctx, p.Revision), p.ID, &profile.Profile{AboutMe: "fresh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Revision != p.Revision+1 {
+		t.Errorf("PatchProfile: got revision %d, want %d", got.Revision, p.Revision+1)
+	}
+	// AnyRevision is satisfied by every revision of a profile which exists.
+	any := profile.WithExpectedRevision(ctx, profile.AnyRevision)
+	if got, err = s.PatchProfile(any, p.ID, &profile.Profile{AboutMe: "any"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteProfile(profile.WithExpectedRevision(ctx, got.Revision), p.ID); err != nil {
+		t.Errorf("DeleteProfile: error should be nil, not %v", err)
+	}
+	_, err = s.PutProfile(any, p.ID, &profile.Profile{Email: p.Email})
+	checkCode(t, "PutProfile", err, profile.FailedPrecondition)
+}
+
+func testIdentities(t *testing.T, s profile.Service, token string) {
+	ctx := context.Background()
+	p := post(t, s, &profile.Profile{Email: email(token, "gunwoo")})
+	other := post(t, s, &profile.Profile{Email: email(token, "other")})
+	identity := profile.Identity{Provider: "github", Subject: token, Metadata: map[string]string{"login": "gunwoo"}}
+
+	got, err := s.LinkIdentity(ctx, p.ID, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Identities) != 1 || got.Identities[0].Subject != token || got.Revision != 2 {
+		t.Errorf("LinkIdentity: got %+v, want the linked identity at revision 2", got)
+	}
+	// linking the identity again updates its metadata.
+	identity.Metadata = map[string]string{"login": "benkim0414"}
+	got, err = s.LinkIdentity(ctx, p.ID, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Identities) != 1 || got.Identities[0].Metadata["login"] != "benkim0414" {
+		t.Errorf("LinkIdentity: got %v, want the updated metadata", got.Identities)
+	}
+
+	_, err = s.LinkIdentity(ctx, other.ID, identity)
+	if e, ok := err.(*profile.Error); !ok || e.Code != profile.Conflict || e.ExistingID != p.ID {
+		t.Errorf("LinkIdentity: got %v, want a Conflict error with the existing id %s", err, p.ID)
+	}
+	_, err = s.LinkIdentity(ctx, p.ID, profile.Identity{Provider: "github"})
+	checkCode(t, "LinkIdentity", err, profile.InvalidArgument)
+	_, err = s.LinkIdentity(ctx, missingID(t, s, token), profile.Identity{Provider: "google", Subject: token})
+	checkCode(t, "LinkIdentity", err, profile.NotFound)
+
+	resolved, err := s.ResolveIdentity(ctx, "github", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.ID != p.ID {
+		t.Errorf("ResolveIdentity: got %s, want %s", resolved.ID, p.ID)
+	}
+	_, err = s.ResolveIdentity(ctx, "google", token)
+	checkCode(t, "ResolveIdentity", err, profile.NotFound)
+
+	got, err = s.UnlinkIdentity(ctx, p.ID, "github", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Identities) != 0 {
+		t.Errorf("UnlinkIdentity: got %v, want no identities", got.Identities)
+	}
+	_, err = s.UnlinkIdentity(ctx, p.ID, "github", token)
+	checkCode(t, "UnlinkIdentity", err, profile.NotFound)
+	_, err = s.ResolveIdentity(ctx, "github", token)
+	checkCode(t, "ResolveIdentity", err, profile.NotFound)
+
+	// the unlinked identity can be linked to another profile.
+	if _, err := s.LinkIdentity(ctx, other.ID, identity); err != nil {
+		t.Errorf("LinkIdentity: error should be nil, not %v", err)
+	}
+}
+
+func testMergeProfiles(t *testing.T, s profile.Service, token string) {
+	ctx := context.Background()
+	survivor := post(t, s, &profile.Profile{Email: email(token, "gunwoo"), AboutMe: "Codercat"})
+	victim := post(t, s, &profile.Profile{DisplayName: "ben", Email: email(token, "ben"), AboutMe: "Octocat"})
+	if _, err := s.LinkIdentity(ctx, victim.ID, profile.Identity{Provider: "github", Subject: token}); err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := s.MergeProfiles(ctx, survivor.ID, victim.ID, profile.MergeStrategy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the survivor keeps its own fields, and takes the fields it lacks.
+	if merged.ID != survivor.ID || merged.DisplayName != "ben" || merged.Email != survivor.Email ||
+		merged.AboutMe != "Codercat" || merged.Revision != survivor.Revision+1 {
+		t.Errorf("MergeProfiles: got %+v, want the victim merged into the survivor", merged)
+	}
+	if len(merged.Identities) != 1 {
+		t.Errorf("MergeProfiles: got the identities %v, want the identity of the victim", merged.Identities)
+	}
+
+	// the victim redirects to the survivor.
+	if got, err := s.GetProfile(ctx, victim.ID); err != nil || got.ID != survivor.ID {
+		t.Errorf("GetProfile: got %v, %v, want the survivor %s", got, err, survivor.ID)
+	}
+	got, err := s.GetProfiles(ctx, []string{victim.ID})
+	if err != nil || len(got) != 1 || got[0] == nil || got[0].ID != survivor.ID {
+		t.Errorf("GetProfiles: got %v, %v, want the survivor %s", got, err, survivor.ID)
+	}
+	if got, err := s.ResolveIdentity(ctx, "github", token); err != nil || got.ID != survivor.ID {
+		t.Errorf("ResolveIdentity: got %v, %v, want the survivor %s", got, err, survivor.ID)
+	}
+	// the email address of the victim is released.
+	_, err = s.LookupProfile(ctx, victim.Email)
+	checkCode(t, "LookupProfile", err, profile.NotFound)
+	post(t, s, &profile.Profile{Email: victim.Email})
+
+	_, err = s.MergeProfiles(ctx, survivor.ID, victim.ID, profile.MergeStrategy{})
+	checkCode(t, "MergeProfiles", err, profile.NotFound)
+	_, err = s.MergeProfiles(ctx, survivor.ID, survivor.ID, profile.MergeStrategy{})
+	checkCode(t, "MergeProfiles", err, profile.InvalidArgument)
+
+	// an explicit strategy fails on conflicting fields.
+	other := post(t, s, &profile.Profile{Email: email(token, "other"), AboutMe: "Octocat"})
+	_, err = s.MergeProfiles(ctx, survivor.ID, other.ID, profile.MergeStrategy{Policy: profile.Explicit})
+	checkCode(t, "MergeProfiles", err, profile.InvalidArgument)
+	if got, err := s.GetProfile(ctx, other.ID); err != nil || got.ID != other.ID {
+		t.Errorf("GetProfile: got %v, %v, want the profile unchanged by the failed merge", got, err)
+	}
+}
+
+func testConcurrentPatches(t *testing.T, s profile.Service, token string) {
+	ctx := context.Background()
+	p := post(t, s, &profile.Profile{Email: email(token, "gunwoo")})
+
+	patches := []*profile.Profile{
+		{DisplayName: "Gunwoo Kim"},
+		{ImageURL: "https://gunwoo.org/gunwoo.png"},
+		{AboutMe: "superego"},
+		{Email: email(token, "ben")},
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(patches))
+	for _, patch := range patches {
+		wg.Add(1)
+		go func(patch *profile.Profile) {
+			defer wg.Done()
+			if _, err := s.PatchProfile(ctx, p.ID, patch); err != nil {
+				errs <- err
+			}
+		}(patch)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("PatchProfile: %v", err)
+	}
+
+	// no patch is lost.
+	got, err := s.GetProfile(ctx, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DisplayName != "Gunwoo Kim" || got.ImageURL != "https://gunwoo.org/gunwoo.png" || got.AboutMe != "superego" ||
+		got.Email != email(token, "ben") || got.Revision != int64(1+len(patches)) {
+		t.Errorf("GetProfile: got %+v, want all of the patches applied", got)
+	}
+}
+
+func testConcurrentEmails(t *testing.T, s profile.Service, token string) {
+	ctx := context.Background()
+
+	// only one of the concurrent posts of an email address succeeds.
+	const n = 4
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			address := email(token, "gunwoo")
+			if i%2 == 1 {
+				address = strings.ToUpper(address)
+			}
+			_, err := s.PostProfile(ctx, &profile.Profile{Email: address})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	var succeeded int
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		} else {
+			checkCode(t, "PostProfile", err, profile.Conflict)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("PostProfile: %d posts succeeded, want 1", succeeded)
+	}
+}
+
+func testConcurrentIdentities(t *testing.T, s profile.Service, token string) {
+	ctx := context.Background()
+
+	// only one of the concurrent links of an identity to different profiles
+	// succeeds.
+	const n = 4
+	identity := profile.Identity{Provider: "google", Subject: token}
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = post(t, s, &profile.Profile{Email: email(token, fmt.Sprintf("gunwoo%d", i))}).ID
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = s.LinkIdentity(ctx, ids[i], identity)
+		}(i)
+	}
+	wg.Wait()
+	var winner string
+	for i, err := range errs {
+		if err == nil {
+			if winner != "" {
+				t.Errorf("LinkIdentity: linked to both %s and %s", winner, ids[i])
+			}
+			winner = ids[i]
+		} else {
+			checkCode(t, "LinkIdentity", err, profile.Conflict)
+		}
+	}
+	if winner == "" {
+		t.Fatal("LinkIdentity: no link succeeded")
+	}
+
+	got, err := s.ResolveIdentity(ctx, identity.Provider, identity.Subject)
+	if err != nil {
+		t.Fatalf("ResolveIdentity: %v", err)
+	}
+	if got.ID != winner {
+		t.Errorf("ResolveIdentity: got %s, want %s", got.ID, winner)
+	}
+	for _, id := range ids {
+		p, err := s.GetProfile(ctx, id)
+		if err != nil {
+			t.Fatalf("GetProfile: %v", err)
+		}
+		if id != winner && len(p.Identities) != 0 {
+			t.Errorf("GetProfile(%s): got identities %v, want none", id, p.Identities)
+		}
+	}
+}
+
+// list returns all of the pages of the query, and checks that none of them
+// is larger than the page size.
+func list(t *testing.T, query func(opts profile.ListOptions) ([]*profile.Profile, string, error), opts profile.ListOptions) []*profile.Profile {
+	t.Helper()
+	var profiles []*profile.Profile
+	for {
+		page, next, err := query(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.PageSize > 0 && len(page) > opts.PageSize {
+			t.Errorf("got a page of %d profiles, want at most %d", len(page), opts.PageSize)
+		}
+		profiles = append(profiles, page...)
+		if next == "" {
+			return profiles
+		}
+		opts.PageToken = next
+	}
+}
+
+// own returns the display names of the profiles created by the subtest, in
+// the order of the profiles.
+func own(profiles []*profile.Profile, token string) []string {
+	var names []string
+	for _, p := range profiles {
+		if strings.HasSuffix(p.DisplayName, token) {
+			names = append(names, strings.TrimSuffix(p.DisplayName, " "+token))
+		}
+	}
+	return names
+}
+
+func testListProfiles(t *testing.T, s profile.Service, token string) {
+	ctx := context.Background()
+	// the display names end with the token, so that the profiles of other
+	// tests can be told apart.
+	for _, name := range []string{"carol", "alice", "dave", "bob", "erin"} {
+		post(t, s, &profile.Profile{DisplayName: name + " " + token, Email: email(token, name)})
+	}
+	listProfiles := func(opts profile.ListOptions) ([]*profile.Profile, string, error) {
+		return s.ListProfiles(ctx, opts)
+	}
+
+	// every profile is listed exactly once across the pages.
+	names := own(list(t, listProfiles, profile.ListOptions{PageSize: 2}), token)
+	sort.Strings(names)
+	if want := []string{"alice", "bob", "carol", "dave", "erin"}; fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("ListProfiles: got %v, want %v", names, want)
+	}
+
+	names = own(list(t, listProfiles, profile.ListOptions{PageSize: 2, OrderBy: "displayName"}), token)
+	if want := []string{"alice", "bob", "carol", "dave", "erin"}; fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("ListProfiles: got %v, want %v ordered by display name", names, want)
+	}
+	names = own(list(t, listProfiles, profile.ListOptions{PageSize: 3, OrderBy: "-email"}), token)
+	if want := []string{"erin", "dave", "carol", "bob", "alice"}; fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("ListProfiles: got %v, want %v ordered by descending email", names, want)
+	}
+
+	_, _, err := s.ListProfiles(ctx, profile.ListOptions{PageSize: -1})
+	checkCode(t, "ListProfiles", err, profile.InvalidArgument)
+	_, _, err = s.ListProfiles(ctx, profile.ListOptions{OrderBy: "aboutMe"})
+	checkCode(t, "ListProfiles", err, profile.InvalidArgument)
+	_, _, err = s.ListProfiles(ctx, profile.ListOptions{PageToken: "%%%"})
+	checkCode(t, "ListProfiles", err, profile.InvalidArgument)
+}
+
+func testSearchProfiles(t *testing.T, s profile.Service, token string) {
+	ctx := context.Background()
+	for _, p := range []struct {
+		name, familyName string
+	}{
+		{"alice", "Kim"},
+		{"bob", "Lee"},
+		{"carol", "Kim"},
+		{"dave", "Park"},
+	} {
+		post(t, s, &profile.Profile{
+			DisplayName: p.name + " " + token,
+			Name:        profile.Name{FamilyName: p.familyName + token},
+			Email:       p.name + "." + token + "@gunwoo.org",
+		})
+	}
+	search := func(query profile.SearchQuery) func(opts profile.ListOptions) ([]*profile.Profile, string, error) {
+		return func(opts profile.ListOptions) ([]*profile.Profile, string, error) {
+			return s.SearchProfiles(ctx, query, opts)
+		}
+	}
+
+	for _, tt := range []struct {
+		query profile.SearchQuery
+		opts  profile.ListOptions
+		want  []string
+	}{
+		{profile.SearchQuery{FamilyName: "Kim" + token}, profile.ListOptions{OrderBy: "email"}, []string{"alice", "carol"}},
+		{profile.SearchQuery{FamilyName: "Kim" + token}, profile.ListOptions{PageSize: 1, OrderBy: "-displayName"}, []string{"carol", "alice"}},
+		{profile.SearchQuery{Email: "bob." + token + "@gunwoo.org"}, profile.ListOptions{}, []string{"bob"}},
+		{profile.SearchQuery{Email: " BOB." + strings.ToUpper(token) + "@Gunwoo.org "}, profile.ListOptions{}, []string{"bob"}},
+		{profile.SearchQuery{Email: "bob." + token + "@gunwoo.org", FamilyName: "Kim" + token}, profile.ListOptions{}, nil},
+		{profile.SearchQuery{DisplayNamePrefix: "c"}, profile.ListOptions{PageSize: 100}, []string{"carol"}},
+		{profile.SearchQuery{DisplayNamePrefix: "a", FamilyName: "Kim" + token}, profile.ListOptions{OrderBy: "-displayName"}, []string{"alice"}},
+		{profile.SearchQuery{EmailPrefix: "d", FamilyName: "Park" + token}, profile.ListOptions{}, []string{"dave"}},
+		{profile.SearchQuery{FamilyName: "Kim" + token, DisplayName: "bob " + token}, profile.ListOptions{}, nil},
+	} {
+		names := own(list(t, search(tt.query), tt.opts), token)
+		if fmt.Sprint(names) != fmt.Sprint(tt.want) {
+			t.Errorf("SearchProfiles(%+v, %+v): got %v, want %v", tt.query, tt.opts, names, tt.want)
+		}
+	}
+
+	_, _, err := s.SearchProfiles(ctx, profile.SearchQuery{EmailPrefix: "a", FamilyNamePrefix: "K"}, profile.ListOptions{})
+	checkCode(t, "SearchProfiles", err, profile.InvalidArgument)
+	_, _, err = s.SearchProfiles(ctx, profile.SearchQuery{Email: "a", EmailPrefix: "a"}, profile.ListOptions{})
+	checkCode(t, "SearchProfiles", err, profile.InvalidArgument)
+	_, _, err = s.SearchProfiles(ctx, profile.SearchQuery{Email: " "}, profile.ListOptions{})
+	checkCode(t, "SearchProfiles", err, profile.InvalidArgument)
+	// a search with a prefix filter can only be ordered by its field.
+	_, _, err = s.SearchProfiles(ctx, profile.SearchQuery{FamilyNamePrefix: "Kim" + token[:4]}, profile.ListOptions{OrderBy: "displayName"})
+	checkCode(t, "SearchProfiles", err, profile.InvalidArgument)
+}
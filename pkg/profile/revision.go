@@ -0,0 +1,40 @@
+package profile
+
+import "context"
+
+type revisionContextKey struct{}
+
+// AnyRevision is the expected revision of WithExpectedRevision which is
+// satisfied by every revision of a profile which exists, as is If-Match: *.
+const AnyRevision int64 = -1
+
+// WithExpectedRevision returns a copy of the context which makes PutProfile,
+// PatchProfile and DeleteProfile fail with FailedPrecondition unless the
+// stored profile is at the given revision. It allows callers to detect
+// concurrent modifications of a profile they have read.
+func WithExpectedRevision(ctx context.Context, revision int64) context.Context {
+	return context.WithValue(ctx, revisionContextKey{}, revision)
+}
+
+// ExpectedRevision returns the revision expected by the context, if any.
+func ExpectedRevision(ctx context.Context) (int64, bool) {
+	revision, ok := ctx.Value(revisionContextKey{}).(int64)
+	return revision, ok
+}
+
+// checkRevision returns a FailedPrecondition error if the context expects a
+// revision other than the current revision of the profile. The current
+// revision of a profile which does not exist is zero.
+func checkRevision(ctx context.Context, current int64) error {
+	expected, ok := ExpectedRevision(ctx)
+	if !ok || expected == current {
+		return nil
+	}
+	if expected == AnyRevision {
+		if current > 0 {
+			return nil
+		}
+		return Errorf(FailedPrecondition, "revision mismatch: expected an existing profile")
+	}
+	return Errorf(FailedPrecondition, "revision mismatch: expected %d, got %d", expected, current)
+}
@@ -0,0 +1,34 @@
+package profile
+
+import (
+	"context"
+	"testing"
+)
+
+func TestExpectedRevision(t *testing.T) {
+	ctx := context.Background()
+	if _, ok := ExpectedRevision(ctx); ok {
+		t.Error("ExpectedRevision: should not be ok without an expected revision")
+	}
+
+	ctx = WithExpectedRevision(ctx, 3)
+	got, ok := ExpectedRevision(ctx)
+	if !ok || got != 3 {
+		t.Errorf("ExpectedRevision: got (%d, %t), want (%d, %t)", got, ok, 3, true)
+	}
+}
+
+func TestCheckRevision(t *testing.T) {
+	ctx := context.Background()
+	if err := checkRevision(ctx, 1); err != nil {
+		t.Errorf("checkRevision: error should be nil, not %v", err)
+	}
+
+	ctx = WithExpectedRevision(ctx, 2)
+	if err := checkRevision(ctx, 2); err != nil {
+		t.Errorf("checkRevision: error should be nil, not %v", err)
+	}
+	if code := ErrorCode(checkRevision(ctx, 1)); code != FailedPrecondition {
+		t.Errorf("checkRevision: got %v, want %v", code, FailedPrecondition)
+	}
+}
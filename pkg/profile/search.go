@@ -0,0 +1,125 @@
+package profile
+
+import "strings"
+
+// SearchQuery specifies the filters of SearchProfiles.
+// Empty filters are ignored, and the remaining filters are combined with AND.
+// At most one prefix filter is supported, and the profiles matching it can
+// only be ordered by its field, which is the default order.
+type SearchQuery struct {
+	// Matches profiles with the given email address, which is compared
+	// case-insensitively as LookupProfile does.
+	Email string `json:"email"`
+	// Matches profiles with an email address starting with the given prefix.
+	EmailPrefix string `json:"emailPrefix"`
+	// Matches profiles with exactly the given display name.
+	DisplayName string `json:"displayName"`
+	// Matches profiles with a display name starting with the given prefix.
+	DisplayNamePrefix string `json:"displayNamePrefix"`
+	// Matches profiles with exactly the given family name.
+	FamilyName string `json:"familyName"`
+	// Matches profiles with a family name starting with the given prefix.
+	FamilyNamePrefix string `json:"familyNamePrefix"`
+	// Matches profiles with exactly the given given name.
+	GivenName string `json:"givenName"`
+	// Matches profiles with a given name starting with the given prefix.
+	GivenNamePrefix string `json:"givenNamePrefix"`
+}
+
+var (
+	errMultiplePrefixFilters = Errorf(InvalidArgument, "at most one prefix filter is supported")
+	errConflictingFilters    = Errorf(InvalidArgument, "exact and prefix filters on the same field are not supported")
+	errPrefixOrder           = Errorf(InvalidArgument, "a search with a prefix filter can only be ordered by its field")
+	errEmptyEmail            = Errorf(InvalidArgument, "empty email filter")
+)
+
+// searchFilter is a single filter of a SearchQuery.
+type searchFilter struct {
+	name   string
+	field  orderField
+	value  string
+	prefix bool
+	// whether the filter matches the emailKey of the address, which is its
+	// value, rather than the address itself.
+	emailKey bool
+}
+
+// match reports whether the profile satisfies the filter.
+func (f searchFilter) match(p *Profile) bool {
+	switch {
+	case f.prefix:
+		return strings.HasPrefix(f.field.value(p), f.value)
+	case f.emailKey:
+		return emailKey(f.field.value(p)) == f.value
+	}
+	return f.field.value(p) == f.value
+}
+
+// filters returns the non-empty filters of the query. Backends only support
+// a single prefix filter, since a prefix is an inequality filter in Datastore.
+func (q SearchQuery) filters() ([]searchFilter, error) {
+	var filters []searchFilter
+	var prefixes int
+	for _, f := range []struct {
+		name          string
+		exact, prefix string
+	}{
+		{"email", q.Email, q.EmailPrefix},
+		{"displayName", q.DisplayName, q.DisplayNamePrefix},
+		{"name.familyName", q.FamilyName, q.FamilyNamePrefix},
+		{"name.givenName", q.GivenName, q.GivenNamePrefix},
+	} {
+		if f.exact != "" && f.prefix != "" {
+			return nil, errConflictingFilters
+		}
+		if f.exact != "" && f.name == "email" {
+			if emailKey(f.exact) == "" {
+				return nil, errEmptyEmail
+			}
+			filters = append(filters, searchFilter{name: f.name, field: orderFields[f.name], value: emailKey(f.exact), emailKey: true})
+		} else if f.exact != "" {
+			filters = append(filters, searchFilter{name: f.name, field: orderFields[f.name], value: f.exact})
+		}
+		if f.prefix != "" {
+			prefixes++
+			filters = append(filters, searchFilter{name: f.name, field: orderFields[f.name], value: f.prefix, prefix: true})
+		}
+	}
+	if prefixes > 1 {
+		return nil, errMultiplePrefixFilters
+	}
+	return filters, nil
+}
+
+// searchOptions returns the options of a search with the given filters. A
+// prefix is an inequality filter in Datastore, which must be the first sort
+// order, so a search with a prefix filter is ordered by its field.
+func searchOptions(filters []searchFilter, opts ListOptions) (ListOptions, error) {
+	for _, f := range filters {
+		if !f.prefix {
+			continue
+		}
+		field, _, err := parseOrderBy(opts.OrderBy)
+		if err != nil {
+			return opts, err
+		}
+		switch field.property {
+		case "":
+			opts.OrderBy = f.name
+		case f.field.property:
+		default:
+			return opts, errPrefixOrder
+		}
+	}
+	return opts, nil
+}
+
+// match reports whether the profile satisfies all of the filters.
+func match(filters []searchFilter, p *Profile) bool {
+	for _, f := range filters {
+		if !f.match(p) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package profile
+
+import "testing"
+
+func TestSearchQueryFilters(t *testing.T) {
+	tests := []struct {
+		query   SearchQuery
+		want    int
+		wantErr error
+	}{
+		{SearchQuery{}, 0, nil},
+		{SearchQuery{Email: "gunwoo@gunwoo.org", FamilyNamePrefix: "Ki"}, 2, nil},
+		{SearchQuery{Email: "gunwoo@gunwoo.org", EmailPrefix: "gunwoo"}, 0, errConflictingFilters},
+		{SearchQuery{EmailPrefix: "gunwoo", GivenNamePrefix: "Gun"}, 0, errMultiplePrefixFilters},
+	}
+	for _, tt := range tests {
+		filters, err := tt.query.filters()
+		if len(filters) != tt.want {
+			t.Errorf("filters(%+v): got %d filters, want %d", tt.query, len(filters), tt.want)
+		}
+		if err != tt.wantErr {
+			t.Errorf("filters(%+v): got %v, want %v", tt.query, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	p := &Profile{
+		DisplayName: "benkim0414",
+		Email:       "gunwoo@gunwoo.org",
+		Name:        Name{FamilyName: "Kim", GivenName: "Gunwoo"},
+	}
+	tests := []struct {
+		query SearchQuery
+		want  bool
+	}{
+		{SearchQuery{}, true},
+		{SearchQuery{Email: "gunwoo@gunwoo.org"}, true},
+		{SearchQuery{Email: "gunwoo"}, false},
+		{SearchQuery{EmailPrefix: "gunwoo"}, true},
+		{SearchQuery{DisplayNamePrefix: "ben", FamilyName: "Kim"}, true},
+		{SearchQuery{GivenNamePrefix: "Gun", FamilyName: "Lee"}, false},
+	}
+	for _, tt := range tests {
+		filters, err := tt.query.filters()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := match(filters, p); got != tt.want {
+			t.Errorf("match(%+v): got %t, want %t", tt.query, got, tt.want)
+		}
+	}
+}
@@ -6,16 +6,53 @@ import (
 	"cloud.google.com/go/datastore"
 )
 
+// MaxBatchSize is the maximum number of profiles retrieved by a single
+// GetProfiles call, which is the limit of a datastore lookup.
+const MaxBatchSize = 1000
+
 // Service is a simple CRUD interface for user profiles.
 type Service interface {
 	PostProfile(ctx context.Context, p *Profile) (*Profile, error)
+	// GetProfile returns the profile with the given id. The id of a profile
+	// which has been merged into another resolves to the survivor.
 	GetProfile(ctx context.Context, id string) (*Profile, error)
+	// GetProfiles returns the profiles with the given ids in a single round
+	// trip, in the same order as the ids. The profile of an id which does
+	// not exist, or is malformed, is nil. At most MaxBatchSize profiles can
+	// be retrieved at once.
+	GetProfiles(ctx context.Context, ids []string) ([]*Profile, error)
 	PutProfile(ctx context.Context, id string, p *Profile) (*Profile, error)
-	PatchProfile(ctx context.Context, id string, p *Profile) (*Profile, error)
+	// PatchProfile applies the patch to the profile and returns the patched
+	// profile.
+	PatchProfile(ctx context.Context, id string, patch Patch) (*Profile, error)
 	DeleteProfile(ctx context.Context, id string) error
+	// LookupProfile returns the profile with the given email address. Email
+	// addresses are unique across profiles, regardless of case.
+	LookupProfile(ctx context.Context, email string) (*Profile, error)
+	// LinkIdentity links the external identity to the profile, or updates
+	// its metadata if it is already linked to the profile. An identity can
+	// only be linked to a single profile.
+	LinkIdentity(ctx context.Context, id string, identity Identity) (*Profile, error)
+	// UnlinkIdentity unlinks the external identity from the profile.
+	UnlinkIdentity(ctx context.Context, id string, provider, subject string) (*Profile, error)
+	// ResolveIdentity returns the profile which the external identity is
+	// linked to.
+	ResolveIdentity(ctx context.Context, provider, subject string) (*Profile, error)
+	// MergeProfiles merges the victim into the survivor in a single
+	// transaction: the fields are combined according to the strategy, the
+	// identities of the victim are moved to the survivor, and the victim is
+	// replaced by a redirect to the survivor. An expected revision applies
+	// to the survivor.
+	MergeProfiles(ctx context.Context, survivorID, victimID string, strategy MergeStrategy) (*Profile, error)
+	// ListProfiles returns a page of profiles and the token to retrieve the
+	// next page, which is empty if there are no more profiles.
+	ListProfiles(ctx context.Context, opts ListOptions) ([]*Profile, string, error)
+	// SearchProfiles is like ListProfiles, but only returns the profiles
+	// matching all of the filters of the query.
+	SearchProfiles(ctx context.Context, query SearchQuery, opts ListOptions) ([]*Profile, string, error)
 }
 
 // NewService returns a datastore service with all of the expected middlewares wired in.
-func NewService(client *datastore.Client) Service {
-	return newDatastoreService(client)
+func NewService(client *datastore.Client, opts ...Option) Service {
+	return newDatastoreService(client, opts...)
 }
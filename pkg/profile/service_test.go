@@ -10,7 +10,7 @@ import (
 )
 
 func TestNewService(t *testing.T) {
-	tc := testutil.SystemTestContext(t)
+	tc := testutil.EmulatorTestContext(t)
 	ctx := context.Background()
 
 	client, err := datastore.NewClient(ctx, tc.ProjectID)
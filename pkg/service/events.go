@@ -0,0 +1,71 @@
+package service
+
+import (
+	"sync"
+
+	"github.com/benkim0414/superego/pkg/profile"
+)
+
+// ChangeType is the kind of change of a profile.
+type ChangeType string
+
+const (
+	Created ChangeType = "CREATED"
+	Updated ChangeType = "UPDATED"
+	Deleted ChangeType = "DELETED"
+)
+
+// Change describes a change of a profile. The profile is the profile after
+// the change, or nil if it was deleted.
+type Change struct {
+	Type    ChangeType
+	ID      string
+	Profile *profile.Profile
+}
+
+// subscriptionBuffer is the number of changes buffered for each subscriber.
+const subscriptionBuffer = 64
+
+// EventBus delivers the changes of profiles published by the service to the
+// subscribers in the same process. A subscriber which does not keep up
+// misses changes rather than blocking the service.
+type EventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan Change]struct{}
+}
+
+// NewEventBus returns an event bus without subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: map[chan Change]struct{}{}}
+}
+
+// Publish delivers the change to the subscribers.
+func (b *EventBus) Publish(c Change) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- c:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of the changes published from now on, and a
+// function which cancels the subscription and closes the channel.
+func (b *EventBus) Subscribe() (<-chan Change, func()) {
+	ch := make(chan Change, subscriptionBuffer)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package service
+
+import "testing"
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus()
+	a, cancelA := bus.Subscribe()
+	b, cancelB := bus.Subscribe()
+	defer cancelB()
+
+	bus.Publish(Change{Type: Created, ID: "a"})
+	cancelA()
+	cancelA()
+	bus.Publish(Change{Type: Deleted, ID: "a"})
+
+	if c := <-a; c.Type != Created {
+		t.Errorf("got %s, want %s", c.Type, Created)
+	}
+	if _, ok := <-a; ok {
+		t.Error("channel of a canceled subscription should be closed")
+	}
+	for _, want := range []ChangeType{Created, Deleted} {
+		if c := <-b; c.Type != want {
+			t.Errorf("got %s, want %s", c.Type, want)
+		}
+	}
+
+	// a subscriber which does not keep up misses changes.
+	for i := 0; i < subscriptionBuffer+1; i++ {
+		bus.Publish(Change{Type: Updated, ID: "a"})
+	}
+	if got := len(b); got != subscriptionBuffer {
+		t.Errorf("got %d buffered changes, want %d", got, subscriptionBuffer)
+	}
+}
@@ -38,3 +38,28 @@ type InstrumentingMiddleware struct {
 	RequestLatency metrics.Histogram
 	Next           Service
 }
+
+// NewValidatingMiddleware returns a service middleware that rejects invalid
+// profiles before they are stored, including the results of patches.
+func NewValidatingMiddleware() Middleware {
+	return func(next Service) Service {
+		return &ValidatingMiddleware{next}
+	}
+}
+
+type ValidatingMiddleware struct {
+	Next Service
+}
+
+// NewPublishingMiddleware returns a service middleware that publishes the
+// changes of profiles to the event bus after they succeed.
+func NewPublishingMiddleware(bus *EventBus) Middleware {
+	return func(next Service) Service {
+		return &PublishingMiddleware{bus, next}
+	}
+}
+
+type PublishingMiddleware struct {
+	Bus  *EventBus
+	Next Service
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/benkim0414/superego/pkg/profile"
+	"github.com/benkim0414/superego/pkg/validation"
 )
 
 func (mw LoggingMiddleware) PostProfile(ctx context.Context, p *profile.Profile) (profile *profile.Profile, err error) {
@@ -22,6 +23,13 @@ func (mw LoggingMiddleware) GetProfile(ctx context.Context, id string) (profile
 	return mw.Next.GetProfile(ctx, id)
 }
 
+func (mw LoggingMiddleware) GetProfiles(ctx context.Context, ids []string) (profiles []*profile.Profile, err error) {
+	defer func(begin time.Time) {
+		mw.Logger.Log("method", "GetProfiles", "count", len(ids), "took", time.Since(begin), "err", err)
+	}(time.Now())
+	return mw.Next.GetProfiles(ctx, ids)
+}
+
 func (mw LoggingMiddleware) PutProfile(ctx context.Context, id string, p *profile.Profile) (profile *profile.Profile, err error) {
 	defer func(begin time.Time) {
 		mw.Logger.Log("method", "PutProfile", "id", id, "took", time.Since(begin), "err", err)
@@ -29,11 +37,11 @@ func (mw LoggingMiddleware) PutProfile(ctx context.Context, id string, p *profil
 	return mw.Next.PutProfile(ctx, id, p)
 }
 
-func (mw LoggingMiddleware) PatchProfile(ctx context.Context, id string, p *profile.Profile) (profile *profile.Profile, err error) {
+func (mw LoggingMiddleware) PatchProfile(ctx context.Context, id string, patch profile.Patch) (profile *profile.Profile, err error) {
 	defer func(begin time.Time) {
 		mw.Logger.Log("method", "PatchProfile", "id", id, "took", time.Since(begin), "err", err)
 	}(time.Now())
-	return mw.Next.PatchProfile(ctx, id, p)
+	return mw.Next.PatchProfile(ctx, id, patch)
 }
 
 func (mw LoggingMiddleware) DeleteProfile(ctx context.Context, id string) (err error) {
@@ -43,6 +51,55 @@ func (mw LoggingMiddleware) DeleteProfile(ctx context.Context, id string) (err e
 	return mw.Next.DeleteProfile(ctx, id)
 }
 
+func (mw LoggingMiddleware) LookupProfile(ctx context.Context, email string) (profile *profile.Profile, err error) {
+	defer func(begin time.Time) {
+		mw.Logger.Log("method", "LookupProfile", "took", time.Since(begin), "err", err)
+	}(time.Now())
+	return mw.Next.LookupProfile(ctx, email)
+}
+
+func (mw LoggingMiddleware) LinkIdentity(ctx context.Context, id string, identity profile.Identity) (profile *profile.Profile, err error) {
+	defer func(begin time.Time) {
+		mw.Logger.Log("method", "LinkIdentity", "id", id, "provider", identity.Provider, "took", time.Since(begin), "err", err)
+	}(time.Now())
+	return mw.Next.LinkIdentity(ctx, id, identity)
+}
+
+func (mw LoggingMiddleware) UnlinkIdentity(ctx context.Context, id string, provider, subject string) (profile *profile.Profile, err error) {
+	defer func(begin time.Time) {
+		mw.Logger.Log("method", "UnlinkIdentity", "id", id, "provider", provider, "took", time.Since(begin), "err", err)
+	}(time.Now())
+	return mw.Next.UnlinkIdentity(ctx, id, provider, subject)
+}
+
+func (mw LoggingMiddleware) ResolveIdentity(ctx context.Context, provider, subject string) (profile *profile.Profile, err error) {
+	defer func(begin time.Time) {
+		mw.Logger.Log("method", "ResolveIdentity", "provider", provider, "took", time.Since(begin), "err", err)
+	}(time.Now())
+	return mw.Next.ResolveIdentity(ctx, provider, subject)
+}
+
+func (mw LoggingMiddleware) MergeProfiles(ctx context.Context, survivorID, victimID string, strategy profile.MergeStrategy) (profile *profile.Profile, err error) {
+	defer func(begin time.Time) {
+		mw.Logger.Log("method", "MergeProfiles", "survivor_id", survivorID, "victim_id", victimID, "policy", strategy.Policy, "took", time.Since(begin), "err", err)
+	}(time.Now())
+	return mw.Next.MergeProfiles(ctx, survivorID, victimID, strategy)
+}
+
+func (mw LoggingMiddleware) ListProfiles(ctx context.Context, opts profile.ListOptions) (profiles []*profile.Profile, nextPageToken string, err error) {
+	defer func(begin time.Time) {
+		mw.Logger.Log("method", "ListProfiles", "page_size", opts.PageSize, "order_by", opts.OrderBy, "took", time.Since(begin), "err", err)
+	}(time.Now())
+	return mw.Next.ListProfiles(ctx, opts)
+}
+
+func (mw LoggingMiddleware) SearchProfiles(ctx context.Context, query profile.SearchQuery, opts profile.ListOptions) (profiles []*profile.Profile, nextPageToken string, err error) {
+	defer func(begin time.Time) {
+		mw.Logger.Log("method", "SearchProfiles", "page_size", opts.PageSize, "order_by", opts.OrderBy, "took", time.Since(begin), "err", err)
+	}(time.Now())
+	return mw.Next.SearchProfiles(ctx, query, opts)
+}
+
 func (mw InstrumentingMiddleware) PostProfile(ctx context.Context, p *profile.Profile) (profile *profile.Profile, err error) {
 	defer func(begin time.Time) {
 		lvs := []string{"method", "PostProfile", "error", fmt.Sprint(err != nil)}
@@ -63,6 +120,16 @@ func (mw InstrumentingMiddleware) GetProfile(ctx context.Context, id string) (pr
 	return
 }
 
+func (mw InstrumentingMiddleware) GetProfiles(ctx context.Context, ids []string) (profiles []*profile.Profile, err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "GetProfiles", "error", fmt.Sprint(err != nil)}
+		mw.RequestCount.With(lvs...).Add(1)
+		mw.RequestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	profiles, err = mw.Next.GetProfiles(ctx, ids)
+	return
+}
+
 func (mw InstrumentingMiddleware) PutProfile(ctx context.Context, id string, p *profile.Profile) (profile *profile.Profile, err error) {
 	defer func(begin time.Time) {
 		lvs := []string{"method", "PutProfile", "error", fmt.Sprint(err != nil)}
@@ -73,13 +140,13 @@ func (mw InstrumentingMiddleware) PutProfile(ctx context.Context, id string, p *
 	return
 }
 
-func (mw InstrumentingMiddleware) PatchProfile(ctx context.Context, id string, p *profile.Profile) (profile *profile.Profile, err error) {
+func (mw InstrumentingMiddleware) PatchProfile(ctx context.Context, id string, patch profile.Patch) (profile *profile.Profile, err error) {
 	defer func(begin time.Time) {
 		lvs := []string{"method", "PatchProfile", "error", fmt.Sprint(err != nil)}
 		mw.RequestCount.With(lvs...).Add(1)
 		mw.RequestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
 	}(time.Now())
-	profile, err = mw.Next.PatchProfile(ctx, id, p)
+	profile, err = mw.Next.PatchProfile(ctx, id, patch)
 	return
 }
 
@@ -92,3 +159,225 @@ func (mw InstrumentingMiddleware) DeleteProfile(ctx context.Context, id string)
 	err = mw.Next.DeleteProfile(ctx, id)
 	return
 }
+
+func (mw InstrumentingMiddleware) LookupProfile(ctx context.Context, email string) (profile *profile.Profile, err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "LookupProfile", "error", fmt.Sprint(err != nil)}
+		mw.RequestCount.With(lvs...).Add(1)
+		mw.RequestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	profile, err = mw.Next.LookupProfile(ctx, email)
+	return
+}
+
+func (mw InstrumentingMiddleware) LinkIdentity(ctx context.Context, id string, identity profile.Identity) (profile *profile.Profile, err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "LinkIdentity", "error", fmt.Sprint(err != nil)}
+		mw.RequestCount.With(lvs...).Add(1)
+		mw.RequestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	profile, err = mw.Next.LinkIdentity(ctx, id, identity)
+	return
+}
+
+func (mw InstrumentingMiddleware) UnlinkIdentity(ctx context.Context, id string, provider, subject string) (profile *profile.Profile, err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "UnlinkIdentity", "error", fmt.Sprint(err != nil)}
+		mw.RequestCount.With(lvs...).Add(1)
+		mw.RequestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	profile, err = mw.Next.UnlinkIdentity(ctx, id, provider, subject)
+	return
+}
+
+func (mw InstrumentingMiddleware) ResolveIdentity(ctx context.Context, provider, subject string) (profile *profile.Profile, err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "ResolveIdentity", "error", fmt.Sprint(err != nil)}
+		mw.RequestCount.With(lvs...).Add(1)
+		mw.RequestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	profile, err = mw.Next.ResolveIdentity(ctx, provider, subject)
+	return
+}
+
+func (mw InstrumentingMiddleware) MergeProfiles(ctx context.Context, survivorID, victimID string, strategy profile.MergeStrategy) (profile *profile.Profile, err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "MergeProfiles", "error", fmt.Sprint(err != nil)}
+		mw.RequestCount.With(lvs...).Add(1)
+		mw.RequestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	profile, err = mw.Next.MergeProfiles(ctx, survivorID, victimID, strategy)
+	return
+}
+
+func (mw InstrumentingMiddleware) ListProfiles(ctx context.Context, opts profile.ListOptions) (profiles []*profile.Profile, nextPageToken string, err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "ListProfiles", "error", fmt.Sprint(err != nil)}
+		mw.RequestCount.With(lvs...).Add(1)
+		mw.RequestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	profiles, nextPageToken, err = mw.Next.ListProfiles(ctx, opts)
+	return
+}
+
+func (mw InstrumentingMiddleware) SearchProfiles(ctx context.Context, query profile.SearchQuery, opts profile.ListOptions) (profiles []*profile.Profile, nextPageToken string, err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "SearchProfiles", "error", fmt.Sprint(err != nil)}
+		mw.RequestCount.With(lvs...).Add(1)
+		mw.RequestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	profiles, nextPageToken, err = mw.Next.SearchProfiles(ctx, query, opts)
+	return
+}
+
+func (mw ValidatingMiddleware) PostProfile(ctx context.Context, p *profile.Profile) (*profile.Profile, error) {
+	if err := validation.Validate(p); err != nil {
+		return nil, err
+	}
+	return mw.Next.PostProfile(ctx, p)
+}
+
+func (mw ValidatingMiddleware) GetProfile(ctx context.Context, id string) (*profile.Profile, error) {
+	return mw.Next.GetProfile(ctx, id)
+}
+
+func (mw ValidatingMiddleware) GetProfiles(ctx context.Context, ids []string) ([]*profile.Profile, error) {
+	if len(ids) > profile.MaxBatchSize {
+		return nil, profile.InvalidFields("too many ids", profile.FieldViolation{
+			Field:       "ids",
+			Description: fmt.Sprintf("must have at most %d ids", profile.MaxBatchSize),
+		})
+	}
+	return mw.Next.GetProfiles(ctx, ids)
+}
+
+func (mw ValidatingMiddleware) PutProfile(ctx context.Context, id string, p *profile.Profile) (*profile.Profile, error) {
+	if err := validation.Validate(p); err != nil {
+		return nil, err
+	}
+	return mw.Next.PutProfile(ctx, id, p)
+}
+
+func (mw ValidatingMiddleware) PatchProfile(ctx context.Context, id string, patch profile.Patch) (*profile.Profile, error) {
+	return mw.Next.PatchProfile(ctx, id, validation.Patch(patch))
+}
+
+func (mw ValidatingMiddleware) DeleteProfile(ctx context.Context, id string) error {
+	return mw.Next.DeleteProfile(ctx, id)
+}
+
+func (mw ValidatingMiddleware) LookupProfile(ctx context.Context, email string) (*profile.Profile, error) {
+	return mw.Next.LookupProfile(ctx, email)
+}
+
+func (mw ValidatingMiddleware) LinkIdentity(ctx context.Context, id string, identity profile.Identity) (*profile.Profile, error) {
+	return mw.Next.LinkIdentity(ctx, id, identity)
+}
+
+func (mw ValidatingMiddleware) UnlinkIdentity(ctx context.Context, id string, provider, subject string) (*profile.Profile, error) {
+	return mw.Next.UnlinkIdentity(ctx, id, provider, subject)
+}
+
+func (mw ValidatingMiddleware) ResolveIdentity(ctx context.Context, provider, subject string) (*profile.Profile, error) {
+	return mw.Next.ResolveIdentity(ctx, provider, subject)
+}
+
+// MergeProfiles validates the strategy up front; the merged profile consists
+// of the fields of stored profiles.
+func (mw ValidatingMiddleware) MergeProfiles(ctx context.Context, survivorID, victimID string, strategy profile.MergeStrategy) (*profile.Profile, error) {
+	if err := strategy.Validate(); err != nil {
+		return nil, err
+	}
+	return mw.Next.MergeProfiles(ctx, survivorID, victimID, strategy)
+}
+
+func (mw ValidatingMiddleware) ListProfiles(ctx context.Context, opts profile.ListOptions) ([]*profile.Profile, string, error) {
+	return mw.Next.ListProfiles(ctx, opts)
+}
+
+func (mw ValidatingMiddleware) SearchProfiles(ctx context.Context, query profile.SearchQuery, opts profile.ListOptions) ([]*profile.Profile, string, error) {
+	return mw.Next.SearchProfiles(ctx, query, opts)
+}
+
+func (mw PublishingMiddleware) PostProfile(ctx context.Context, p *profile.Profile) (*profile.Profile, error) {
+	p, err := mw.Next.PostProfile(ctx, p)
+	if err == nil {
+		mw.Bus.Publish(Change{Type: Created, ID: p.ID, Profile: p})
+	}
+	return p, err
+}
+
+func (mw PublishingMiddleware) GetProfile(ctx context.Context, id string) (*profile.Profile, error) {
+	return mw.Next.GetProfile(ctx, id)
+}
+
+func (mw PublishingMiddleware) GetProfiles(ctx context.Context, ids []string) ([]*profile.Profile, error) {
+	return mw.Next.GetProfiles(ctx, ids)
+}
+
+func (mw PublishingMiddleware) PutProfile(ctx context.Context, id string, p *profile.Profile) (*profile.Profile, error) {
+	p, err := mw.Next.PutProfile(ctx, id, p)
+	if err == nil {
+		mw.Bus.Publish(Change{Type: Updated, ID: p.ID, Profile: p})
+	}
+	return p, err
+}
+
+func (mw PublishingMiddleware) PatchProfile(ctx context.Context, id string, patch profile.Patch) (*profile.Profile, error) {
+	p, err := mw.Next.PatchProfile(ctx, id, patch)
+	if err == nil {
+		mw.Bus.Publish(Change{Type: Updated, ID: p.ID, Profile: p})
+	}
+	return p, err
+}
+
+func (mw PublishingMiddleware) DeleteProfile(ctx context.Context, id string) error {
+	err := mw.Next.DeleteProfile(ctx, id)
+	if err == nil {
+		mw.Bus.Publish(Change{Type: Deleted, ID: id})
+	}
+	return err
+}
+
+func (mw PublishingMiddleware) LookupProfile(ctx context.Context, email string) (*profile.Profile, error) {
+	return mw.Next.LookupProfile(ctx, email)
+}
+
+func (mw PublishingMiddleware) LinkIdentity(ctx context.Context, id string, identity profile.Identity) (*profile.Profile, error) {
+	p, err := mw.Next.LinkIdentity(ctx, id, identity)
+	if err == nil {
+		mw.Bus.Publish(Change{Type: Updated, ID: p.ID, Profile: p})
+	}
+	return p, err
+}
+
+func (mw PublishingMiddleware) UnlinkIdentity(ctx context.Context, id string, provider, subject string) (*profile.Profile, error) {
+	p, err := mw.Next.UnlinkIdentity(ctx, id, provider, subject)
+	if err == nil {
+		mw.Bus.Publish(Change{Type: Updated, ID: p.ID, Profile: p})
+	}
+	return p, err
+}
+
+func (mw PublishingMiddleware) ResolveIdentity(ctx context.Context, provider, subject string) (*profile.Profile, error) {
+	return mw.Next.ResolveIdentity(ctx, provider, subject)
+}
+
+// MergeProfiles publishes the update of the survivor and the deletion of the
+// victim, which is replaced by a redirect.
+func (mw PublishingMiddleware) MergeProfiles(ctx context.Context, survivorID, victimID string, strategy profile.MergeStrategy) (*profile.Profile, error) {
+	p, err := mw.Next.MergeProfiles(ctx, survivorID, victimID, strategy)
+	if err == nil {
+		mw.Bus.Publish(Change{Type: Updated, ID: p.ID, Profile: p})
+		mw.Bus.Publish(Change{Type: Deleted, ID: victimID})
+	}
+	return p, err
+}
+
+func (mw PublishingMiddleware) ListProfiles(ctx context.Context, opts profile.ListOptions) ([]*profile.Profile, string, error) {
+	return mw.Next.ListProfiles(ctx, opts)
+}
+
+func (mw PublishingMiddleware) SearchProfiles(ctx context.Context, query profile.SearchQuery, opts profile.ListOptions) ([]*profile.Profile, string, error) {
+	return mw.Next.SearchProfiles(ctx, query, opts)
+}
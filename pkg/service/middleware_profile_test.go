@@ -40,11 +40,12 @@ func TestLoggingMiddlewareGetProfile(t *testing.T) {
 	mw := NewLoggingMiddleware(logger)(profile.FakeService)
 
 	ctx := context.Background()
-	p := &profile.Profile{ID: "", Email: "gunwoo@gunwoo.org"}
+	p := &profile.Profile{ID: "", Email: "gunwoo@gunwoo.org", Revision: 1}
 	got, err := mw.GetProfile(ctx, p.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
+	p.UpdateTime = got.UpdateTime
 	if !reflect.DeepEqual(got, p) {
 		t.Errorf("GetProfile: got %v, want %v", got, p)
 	}
@@ -75,12 +76,16 @@ func TestLoggingMiddlewarePatchProfile(t *testing.T) {
 
 	ctx := context.Background()
 	p := &profile.Profile{ID: "", Email: "gunwoo@gunwoo.org"}
+	existing, err := profile.FakeService.GetProfile(ctx, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
 	got, err := mw.PatchProfile(ctx, p.ID, p)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !reflect.DeepEqual(got, p) {
-		t.Errorf("PatchProfile: got %v, want %v", got, p)
+	if got.Email != p.Email || got.Revision != existing.Revision+1 {
+		t.Errorf("PatchProfile: got %v, want %v with revision %d", got, p, existing.Revision+1)
 	}
 }
 
@@ -98,6 +103,41 @@ func TestLoggingMiddlewareDeleteProfile(t *testing.T) {
 	}
 }
 
+func TestLoggingMiddlewareListProfiles(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.NewLogfmtLogger(&buf)
+
+	mw := NewLoggingMiddleware(logger)(profile.FakeService)
+
+	ctx := context.Background()
+	want, wantToken, _ := profile.FakeService.ListProfiles(ctx, profile.ListOptions{})
+	got, gotToken, err := mw.ListProfiles(ctx, profile.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) || gotToken != wantToken {
+		t.Errorf("ListProfiles: got (%v, %q), want (%v, %q)", got, gotToken, want, wantToken)
+	}
+}
+
+func TestLoggingMiddlewareSearchProfiles(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.NewLogfmtLogger(&buf)
+
+	mw := NewLoggingMiddleware(logger)(profile.FakeService)
+
+	ctx := context.Background()
+	query := profile.SearchQuery{EmailPrefix: "gunwoo"}
+	want, wantToken, _ := profile.FakeService.SearchProfiles(ctx, query, profile.ListOptions{})
+	got, gotToken, err := mw.SearchProfiles(ctx, query, profile.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) || gotToken != wantToken {
+		t.Errorf("SearchProfiles: got (%v, %q), want (%v, %q)", got, gotToken, want, wantToken)
+	}
+}
+
 func TestInstrumentingMiddlewarePostProfile(t *testing.T) {
 	namespace, subsystem := "middleware_profile_test", "post_profile"
 	mw := newTestInstrumentingMiddleware(namespace, subsystem)
@@ -174,6 +214,34 @@ func TestInstrumentingMiddlewareDeleteProfile(t *testing.T) {
 	}
 }
 
+func TestInstrumentingMiddlewareListProfiles(t *testing.T) {
+	namespace, subsystem := "middleware_profile_test", "list_profiles"
+	mw := newTestInstrumentingMiddleware(namespace, subsystem)
+	svc := mw(profile.FakeService)
+	_, _, err := svc.ListProfiles(context.Background(), profile.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, have := metric(namespace, subsystem, "request_count"), scrapePrometheus(t)
+	if !strings.Contains(have, want) {
+		t.Errorf("metric stanza not found or incorrect\n%s", have)
+	}
+}
+
+func TestInstrumentingMiddlewareSearchProfiles(t *testing.T) {
+	namespace, subsystem := "middleware_profile_test", "search_profiles"
+	mw := newTestInstrumentingMiddleware(namespace, subsystem)
+	svc := mw(profile.FakeService)
+	_, _, err := svc.SearchProfiles(context.Background(), profile.SearchQuery{Email: "gunwoo@gunwoo.org"}, profile.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, have := metric(namespace, subsystem, "request_count"), scrapePrometheus(t)
+	if !strings.Contains(have, want) {
+		t.Errorf("metric stanza not found or incorrect\n%s", have)
+	}
+}
+
 func newTestInstrumentingMiddleware(namespace, subsystem string) Middleware {
 	fieldKeys := []string{"method", "error"}
 	requestCount := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
@@ -220,3 +288,77 @@ func scrapePrometheus(t *testing.T) string {
 
 	return strings.TrimSpace(string(buf))
 }
+
+func TestValidatingMiddleware(t *testing.T) {
+	ctx := context.Background()
+	svc := NewValidatingMiddleware()(profile.FakeService)
+
+	invalid := &profile.Profile{ID: "validating", Email: "gunwoo"}
+	if _, err := svc.PostProfile(ctx, invalid); profile.ErrorCode(err) != profile.InvalidArgument {
+		t.Errorf("PostProfile: got %v, want %v", err, profile.InvalidArgument)
+	}
+	if _, err := svc.PutProfile(ctx, invalid.ID, invalid); profile.ErrorCode(err) != profile.InvalidArgument {
+		t.Errorf("PutProfile: got %v, want %v", err, profile.InvalidArgument)
+	}
+
+	p := &profile.Profile{ID: "validating", Email: "gunwoo@gunwoo.org"}
+	if _, err := svc.PostProfile(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	defer svc.DeleteProfile(ctx, p.ID)
+	if _, err := svc.PatchProfile(ctx, p.ID, profile.MergePatch{"email": nil}); profile.ErrorCode(err) != profile.InvalidArgument {
+		t.Errorf("PatchProfile: got %v, want %v", err, profile.InvalidArgument)
+	}
+	got, err := svc.GetProfile(ctx, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != p.Email {
+		t.Errorf("PatchProfile: invalid patch changed the email to %q", got.Email)
+	}
+
+	if _, err := svc.GetProfiles(ctx, make([]string, profile.MaxBatchSize+1)); profile.ErrorCode(err) != profile.InvalidArgument {
+		t.Errorf("GetProfiles: got %v, want %v", err, profile.InvalidArgument)
+	}
+}
+
+func TestPublishingMiddleware(t *testing.T) {
+	ctx := context.Background()
+	bus := NewEventBus()
+	changes, cancel := bus.Subscribe()
+	defer cancel()
+	svc := NewPublishingMiddleware(bus)(NewValidatingMiddleware()(profile.FakeService))
+
+	if _, err := svc.PostProfile(ctx, &profile.Profile{ID: "publishing", Email: "gunwoo"}); err == nil {
+		t.Fatal("PostProfile: error should not be nil with an invalid email")
+	}
+	p, err := svc.PostProfile(ctx, &profile.Profile{ID: "publishing", Email: "publishing@gunwoo.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.PatchProfile(ctx, p.ID, profile.MergePatch{"aboutMe": "about"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.DeleteProfile(ctx, p.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []ChangeType{Created, Updated, Deleted} {
+		select {
+		case c := <-changes:
+			if c.Type != want || c.ID != p.ID {
+				t.Errorf("got %s of %q, want %s of %q", c.Type, c.ID, want, p.ID)
+			}
+			if (c.Profile == nil) != (want == Deleted) {
+				t.Errorf("%s: got profile %v", c.Type, c.Profile)
+			}
+		default:
+			t.Fatalf("got no change, want %s", want)
+		}
+	}
+	select {
+	case c := <-changes:
+		t.Errorf("got unexpected change %+v", c)
+	default:
+	}
+}
@@ -1,7 +1,6 @@
 package service
 
 import (
-	"cloud.google.com/go/datastore"
 	"github.com/benkim0414/superego/pkg/profile"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/metrics"
@@ -11,11 +10,14 @@ type Service interface {
 	profile.Service
 }
 
-func New(client *datastore.Client, logger log.Logger, requestCount metrics.Counter, requestLatency metrics.Histogram) Service {
+// New returns the service of the profiles of the store, such as a backend
+// opened by profile.Open, with all of the expected middlewares wired in.
+func New(store profile.Service, logger log.Logger, requestCount metrics.Counter, requestLatency metrics.Histogram) Service {
 	var svc Service
 	svc = &service{
-		profile.NewService(client),
+		store,
 	}
+	svc = NewValidatingMiddleware()(svc)
 	svc = NewLoggingMiddleware(logger)(svc)
 	svc = NewInstrumentingMiddleware(requestCount, requestLatency)(svc)
 	return svc
@@ -1,12 +1,9 @@
 package service
 
 import (
-	"context"
 	"reflect"
 	"testing"
 
-	"cloud.google.com/go/datastore"
-	"github.com/benkim0414/superego/internal/testutil"
 	"github.com/benkim0414/superego/pkg/profile"
 	"github.com/go-kit/kit/log"
 	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
@@ -14,13 +11,7 @@ import (
 )
 
 func TestNew(t *testing.T) {
-	tc := testutil.SystemTestContext(t)
-	ctx := context.Background()
-	client, err := datastore.NewClient(ctx, tc.ProjectID)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer client.Close()
+	store := profile.NewMemoryService()
 	logger := log.NewNopLogger()
 
 	fieldKeys := []string{"method", "error"}
@@ -39,12 +30,13 @@ func TestNew(t *testing.T) {
 
 	var svc Service
 	svc = &service{
-		profile.NewService(client),
+		store,
 	}
+	svc = NewValidatingMiddleware()(svc)
 	svc = NewLoggingMiddleware(logger)(svc)
 	svc = NewInstrumentingMiddleware(requestCount, requestLatency)(svc)
 
-	got := New(client, logger, requestCount, requestLatency)
+	got := New(store, logger, requestCount, requestLatency)
 	if !reflect.DeepEqual(got, svc) {
 		t.Errorf("New: got %v, want %v", got, svc)
 	}
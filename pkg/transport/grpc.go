@@ -0,0 +1,275 @@
+package transport
+
+import (
+	"context"
+
+	"github.com/benkim0414/superego/pkg/endpoint"
+	"github.com/benkim0414/superego/pkg/pb"
+	"github.com/benkim0414/superego/pkg/profile"
+	kitendpoint "github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServer implements pb.ProfileServiceServer with the service endpoints.
+type grpcServer struct {
+	postProfile   grpcHandler
+	getProfile    grpcHandler
+	putProfile    grpcHandler
+	patchProfile  grpcHandler
+	deleteProfile grpcHandler
+}
+
+// NewGRPCServer makes the service endpoints available as a gRPC
+// ProfileService, which is registered with pb.RegisterProfileServiceServer.
+func NewGRPCServer(endpoints endpoint.Endpoints, logger log.Logger) pb.ProfileServiceServer {
+	return &grpcServer{
+		postProfile: grpcHandler{
+			endpoint: endpoints.PostProfileEndpoint,
+			decode:   decodeGRPCPostProfileRequest,
+			encode:   encodeGRPCProfileResponse,
+			logger:   logger,
+		},
+		getProfile: grpcHandler{
+			endpoint: endpoints.GetProfileEndpoint,
+			decode:   decodeGRPCGetProfileRequest,
+			encode:   encodeGRPCProfileResponse,
+			logger:   logger,
+		},
+		putProfile: grpcHandler{
+			endpoint: endpoints.PutProfileEndpoint,
+			decode:   decodeGRPCPutProfileRequest,
+			encode:   encodeGRPCProfileResponse,
+			logger:   logger,
+		},
+		patchProfile: grpcHandler{
+			endpoint: endpoints.PatchProfileEndpoint,
+			decode:   decodeGRPCPatchProfileRequest,
+			encode:   encodeGRPCProfileResponse,
+			logger:   logger,
+		},
+		deleteProfile: grpcHandler{
+			endpoint: endpoints.DeleteProfileEndpoint,
+			decode:   decodeGRPCDeleteProfileRequest,
+			encode:   encodeGRPCDeleteProfileResponse,
+			logger:   logger,
+		},
+	}
+}
+
+func (s *grpcServer) PostProfile(ctx context.Context, req *pb.PostProfileRequest) (*pb.Profile, error) {
+	resp, err := s.postProfile.serve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.Profile), nil
+}
+
+func (s *grpcServer) GetProfile(ctx context.Context, req *pb.GetProfileRequest) (*pb.Profile, error) {
+	resp, err := s.getProfile.serve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.Profile), nil
+}
+
+func (s *grpcServer) PutProfile(ctx context.Context, req *pb.PutProfileRequest) (*pb.Profile, error) {
+	resp, err := s.putProfile.serve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.Profile), nil
+}
+
+func (s *grpcServer) PatchProfile(ctx context.Context, req *pb.PatchProfileRequest) (*pb.Profile, error) {
+	resp, err := s.patchProfile.serve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.Profile), nil
+}
+
+func (s *grpcServer) DeleteProfile(ctx context.Context, req *pb.DeleteProfileRequest) (*pb.DeleteProfileResponse, error) {
+	resp, err := s.deleteProfile.serve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.DeleteProfileResponse), nil
+}
+
+// grpcHandler serves a single RPC with an endpoint, like the servers of the
+// go-kit gRPC transport: the request message is decoded into the request of
+// the endpoint, and its response is encoded into the response message.
+type grpcHandler struct {
+	endpoint kitendpoint.Endpoint
+	decode   func(ctx context.Context, req interface{}) (request interface{}, err error)
+	encode   func(ctx context.Context, response interface{}) (resp interface{}, err error)
+	logger   log.Logger
+}
+
+// serve handles the request message, and returns the response message or
+// an error with the status corresponding to the error of the service.
+func (h grpcHandler) serve(ctx context.Context, req interface{}) (interface{}, error) {
+	request, err := h.decode(ctx, req)
+	if err != nil {
+		h.logger.Log("err", err)
+		return nil, grpcError(err)
+	}
+	response, err := h.endpoint(ctx, request)
+	if err != nil {
+		h.logger.Log("err", err)
+		return nil, grpcError(err)
+	}
+	if f, ok := response.(endpoint.Failer); ok && f.Failed() != nil {
+		return nil, grpcError(f.Failed())
+	}
+	resp, err := h.encode(ctx, response)
+	if err != nil {
+		h.logger.Log("err", err)
+		return nil, grpcError(err)
+	}
+	return resp, nil
+}
+
+func decodeGRPCPostProfileRequest(_ context.Context, req interface{}) (interface{}, error) {
+	r := req.(*pb.PostProfileRequest)
+	return endpoint.PostProfileRequest{Profile: profileFromPB(r.Profile)}, nil
+}
+
+func decodeGRPCGetProfileRequest(_ context.Context, req interface{}) (interface{}, error) {
+	r := req.(*pb.GetProfileRequest)
+	return endpoint.GetProfileRequest{ID: r.Id}, nil
+}
+
+func decodeGRPCPutProfileRequest(_ context.Context, req interface{}) (interface{}, error) {
+	r := req.(*pb.PutProfileRequest)
+	return endpoint.PutProfileRequest{ID: r.Id, Profile: profileFromPB(r.Profile), Revision: r.Revision}, nil
+}
+
+// decodeGRPCPatchProfileRequest decodes the profile into a patch which
+// merges its non-empty fields, or overwrites exactly the fields of the
+// update mask, as the HTTP transport does for application/json.
+func decodeGRPCPatchProfileRequest(_ context.Context, req interface{}) (interface{}, error) {
+	r := req.(*pb.PatchProfileRequest)
+	p := profileFromPB(r.Profile)
+	var patch profile.Patch = p
+	if len(r.UpdateMask) > 0 {
+		patch = profile.MaskedPatch{Profile: p, Mask: profile.FieldMask(r.UpdateMask)}
+	}
+	return endpoint.PatchProfileRequest{ID: r.Id, Patch: patch, Revision: r.Revision}, nil
+}
+
+func decodeGRPCDeleteProfileRequest(_ context.Context, req interface{}) (interface{}, error) {
+	r := req.(*pb.DeleteProfileRequest)
+	return endpoint.DeleteProfileRequest{ID: r.Id, Revision: r.Revision}, nil
+}
+
+// encodeGRPCProfileResponse encodes the profile of a response which contains
+// a single profile.
+func encodeGRPCProfileResponse(_ context.Context, response interface{}) (interface{}, error) {
+	return profileToPB(responseProfile(response))
+}
+
+func encodeGRPCDeleteProfileResponse(_ context.Context, _ interface{}) (interface{}, error) {
+	return &pb.DeleteProfileResponse{}, nil
+}
+
+// profileFromPB converts a profile message into a profile. A missing
+// message is an empty profile.
+func profileFromPB(m *pb.Profile) *profile.Profile {
+	if m == nil {
+		return &profile.Profile{}
+	}
+	p := &profile.Profile{
+		ID:          m.Id,
+		DisplayName: m.DisplayName,
+		Email:       m.Email,
+		ImageURL:    m.ImageUrl,
+		AboutMe:     m.AboutMe,
+		Revision:    m.Revision,
+	}
+	if m.Name != nil {
+		p.Name = profile.Name{
+			Formatted:  m.Name.Formatted,
+			FamilyName: m.Name.FamilyName,
+			GivenName:  m.Name.GivenName,
+		}
+	}
+	// the update time is set by the service, so it is not decoded.
+	return p
+}
+
+// profileToPB converts a profile into a profile message.
+func profileToPB(p *profile.Profile) (*pb.Profile, error) {
+	if p == nil {
+		return &pb.Profile{}, nil
+	}
+	m := &pb.Profile{
+		Id:          p.ID,
+		DisplayName: p.DisplayName,
+		Name: &pb.Name{
+			Formatted:  p.Name.Formatted,
+			FamilyName: p.Name.FamilyName,
+			GivenName:  p.Name.GivenName,
+		},
+		Email:    p.Email,
+		ImageUrl: p.ImageURL,
+		AboutMe:  p.AboutMe,
+		Revision: p.Revision,
+	}
+	if !p.UpdateTime.IsZero() {
+		updateTime, err := ptypes.TimestampProto(p.UpdateTime)
+		if err != nil {
+			return nil, err
+		}
+		m.UpdateTime = updateTime
+	}
+	return m, nil
+}
+
+// grpcError returns the status error corresponding to the error, with an
+// ErrorDetail carrying the same information as the problem details of the
+// HTTP transport.
+func grpcError(err error) error {
+	code := profile.ErrorCode(err)
+	detail := &pb.ErrorDetail{Code: code.String()}
+	if e, ok := err.(*profile.Error); ok {
+		for _, v := range e.Violations {
+			detail.Violations = append(detail.Violations, &pb.FieldViolation{Field: v.Field, Description: v.Description})
+		}
+		detail.ExistingId = e.ExistingID
+	}
+	s, detailErr := status.New(grpcCodeFrom(err), err.Error()).WithDetails(detail)
+	if detailErr != nil {
+		return status.Error(grpcCodeFrom(err), err.Error())
+	}
+	return s.Err()
+}
+
+// grpcCodeFrom returns the gRPC status code corresponding to the error, as
+// codeFrom does for HTTP. A Conflict with an existing profile, whose email
+// address or identity is taken, is AlreadyExists; other conflicts, such as
+// contention or a failed JSON Patch test, are Aborted, which clients retry
+// at a higher level.
+func grpcCodeFrom(err error) codes.Code {
+	switch profile.ErrorCode(err) {
+	case profile.NotFound:
+		return codes.NotFound
+	case profile.InvalidArgument:
+		return codes.InvalidArgument
+	case profile.Conflict:
+		if err.(*profile.Error).ExistingID != "" {
+			return codes.AlreadyExists
+		}
+		return codes.Aborted
+	case profile.PermissionDenied:
+		return codes.PermissionDenied
+	case profile.Unavailable:
+		return codes.Unavailable
+	case profile.FailedPrecondition:
+		return codes.FailedPrecondition
+	}
+	return codes.Internal
+}
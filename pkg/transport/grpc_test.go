@@ -0,0 +1,130 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/benkim0414/superego/pkg/endpoint"
+	"github.com/benkim0414/superego/pkg/pb"
+	"github.com/benkim0414/superego/pkg/profile"
+	"github.com/go-kit/kit/log"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewGRPCServer(t *testing.T) {
+	logger := log.NewNopLogger()
+	duration := kitprometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+		Namespace: "grpc_test",
+		Subsystem: "profile",
+		Name:      "request_duration_seconds",
+		Help:      "Request duration in seconds.",
+	}, []string{"method", "success"})
+	endpoints := endpoint.New(profile.FakeService, logger, duration)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	pb.RegisterProfileServiceServer(server, NewGRPCServer(endpoints, logger))
+	go server.Serve(ln)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewProfileServiceClient(conn)
+	ctx := context.Background()
+
+	p, err := client.PostProfile(ctx, &pb.PostProfileRequest{Profile: &pb.Profile{
+		Id:    "grpc",
+		Email: "grpc@gunwoo.org",
+		Name:  &pb.Name{GivenName: "Gunwoo"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Revision != 1 || p.UpdateTime == nil || p.GetName().GetGivenName() != "Gunwoo" {
+		t.Errorf("PostProfile: got %v", p)
+	}
+
+	p, err = client.PatchProfile(ctx, &pb.PatchProfileRequest{
+		Id:         "grpc",
+		Profile:    &pb.Profile{},
+		UpdateMask: []string{"name.givenName"},
+		Revision:   1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Revision != 2 || p.GetName().GetGivenName() != "" {
+		t.Errorf("PatchProfile: got %v, want the given name cleared", p)
+	}
+
+	_, err = client.PutProfile(ctx, &pb.PutProfileRequest{Id: "grpc", Profile: &pb.Profile{Id: "grpc", Email: "grpc@gunwoo.org"}, Revision: 1})
+	if got := status.Code(err); got != codes.FailedPrecondition {
+		t.Errorf("PutProfile: got %v, want %v", got, codes.FailedPrecondition)
+	}
+
+	if _, err := client.DeleteProfile(ctx, &pb.DeleteProfileRequest{Id: "grpc"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.GetProfile(ctx, &pb.GetProfileRequest{Id: "grpc"})
+	s := status.Convert(err)
+	if s.Code() != codes.NotFound {
+		t.Errorf("GetProfile: got %v, want %v", s.Code(), codes.NotFound)
+	}
+	if details := s.Details(); len(details) != 1 || details[0].(*pb.ErrorDetail).Code != "NOT_FOUND" {
+		t.Errorf("GetProfile: got details %v", details)
+	}
+}
+
+func TestGRPCError(t *testing.T) {
+	err := &profile.Error{
+		Code:       profile.Conflict,
+		Message:    "email address is already in use",
+		Violations: []profile.FieldViolation{{Field: "email", Description: "is already in use"}},
+		ExistingID: "gunwoo",
+	}
+	s := status.Convert(grpcError(err))
+	if s.Code() != codes.AlreadyExists {
+		t.Errorf("grpcError: got %v, want %v", s.Code(), codes.AlreadyExists)
+	}
+	details := s.Details()
+	if len(details) != 1 {
+		t.Fatalf("grpcError: got details %v", details)
+	}
+	detail := details[0].(*pb.ErrorDetail)
+	if detail.ExistingId != "gunwoo" || len(detail.Violations) != 1 || detail.Violations[0].Field != "email" {
+		t.Errorf("grpcError: got detail %v", detail)
+	}
+}
+
+func TestGRPCCodeFrom(t *testing.T) {
+	tests := []struct {
+		err  error
+		want codes.Code
+	}{
+		{profile.ErrNoSuchEntity, codes.NotFound},
+		{profile.Errorf(profile.InvalidArgument, "invalid"), codes.InvalidArgument},
+		{&profile.Error{Code: profile.Conflict, Message: "in use", ExistingID: "gunwoo"}, codes.AlreadyExists},
+		{profile.Errorf(profile.Conflict, "conflict"), codes.Aborted},
+		{profile.Errorf(profile.PermissionDenied, "denied"), codes.PermissionDenied},
+		{profile.Errorf(profile.Unavailable, "unavailable"), codes.Unavailable},
+		{profile.Errorf(profile.FailedPrecondition, "mismatch"), codes.FailedPrecondition},
+		{errors.New("unknown"), codes.Internal},
+	}
+	for _, tt := range tests {
+		if got := grpcCodeFrom(tt.err); got != tt.want {
+			t.Errorf("grpcCodeFrom(%v): got %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -4,10 +4,16 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
+	"github.com/benkim0414/superego/pkg/dedupe"
 	"github.com/benkim0414/superego/pkg/endpoint"
 	"github.com/benkim0414/superego/pkg/profile"
+	"github.com/benkim0414/superego/pkg/validation"
 	"github.com/go-kit/kit/log"
 	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
@@ -17,29 +23,109 @@ var (
 	// ErrBadRouting is returned when an expected path variable is missing.
 	// It always indicates programmer error.
 	ErrBadRouting = errors.New("inconsistent mapping between route and handler (programmer error)")
+
+	errNotFound         = profile.Errorf(profile.NotFound, "no such route")
+	errMethodNotAllowed = profile.Errorf(profile.InvalidArgument, "method is not allowed")
+	errUnsupportedPatch = profile.Errorf(profile.InvalidArgument, "unsupported patch media type")
+)
+
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
 )
 
-// NewHTTPHandler mounts all of the service endpoints into an http.Handler.
+// NewHTTPHandler mounts all of the service endpoints into an http.Handler,
+// except for the admin endpoints of NewAdminHTTPHandler.
 func NewHTTPHandler(endpoints endpoint.Endpoints, logger log.Logger) http.Handler {
-	r := mux.NewRouter().PathPrefix("/api/v1/").Subrouter()
+	router := mux.NewRouter().UseEncodedPath()
+	router.NotFoundHandler = problemHandler(errNotFound)
+	router.MethodNotAllowedHandler = problemHandler(errMethodNotAllowed)
+	// the path variables are matched escaped, so that ids and identities
+	// may contain "/", and are unescaped by pathVar. Subrouters do not
+	// inherit UseEncodedPath.
+	r := router.PathPrefix("/api/v1/").Subrouter().UseEncodedPath()
 
 	options := []httptransport.ServerOption{
+		httptransport.ServerBefore(httptransport.PopulateRequestContext),
 		httptransport.ServerErrorLogger(logger),
 		httptransport.ServerErrorEncoder(encodeError),
 	}
 
+	// GET		/api/v1/profiles/		retrieves a page of profiles
 	// POST		/api/v1/profiles/		adds another profile
+	// GET		/api/v1/profiles:search	retrieves a page of profiles matching the filters
+	// GET		/api/v1/profiles:batchGet	retrieves the profiles with the given ids
+	// GET		/api/v1/profiles:lookup	retrieves the profile with the given email
+	// GET		/api/v1/profiles:resolve	retrieves the profile linked to the given identity
 	// GET		/api/v1/profiles/:id	retrieves the given profile by id
 	// PUT		/api/v1/profiles/:id	post updated profile information about the profile
 	// PATCH	/api/v1/profiles/:id	partial updated profile information
 	// DELETE	/api/v1/profiles/:id	removes the given profile
+	// POST		/api/v1/profiles/:id:merge	merges another profile into the given profile
+	// GET		/api/v1/profiles/:id/identities	retrieves the identities linked to the profile
+	// POST		/api/v1/profiles/:id/identities	links another identity to the profile
+	// DELETE	/api/v1/profiles/:id/identities/:provider/:subject	unlinks the given identity
 
+	r.Methods("GET").Path("/profiles/").Handler(httptransport.NewServer(
+		endpoints.ListProfilesEndpoint,
+		decodeListProfilesRequest,
+		encodeResponse,
+		options...,
+	))
 	r.Methods("POST").Path("/profiles/").Handler(httptransport.NewServer(
 		endpoints.PostProfileEndpoint,
 		decodePostProfileRequest,
 		encodeResponse,
 		options...,
 	))
+	r.Methods("GET").Path("/profiles:search").Handler(httptransport.NewServer(
+		endpoints.SearchProfilesEndpoint,
+		decodeSearchProfilesRequest,
+		encodeResponse,
+		options...,
+	))
+	r.Methods("GET").Path("/profiles:batchGet").Handler(httptransport.NewServer(
+		endpoints.GetProfilesEndpoint,
+		decodeGetProfilesRequest,
+		encodeResponse,
+		options...,
+	))
+	r.Methods("GET").Path("/profiles:lookup").Handler(httptransport.NewServer(
+		endpoints.LookupProfileEndpoint,
+		decodeLookupProfileRequest,
+		encodeResponse,
+		options...,
+	))
+	r.Methods("GET").Path("/profiles:resolve").Handler(httptransport.NewServer(
+		endpoints.ResolveIdentityEndpoint,
+		decodeResolveIdentityRequest,
+		encodeResponse,
+		options...,
+	))
+	r.Methods("GET").Path("/profiles/{id}/identities").Handler(httptransport.NewServer(
+		endpoints.ListIdentitiesEndpoint,
+		decodeListIdentitiesRequest,
+		encodeResponse,
+		options...,
+	))
+	r.Methods("POST").Path("/profiles/{id}/identities").Handler(httptransport.NewServer(
+		endpoints.LinkIdentityEndpoint,
+		decodeLinkIdentityRequest,
+		encodeResponse,
+		options...,
+	))
+	r.Methods("DELETE").Path("/profiles/{id}/identities/{provider}/{subject}").Handler(httptransport.NewServer(
+		endpoints.UnlinkIdentityEndpoint,
+		decodeUnlinkIdentityRequest,
+		encodeResponse,
+		options...,
+	))
+	r.Methods("POST").Path("/profiles/{id}:merge").Handler(httptransport.NewServer(
+		endpoints.MergeProfilesEndpoint,
+		decodeMergeProfilesRequest,
+		encodeResponse,
+		options...,
+	))
 	r.Methods("GET").Path("/profiles/{id}").Handler(httptransport.NewServer(
 		endpoints.GetProfileEndpoint,
 		decodeGetProfileRequest,
@@ -64,86 +150,456 @@ func NewHTTPHandler(endpoints endpoint.Endpoints, logger log.Logger) http.Handle
 		encodeResponse,
 		options...,
 	))
-	return r
+	return router
+}
+
+// NewAdminHTTPHandler mounts the admin endpoints into an http.Handler, which
+// does not authenticate its clients and must only be served to operators,
+// such as on a listener which is not exposed.
+func NewAdminHTTPHandler(endpoints endpoint.Endpoints, logger log.Logger) http.Handler {
+	router := mux.NewRouter()
+	router.NotFoundHandler = problemHandler(errNotFound)
+	router.MethodNotAllowedHandler = problemHandler(errMethodNotAllowed)
+	r := router.PathPrefix("/api/v1/admin/").Subrouter()
+
+	options := []httptransport.ServerOption{
+		httptransport.ServerBefore(httptransport.PopulateRequestContext),
+		httptransport.ServerErrorLogger(logger),
+		httptransport.ServerErrorEncoder(encodeError),
+	}
+
+	// GET		/api/v1/admin/duplicates	retrieves a page of the pairs of likely duplicate profiles
+	r.Methods("GET").Path("/duplicates").Handler(httptransport.NewServer(
+		endpoints.FindDuplicatesEndpoint,
+		decodeFindDuplicatesRequest,
+		encodeResponse,
+		options...,
+	))
+	return router
 }
 
 func decodePostProfileRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
 	var req endpoint.PostProfileRequest
 	if err := json.NewDecoder(r.Body).Decode(&req.Profile); err != nil {
-		return nil, err
+		return nil, invalidBody(err)
 	}
 	return req, nil
 }
 
 func decodeGetProfileRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
-	vars := mux.Vars(r)
-	id, ok := vars["id"]
-	if !ok {
-		return nil, ErrBadRouting
+	id, err := pathVar(r, "id")
+	if err != nil {
+		return nil, err
 	}
-	return endpoint.GetProfileRequest{ID: id}, nil
+	fields, err := decodeFieldMask(r, "fields")
+	if err != nil {
+		return nil, err
+	}
+	return endpoint.GetProfileRequest{ID: id, Fields: fields}, nil
+}
+
+func decodeGetProfilesRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	fields, err := decodeFieldMask(r, "fields")
+	if err != nil {
+		return nil, err
+	}
+	return endpoint.GetProfilesRequest{IDs: r.URL.Query()["ids"], Fields: fields}, nil
 }
 
 func decodePutProfileRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
-	vars := mux.Vars(r)
-	id, ok := vars["id"]
-	if !ok {
-		return nil, ErrBadRouting
+	id, err := pathVar(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	revision, err := decodeIfMatch(r)
+	if err != nil {
+		return nil, err
 	}
 	profile := &profile.Profile{}
 	if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
-		return nil, err
+		return nil, invalidBody(err)
 	}
-	return endpoint.PutProfileRequest{ID: id, Profile: profile}, nil
+	return endpoint.PutProfileRequest{ID: id, Profile: profile, Revision: revision}, nil
 }
 
 func decodePatchProfileRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
-	vars := mux.Vars(r)
-	id, ok := vars["id"]
-	if !ok {
-		return nil, ErrBadRouting
+	id, err := pathVar(r, "id")
+	if err != nil {
+		return nil, err
 	}
-	profile := &profile.Profile{}
-	if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+	revision, err := decodeIfMatch(r)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := decodePatch(r)
+	if err != nil {
 		return nil, err
 	}
-	return endpoint.PatchProfileRequest{ID: id, Profile: profile}, nil
+	return endpoint.PatchProfileRequest{ID: id, Patch: patch, Revision: revision}, nil
+}
+
+// decodePatch decodes the body of a PATCH request into the patch of the
+// media type of the request. A plain JSON profile merges its non-empty
+// fields, unless the updateMask parameter specifies exactly which fields to
+// overwrite.
+func decodePatch(r *http.Request) (profile.Patch, error) {
+	mediaType := "application/json"
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return nil, errUnsupportedPatch
+		}
+	}
+	var patch profile.Patch
+	switch mediaType {
+	case "application/json":
+		patch = &profile.Profile{}
+	case mergePatchContentType:
+		patch = &profile.MergePatch{}
+	case jsonPatchContentType:
+		patch = &profile.JSONPatch{}
+	default:
+		return nil, errUnsupportedPatch
+	}
+	updateMask, err := decodeFieldMask(r, "updateMask")
+	if err != nil {
+		return nil, err
+	}
+	if updateMask != nil && mediaType != "application/json" {
+		return nil, profile.InvalidFields("invalid update mask", profile.FieldViolation{
+			Field:       "updateMask",
+			Description: "is only supported with application/json",
+		})
+	}
+	if err := json.NewDecoder(r.Body).Decode(patch); err != nil {
+		return nil, invalidBody(err)
+	}
+	// the patches are decoded through pointers, but applied by value.
+	switch p := patch.(type) {
+	case *profile.Profile:
+		if updateMask != nil {
+			patch = profile.MaskedPatch{Profile: p, Mask: updateMask}
+		}
+	case *profile.MergePatch:
+		patch = *p
+	case *profile.JSONPatch:
+		patch = *p
+	}
+	return patch, nil
 }
 
 func decodeDeleteProfileRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
-	vars := mux.Vars(r)
-	id, ok := vars["id"]
+	id, err := pathVar(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	revision, err := decodeIfMatch(r)
+	if err != nil {
+		return nil, err
+	}
+	return endpoint.DeleteProfileRequest{ID: id, Revision: revision}, nil
+}
+
+// decodeIfMatch returns the revision of the profile expected by the If-Match
+// header of the request, which is zero if the header is absent, and
+// profile.AnyRevision if it is "*", so that the profile must exist.
+func decodeIfMatch(r *http.Request) (int64, error) {
+	ifMatch := r.Header.Get("If-Match")
+	switch ifMatch {
+	case "":
+		return 0, nil
+	case "*":
+		return profile.AnyRevision, nil
+	}
+	revision, err := strconv.ParseInt(strings.Trim(ifMatch, `"`), 10, 64)
+	if err != nil || revision <= 0 || !strings.HasPrefix(ifMatch, `"`) || !strings.HasSuffix(ifMatch, `"`) {
+		return 0, profile.InvalidFields("invalid precondition", profile.FieldViolation{
+			Field:       "If-Match",
+			Description: "must be a single strong entity tag returned as ETag",
+		})
+	}
+	return revision, nil
+}
+
+// etag returns the entity tag of the given revision of a profile.
+func etag(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
+}
+
+func decodeListProfilesRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	opts, err := decodeListOptions(r)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := decodeFieldMask(r, "fields")
+	if err != nil {
+		return nil, err
+	}
+	return endpoint.ListProfilesRequest{Options: opts, Fields: fields}, nil
+}
+
+func decodeSearchProfilesRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	opts, err := decodeListOptions(r)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := decodeFieldMask(r, "fields")
+	if err != nil {
+		return nil, err
+	}
+	q := r.URL.Query()
+	query := profile.SearchQuery{
+		Email:             q.Get("email"),
+		EmailPrefix:       q.Get("emailPrefix"),
+		DisplayName:       q.Get("displayName"),
+		DisplayNamePrefix: q.Get("displayNamePrefix"),
+		FamilyName:        q.Get("familyName"),
+		FamilyNamePrefix:  q.Get("familyNamePrefix"),
+		GivenName:         q.Get("givenName"),
+		GivenNamePrefix:   q.Get("givenNamePrefix"),
+	}
+	return endpoint.SearchProfilesRequest{Query: query, Options: opts, Fields: fields}, nil
+}
+
+// decodeFieldMask decodes the field mask of the given query parameter.
+func decodeFieldMask(r *http.Request, name string) (profile.FieldMask, error) {
+	mask, err := profile.ParseFieldMask(r.URL.Query().Get(name))
+	if err != nil {
+		return nil, profile.InvalidFields("invalid field mask", profile.FieldViolation{
+			Field:       name,
+			Description: err.(*profile.Error).Message,
+		})
+	}
+	return mask, nil
+}
+
+// pathVar returns the unescaped value of the given path variable, which the
+// router matches escaped.
+func pathVar(r *http.Request, name string) (string, error) {
+	v, ok := mux.Vars(r)[name]
 	if !ok {
-		return nil, ErrBadRouting
+		return "", ErrBadRouting
+	}
+	s, err := url.PathUnescape(v)
+	if err != nil {
+		return "", profile.Errorf(profile.InvalidArgument, "invalid %s: %v", name, err)
+	}
+	return s, nil
+}
+
+func decodeLookupProfileRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	email := r.URL.Query().Get("email")
+	if email == "" {
+		return nil, profile.InvalidFields("missing email", profile.FieldViolation{
+			Field:       "email",
+			Description: "is required",
+		})
+	}
+	return endpoint.LookupProfileRequest{Email: email}, nil
+}
+
+func decodeResolveIdentityRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	q := r.URL.Query()
+	return endpoint.ResolveIdentityRequest{
+		Provider: q.Get("provider"),
+		Subject:  q.Get("subject"),
+	}, nil
+}
+
+func decodeListIdentitiesRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	id, err := pathVar(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	return endpoint.ListIdentitiesRequest{ID: id}, nil
+}
+
+func decodeLinkIdentityRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	id, err := pathVar(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	revision, err := decodeIfMatch(r)
+	if err != nil {
+		return nil, err
+	}
+	identity := profile.Identity{}
+	if err := json.NewDecoder(r.Body).Decode(&identity); err != nil {
+		return nil, invalidBody(err)
 	}
-	return endpoint.DeleteProfileRequest{ID: id}, nil
+	return endpoint.LinkIdentityRequest{ID: id, Identity: identity, Revision: revision}, nil
 }
 
-// errorer is implemented by all concrete response types that may contain
-// errors. It allows us to change the HTTP response code without needing to
-// trigger an endpoint (transport-level) error.
-type errorer interface {
-	error() error
+func decodeUnlinkIdentityRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	id, err := pathVar(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	provider, err := pathVar(r, "provider")
+	if err != nil {
+		return nil, err
+	}
+	subject, err := pathVar(r, "subject")
+	if err != nil {
+		return nil, err
+	}
+	revision, err := decodeIfMatch(r)
+	if err != nil {
+		return nil, err
+	}
+	return endpoint.UnlinkIdentityRequest{ID: id, Provider: provider, Subject: subject, Revision: revision}, nil
+}
+
+func decodeMergeProfilesRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	id, err := pathVar(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	revision, err := decodeIfMatch(r)
+	if err != nil {
+		return nil, err
+	}
+	var body struct {
+		VictimID string                `json:"victimId"`
+		Strategy profile.MergeStrategy `json:"strategy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, invalidBody(err)
+	}
+	if body.VictimID == "" {
+		return nil, profile.InvalidFields("missing victim", profile.FieldViolation{
+			Field:       "victimId",
+			Description: "is required",
+		})
+	}
+	return endpoint.MergeProfilesRequest{
+		SurvivorID: id,
+		VictimID:   body.VictimID,
+		Strategy:   body.Strategy,
+		Revision:   revision,
+	}, nil
+}
+
+func decodeFindDuplicatesRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	opts, err := decodeListOptions(r)
+	if err != nil {
+		return nil, err
+	}
+	req := endpoint.FindDuplicatesRequest{
+		MinScore:  dedupe.DefaultMinScore,
+		PageSize:  opts.PageSize,
+		PageToken: opts.PageToken,
+	}
+	if minScore := r.URL.Query().Get("minScore"); minScore != "" {
+		req.MinScore, err = strconv.ParseFloat(minScore, 64)
+		if err != nil || req.MinScore < 0 || req.MinScore > 1 {
+			return nil, profile.InvalidFields("invalid minimum score", profile.FieldViolation{
+				Field:       "minScore",
+				Description: "must be a number from 0 to 1",
+			})
+		}
+	}
+	return req, nil
+}
+
+// decodeListOptions decodes the pagination and ordering query parameters
+// shared by the listing routes.
+func decodeListOptions(r *http.Request) (profile.ListOptions, error) {
+	q := r.URL.Query()
+	opts := profile.ListOptions{
+		PageToken: q.Get("pageToken"),
+		OrderBy:   q.Get("orderBy"),
+	}
+	if pageSize := q.Get("pageSize"); pageSize != "" {
+		var err error
+		opts.PageSize, err = strconv.Atoi(pageSize)
+		if err != nil {
+			return profile.ListOptions{}, profile.InvalidFields("invalid list options", profile.FieldViolation{
+				Field:       "pageSize",
+				Description: "must be an integer",
+			})
+		}
+	}
+	return opts, nil
+}
+
+// invalidBody returns an InvalidArgument error for a request body which
+// could not be decoded, with the violating field if it is known.
+func invalidBody(err error) error {
+	e := &profile.Error{Code: profile.InvalidArgument, Message: "invalid request body", Err: err}
+	if te, ok := err.(*json.UnmarshalTypeError); ok && te.Field != "" {
+		e.Violations = []profile.FieldViolation{{
+			Field:       te.Field,
+			Description: "must be " + te.Type.String(),
+		}}
+	}
+	return e
 }
 
 // encodeResponse is the common method to encode all response types to the
 // client.
 func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
-	if e, ok := response.(errorer); ok && e.error() != nil {
-		encodeError(ctx, e.error(), w)
+	if f, ok := response.(endpoint.Failer); ok && f.Failed() != nil {
+		encodeError(ctx, f.Failed(), w)
 		return nil
 	}
+	if p := responseProfile(response); p != nil && p.Revision != 0 {
+		w.Header().Set("ETag", etag(p.Revision))
+	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	return json.NewEncoder(w).Encode(response)
 }
 
-func encodeError(_ context.Context, err error, w http.ResponseWriter) {
-	if err == nil {
-		panic("encodeError with nil error")
+// responseProfile returns the profile of a response which contains a single
+// profile, or nil.
+func responseProfile(response interface{}) *profile.Profile {
+	switch r := response.(type) {
+	case endpoint.PostProfileResponse:
+		return r.Profile
+	case endpoint.GetProfileResponse:
+		return r.Profile
+	case endpoint.PutProfileResponse:
+		return r.Profile
+	case endpoint.PatchProfileResponse:
+		return r.Profile
+	case endpoint.LookupProfileResponse:
+		return r.Profile
+	case endpoint.LinkIdentityResponse:
+		return r.Profile
+	case endpoint.UnlinkIdentityResponse:
+		return r.Profile
+	case endpoint.ResolveIdentityResponse:
+		return r.Profile
+	case endpoint.MergeProfilesResponse:
+		return r.Profile
 	}
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(http.StatusInternalServerError)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": err.Error(),
-	})
+	return nil
+}
+
+// codeFrom returns the HTTP status code corresponding to the error.
+func codeFrom(err error) int {
+	switch err {
+	case errMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case errUnsupportedPatch:
+		return http.StatusUnsupportedMediaType
+	}
+	if validation.IsInvalid(err) {
+		return http.StatusUnprocessableEntity
+	}
+	switch profile.ErrorCode(err) {
+	case profile.NotFound:
+		return http.StatusNotFound
+	case profile.InvalidArgument:
+		return http.StatusBadRequest
+	case profile.Conflict:
+		return http.StatusConflict
+	case profile.PermissionDenied:
+		return http.StatusForbidden
+	case profile.Unavailable:
+		return http.StatusServiceUnavailable
+	case profile.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusInternalServerError
 }
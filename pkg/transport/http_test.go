@@ -8,10 +8,14 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/benkim0414/superego/pkg/dedupe"
 	"github.com/benkim0414/superego/pkg/endpoint"
 	"github.com/benkim0414/superego/pkg/profile"
+	"github.com/benkim0414/superego/pkg/validation"
 	"github.com/go-kit/kit/log"
 	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
@@ -25,22 +29,22 @@ var handlerTests = []struct {
 	{
 		method:  http.MethodPost,
 		path:    "/api/v1/profiles/",
-		profile: &profile.Profile{ID: "gunwoo", Email: "gunwoo@gunwoo.org"},
+		profile: &profile.Profile{ID: "gunwoo", Email: "gunwoo@gunwoo.org", Revision: 1},
 	},
 	{
 		method:  http.MethodGet,
 		path:    "/api/v1/profiles/gunwoo",
-		profile: &profile.Profile{ID: "gunwoo", Email: "gunwoo@gunwoo.org"},
+		profile: &profile.Profile{ID: "gunwoo", Email: "gunwoo@gunwoo.org", Revision: 1},
 	},
 	{
 		method:  http.MethodPut,
 		path:    "/api/v1/profiles/gunwoo",
-		profile: &profile.Profile{ID: "gunwoo", Email: "ben.kim@greenenergytrading.com.au"},
+		profile: &profile.Profile{ID: "gunwoo", Email: "ben.kim@greenenergytrading.com.au", Revision: 2},
 	},
 	{
 		method:  http.MethodPatch,
 		path:    "/api/v1/profiles/gunwoo",
-		profile: &profile.Profile{ID: "gunwoo", Email: "gunwoo@gunwoo.org"},
+		profile: &profile.Profile{ID: "gunwoo", Email: "gunwoo@gunwoo.org", Revision: 3},
 	},
 	{
 		method:  http.MethodDelete,
@@ -86,6 +90,13 @@ func TestNewHTTPHandler(t *testing.T) {
 			t.Fatal(err)
 		}
 
+		// the update time is set by the service.
+		if response.Profile != nil {
+			if response.Profile.UpdateTime.IsZero() {
+				t.Errorf("%s %s got zero updateTime", tt.method, tt.path)
+			}
+			response.Profile.UpdateTime = time.Time{}
+		}
 		if !reflect.DeepEqual(response.Profile, tt.profile) {
 			t.Errorf("%s %s got %v, want %v", tt.method, tt.path, response.Profile, tt.profile)
 		}
@@ -101,6 +112,9 @@ func TestDecodePostProfileRequest(t *testing.T) {
 	if request != nil {
 		t.Errorf("decodePostProfileRequest: got %v, want %v", request, nil)
 	}
+	if code := profile.ErrorCode(err); code != profile.InvalidArgument {
+		t.Errorf("decodePostProfileRequest: got %v, want %v", code, profile.InvalidArgument)
+	}
 
 	p := &profile.Profile{Email: "gunwoo@gunwoo.org"}
 	err = json.NewEncoder(&buf).Encode(p)
@@ -155,6 +169,86 @@ func TestDecodePatchProfileRequest(t *testing.T) {
 	}
 }
 
+func TestDecodePatch(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		want        profile.Patch
+		err         error
+	}{
+		{"", `{"email": "gunwoo@gunwoo.org"}`, &profile.Profile{Email: "gunwoo@gunwoo.org"}, nil},
+		{"application/json; charset=utf-8", `{"aboutMe": "Codercat"}`, &profile.Profile{AboutMe: "Codercat"}, nil},
+		{mergePatchContentType, `{"aboutMe": null}`, profile.MergePatch{"aboutMe": nil}, nil},
+		{
+			jsonPatchContentType,
+			`[{"op": "remove", "path": "/aboutMe"}]`,
+			profile.JSONPatch{{Op: "remove", Path: "/aboutMe"}},
+			nil,
+		},
+		{"text/plain", `aboutMe`, nil, errUnsupportedPatch},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPatch, "/api/v1/profiles/gunwoo", bytes.NewBufferString(tt.body))
+		r.Header.Set("Content-Type", tt.contentType)
+		got, err := decodePatch(r)
+		if err != tt.err {
+			t.Errorf("decodePatch(%q): got error %v, want %v", tt.contentType, err, tt.err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("decodePatch(%q): got %#v, want %#v", tt.contentType, got, tt.want)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPatch, "/api/v1/profiles/gunwoo", bytes.NewBufferString(`"aboutMe"`))
+	r.Header.Set("Content-Type", mergePatchContentType)
+	if _, err := decodePatch(r); profile.ErrorCode(err) != profile.InvalidArgument {
+		t.Errorf("decodePatch: got %v, want %v", err, profile.InvalidArgument)
+	}
+}
+
+func TestNewHTTPHandlerPatch(t *testing.T) {
+	logger := log.NewNopLogger()
+	duration := kitprometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+		Namespace: "http_patch_test",
+		Subsystem: "profile",
+		Name:      "request_duration_seconds",
+		Help:      "Request duration in seconds.",
+	}, []string{"method", "success"})
+	endpoints := endpoint.New(profile.FakeService, logger, duration)
+	handler := NewHTTPHandler(endpoints, logger)
+
+	p := &profile.Profile{ID: "patch", Email: "gunwoo@gunwoo.org", AboutMe: "Codercat"}
+	if _, err := profile.FakeService.PostProfile(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	defer profile.FakeService.DeleteProfile(context.Background(), p.ID)
+
+	r := httptest.NewRequest(http.MethodPatch, "/api/v1/profiles/patch", bytes.NewBufferString(`{"aboutMe": null}`))
+	r.Header.Set("Content-Type", mergePatchContentType)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	var resp struct {
+		Profile *profile.Profile `json:"profile"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Profile == nil || resp.Profile.AboutMe != "" || resp.Profile.Email != p.Email {
+		t.Errorf("PATCH merge patch: got %v, want aboutMe to be cleared", resp.Profile)
+	}
+
+	r = httptest.NewRequest(http.MethodPatch, "/api/v1/profiles/patch", bytes.NewBufferString(`aboutMe`))
+	r.Header.Set("Content-Type", "text/plain")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if got := w.Result().StatusCode; got != http.StatusUnsupportedMediaType {
+		t.Errorf("PATCH text/plain: got %d, want %d", got, http.StatusUnsupportedMediaType)
+	}
+	if got := w.Result().Header.Get("Accept-Patch"); got == "" {
+		t.Errorf("PATCH text/plain: got no Accept-Patch header")
+	}
+}
+
 func TestDecodeDeleteProfileRequest(t *testing.T) {
 	ctx := context.Background()
 	r := httptest.NewRequest(http.MethodDelete, "/api/v1/profiles/", nil)
@@ -170,7 +264,7 @@ func TestDecodeDeleteProfileRequest(t *testing.T) {
 
 type Response struct{ err error }
 
-func (r Response) error() error { return r.err }
+func (r Response) Failed() error { return r.err }
 
 func TestEncodeResponse(t *testing.T) {
 	want := struct {
@@ -190,6 +284,7 @@ func TestEncodeResponse(t *testing.T) {
 
 	response = struct{}{}
 
+	w = httptest.NewRecorder()
 	err = encodeResponse(ctx, w, response)
 	resp := w.Result()
 	contentType := resp.Header.Get("Content-Type")
@@ -201,37 +296,453 @@ func TestEncodeResponse(t *testing.T) {
 	}
 }
 
-func TestEncodeError(t *testing.T) {
-	want := struct {
-		contentType string
-		code        int
-	}{
-		"application/json; charset=utf-8",
-		http.StatusInternalServerError,
+func TestDecodeListProfilesRequest(t *testing.T) {
+	ctx := context.Background()
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/profiles/?pageSize=10&pageToken=token&orderBy=-email", nil)
+
+	request, err := decodeListProfilesRequest(ctx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := endpoint.ListProfilesRequest{
+		Options: profile.ListOptions{PageSize: 10, PageToken: "token", OrderBy: "-email"},
+	}
+	if !reflect.DeepEqual(request, want) {
+		t.Errorf("decodeListProfilesRequest: got %v, want %v", request, want)
 	}
 
+	r = httptest.NewRequest(http.MethodGet, "/api/v1/profiles/?pageSize=ten", nil)
+	request, err = decodeListProfilesRequest(ctx, r)
+	if request != nil {
+		t.Errorf("decodeListProfilesRequest: got %v, want %v", request, nil)
+	}
+	if err == nil {
+		t.Error("decodeListProfilesRequest: error should not be nil with an invalid page size")
+	}
+}
+
+func TestDecodeSearchProfilesRequest(t *testing.T) {
 	ctx := context.Background()
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/profiles:search?email=gunwoo@gunwoo.org&familyNamePrefix=Ki&pageSize=5", nil)
+
+	request, err := decodeSearchProfilesRequest(ctx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := endpoint.SearchProfilesRequest{
+		Query:   profile.SearchQuery{Email: "gunwoo@gunwoo.org", FamilyNamePrefix: "Ki"},
+		Options: profile.ListOptions{PageSize: 5},
+	}
+	if !reflect.DeepEqual(request, want) {
+		t.Errorf("decodeSearchProfilesRequest: got %v, want %v", request, want)
+	}
+}
+
+func TestCodeFrom(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{profile.ErrNoSuchEntity, http.StatusNotFound},
+		{profile.Errorf(profile.InvalidArgument, ""), http.StatusBadRequest},
+		{profile.Errorf(profile.Conflict, ""), http.StatusConflict},
+		{profile.Errorf(profile.PermissionDenied, ""), http.StatusForbidden},
+		{profile.Errorf(profile.Unavailable, ""), http.StatusServiceUnavailable},
+		{profile.Errorf(profile.FailedPrecondition, ""), http.StatusPreconditionFailed},
+		{errMethodNotAllowed, http.StatusMethodNotAllowed},
+		{errUnsupportedPatch, http.StatusUnsupportedMediaType},
+		{validation.Validate(&profile.Profile{}), http.StatusUnprocessableEntity},
+		{errors.New(""), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := codeFrom(tt.err); got != tt.want {
+			t.Errorf("codeFrom(%v): got %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeIfMatch(t *testing.T) {
+	tests := []struct {
+		ifMatch string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"*", profile.AnyRevision, false},
+		{`"3"`, 3, false},
+		{"3", 0, true},
+		{`W/"3"`, 0, true},
+		{`"0"`, 0, true},
+		{`"1", "2"`, 0, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPut, "/api/v1/profiles/gunwoo", nil)
+		r.Header.Set("If-Match", tt.ifMatch)
+		got, err := decodeIfMatch(r)
+		if got != tt.want {
+			t.Errorf("decodeIfMatch(%q): got %d, want %d", tt.ifMatch, got, tt.want)
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("decodeIfMatch(%q): unexpected error %v", tt.ifMatch, err)
+		}
+	}
+}
+
+func TestNewHTTPHandlerConditionalRequests(t *testing.T) {
+	logger := log.NewNopLogger()
+	duration := kitprometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+		Namespace: "http_conditional_test",
+		Subsystem: "profile",
+		Name:      "request_duration_seconds",
+		Help:      "Request duration in seconds.",
+	}, []string{"method", "success"})
+	endpoints := endpoint.New(profile.FakeService, logger, duration)
+	handler := NewHTTPHandler(endpoints, logger)
+
+	p := &profile.Profile{ID: "conditional", Email: "gunwoo@gunwoo.org"}
+	if _, err := profile.FakeService.PostProfile(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	defer profile.FakeService.DeleteProfile(context.Background(), p.ID)
+
 	w := httptest.NewRecorder()
-	var err error = nil
-	defer func() {
-		if r := recover(); r == nil {
-			t.Error("encodceError could not be recovered with nil error")
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/profiles/conditional", nil))
+	tag := w.Result().Header.Get("ETag")
+	if tag != `"1"` {
+		t.Fatalf("GET: got ETag %q, want %q", tag, `"1"`)
+	}
+
+	for _, tt := range []struct {
+		ifMatch string
+		status  int
+	}{
+		{`"2"`, http.StatusPreconditionFailed},
+		{tag, http.StatusOK},
+		{tag, http.StatusPreconditionFailed},
+		{"*", http.StatusOK},
+	} {
+		var body bytes.Buffer
+		json.NewEncoder(&body).Encode(p)
+		r := httptest.NewRequest(http.MethodPut, "/api/v1/profiles/conditional", &body)
+		r.Header.Set("If-Match", tt.ifMatch)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if got := w.Result().StatusCode; got != tt.status {
+			t.Errorf("PUT If-Match %s: got %d, want %d", tt.ifMatch, got, tt.status)
 		}
-	}()
+	}
 
-	encodeError(ctx, err, w)
+	// If-Match: * requires the profile to exist, rather than creating it.
+	var body bytes.Buffer
+	json.NewEncoder(&body).Encode(&profile.Profile{Email: "conditional-missing@gunwoo.org"})
+	r := httptest.NewRequest(http.MethodPut, "/api/v1/profiles/conditional-missing", &body)
+	r.Header.Set("If-Match", "*")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if got := w.Result().StatusCode; got != http.StatusPreconditionFailed {
+		t.Errorf("PUT If-Match * of a missing profile: got %d, want %d", got, http.StatusPreconditionFailed)
+	}
+	if _, err := profile.FakeService.GetProfile(context.Background(), "conditional-missing"); profile.ErrorCode(err) != profile.NotFound {
+		t.Errorf("GET conditional-missing: got %v, want %v", err, profile.NotFound)
+	}
+}
 
-	err = errors.New("")
+func TestNewHTTPHandlerFieldMasks(t *testing.T) {
+	logger := log.NewNopLogger()
+	duration := kitprometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+		Namespace: "http_field_mask_test",
+		Subsystem: "profile",
+		Name:      "request_duration_seconds",
+		Help:      "Request duration in seconds.",
+	}, []string{"method", "success"})
+	endpoints := endpoint.New(profile.FakeService, logger, duration)
+	handler := NewHTTPHandler(endpoints, logger)
 
-	encodeError(ctx, err, w)
-	resp := w.Result()
-	contentType := resp.Header.Get("Content-Type")
-	if contentType != want.contentType {
-		t.Errorf("encodeError: got %q, want %q", contentType, want.contentType)
+	p := &profile.Profile{
+		ID:          "field-mask",
+		DisplayName: "benkim0414",
+		Name:        profile.Name{GivenName: "Gunwoo"},
+		Email:       "gunwoo@gunwoo.org",
+		AboutMe:     "Codercat",
+	}
+	if _, err := profile.FakeService.PostProfile(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	defer profile.FakeService.DeleteProfile(context.Background(), p.ID)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/profiles/field-mask?fields=displayName,name.givenName", nil))
+	want := `{"profile":{"displayName":"benkim0414","name":{"givenName":"Gunwoo"}}}` + "\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("GET fields: got %s, want %s", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/profiles/field-mask?fields=unknown", nil))
+	if got := w.Result().StatusCode; got != http.StatusBadRequest {
+		t.Errorf("GET unknown fields: got %d, want %d", got, http.StatusBadRequest)
+	}
+
+	body := bytes.NewBufferString(`{"displayName": "gunwoo"}`)
+	r := httptest.NewRequest(http.MethodPatch, "/api/v1/profiles/field-mask?updateMask=displayName,aboutMe", body)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	got, err := profile.FakeService.GetProfile(context.Background(), p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DisplayName != "gunwoo" || got.AboutMe != "" || got.Email != p.Email {
+		t.Errorf("PATCH updateMask: got %v, want displayName to be set and aboutMe to be cleared", got)
+	}
+
+	r = httptest.NewRequest(http.MethodPatch, "/api/v1/profiles/field-mask?updateMask=aboutMe", bytes.NewBufferString(`{}`))
+	r.Header.Set("Content-Type", mergePatchContentType)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if got := w.Result().StatusCode; got != http.StatusBadRequest {
+		t.Errorf("PATCH merge patch with updateMask: got %d, want %d", got, http.StatusBadRequest)
+	}
+}
+
+func TestNewHTTPHandlerLookup(t *testing.T) {
+	logger := log.NewNopLogger()
+	duration := kitprometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+		Namespace: "http_lookup_test",
+		Subsystem: "profile",
+		Name:      "request_duration_seconds",
+		Help:      "Request duration in seconds.",
+	}, []string{"method", "success"})
+	endpoints := endpoint.New(profile.FakeService, logger, duration)
+	handler := NewHTTPHandler(endpoints, logger)
+
+	p := &profile.Profile{ID: "lookup", Email: "lookup@gunwoo.org"}
+	if _, err := profile.FakeService.PostProfile(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	defer profile.FakeService.DeleteProfile(context.Background(), p.ID)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/profiles:lookup?email=lookup@gunwoo.org", nil))
+	var resp endpoint.LookupProfileResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Profile == nil || resp.Profile.ID != p.ID {
+		t.Errorf("GET lookup: got %v, want %v", resp.Profile, p)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/profiles:lookup", nil))
+	if got := w.Result().StatusCode; got != http.StatusBadRequest {
+		t.Errorf("GET lookup without email: got %d, want %d", got, http.StatusBadRequest)
+	}
+
+	var body bytes.Buffer
+	json.NewEncoder(&body).Encode(&profile.Profile{ID: "lookup-conflict", Email: p.Email})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/profiles/", &body))
+	var problem Problem
+	if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Status != http.StatusConflict || problem.ExistingID != p.ID {
+		t.Errorf("POST duplicate email: got %+v, want a conflict with %q", problem, p.ID)
+	}
+}
+
+func TestNewHTTPHandlerIdentities(t *testing.T) {
+	logger := log.NewNopLogger()
+	duration := kitprometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+		Namespace: "http_identities_test",
+		Subsystem: "profile",
+		Name:      "request_duration_seconds",
+		Help:      "Request duration in seconds.",
+	}, []string{"method", "success"})
+	endpoints := endpoint.New(profile.FakeService, logger, duration)
+	handler := NewHTTPHandler(endpoints, logger)
+
+	p := &profile.Profile{ID: "http-identities", Email: "http-identities@gunwoo.org"}
+	if _, err := profile.FakeService.PostProfile(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	defer profile.FakeService.DeleteProfile(context.Background(), p.ID)
+
+	body := bytes.NewBufferString(`{"provider": "github", "subject": "1234", "metadata": {"login": "benkim0414"}}`)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/profiles/http-identities/identities", body))
+	if got := w.Result().StatusCode; got != http.StatusOK {
+		t.Fatalf("POST identities: got %d, want %d", got, http.StatusOK)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/profiles:resolve?provider=github&subject=1234", nil))
+	var resp endpoint.ResolveIdentityResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Profile == nil || resp.Profile.ID != p.ID {
+		t.Errorf("GET resolve: got %v, want %v", resp.Profile, p)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/v1/profiles/http-identities/identities/github/1234", nil))
+	if got := w.Result().StatusCode; got != http.StatusOK {
+		t.Errorf("DELETE identity: got %d, want %d", got, http.StatusOK)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/profiles:resolve?provider=github&subject=1234", nil))
+	if got := w.Result().StatusCode; got != http.StatusNotFound {
+		t.Errorf("GET resolve unlinked: got %d, want %d", got, http.StatusNotFound)
+	}
+
+	// the path variables are escaped, so that subjects may contain "/".
+	body = bytes.NewBufferString(`{"provider": "saml", "subject": "a/b?c%d"}`)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/profiles/http-identities/identities", body))
+	if got := w.Result().StatusCode; got != http.StatusOK {
+		t.Fatalf("POST identities with an escaped subject: got %d, want %d", got, http.StatusOK)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/v1/profiles/http-identities/identities/saml/a%2Fb%3Fc%25d", nil))
+	if got := w.Result().StatusCode; got != http.StatusOK {
+		t.Errorf("DELETE identity with an escaped subject: got %d, want %d", got, http.StatusOK)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/profiles:resolve?provider=saml&subject=a%2Fb%3Fc%25d", nil))
+	if got := w.Result().StatusCode; got != http.StatusNotFound {
+		t.Errorf("GET resolve unlinked with an escaped subject: got %d, want %d", got, http.StatusNotFound)
+	}
+}
+
+func TestNewHTTPHandlerMerge(t *testing.T) {
+	logger := log.NewNopLogger()
+	duration := kitprometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+		Namespace: "http_merge_test",
+		Subsystem: "profile",
+		Name:      "request_duration_seconds",
+		Help:      "Request duration in seconds.",
+	}, []string{"method", "success"})
+	endpoints := endpoint.New(profile.FakeService, logger, duration)
+	handler := NewHTTPHandler(endpoints, logger)
+
+	for _, p := range []*profile.Profile{
+		{ID: "http-survivor", Email: "http-survivor@gunwoo.org"},
+		{ID: "http-victim", Email: "http-victim@gunwoo.org", AboutMe: "Codercat"},
+	} {
+		if _, err := profile.FakeService.PostProfile(context.Background(), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer profile.FakeService.DeleteProfile(context.Background(), "http-survivor")
+
+	body := bytes.NewBufferString(`{"victimId": "http-victim", "strategy": {"policy": "preferOldest"}}`)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/profiles/http-survivor:merge", body))
+	if got := w.Result().StatusCode; got != http.StatusBadRequest {
+		t.Errorf("POST merge with an unknown policy: got %d, want %d", got, http.StatusBadRequest)
+	}
+
+	body = bytes.NewBufferString(`{"victimId": "http-victim", "strategy": {"fields": {"email": "victim"}}}`)
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/profiles/http-survivor:merge", body)
+	r.Header.Set("If-Match", `"1"`)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	var resp endpoint.MergeProfilesResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Profile == nil || resp.Profile.Email != "http-victim@gunwoo.org" || resp.Profile.AboutMe != "Codercat" {
+		t.Errorf("POST merge: got %v", resp.Profile)
+	}
+	if got := w.Result().Header.Get("ETag"); got != `"2"` {
+		t.Errorf("POST merge: got ETag %q, want %q", got, `"2"`)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/profiles/http-victim", nil))
+	var got endpoint.GetProfileResponse
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Profile == nil || got.Profile.ID != "http-survivor" {
+		t.Errorf("GET merged profile: got %v, want the survivor", got.Profile)
+	}
+}
+
+func TestNewHTTPHandlerBatchGet(t *testing.T) {
+	logger := log.NewNopLogger()
+	duration := kitprometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+		Namespace: "http_batch_get_test",
+		Subsystem: "profile",
+		Name:      "request_duration_seconds",
+		Help:      "Request duration in seconds.",
+	}, []string{"method", "success"})
+	handler := NewHTTPHandler(endpoint.New(profile.FakeService, logger, duration), logger)
+
+	p := &profile.Profile{ID: "http-batch-get", Email: "http-batch-get@gunwoo.org"}
+	if _, err := profile.FakeService.PostProfile(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	defer profile.FakeService.DeleteProfile(context.Background(), p.ID)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/profiles:batchGet?ids=http-batch-get&ids=unknown&fields=email", nil))
+	if got := w.Body.String(); got != `{"profiles":[{"email":"http-batch-get@gunwoo.org"},null]}`+"\n" {
+		t.Errorf("GET batchGet: got %s", got)
+	}
+}
+
+func TestDecodeFindDuplicatesRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/admin/duplicates?minScore=0.8", nil)
+	req, err := decodeFindDuplicatesRequest(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := req.(endpoint.FindDuplicatesRequest).MinScore; got != 0.8 {
+		t.Errorf("decodeFindDuplicatesRequest: got %v, want %v", got, 0.8)
+	}
+	for query, want := range map[string]float64{"": dedupe.DefaultMinScore, "?minScore=0": 0} {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/admin/duplicates"+query, nil)
+		req, err := decodeFindDuplicatesRequest(context.Background(), r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := req.(endpoint.FindDuplicatesRequest).MinScore; got != want {
+			t.Errorf("decodeFindDuplicatesRequest(%q): got %v, want %v", query, got, want)
+		}
+	}
+
+	for _, query := range []string{"minScore=high", "minScore=-1", "minScore=1.5", "pageSize=ten"} {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/admin/duplicates?"+query, nil)
+		if _, err := decodeFindDuplicatesRequest(context.Background(), r); profile.ErrorCode(err) != profile.InvalidArgument {
+			t.Errorf("decodeFindDuplicatesRequest(%q): got %v, want %v", query, err, profile.InvalidArgument)
+		}
+	}
+}
+
+func TestAdminHTTPHandler(t *testing.T) {
+	logger := log.NewNopLogger()
+	duration := kitprometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+		Namespace: "admin_http_test",
+		Subsystem: "profile",
+		Name:      "request_duration_seconds",
+		Help:      "Request duration in seconds.",
+	}, []string{"method", "success"})
+	endpoints := endpoint.New(profile.NewFakeService(), logger, duration)
+
+	// the admin endpoints are not served with the others.
+	w := httptest.NewRecorder()
+	NewHTTPHandler(endpoints, logger).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/admin/duplicates", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET duplicates: got status %d, want %d", w.Code, http.StatusNotFound)
 	}
 
-	code := resp.StatusCode
-	if code != want.code {
-		t.Errorf("encodeError: got %d, want %v", code, want.code)
+	w = httptest.NewRecorder()
+	NewAdminHTTPHandler(endpoints, logger).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/admin/duplicates?pageSize=10", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"duplicates":[]`) {
+		t.Errorf("GET duplicates: got status %d and %s", w.Code, w.Body)
 	}
 }
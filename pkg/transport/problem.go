@@ -0,0 +1,86 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/benkim0414/superego/pkg/profile"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// problemContentType is the media type of problem details defined by RFC 7807.
+const problemContentType = "application/problem+json"
+
+// Problem is the body of an error response, as defined by RFC 7807.
+// Clients should rely on Type or Code to identify the kind of the problem,
+// since Detail is a human-readable message which may change.
+type Problem struct {
+	// A URI reference that identifies the problem type.
+	Type string `json:"type"`
+	// A short, human-readable summary of the problem type.
+	Title string `json:"title"`
+	// The HTTP status code of the response.
+	Status int `json:"status"`
+	// A human-readable explanation specific to this occurrence of the problem.
+	Detail string `json:"detail,omitempty"`
+	// A URI reference that identifies this occurrence of the problem.
+	Instance string `json:"instance,omitempty"`
+	// The stable, machine-readable code of the problem type, such as "NOT_FOUND".
+	Code string `json:"code"`
+	// The invalid fields of the request, for validation failures.
+	Violations []profile.FieldViolation `json:"violations,omitempty"`
+	// The id of the existing profile which the request conflicts with, such
+	// as the owner of an email address.
+	ExistingID string `json:"existingId,omitempty"`
+}
+
+// problemType returns the type URI of the problems with the given code.
+func problemType(code profile.Code) string {
+	return "urn:superego:problem:" + strings.Replace(strings.ToLower(code.String()), "_", "-", -1)
+}
+
+// newProblem returns the Problem describing the error. The instance is the
+// path of the request populated in the context, if any.
+func newProblem(ctx context.Context, err error) Problem {
+	code := profile.ErrorCode(err)
+	status := codeFrom(err)
+	p := Problem{
+		Type:   problemType(code),
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: err.Error(),
+		Code:   code.String(),
+	}
+	if path, ok := ctx.Value(httptransport.ContextKeyRequestPath).(string); ok {
+		p.Instance = path
+	}
+	if e, ok := err.(*profile.Error); ok {
+		p.Violations = e.Violations
+		p.ExistingID = e.ExistingID
+	}
+	return p
+}
+
+// problemHandler returns an http.Handler which responds with a problem
+// describing the error, for requests which are not routed to an endpoint.
+func problemHandler(err error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := httptransport.PopulateRequestContext(r.Context(), r)
+		encodeError(ctx, err, w)
+	})
+}
+
+func encodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	if err == nil {
+		panic("encodeError with nil error")
+	}
+	p := newProblem(ctx, err)
+	if err == errUnsupportedPatch {
+		w.Header().Set("Accept-Patch", mergePatchContentType+", "+jsonPatchContentType)
+	}
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
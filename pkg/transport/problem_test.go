@@ -0,0 +1,136 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/benkim0414/superego/pkg/endpoint"
+	"github.com/benkim0414/superego/pkg/profile"
+	"github.com/go-kit/kit/log"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	httptransport "github.com/go-kit/kit/transport/http"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+func TestEncodeError(t *testing.T) {
+	want := struct {
+		contentType string
+		code        int
+	}{
+		problemContentType,
+		http.StatusInternalServerError,
+	}
+
+	ctx := context.Background()
+	w := httptest.NewRecorder()
+	var err error = nil
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("encodceError could not be recovered with nil error")
+		}
+	}()
+
+	encodeError(ctx, err, w)
+
+	err = errors.New("")
+
+	encodeError(ctx, err, w)
+	resp := w.Result()
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != want.contentType {
+		t.Errorf("encodeError: got %q, want %q", contentType, want.contentType)
+	}
+
+	code := resp.StatusCode
+	if code != want.code {
+		t.Errorf("encodeError: got %d, want %v", code, want.code)
+	}
+}
+
+func TestNewProblem(t *testing.T) {
+	ctx := context.WithValue(context.Background(), httptransport.ContextKeyRequestPath, "/api/v1/profiles/")
+	v := profile.FieldViolation{Field: "pageSize", Description: "must be an integer"}
+	err := profile.InvalidFields("invalid list options", v)
+
+	got := newProblem(ctx, err)
+	want := Problem{
+		Type:       "urn:superego:problem:invalid-argument",
+		Title:      "Bad Request",
+		Status:     http.StatusBadRequest,
+		Detail:     "invalid list options",
+		Instance:   "/api/v1/profiles/",
+		Code:       "INVALID_ARGUMENT",
+		Violations: []profile.FieldViolation{v},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("newProblem: got %+v, want %+v", got, want)
+	}
+}
+
+func TestInvalidBody(t *testing.T) {
+	var p profile.Profile
+	err := invalidBody(json.NewDecoder(strings.NewReader(`{"email": 1}`)).Decode(&p))
+	if code := profile.ErrorCode(err); code != profile.InvalidArgument {
+		t.Errorf("invalidBody: got %v, want %v", code, profile.InvalidArgument)
+	}
+	violations := err.(*profile.Error).Violations
+	if len(violations) != 1 || violations[0].Field != "email" {
+		t.Errorf("invalidBody: got %v, want a violation of email", violations)
+	}
+}
+
+var problemTests = []struct {
+	method string
+	path   string
+	body   string
+	status int
+	code   string
+}{
+	{http.MethodGet, "/api/v1/profiles/unknown", "", http.StatusNotFound, "NOT_FOUND"},
+	{http.MethodGet, "/api/v1/profiles/?pageSize=ten", "", http.StatusBadRequest, "INVALID_ARGUMENT"},
+	{http.MethodPost, "/api/v1/profiles/", "{", http.StatusBadRequest, "INVALID_ARGUMENT"},
+	{http.MethodGet, "/api/v1/unknown", "", http.StatusNotFound, "NOT_FOUND"},
+	{http.MethodPost, "/api/v1/profiles/unknown", "", http.StatusMethodNotAllowed, "INVALID_ARGUMENT"},
+}
+
+func TestNewHTTPHandlerProblems(t *testing.T) {
+	logger := log.NewNopLogger()
+	duration := kitprometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+		Namespace: "problem_test",
+		Subsystem: "profile",
+		Name:      "request_duration_seconds",
+		Help:      "Request duration in seconds.",
+	}, []string{"method", "success"})
+	endpoints := endpoint.New(profile.FakeService, logger, duration)
+	handler := NewHTTPHandler(endpoints, logger)
+
+	for _, tt := range problemTests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		resp := w.Result()
+		if got := resp.Header.Get("Content-Type"); got != problemContentType {
+			t.Errorf("%s %s: got %q, want %q", tt.method, tt.path, got, problemContentType)
+		}
+		var p Problem
+		if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Status != tt.status || resp.StatusCode != tt.status {
+			t.Errorf("%s %s: got %d, want %d", tt.method, tt.path, p.Status, tt.status)
+		}
+		if p.Code != tt.code {
+			t.Errorf("%s %s: got %q, want %q", tt.method, tt.path, p.Code, tt.code)
+		}
+		if p.Instance != strings.SplitN(tt.path, "?", 2)[0] {
+			t.Errorf("%s %s: got instance %q", tt.method, tt.path, p.Instance)
+		}
+	}
+}
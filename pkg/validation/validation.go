@@ -0,0 +1,158 @@
+// Package validation checks profiles against the per-field rules of
+// superego, such as the syntax of email addresses and length limits.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/benkim0414/superego/pkg/profile"
+)
+
+const (
+	// MaxEmailLength is the maximum length of an email address in bytes,
+	// as limited by the SMTP path length.
+	MaxEmailLength = 254
+	// MaxNameLength is the maximum number of characters of the display name
+	// and of each component of the name.
+	MaxNameLength = 100
+	// MaxImageURLLength is the maximum length of an image URL in bytes.
+	MaxImageURLLength = 2048
+	// MaxAboutMeLength is the maximum number of characters of AboutMe.
+	MaxAboutMeLength = 1000
+)
+
+// ErrInvalid is the underlying error of the errors returned by Validate, so
+// that transports can tell invalid profiles from malformed requests.
+var ErrInvalid = errors.New("one or more fields are invalid")
+
+// Validate returns an InvalidArgument *profile.Error, with a violation for
+// every invalid field, if the profile is not valid.
+func Validate(p *profile.Profile) error {
+	var violations []profile.FieldViolation
+	check := func(field, value string, rules ...rule) {
+		for _, r := range rules {
+			if description := r(value); description != "" {
+				violations = append(violations, profile.FieldViolation{Field: field, Description: description})
+				return
+			}
+		}
+	}
+
+	check("displayName", p.DisplayName, text(false), maxLength(MaxNameLength))
+	check("name.formatted", p.Name.Formatted, text(false), maxLength(MaxNameLength))
+	check("name.familyName", p.Name.FamilyName, text(false), maxLength(MaxNameLength))
+	check("name.givenName", p.Name.GivenName, text(false), maxLength(MaxNameLength))
+	check("email", p.Email, required, text(false), maxBytes(MaxEmailLength), email)
+	check("imageUrl", p.ImageURL, text(false), maxBytes(MaxImageURLLength), httpURL)
+	check("aboutMe", p.AboutMe, text(true), maxLength(MaxAboutMeLength))
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return &profile.Error{
+		Code:       profile.InvalidArgument,
+		Message:    "invalid profile",
+		Violations: violations,
+		Err:        ErrInvalid,
+	}
+}
+
+// IsInvalid reports whether the error was returned by Validate.
+func IsInvalid(err error) bool {
+	e, ok := err.(*profile.Error)
+	return ok && e.Err == ErrInvalid
+}
+
+// Patch returns a patch which applies the given patch, and then validates
+// the patched profile.
+func Patch(patch profile.Patch) profile.Patch {
+	return validatingPatch{patch}
+}
+
+type validatingPatch struct {
+	profile.Patch
+}
+
+func (patch validatingPatch) Apply(p *profile.Profile) error {
+	if err := patch.Patch.Apply(p); err != nil {
+		return err
+	}
+	return Validate(p)
+}
+
+// rule checks a single field, and returns the description of the violation
+// if the value is invalid. Empty values are only checked by required.
+type rule func(value string) string
+
+func required(value string) string {
+	if value == "" {
+		return "is required"
+	}
+	return ""
+}
+
+// text rejects invalid UTF-8 and control characters, except for newlines
+// and tabs if multiline is true.
+func text(multiline bool) rule {
+	return func(value string) string {
+		if !utf8.ValidString(value) {
+			return "must be valid UTF-8"
+		}
+		for _, r := range value {
+			if multiline && (r == '\n' || r == '\r' || r == '\t') {
+				continue
+			}
+			if unicode.IsControl(r) {
+				return "must not contain control characters"
+			}
+		}
+		return ""
+	}
+}
+
+func maxLength(n int) rule {
+	return func(value string) string {
+		if utf8.RuneCountInString(value) > n {
+			return fmt.Sprintf("must be at most %d characters", n)
+		}
+		return ""
+	}
+}
+
+func maxBytes(n int) rule {
+	return func(value string) string {
+		if len(value) > n {
+			return fmt.Sprintf("must be at most %d bytes", n)
+		}
+		return ""
+	}
+}
+
+// email accepts a bare address, such as "gunwoo@gunwoo.org", but not a name
+// address, such as "Gunwoo <gunwoo@gunwoo.org>".
+func email(value string) string {
+	if value == "" {
+		return ""
+	}
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Address != value {
+		return "must be a valid email address"
+	}
+	return ""
+}
+
+func httpURL(value string) string {
+	if value == "" {
+		return ""
+	}
+	u, err := url.Parse(value)
+	if err != nil || !u.IsAbs() || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "must be an absolute http or https URL"
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+package validation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/benkim0414/superego/pkg/profile"
+)
+
+func TestValidate(t *testing.T) {
+	valid := func() *profile.Profile {
+		return &profile.Profile{
+			DisplayName: "benkim0414",
+			Name:        profile.Name{FamilyName: "Kim", GivenName: "Gunwoo"},
+			Email:       "gunwoo@gunwoo.org",
+			ImageURL:    "https://octodex.github.com/images/codercat.jpg",
+			AboutMe:     "Codercat\nand Octocat",
+		}
+	}
+	tests := []struct {
+		modify func(p *profile.Profile)
+		fields []string
+	}{
+		{func(p *profile.Profile) {}, nil},
+		{func(p *profile.Profile) { p.Email = "" }, []string{"email"}},
+		{func(p *profile.Profile) { p.Email = "gunwoo" }, []string{"email"}},
+		{func(p *profile.Profile) { p.Email = "Gunwoo <gunwoo@gunwoo.org>" }, []string{"email"}},
+		{func(p *profile.Profile) { p.Email = strings.Repeat("a", 250) + "@b.co" }, []string{"email"}},
+		{func(p *profile.Profile) { p.ImageURL = "/images/codercat.jpg" }, []string{"imageUrl"}},
+		{func(p *profile.Profile) { p.ImageURL = "ftp://octodex.github.com/codercat.jpg" }, []string{"imageUrl"}},
+		{func(p *profile.Profile) { p.ImageURL = "javascript:alert(1)" }, []string{"imageUrl"}},
+		{func(p *profile.Profile) { p.ImageURL = "" }, nil},
+		{func(p *profile.Profile) { p.DisplayName = strings.Repeat("가", MaxNameLength) }, nil},
+		{func(p *profile.Profile) { p.DisplayName = strings.Repeat("가", MaxNameLength+1) }, []string{"displayName"}},
+		{func(p *profile.Profile) { p.Name.GivenName = "Gun\x00woo" }, []string{"name.givenName"}},
+		{func(p *profile.Profile) { p.DisplayName = "ben\nkim" }, []string{"displayName"}},
+		{func(p *profile.Profile) { p.AboutMe = "\xff" }, []string{"aboutMe"}},
+		{func(p *profile.Profile) { p.AboutMe = "Coder\x1bcat" }, []string{"aboutMe"}},
+		{
+			func(p *profile.Profile) { p.Email, p.ImageURL = "", "codercat.jpg" },
+			[]string{"email", "imageUrl"},
+		},
+	}
+	for i, tt := range tests {
+		p := valid()
+		tt.modify(p)
+		err := Validate(p)
+		var fields []string
+		if err != nil {
+			if !IsInvalid(err) {
+				t.Errorf("%d: Validate: got %v, want an invalid profile error", i, err)
+				continue
+			}
+			for _, v := range err.(*profile.Error).Violations {
+				fields = append(fields, v.Field)
+			}
+		}
+		if !reflect.DeepEqual(fields, tt.fields) {
+			t.Errorf("%d: Validate: got violations of %q, want %q", i, fields, tt.fields)
+		}
+	}
+}
+
+func TestPatch(t *testing.T) {
+	p := &profile.Profile{Email: "gunwoo@gunwoo.org"}
+	if err := Patch(profile.MergePatch{"email": nil}).Apply(p); !IsInvalid(err) {
+		t.Errorf("Apply: got %v, want an invalid profile error", err)
+	}
+
+	p = &profile.Profile{Email: "gunwoo@gunwoo.org"}
+	if err := Patch(profile.MergePatch{"aboutMe": "Codercat"}).Apply(p); err != nil {
+		t.Errorf("Apply: got %v, want nil", err)
+	}
+	if p.AboutMe != "Codercat" {
+		t.Errorf("Apply: got %v, want the patch to be applied", p)
+	}
+}